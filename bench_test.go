@@ -9,12 +9,17 @@ import (
 	"dbms/internal/config"
 	"dbms/internal/runners"
 	"log"
+	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
 var dbClient client.ClientCommands
 
+// dbUrl allows tests to open additional connections
+var dbUrl string
+
 // TestMain runs DBMS server in background before requests execution
 // NOTE: placed in this file for ability to run it in tests and benchmarks either
 func TestMain(m *testing.M) {
@@ -29,7 +34,8 @@ func TestMain(m *testing.M) {
 	// TODO: rework
 	time.Sleep(time.Second)
 	// prepare client
-	c, err := client.Connect(urlFactory.BuildUrl())
+	dbUrl = urlFactory.BuildUrl()
+	c, err := client.Connect(dbUrl)
 	if err != nil {
 		log.Panic(err)
 	}
@@ -85,4 +91,26 @@ func Benchmark_TxAbort(b *testing.B) {
 		tx.MustSet("key", []byte("some-val"))
 		tx.Abort()
 	}
-}
\ No newline at end of file
+}
+
+// Benchmark_ParallelTxCommit tests performance of concurrent committed transactions of different keys;
+// their commits are flushed in groups, so throughput isn't bound by single fsync latency
+func Benchmark_ParallelTxCommit(b *testing.B) {
+	var connId int64
+	b.RunParallel(func(pb *testing.PB) {
+		c, err := client.Connect(dbUrl)
+		if err != nil {
+			log.Panic(err)
+		}
+		defer c.Finalize()
+		key := "parallel-key" + strconv.FormatInt(atomic.AddInt64(&connId, 1), 10)
+		for pb.Next() {
+			tx, err := c.BeginEx()
+			if err != nil {
+				log.Panic(err)
+			}
+			tx.MustSet(key, []byte("some-val"))
+			tx.Commit()
+		}
+	})
+}
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 )
 
 var (
@@ -27,6 +28,15 @@ func createResMsgExtractor() func(res *transfer.Result) string {
 		transfer.OkResultCode:    func(_ *transfer.Result) string { return "OK" },
 		transfer.ValueResultCode: func(res *transfer.Result) string { return string(res.Value()) },
 		transfer.ErrResultCode:   func(res *transfer.Result) string { return res.Error() },
+		transfer.PairsResultCode: func(res *transfer.Result) string {
+			lines := make([]string, 0, len(res.Pairs()))
+			for _, p := range res.Pairs() {
+				lines = append(lines, fmt.Sprintf("%s %s", p.Key, p.Value))
+			}
+			return strings.Join(lines, "\n")
+		},
+		transfer.KeysResultCode:     func(res *transfer.Result) string { return strings.Join(res.Keys(), "\n") },
+		transfer.RedirectResultCode: func(res *transfer.Result) string { return res.Error() },
 	}
 	return func(res *transfer.Result) string {
 		return codeMap[res.Type()](res)
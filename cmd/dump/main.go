@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bufio"
+	"dbms/pkg/client"
+	"flag"
+	"fmt"
+	"os"
+)
+
+var (
+	host    string
+	port    uint
+	outPath string
+)
+
+func init() {
+	flag.StringVar(&host, "host", "localhost", "DBMS's hostname")
+	flag.UintVar(&port, "port", 8080, "DBMS's TCP-port")
+	flag.StringVar(&outPath, "out", "", "dump file (standard output by default)")
+}
+
+// main writes all key-value pairs of running server as JSON Lines; dump is loaded by dbms-restore -dump
+func main() {
+	flag.Parse()
+	dbClient, err := client.Connect(fmt.Sprintf("%s:%d", host, port))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer dbClient.Finalize()
+	out := os.Stdout
+	if outPath != "" {
+		if out, err = os.Create(outPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer out.Close()
+	}
+	w := bufio.NewWriter(out)
+	n, err := dbClient.Dump(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil && outPath != "" {
+		err = out.Sync()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Dumped %d pairs\n", n)
+}
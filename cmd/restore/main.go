@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"dbms/internal/config"
+	"dbms/internal/core/restore"
+	"dbms/pkg/client"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	cfgPath     string
+	backupPath  string
+	incremental string
+	archivePath string
+	walPath     string
+	txId        int
+	timestamp   string
+	dumpPath    string
+	host        string
+	port        uint
+	batchSize   int
+)
+
+func init() {
+	flag.StringVar(&cfgPath, "config", "", "JSON configuration file of server which storage is restored")
+	flag.StringVar(&backupPath, "backup", "", "full backup directory (made by BACKUP command or copy of data.bin and log directory of stopped server)")
+	flag.StringVar(&incremental, "incremental", "", "comma separated incremental backup directories in order of their creation after full backup")
+	flag.StringVar(&archivePath, "archive", "", "directory of archived journal segments (archivePath setting by default)")
+	flag.StringVar(&walPath, "wal", "", "directory of the latest journal segments, e.g. log directory of damaged server")
+	flag.IntVar(&txId, "tx", 0, "id of the last restored transaction (the end of journal by default)")
+	flag.StringVar(&timestamp, "time", "", "time of the last restored commit (RFC3339, the end of journal by default)")
+	flag.StringVar(&dumpPath, "dump", "", "dump file made by dbms-dump; it is loaded to running server instead of backup restore")
+	flag.StringVar(&host, "host", "localhost", "DBMS's hostname (dump load)")
+	flag.UintVar(&port, "port", 8080, "DBMS's TCP-port (dump load)")
+	flag.IntVar(&batchSize, "batch", client.LoadBatchSize, "number of pairs loaded by one transaction (dump load)")
+}
+
+// main restores storage to files path of configuration; server recovers it at the next start;
+// dump is loaded to running server instead
+func main() {
+	flag.Parse()
+	var err error
+	if dumpPath != "" {
+		err = loadDump()
+	} else {
+		err = restoreBackup()
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func restoreBackup() error {
+	cfgLdr := config.NewConfigLoader(cfgPath)
+	cfgLdr.Load()
+	target := restore.Target{TxId: txId}
+	if timestamp != "" {
+		t, err := time.Parse(time.RFC3339, timestamp)
+		if err != nil {
+			return err
+		}
+		target.Time = t
+	}
+	if archivePath == "" {
+		archivePath = cfgLdr.CoreCfg().ArchiveDir()
+	}
+	segDirs := make([]string, 0, 2)
+	for _, dir := range []string{archivePath, walPath} {
+		if dir != "" {
+			segDirs = append(segDirs, dir)
+		}
+	}
+	backupPaths := []string{backupPath}
+	if incremental != "" {
+		backupPaths = append(backupPaths, strings.Split(incremental, ",")...)
+	}
+	if err := restore.Restore(cfgLdr.CoreCfg(), backupPaths, target, segDirs...); err != nil {
+		return err
+	}
+	fmt.Printf("Restored storage %s; start server to recover it\n", cfgLdr.CoreCfg().DataPath())
+	return nil
+}
+
+// loadDump sets pairs of dump in batches; pairs loaded before failed batch are kept
+func loadDump() error {
+	file, err := os.Open(dumpPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	dbClient, err := client.Connect(fmt.Sprintf("%s:%d", host, port))
+	if err != nil {
+		return err
+	}
+	defer dbClient.Finalize()
+	n, err := dbClient.Load(bufio.NewReader(file), batchSize)
+	fmt.Printf("Loaded %d pairs\n", n)
+	return err
+}
@@ -4,10 +4,18 @@ import (
 	"dbms/internal/config"
 	"dbms/internal/core"
 	"dbms/internal/server"
+	"flag"
 )
 
+var cfgPath string
+
+func init() {
+	flag.StringVar(&cfgPath, "config", "", "JSON configuration file (settings absent in it keep default values)")
+}
+
 func main() {
-	cfgLdr := new(config.DefaultConfigLoader)
+	flag.Parse()
+	cfgLdr := config.NewConfigLoader(cfgPath)
 	cfgLdr.Load()
 	coreFactory := core.NewDefaultDBMSCoreFactory(cfgLdr.CoreCfg())
 	coreBtstp := coreFactory.BtstpMgr()
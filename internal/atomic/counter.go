@@ -21,5 +21,5 @@ func (c *AtomicCounter) Decr() int {
 }
 
 func (c *AtomicCounter) Value() int {
-	return int(c.counter)
+	return int(atomic.LoadInt64(&c.counter))
 }
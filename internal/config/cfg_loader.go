@@ -5,3 +5,11 @@ type ConfigLoader interface {
 	SrvCfg() *ServerConfig
 	Load()
 }
+
+// NewConfigLoader returns loader of JSON file or DefaultConfigLoader if path is empty
+func NewConfigLoader(cfgFilePath string) ConfigLoader {
+	if cfgFilePath == "" {
+		return new(DefaultConfigLoader)
+	}
+	return NewJSONConfigLoader(cfgFilePath)
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"log"
 	"path/filepath"
+	"time"
 )
 
 type CoreConfig struct {
@@ -10,8 +11,67 @@ type CoreConfig struct {
 	BufCap    int    `json:"bufferCapacity"`
 	FilesPath string `json:"filesPath"`
 	LogSegCap int    `json:"logSegmentCapacity"`
+	// MaxKeyLength defines B+ tree nodes capacity together with PageSize,
+	// so both are kept in storage meta page, and server refuses to start if they are changed for existing storage
+	MaxKeyLength int `json:"maxKeyLength"`
+	// LockTimeoutMs is a default time in milliseconds which transaction waits for lock; zero means 10 seconds
+	LockTimeoutMs int `json:"lockTimeoutMs"`
+	// VacuumIntervalMs is a period in milliseconds of old records versions removal
+	VacuumIntervalMs int `json:"vacuumIntervalMs"`
+	// CheckpointIntervalMs is a period in milliseconds of checkpoints, which truncate journal
+	CheckpointIntervalMs int `json:"checkpointIntervalMs"`
+	// Durability defines when journal of committed changes is synced to disk (see Durability* constants);
+	// commits don't write storage in any mode, its pages are written by checkpoints and evictions after their
+	// journaled images are synced
+	Durability string `json:"durability"`
+	// DurabilityIntervalMs is a period in milliseconds of background sync in interval durability mode
+	DurabilityIntervalMs int `json:"durabilityIntervalMs"`
+	// BackupRoot is a directory where BACKUP command makes backups; command paths are resolved inside it
+	BackupRoot string `json:"backupRoot"`
+	// ArchivePath is a directory where journal segments are copied before removal by checkpoints;
+	// segments aren't archived if it is empty
+	ArchivePath string `json:"archivePath"`
+	// ReplicaOf is an address (host:port) of primary server which journal is replayed by this read-only replica;
+	// server is a primary if it is empty
+	ReplicaOf string `json:"replicaOf"`
+	// ReplicaName identifies replica in replicas status of primary; address of replica connection is used if it is empty
+	ReplicaName string `json:"replicaName"`
+	// SyncReplicas is a number of replicas which must durably receive journal records of commit before it returns;
+	// replication is asynchronous if it is zero
+	SyncReplicas int `json:"syncReplicas"`
+	// SyncReplicationTimeoutMs bounds time in milliseconds which commit waits for replicas; zero means no bound
+	SyncReplicationTimeoutMs int `json:"syncReplicationTimeoutMs"`
+	// SyncReplicationFallback defines what commit does on timeout (see SyncFallback* constants)
+	SyncReplicationFallback string `json:"syncReplicationFallback"`
+	// Cluster lists addresses (host:port) of servers of Raft cluster including this one; server isn't a node
+	// of cluster if it is empty
+	Cluster []string `json:"cluster"`
+	// ClusterAddr is an address of this server in Cluster
+	ClusterAddr string `json:"clusterAddr"`
+	// ElectionTimeoutMs is a minimal time in milliseconds which follower waits for leader before election
+	ElectionTimeoutMs int `json:"electionTimeoutMs"`
 }
 
+const (
+	// DurabilitySync syncs journal before commit returns; committed changes are never lost
+	DurabilitySync = "sync"
+	// DurabilityInterval syncs journal in background every DurabilityIntervalMs;
+	// process crash loses nothing, OS crash or power loss loses commits of the last interval
+	DurabilityInterval = "interval"
+	// DurabilityOff leaves sync of journal to OS and checkpoints; process crash loses nothing, OS crash or power loss
+	// loses commits made since the last checkpoint
+	DurabilityOff = "off"
+)
+
+const (
+	// SyncFallbackAsync returns commit after timeout and makes replication asynchronous till enough replicas
+	// catch up, so commits don't wait for lost replicas one by one
+	SyncFallbackAsync = "async"
+	// SyncFallbackError fails commit after timeout; transaction is committed on primary anyway, but client knows
+	// that its changes may be lost with primary
+	SyncFallbackError = "error"
+)
+
 func (c *CoreConfig) absFilesPath() string {
 	p, err := filepath.Abs(c.FilesPath)
 	if err != nil {
@@ -20,10 +80,107 @@ func (c *CoreConfig) absFilesPath() string {
 	return p
 }
 
+// defaultLockTimeout is used if LockTimeoutMs is zero
+const defaultLockTimeout = 10 * time.Second
+
+func (c *CoreConfig) LockTimeout() time.Duration {
+	if c.LockTimeoutMs == 0 {
+		return defaultLockTimeout
+	}
+	return time.Duration(c.LockTimeoutMs) * time.Millisecond
+}
+
+func (c *CoreConfig) VacuumInterval() time.Duration {
+	return time.Duration(c.VacuumIntervalMs) * time.Millisecond
+}
+
+func (c *CoreConfig) CheckpointInterval() time.Duration {
+	return time.Duration(c.CheckpointIntervalMs) * time.Millisecond
+}
+
+// defaultElectionTimeout is used if ElectionTimeoutMs is zero
+const defaultElectionTimeout = 500 * time.Millisecond
+
+func (c *CoreConfig) ElectionTimeout() time.Duration {
+	if c.ElectionTimeoutMs == 0 {
+		return defaultElectionTimeout
+	}
+	return time.Duration(c.ElectionTimeoutMs) * time.Millisecond
+}
+
+// DurabilityMode returns durability mode; empty mode means sync; interval mode requires positive
+// DurabilityIntervalMs, otherwise it would never sync
+func (c *CoreConfig) DurabilityMode() string {
+	switch c.Durability {
+	case "", DurabilitySync:
+		return DurabilitySync
+	case DurabilityInterval:
+		if c.DurabilityIntervalMs <= 0 {
+			log.Panicf("durabilityIntervalMs must be positive in %s durability mode", DurabilityInterval)
+		}
+		return c.Durability
+	case DurabilityOff:
+		return c.Durability
+	}
+	log.Panicf("unknown durability mode %s", c.Durability)
+	return ""
+}
+
+func (c *CoreConfig) SyncReplicationTimeout() time.Duration {
+	return time.Duration(c.SyncReplicationTimeoutMs) * time.Millisecond
+}
+
+// SyncFallbackMode returns fallback of synchronous replication; empty fallback means async
+func (c *CoreConfig) SyncFallbackMode() string {
+	switch c.SyncReplicationFallback {
+	case "", SyncFallbackAsync:
+		return SyncFallbackAsync
+	case SyncFallbackError:
+		return SyncFallbackError
+	}
+	log.Panicf("unknown synchronous replication fallback %s", c.SyncReplicationFallback)
+	return ""
+}
+
+func (c *CoreConfig) DurabilityInterval() time.Duration {
+	return time.Duration(c.DurabilityIntervalMs) * time.Millisecond
+}
+
+// BackupRootDir returns absolute path of backups root directory
+func (c *CoreConfig) BackupRootDir() string {
+	p, err := filepath.Abs(c.BackupRoot)
+	if err != nil {
+		log.Panic(err)
+	}
+	return p
+}
+
+// ArchiveDir returns absolute path of archive directory or empty string if archiving is disabled
+func (c *CoreConfig) ArchiveDir() string {
+	if c.ArchivePath == "" {
+		return ""
+	}
+	p, err := filepath.Abs(c.ArchivePath)
+	if err != nil {
+		log.Panic(err)
+	}
+	return p
+}
+
 func (c *CoreConfig) DataPath() string {
 	return filepath.Join(c.absFilesPath(), "data.bin")
 }
 
+// PagesPath returns path of changed pages copied by incremental backup
+func (c *CoreConfig) PagesPath() string {
+	return filepath.Join(c.absFilesPath(), "pages.bin")
+}
+
 func (c *CoreConfig) LogPath() string {
 	return filepath.Join(c.absFilesPath(), "log")
 }
+
+// RaftPath returns directory of Raft log and state of cluster node
+func (c *CoreConfig) RaftPath() string {
+	return filepath.Join(c.absFilesPath(), "raft")
+}
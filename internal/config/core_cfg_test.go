@@ -0,0 +1,17 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCoreConfig_DurabilityMode(t *testing.T) {
+	cfg := CoreConfig{Durability: DurabilityInterval, DurabilityIntervalMs: 100}
+	assert.Equal(t, DurabilityInterval, cfg.DurabilityMode())
+	cfg.DurabilityIntervalMs = 0
+	assert.Panics(t, func() { cfg.DurabilityMode() })
+	cfg.DurabilityIntervalMs = -1
+	assert.Panics(t, func() { cfg.DurabilityMode() })
+	assert.Equal(t, DurabilitySync, (&CoreConfig{}).DurabilityMode())
+	assert.Equal(t, DurabilityOff, (&CoreConfig{Durability: DurabilityOff}).DurabilityMode())
+}
@@ -20,10 +20,17 @@ const (
 func (l *DefaultConfigLoader) Load() {
 	l.cfg = &config{
 		CoreConfig{
-			PageSize:  8 * KB,
-			BufCap:    4 * KB,
-			FilesPath: ".",
-			LogSegCap: 1 * MB,
+			PageSize:             8 * KB,
+			BufCap:               4 * KB,
+			FilesPath:            ".",
+			BackupRoot:           "backups",
+			LogSegCap:            1 * MB,
+			MaxKeyLength:         64,
+			LockTimeoutMs:        10 * 1000,
+			VacuumIntervalMs:     1000,
+			CheckpointIntervalMs: 5 * 1000,
+			Durability:           DurabilitySync,
+			DurabilityIntervalMs: 100,
 		},
 		ServerConfig{
 			TransportProtocol: "tcp",
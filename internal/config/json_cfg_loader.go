@@ -6,6 +6,8 @@ import (
 	"log"
 )
 
+// JSONConfigLoader loads settings from JSON file; settings which are absent in file keep values
+// of DefaultConfigLoader
 type JSONConfigLoader struct {
 	cfgFilePath string
 	cfg         *config
@@ -30,7 +32,9 @@ func (l *JSONConfigLoader) Load() {
 	if err != nil {
 		log.Panic(err)
 	}
-	l.cfg = new(config)
+	defaults := new(DefaultConfigLoader)
+	defaults.Load()
+	l.cfg = defaults.cfg
 	if err := json.Unmarshal(data, l.cfg); err != nil {
 		log.Panic(err)
 	}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func loadJSON(t *testing.T, data string) *JSONConfigLoader {
+	path := filepath.Join(t.TempDir(), "config.json")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(data), 0644))
+	l := NewJSONConfigLoader(path)
+	l.Load()
+	return l
+}
+
+func TestJSONConfigLoader_Cluster(t *testing.T) {
+	// settings of cluster node from README
+	l := loadJSON(t, `{
+  "filesPath": "/var/dbms/node1",
+  "port": 8081,
+  "cluster": ["node1:8081", "node2:8081", "node3:8081"],
+  "clusterAddr": "node1:8081",
+  "electionTimeoutMs": 500
+}`)
+	defaults := new(DefaultConfigLoader)
+	defaults.Load()
+	expected := *defaults.CoreCfg()
+	expected.FilesPath = "/var/dbms/node1"
+	expected.Cluster = []string{"node1:8081", "node2:8081", "node3:8081"}
+	expected.ClusterAddr = "node1:8081"
+	expected.ElectionTimeoutMs = 500
+	assert.Equal(t, expected, *l.CoreCfg())
+	assert.Equal(t, ServerConfig{TransportProtocol: "tcp", Port: 8081, MaxConnections: 100}, *l.SrvCfg())
+}
+
+func TestJSONConfigLoader_Replica(t *testing.T) {
+	// settings of replica from README
+	l := loadJSON(t, `{
+  "filesPath": "/backups/replica",
+  "replicaOf": "primary:8080",
+  "port": 8081
+}`)
+	defaults := new(DefaultConfigLoader)
+	defaults.Load()
+	expected := *defaults.CoreCfg()
+	expected.FilesPath = "/backups/replica"
+	expected.ReplicaOf = "primary:8080"
+	assert.Equal(t, expected, *l.CoreCfg())
+	assert.Equal(t, 8081, l.SrvCfg().Port)
+}
+
+func TestJSONConfigLoader_SyncReplication(t *testing.T) {
+	// settings of primary with synchronous replication from README
+	l := loadJSON(t, `{
+  "syncReplicas": 1,
+  "syncReplicationTimeoutMs": 1000,
+  "syncReplicationFallback": "error"
+}`)
+	cfg := l.CoreCfg()
+	assert.Equal(t, 1, cfg.SyncReplicas)
+	assert.Equal(t, time.Second, cfg.SyncReplicationTimeout())
+	assert.Equal(t, SyncFallbackError, cfg.SyncFallbackMode())
+	assert.Equal(t, 8*KB, cfg.PageSize)
+}
@@ -3,55 +3,77 @@ package bp_tree
 import (
 	"dbms/internal/core/storage/adapters/bp_tree"
 	"errors"
-	"sync"
+	"log"
 )
 
 var (
 	ErrKeyNotFound = errors.New("Not found")
+	ErrKeyTooLong  = errors.New("key is too long")
 )
 
-const DefaultT = 100
-
-// locking inspired by Lehman and Yao whitepaper (Efficient Locking for Concurrent Operations on B-Trees)
+// BPTree nodes are protected by tree latch of transaction (see transaction.Tx LatchTree): searches hold it
+// in shared mode, while inserts and deletes, which split and merge nodes, hold it exclusively
 type BPTree struct {
-	// deleteLock allows exclusive deletes or concurrent insert/reads;
-	// insertLock makes insert exclusive;
-	// this locking scheme guarantee:
-	//     1) delete locks other deletes, inserts, reads
-	//     2) insert locks other inserts
-	// so reads are nearly lock-free (except rare delete calls)
-	deleteLock sync.RWMutex
-	insertLock sync.Mutex
-	t          int
-	rw         *bpTreeReaderWriter
+	t int
+	// maxKeyLength is a max length of key which fits node page
+	maxKeyLength int
+	hdrPos       int64
+	rw           *bpTreeReaderWriter
 }
 
 func NewBPTree(t int, ba *bp_tree.BPTreeAdapter) *BPTree {
 	var tree BPTree
 	tree.t = t
+	tree.maxKeyLength = ba.MaxKeyLength()
 	tree.rw = NewBPTreeReaderWriter(t, ba)
+	tree.hdrPos = tree.rw.HeaderPos()
 	return &tree
 }
 
+// NewDefaultBPTree creates tree with the largest order which keeps nodes in single pages
 func NewDefaultBPTree(ba *bp_tree.BPTreeAdapter) *BPTree {
-	return NewBPTree(DefaultT, ba)
+	return NewBPTree(ba.Order(), ba)
 }
 
 func (t *BPTree) Init() {
 	if t.rw.NoDataFound() {
 		hdr := createDefaultNode(t.t)
-		t.rw.AppendNodeToStorage(hdr)
+		if hdrPos := t.rw.AppendNodeToStorage(hdr); hdrPos != t.hdrPos {
+			log.Panicf("header allocated at %d instead of %d", hdrPos, t.hdrPos)
+		}
 		root := createDefaultNode(t.t)
 		root.Leaf = true
 		rootPos := t.rw.AppendNodeToStorage(root)
 		hdr.Pointers[0] = rootPos
-		t.rw.WriteNodeToStorage(hdr, 0)
+		t.rw.WriteNodeToStorage(hdr, t.hdrPos)
 	}
 }
 
 func (t *BPTree) Find(key string) (int64, error) {
-	t.deleteLock.RLock()
-	defer t.deleteLock.RUnlock()
+	t.rw.LatchTree(false)
+	defer t.rw.UnlatchTree()
+	return t.find(key)
+}
+
+// FindLatched returns position of key's record with its page latched (see transaction.Tx LatchPage) and releases
+// pages latched before; page latch is tried with tree latched, so record isn't moved meanwhile (command which moves
+// record updates index before it unlatches page); otherwise latch is awaited without tree latch,
+// and search is repeated, because record may be moved till then
+func (t *BPTree) FindLatched(key string, exclusive bool) (int64, error) {
+	for {
+		t.rw.UnlatchPages()
+		t.rw.LatchTree(false)
+		pos, err := t.find(key)
+		latched := err == nil && t.rw.TryLatchPage(pos, exclusive)
+		t.rw.UnlatchTree()
+		if err != nil || latched {
+			return pos, err
+		}
+		t.rw.LatchPage(pos, exclusive)
+	}
+}
+
+func (t *BPTree) find(key string) (int64, error) {
 	pos := t.findLeafPos(key)
 	leaf := t.rw.ReadNodeFromStorage(pos)
 	keyPos := leaf.findKeyPos(key)
@@ -61,11 +83,12 @@ func (t *BPTree) Find(key string) (int64, error) {
 	return leaf.Pointers[keyPos], nil
 }
 
-func (t *BPTree) Insert(key string, ptr int64) {
-	t.deleteLock.RLock()
-	defer t.deleteLock.RUnlock()
-	t.insertLock.Lock()
-	defer t.insertLock.Unlock()
+func (t *BPTree) Insert(key string, ptr int64) error {
+	if len(key) > t.maxKeyLength {
+		return ErrKeyTooLong
+	}
+	t.rw.LatchTree(true)
+	defer t.rw.UnlatchTree()
 	pos := t.findLeafPos(key)
 	leaf := t.rw.ReadNodeFromStorage(pos)
 	// find write position in leaf
@@ -75,7 +98,7 @@ func (t *BPTree) Insert(key string, ptr int64) {
 			// check if key exists; only change addr value
 			leaf.Pointers[keyPos] = ptr
 			t.rw.WriteNodeToStorage(leaf, pos)
-			return
+			return nil
 		} else if key < leaf.Keys[keyPos] {
 			break
 		}
@@ -86,11 +109,12 @@ func (t *BPTree) Insert(key string, ptr int64) {
 	if leaf.Size == 2*t.t {
 		t.split(leaf, pos)
 	}
+	return nil
 }
 
 func (t *BPTree) Delete(key string) (int64, error) {
-	t.deleteLock.Lock()
-	defer t.deleteLock.Unlock()
+	t.rw.LatchTree(true)
+	defer t.rw.UnlatchTree()
 	pos := t.findLeafPos(key)
 	leaf := t.rw.ReadNodeFromStorage(pos)
 	keyPos := leaf.findKeyPos(key)
@@ -145,7 +169,7 @@ func (t *BPTree) Delete(key string) (int64, error) {
 
 func (t *BPTree) findLeafPos(key string) int64 {
 	var node *BPTreeNode
-	hdr := t.rw.ReadNodeFromStorage(0)
+	hdr := t.rw.ReadNodeFromStorage(t.hdrPos)
 	var pos = hdr.Pointers[0]
 	node = t.rw.ReadNodeFromStorage(pos)
 	rightPos := node.Right
@@ -174,7 +198,7 @@ func (t *BPTree) findLeafPos(key string) int64 {
 }
 
 func (t *BPTree) split(node *BPTreeNode, pos int64) {
-	hdr := t.rw.ReadNodeFromStorage(0)
+	hdr := t.rw.ReadNodeFromStorage(t.hdrPos)
 	for {
 		midKey := node.Keys[t.t]
 		midPtr := node.Pointers[t.t]
@@ -222,7 +246,7 @@ func (t *BPTree) split(node *BPTreeNode, pos int64) {
 			hdr.Pointers[0] = newRootPos
 			node.Parent = newRootPos
 			newNode.Parent = newRootPos
-			t.rw.WriteNodeToStorage(hdr, 0)
+			t.rw.WriteNodeToStorage(hdr, t.hdrPos)
 			t.rw.WriteNodeToStorage(node, pos)
 			t.rw.WriteNodeToStorage(newNode, newPos)
 		} else {
@@ -410,11 +434,13 @@ func (t *BPTree) deleteInternal(pos int64, key string, removeFirst bool) {
 				t.mergeInternalNodes(left, node)
 				t.rw.WriteNodeToStorage(left, node.Left)
 				t.unlinkNode(node)
+				// find separator before release; released node can't be read anymore
+				minKey := t.rw.ReadNodeFromStorage(t.findMinLeaf(node.Pointers[0])).Keys[0]
 				t.rw.ReleaseNodeInStorage(pos)
 				if node.Parent == -1 {
 					return
 				}
-				key = t.rw.ReadNodeFromStorage(t.findMinLeaf(pos)).Keys[0]
+				key = minKey
 				pos = node.Parent
 				removeFirst = left.Parent != node.Parent
 			} else if right != nil {
@@ -422,21 +448,22 @@ func (t *BPTree) deleteInternal(pos int64, key string, removeFirst bool) {
 				t.mergeInternalNodes(node, right)
 				t.rw.WriteNodeToStorage(node, pos)
 				t.unlinkNode(right)
+				minKey := t.rw.ReadNodeFromStorage(t.findMinLeaf(right.Pointers[0])).Keys[0]
 				t.rw.ReleaseNodeInStorage(node.Right)
 				if node.Parent == -1 {
 					return
 				}
 				pos = node.Parent
-				key = t.rw.ReadNodeFromStorage(t.findMinLeaf(node.Right)).Keys[0]
+				key = minKey
 			} else {
 				// root deletion case
-				hdr := t.rw.ReadNodeFromStorage(0)
+				hdr := t.rw.ReadNodeFromStorage(t.hdrPos)
 				rootPos := hdr.Pointers[0]
 				root := t.rw.ReadNodeFromStorage(rootPos)
 				if root.Size == 0 {
 					t.rw.ReleaseNodeInStorage(rootPos)
 					hdr.Pointers[0] = node.Pointers[0]
-					t.rw.WriteNodeToStorage(hdr, 0)
+					t.rw.WriteNodeToStorage(hdr, t.hdrPos)
 					node = t.rw.ReadNodeFromStorage(hdr.Pointers[0])
 					node.Left = -1
 					node.Right = -1
@@ -0,0 +1,96 @@
+package bp_tree
+
+import (
+	"io"
+	"strings"
+)
+
+// BPTreeIterator is a cursor over leaves chain;
+// walks keys in ascending order using right neighbour links of leaves; leaves are read with tree latched,
+// and iterator keeps copy of the current leaf, so the next one is found from the root again, because leaves
+// may be split or merged by concurrent commands meanwhile
+type BPTreeIterator struct {
+	t      *BPTree
+	node   *BPTreeNode
+	keyPos int
+	// last is the last returned key
+	last string
+}
+
+// Seek positions iterator at the first key greater or equal to passed key
+func (t *BPTree) Seek(key string) *BPTreeIterator {
+	i := new(BPTreeIterator)
+	i.t = t
+	i.load(key)
+	return i
+}
+
+// load reads leaf with the first key greater or equal to passed key; right leaves are read while
+// the current one has no such keys (e.g. it is empty)
+func (i *BPTreeIterator) load(key string) {
+	i.t.rw.LatchTree(false)
+	defer i.t.rw.UnlatchTree()
+	i.node = i.t.rw.ReadNodeFromStorage(i.t.findLeafPos(key))
+	i.keyPos = 0
+	for {
+		for i.keyPos < i.node.Size && i.node.Keys[i.keyPos] < key {
+			i.keyPos++
+		}
+		if i.keyPos < i.node.Size || i.node.Right == -1 {
+			return
+		}
+		i.node = i.t.rw.ReadNodeFromStorage(i.node.Right)
+		i.keyPos = 0
+	}
+}
+
+// Next returns key and pointer pair under cursor and moves cursor forward;
+// io.EOF is returned when leaves chain is exhausted
+func (i *BPTreeIterator) Next() (string, int64, error) {
+	if i.keyPos == i.node.Size {
+		if i.node.Right == -1 {
+			return "", -1, io.EOF
+		}
+		// loaded leaf is exhausted only after its keys are returned, so the last key is set
+		i.load(i.last + "\x00")
+		if i.keyPos == i.node.Size {
+			return "", -1, io.EOF
+		}
+	}
+	key := i.node.Keys[i.keyPos]
+	ptr := i.node.Pointers[i.keyPos]
+	i.keyPos++
+	i.last = key
+	return key, ptr, nil
+}
+
+// BPTreePrefixIterator is a BPTreeIterator bounded with keys having common prefix
+type BPTreePrefixIterator struct {
+	iter   *BPTreeIterator
+	prefix string
+}
+
+// SeekPrefix positions iterator at the first key with passed prefix which is greater or equal to start;
+// keys are ordered, so iteration stops at the first key without prefix
+func (t *BPTree) SeekPrefix(prefix string, start string) *BPTreePrefixIterator {
+	i := new(BPTreePrefixIterator)
+	i.prefix = prefix
+	if start < prefix {
+		start = prefix
+	}
+	i.iter = t.Seek(start)
+	return i
+}
+
+// Next returns key and pointer pair under cursor and moves cursor forward;
+// io.EOF is returned when there are no more keys with prefix
+func (i *BPTreePrefixIterator) Next() (string, int64, error) {
+	key, ptr, err := i.iter.Next()
+	if err != nil {
+		return "", -1, err
+	}
+	if !strings.HasPrefix(key, i.prefix) {
+		return "", -1, io.EOF
+	}
+	return key, ptr, nil
+}
@@ -51,10 +51,34 @@ func (rw *bpTreeReaderWriter) AppendNodeToStorage(n *BPTreeNode) int64 {
 	return rw.ba.WriteNode(marshalToStoreNode(n))
 }
 
-// ReleaseNodeInStorage marks node as free to use it for other nodes
+// ReleaseNodeInStorage marks node as free to use it for other nodes;
+// node must not be read after release
 func (rw *bpTreeReaderWriter) ReleaseNodeInStorage(pos int64) {
-	// TODO: add implementation
-	// rw.ba.ReleaseNode(pos)
+	rw.ba.ReleaseNode(pos)
+}
+
+func (rw *bpTreeReaderWriter) LatchTree(exclusive bool) {
+	rw.ba.LatchTree(exclusive)
+}
+
+func (rw *bpTreeReaderWriter) UnlatchTree() {
+	rw.ba.UnlatchTree()
+}
+
+func (rw *bpTreeReaderWriter) LatchPage(pos int64, exclusive bool) {
+	rw.ba.LatchPage(pos, exclusive)
+}
+
+func (rw *bpTreeReaderWriter) TryLatchPage(pos int64, exclusive bool) bool {
+	return rw.ba.TryLatchPage(pos, exclusive)
+}
+
+func (rw *bpTreeReaderWriter) UnlatchPages() {
+	rw.ba.UnlatchPages()
+}
+
+func (rw *bpTreeReaderWriter) HeaderPos() int64 {
+	return rw.ba.HeaderPos()
 }
 
 func (rw *bpTreeReaderWriter) NoDataFound() bool {
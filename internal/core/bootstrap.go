@@ -4,6 +4,7 @@ import (
 	"dbms/internal/config"
 	"dbms/internal/core/access/bp_tree"
 	"dbms/internal/core/concurrency"
+	"dbms/internal/core/storage"
 	bpAdapter "dbms/internal/core/storage/adapters/bp_tree"
 	"dbms/pkg"
 	"log"
@@ -48,13 +49,68 @@ func (m *BootstrapManager) Init() {
 	log.Printf(serverSplash, pkg.Version)
 	// load log segments
 	m.factory.SegMgr().LoadSegments()
+	m.validateCluster()
 	// init storage before recovery attempt
 	m.initStorage()
+	if m.cfg.ReplicaOf != "" {
+		m.initReplica()
+		return
+	}
 	// run recovery from journal
 	m.factory.RecMgr().RollForward(m.factory.TxMgr())
+	m.factory.TxMgr().RestoreIdCounter()
+	// recovered state is checkpointed, so journal of the previous runs is removed
+	m.factory.TxMgr().Checkpoint()
+	m.factory.VacuumMgr().Start()
+	m.factory.CheckpointMgr().Start()
+	m.factory.SyncMgr().Start()
+	if len(m.cfg.Cluster) != 0 {
+		// recovered storage has changes of applied entries, so node continues after them
+		m.factory.RaftNode().Start()
+	}
+}
+
+// validateCluster checks that changes committed by cluster can't be lost by node: they are applied
+// to storage of primary with synced commits only
+func (m *BootstrapManager) validateCluster() {
+	if len(m.cfg.Cluster) == 0 {
+		return
+	}
+	if m.cfg.ReplicaOf != "" {
+		log.Fatalln("Node of cluster can't be a replica")
+	}
+	if m.cfg.DurabilityMode() != config.DurabilitySync {
+		log.Fatalf("Node of cluster requires %s durability", config.DurabilitySync)
+	}
+	for _, addr := range m.cfg.Cluster {
+		if addr == m.cfg.ClusterAddr {
+			return
+		}
+	}
+	log.Fatalf("Cluster address %q is not in cluster %v", m.cfg.ClusterAddr, m.cfg.Cluster)
+}
+
+// initReplica replays journal of replica and starts receiving journal of primary; replica changes nothing
+// by itself, so ids counter isn't restored, and vacuum and checkpoints are left to primary
+func (m *BootstrapManager) initReplica() {
+	m.factory.TxMgr().SetReadOnly(true)
+	pos := m.factory.Replica().Init()
+	m.factory.Receiver().Start()
+	log.Printf("Replica of %s continues from journal position %d:%d", m.cfg.ReplicaOf, pos.SegId, pos.Offset)
 }
 
 func (m *BootstrapManager) Finalize() {
+	if len(m.cfg.Cluster) != 0 {
+		// node stops applying entries before transactions are stopped
+		m.factory.RaftNode().Stop()
+	}
+	if m.cfg.ReplicaOf != "" {
+		// replica stops applying shipments before journal is closed
+		m.factory.Receiver().Stop()
+	}
+	m.factory.SyncMgr().Stop()
+	m.factory.CheckpointMgr().Stop()
+	m.factory.VacuumMgr().Stop()
 	m.closeStrg()
 	m.factory.SegMgr().CloseSegments()
 }
@@ -67,6 +123,25 @@ func (m *BootstrapManager) openStrg() {
 	m.strgFile = strgFile
 }
 
+// validateStorage refuses storage of another format or created with another settings before any of its pages is read
+func (m *BootstrapManager) validateStorage() {
+	meta, err := storage.ReadFileMetaPage(m.strgFile)
+	if err != nil {
+		log.Fatalf("Storage %s can't be opened: %v", m.cfg.DataPath(), err)
+	}
+	if meta == nil {
+		return
+	}
+	if int(meta.PageSize) != m.cfg.PageSize {
+		log.Fatalf("Storage %s is created with page size %d, but pageSize setting is %d",
+			m.cfg.DataPath(), meta.PageSize, m.cfg.PageSize)
+	}
+	if int(meta.MaxKeyLength) != m.cfg.MaxKeyLength {
+		log.Fatalf("Storage %s is created with max key length %d, but maxKeyLength setting is %d",
+			m.cfg.DataPath(), meta.MaxKeyLength, m.cfg.MaxKeyLength)
+	}
+}
+
 func (m *BootstrapManager) closeStrg() {
 	if m.strgFile != nil {
 		m.strgFile.Close()
@@ -75,9 +150,20 @@ func (m *BootstrapManager) closeStrg() {
 
 func (m *BootstrapManager) initStorage() {
 	m.openStrg()
+	m.validateStorage()
 	// now TxMgr can access storage
 	tx := m.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
-	bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx)).Init()
-	tx.Commit()
+	if tx.NoDataFound() && m.cfg.ReplicaOf != "" {
+		log.Fatalf("Replica storage %s is empty: it must be restored from backup of primary", m.cfg.DataPath())
+	} else if tx.NoDataFound() {
+		bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, m.cfg.MaxKeyLength)).Init()
+		meta := tx.ReadMetaPage()
+		meta.MaxKeyLength = int32(m.cfg.MaxKeyLength)
+		tx.WriteMetaPage(meta)
+		tx.Commit()
+	} else {
+		// nothing is journaled before recovery, otherwise commit record would finish journal of the previous run
+		tx.Abort()
+	}
 	log.Printf("Initialized storage %s", m.cfg.DataPath())
 }
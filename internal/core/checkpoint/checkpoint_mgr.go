@@ -0,0 +1,45 @@
+package checkpoint
+
+import (
+	"dbms/internal/core/transaction"
+	"dbms/internal/utils"
+	"time"
+)
+
+// CheckpointManager makes checkpoints periodically, so journal size and recovery time stay bounded
+// under continuous load
+type CheckpointManager struct {
+	txMgr  *transaction.TxManager
+	runner *utils.PeriodicRunner
+	// compact removes log entries kept by storage after checkpoint, e.g. entries of Raft log
+	compact func()
+}
+
+func NewCheckpointManager(txMgr *transaction.TxManager, interval time.Duration) *CheckpointManager {
+	m := new(CheckpointManager)
+	m.txMgr = txMgr
+	m.runner = utils.NewPeriodicRunner(interval, m.checkpoint)
+	return m
+}
+
+// SetCompaction sets function called after each checkpoint, so logs other than journal are bounded too
+func (m *CheckpointManager) SetCompaction(compact func()) {
+	m.compact = compact
+}
+
+// Start runs checkpoints periodically in background; non-positive interval disables them,
+// so journal is truncated only by checkpoint made after recovery
+func (m *CheckpointManager) Start() {
+	m.runner.Start()
+}
+
+func (m *CheckpointManager) Stop() {
+	m.runner.Stop()
+}
+
+func (m *CheckpointManager) checkpoint() {
+	m.txMgr.Checkpoint()
+	if m.compact != nil {
+		m.compact()
+	}
+}
@@ -0,0 +1,183 @@
+package core
+
+import (
+	"bytes"
+	"dbms/internal/core/raft"
+	"dbms/internal/core/transaction"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotCommitted is returned if changes are replaced by entry of another leader
+	ErrNotCommitted = errors.New("changes are not committed by cluster: leader is changed")
+	// ErrCommitUnknown is returned if cluster doesn't commit changes in time; they may be committed later
+	ErrCommitUnknown = errors.New("changes are not committed by cluster in time, they may be committed later")
+)
+
+// commitWaitFactor defines time which commit waits for cluster in election timeouts
+const commitWaitFactor = 10
+
+// proposal is a log entry proposed by local transaction, which waits till entry is applied
+type proposal struct {
+	term int64
+	// ready passes if entry is committed as proposed
+	ready chan bool
+	// done is closed when transaction has committed changes locally
+	done chan struct{}
+}
+
+// txLog replicates changes of transactions by Raft log: leader proposes changes of committing transaction
+// and commits them locally when their entry is applied, while other nodes apply entry by transaction of their own;
+// entries are applied one by one, so changes are committed in the same order on all nodes
+type txLog struct {
+	node       *raft.Node
+	txMgr      *transaction.TxManager
+	commitWait time.Duration
+	mux        sync.Mutex
+	proposals  map[int64]*proposal
+}
+
+func newTxLog(node *raft.Node, txMgr *transaction.TxManager, electionTimeout time.Duration) *txLog {
+	l := new(txLog)
+	l.node = node
+	l.txMgr = txMgr
+	l.commitWait = commitWaitFactor * electionTimeout
+	l.proposals = make(map[int64]*proposal)
+	return l
+}
+
+func (l *txLog) Replicate(changes []transaction.KeyChange) (func(), error) {
+	p := &proposal{ready: make(chan bool, 1), done: make(chan struct{})}
+	// proposal is registered before its entry may be applied
+	l.mux.Lock()
+	index, term, err := l.node.Propose(marshalChanges(changes))
+	if err != nil {
+		l.mux.Unlock()
+		return nil, err
+	}
+	p.term = term
+	l.proposals[index] = p
+	l.mux.Unlock()
+	var committed bool
+	select {
+	case committed = <-p.ready:
+	case <-time.After(l.commitWait):
+		l.mux.Lock()
+		_, waiting := l.proposals[index]
+		delete(l.proposals, index)
+		l.mux.Unlock()
+		if waiting {
+			// entry is applied by transaction of its own if it is committed later
+			return nil, ErrCommitUnknown
+		}
+		committed = <-p.ready
+	}
+	if !committed {
+		return nil, ErrNotCommitted
+	}
+	return func() { close(p.done) }, nil
+}
+
+func (l *txLog) Apply(index int64, term int64, data []byte) {
+	l.mux.Lock()
+	p := l.proposals[index]
+	delete(l.proposals, index)
+	for i, other := range l.proposals {
+		// entries of older terms are never committed after entry of this term
+		if other.term < term {
+			other.ready <- false
+			delete(l.proposals, i)
+		}
+	}
+	l.mux.Unlock()
+	if p != nil && p.term == term {
+		p.ready <- true
+		<-p.done
+		return
+	} else if p != nil {
+		p.ready <- false
+	}
+	// entries with empty data are appended by new leaders
+	if len(data) == 0 {
+		return
+	}
+	changes, err := unmarshalChanges(data)
+	if err != nil {
+		log.Panicf("raft entry %d: %v", index, err)
+	}
+	if err := l.txMgr.ApplyChanges(changes, l.node.Done()); err != nil {
+		// entry isn't marked applied by stopped node, so it is applied after restart
+		log.Printf("Raft entry %d: %v", index, err)
+	}
+}
+
+func marshalChanges(changes []transaction.KeyChange) []byte {
+	buf := new(bytes.Buffer)
+	writeUint32(buf, uint32(len(changes)))
+	for _, c := range changes {
+		writeUint32(buf, uint32(len(c.Key)))
+		buf.WriteString(c.Key)
+		if c.Image.Deleted {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+		writeUint32(buf, uint32(len(c.Image.Value)))
+		buf.Write(c.Image.Value)
+	}
+	return buf.Bytes()
+}
+
+func unmarshalChanges(data []byte) ([]transaction.KeyChange, error) {
+	r := bytes.NewReader(data)
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, err
+	}
+	changes := make([]transaction.KeyChange, 0, count)
+	for i := uint32(0); i < count; i++ {
+		key, err := readChunk(r)
+		if err != nil {
+			return nil, err
+		}
+		deleted, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		value, err := readChunk(r)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, transaction.KeyChange{
+			Key:   string(key),
+			Image: transaction.KeyImage{Value: value, Deleted: deleted == 1},
+		})
+	}
+	return changes, nil
+}
+
+func writeUint32(buf *bytes.Buffer, v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	buf.Write(b[:])
+}
+
+func readChunk(r *bytes.Reader) ([]byte, error) {
+	var size uint32
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return nil, err
+	}
+	if int64(size) > int64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	chunk := make([]byte, size)
+	if _, err := io.ReadFull(r, chunk); err != nil {
+		return nil, err
+	}
+	return chunk, nil
+}
@@ -1,14 +1,16 @@
 package concurrency
 
-import (
-	"errors"
-	"time"
-)
+import "errors"
 
 const (
 	SharedMode    = 0
 	ExclusiveMode = 1
 	UpdateMode    = 2
+	// SnapshotMode is a transaction mode only; snapshot transactions don't lock keys
+	SnapshotMode = 3
+	// SerializableMode is a transaction mode only; serializable transactions are snapshot ones,
+	// which reads are validated at commit
+	SerializableMode = 4
 )
 
 var locksCompatMatrix = [][]bool{
@@ -17,6 +19,7 @@ var locksCompatMatrix = [][]bool{
 	{true, false, false},
 }
 
-const lockTimeout = 10 * time.Second
-
-var ErrTxLockTimeout = errors.New("Lock timeout exceeded")
+var (
+	ErrTxLockTimeout = errors.New("Lock timeout exceeded")
+	ErrDeadlock      = errors.New("Deadlock detected: transaction is aborted")
+)
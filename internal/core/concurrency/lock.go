@@ -45,12 +45,14 @@ func (l *Lock) Lock(mode int) {
 
 func (l *Lock) Unlock() {
 	l.mux.Lock()
-	defer func() {
-		l.updateCond.Broadcast()
-		l.mux.Unlock()
-	}()
 	if l.refcount == 0 {
+		l.mux.Unlock()
 		log.Panicf("Trying unlock unlocked lock")
 	}
 	l.refcount--
+	l.mux.Unlock()
+	// waiter holds cond mutex from failed attempt till wait, so wakeup isn't lost between them
+	l.updateCondMux.Lock()
+	l.updateCond.Broadcast()
+	l.updateCondMux.Unlock()
 }
@@ -6,77 +6,228 @@ import (
 	"time"
 )
 
+// lockRequest describes lock which transaction is blocked on
+type lockRequest struct {
+	key      interface{}
+	txId     int
+	mode     int
+	upgrade  bool
+	timedOut bool
+}
+
 type lockTableRecord struct {
-	mode       int
-	acquirers  int
+	mode int
+	// holders maps transaction id to the number of its acquisitions
+	holders    map[int]int
 	updateTxId int
+	// queue keeps blocked requests in arrival order
+	queue []*lockRequest
+	// cond is bound to table mutex; it is broadcasted on every change of record
+	cond *sync.Cond
+}
+
+// compatible checks if lock in passed mode can be shared with current holders
+func (rec *lockTableRecord) compatible(mode int) bool {
+	return len(rec.holders) == 0 || locksCompatMatrix[rec.mode][mode]
+}
+
+// grantable checks if request can be granted; requests are granted in FIFO order,
+// so request waits for earlier incompatible requests (writers are not starved by readers);
+// upgrade requests are not queued behind new requests, because their holders already own the lock
+func (rec *lockTableRecord) grantable(req *lockRequest) bool {
+	if req.upgrade {
+		return rec.mode != UpdateMode || rec.updateTxId == req.txId
+	}
+	if !rec.compatible(req.mode) {
+		return false
+	}
+	for _, queued := range rec.queue {
+		if queued == req {
+			break
+		}
+		if !queued.upgrade && !locksCompatMatrix[queued.mode][req.mode] {
+			return false
+		}
+	}
+	return true
+}
+
+func (rec *lockTableRecord) grant(txId int, mode int) {
+	if len(rec.holders) == 0 {
+		rec.mode = mode
+	}
+	rec.holders[txId]++
+}
+
+func (rec *lockTableRecord) dequeue(req *lockRequest) {
+	for i, queued := range rec.queue {
+		if queued == req {
+			rec.queue = append(rec.queue[:i], rec.queue[i+1:]...)
+			return
+		}
+	}
 }
 
-// TODO: return cond variables on lock/unlock
 type LockTable struct {
 	// table related data
 	tableMux sync.Mutex
 	table    map[interface{}]*lockTableRecord
+	graph    *waitForGraph
 }
 
 func NewLockTable() *LockTable {
 	var t LockTable
 	t.table = make(map[interface{}]*lockTableRecord)
+	t.graph = newWaitForGraph()
 	return &t
 }
 
-func (t *LockTable) TryLock(key interface{}, mode int) bool {
+func (t *LockTable) record(key interface{}) *lockTableRecord {
+	rec, found := t.table[key]
+	if !found {
+		rec = new(lockTableRecord)
+		rec.holders = make(map[int]int)
+		rec.cond = sync.NewCond(&t.tableMux)
+		t.table[key] = rec
+	}
+	return rec
+}
+
+// release removes record if nobody holds or waits for it
+func (t *LockTable) release(key interface{}, rec *lockTableRecord) {
+	if len(rec.holders) == 0 && len(rec.queue) == 0 {
+		delete(t.table, key)
+	}
+}
+
+func (t *LockTable) TryLock(key interface{}, txId int, mode int) bool {
 	t.tableMux.Lock()
 	defer t.tableMux.Unlock()
-	rec, found := t.table[key]
-	if found {
-		if !locksCompatMatrix[rec.mode][mode] {
-			return false
-		}
-		rec.acquirers++
-	} else {
-		newRec := new(lockTableRecord)
-		newRec.mode = mode
-		newRec.acquirers = 1
-		t.table[key] = newRec
+	rec := t.record(key)
+	req := &lockRequest{key: key, txId: txId, mode: mode}
+	if !rec.grantable(req) {
+		t.release(key, rec)
+		return false
 	}
+	rec.grant(txId, mode)
 	return true
 }
 
-func (t *LockTable) Lock(key interface{}, mode int) {
-	start := time.Now()
-	for !t.TryLock(key, mode) {
-		if time.Now().Sub(start) > lockTimeout {
+// Lock blocks until lock is acquired; panics with ErrTxLockTimeout if lock is not acquired in timeout
+// and with ErrDeadlock if transaction is chosen as deadlock victim
+func (t *LockTable) Lock(key interface{}, txId int, mode int, timeout time.Duration) {
+	t.tableMux.Lock()
+	defer t.tableMux.Unlock()
+	rec := t.record(key)
+	req := &lockRequest{key: key, txId: txId, mode: mode}
+	if !rec.grantable(req) {
+		t.await(rec, req, timeout)
+	}
+	rec.grant(txId, mode)
+}
+
+func (t *LockTable) UpgradeLock(key interface{}, txId int, timeout time.Duration) {
+	t.tableMux.Lock()
+	defer t.tableMux.Unlock()
+	rec, found := t.table[key]
+	if !found {
+		log.Panicf("%v key not found", key)
+	}
+	req := &lockRequest{key: key, txId: txId, mode: UpdateMode, upgrade: true}
+	if !rec.grantable(req) {
+		t.await(rec, req, timeout)
+	}
+	if rec.mode == SharedMode {
+		rec.mode = UpdateMode
+		rec.updateTxId = txId
+	}
+}
+
+// await puts request into record's queue and sleeps until request becomes grantable;
+// must be called with table mutex held
+func (t *LockTable) await(rec *lockTableRecord, req *lockRequest, timeout time.Duration) {
+	rec.queue = append(rec.queue, req)
+	t.graph.Wait(req.txId, req)
+	timer := time.AfterFunc(timeout, func() {
+		t.tableMux.Lock()
+		defer t.tableMux.Unlock()
+		req.timedOut = true
+		rec.cond.Broadcast()
+	})
+	granted := false
+	defer func() {
+		timer.Stop()
+		rec.dequeue(req)
+		t.graph.StopWaiting(req.txId)
+		if !granted {
+			t.release(req.key, rec)
+		}
+		// queue is changed, so requests behind may become grantable
+		rec.cond.Broadcast()
+	}()
+	for {
+		t.detectDeadlock(req.txId)
+		if req.timedOut {
 			panic(ErrTxLockTimeout)
 		}
+		if rec.grantable(req) {
+			granted = true
+			return
+		}
+		rec.cond.Wait()
 	}
 }
 
-func (t *LockTable) UpgradeLock(key interface{}, txId int) {
-	start := time.Now()
-	for {
-		mustRet := func() bool {
-			t.tableMux.Lock()
-			defer t.tableMux.Unlock()
-			rec, found := t.table[key]
-			if !found {
-				log.Panicf("%v key not found", key)
-			}
-			if rec.mode == SharedMode {
-				rec.mode = UpdateMode
-				rec.updateTxId = txId
-			} else if rec.mode == UpdateMode {
-				return rec.updateTxId == txId
+// detectDeadlock checks if blocked transaction closes a cycle in wait-for graph;
+// the youngest transaction of cycle is aborted with ErrDeadlock
+func (t *LockTable) detectDeadlock(txId int) {
+	if t.graph.Victim(txId) {
+		panic(ErrDeadlock)
+	}
+	cycle := t.graph.FindCycle(txId, t.waitsFor)
+	if cycle == nil {
+		return
+	}
+	victim := chooseVictim(cycle)
+	if victim == txId {
+		panic(ErrDeadlock)
+	}
+	// victim is blocked too, so it is woken up to notice abort
+	t.graph.SetVictim(victim)
+	t.table[t.graph.waiting[victim].key].cond.Broadcast()
+}
+
+// waitsFor returns transactions which block request of passed transaction:
+// incompatible holders and transactions of earlier incompatible requests
+func (t *LockTable) waitsFor(txId int) []int {
+	req, found := t.graph.waiting[txId]
+	if !found {
+		return nil
+	}
+	rec := t.table[req.key]
+	if req.upgrade {
+		if rec.mode == UpdateMode && rec.updateTxId != txId {
+			return []int{rec.updateTxId}
+		}
+		return nil
+	}
+	var blockers []int
+	if !rec.compatible(req.mode) {
+		for holder := range rec.holders {
+			if holder != txId {
+				blockers = append(blockers, holder)
 			}
-			return true
-		}()
-		if mustRet {
-			return
 		}
-		if time.Now().Sub(start) > lockTimeout {
-			panic(ErrTxLockTimeout)
+	}
+	for _, queued := range rec.queue {
+		if queued == req {
+			break
+		}
+		if !queued.upgrade && !locksCompatMatrix[queued.mode][req.mode] && queued.txId != txId {
+			blockers = append(blockers, queued.txId)
 		}
 	}
+	return blockers
 }
 
 func (t *LockTable) DowngradeLock(key interface{}) {
@@ -90,21 +241,27 @@ func (t *LockTable) DowngradeLock(key interface{}) {
 	}
 	if rec.mode == UpdateMode {
 		rec.mode = SharedMode
+		rec.cond.Broadcast()
 	}
 }
 
-func (t *LockTable) Unlock(key interface{}) {
+func (t *LockTable) Unlock(key interface{}, txId int) {
 	t.tableMux.Lock()
 	defer func() {
 		t.tableMux.Unlock()
 	}()
 	rec, found := t.table[key]
-	if found {
-		rec.acquirers--
-	} else {
+	if !found || rec.holders[txId] == 0 {
 		log.Panicf("Trying unlock unlocked key %v", key)
 	}
-	if rec.acquirers == 0 {
-		delete(t.table, key)
+	rec.holders[txId]--
+	if rec.holders[txId] == 0 {
+		delete(rec.holders, txId)
+		if rec.mode == UpdateMode && rec.updateTxId == txId {
+			// let other holders upgrade lock
+			rec.mode = SharedMode
+		}
 	}
+	rec.cond.Broadcast()
+	t.release(key, rec)
 }
@@ -0,0 +1,124 @@
+package concurrency
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+const timeout = 10 * time.Second
+
+// lockAsync runs lock attempt in background; recovered panic value is sent to channel
+func lockAsync(lock func()) chan interface{} {
+	done := make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			done <- recover()
+		}()
+		lock()
+	}()
+	return done
+}
+
+func TestLockTable_Deadlock(t *testing.T) {
+	table := NewLockTable()
+	table.Lock("a", 1, ExclusiveMode, timeout)
+	table.Lock("b", 2, ExclusiveMode, timeout)
+	older := lockAsync(func() { table.Lock("b", 1, ExclusiveMode, timeout) })
+	younger := lockAsync(func() { table.Lock("a", 2, ExclusiveMode, timeout) })
+	// the youngest tx is aborted
+	assert.Equal(t, ErrDeadlock, <-younger)
+	table.Unlock("b", 2)
+	assert.Nil(t, <-older)
+	table.Unlock("a", 1)
+	table.Unlock("b", 1)
+	assert.Empty(t, table.table)
+}
+
+func TestLockTable_UpgradeDeadlock(t *testing.T) {
+	table := NewLockTable()
+	for _, key := range []string{"a", "b"} {
+		table.Lock(key, 1, SharedMode, timeout)
+		table.Lock(key, 2, SharedMode, timeout)
+	}
+	table.UpgradeLock("a", 2, timeout)
+	table.UpgradeLock("b", 1, timeout)
+	younger := lockAsync(func() { table.UpgradeLock("b", 2, timeout) })
+	older := lockAsync(func() { table.UpgradeLock("a", 1, timeout) })
+	assert.Equal(t, ErrDeadlock, <-younger)
+	// release locks of aborted tx
+	table.Unlock("a", 2)
+	table.Unlock("b", 2)
+	assert.Nil(t, <-older)
+}
+
+func TestLockTable_NoDeadlock(t *testing.T) {
+	table := NewLockTable()
+	table.Lock("a", 1, ExclusiveMode, timeout)
+	first := lockAsync(func() { table.Lock("a", 2, ExclusiveMode, timeout) })
+	second := lockAsync(func() { table.Lock("a", 3, SharedMode, timeout) })
+	table.Unlock("a", 1)
+	// waiters chain without cycle is resolved by releases
+	select {
+	case err := <-first:
+		assert.Nil(t, err)
+		table.Unlock("a", 2)
+		assert.Nil(t, <-second)
+	case err := <-second:
+		assert.Nil(t, err)
+		table.Unlock("a", 3)
+		assert.Nil(t, <-first)
+	}
+}
+
+func TestLockTable_Timeout(t *testing.T) {
+	table := NewLockTable()
+	table.Lock("a", 1, SharedMode, timeout)
+	start := time.Now()
+	waiter := lockAsync(func() { table.Lock("a", 2, ExclusiveMode, 50*time.Millisecond) })
+	assert.Equal(t, ErrTxLockTimeout, <-waiter)
+	assert.Less(t, int64(time.Now().Sub(start)), int64(time.Second))
+	// timed out request doesn't block others
+	table.Lock("a", 3, SharedMode, timeout)
+	table.Unlock("a", 1)
+	table.Unlock("a", 3)
+	assert.Empty(t, table.table)
+}
+
+// waitQueued waits until key has passed number of queued requests
+func waitQueued(table *LockTable, key string, requests int) {
+	for {
+		table.tableMux.Lock()
+		queued := 0
+		if rec, found := table.table[key]; found {
+			queued = len(rec.queue)
+		}
+		table.tableMux.Unlock()
+		if queued == requests {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLockTable_Fairness(t *testing.T) {
+	table := NewLockTable()
+	table.Lock("a", 1, SharedMode, timeout)
+	writer := lockAsync(func() { table.Lock("a", 2, ExclusiveMode, timeout) })
+	waitQueued(table, "a", 1)
+	// reader doesn't overtake queued writer
+	assert.False(t, table.TryLock("a", 3, SharedMode))
+	reader := lockAsync(func() { table.Lock("a", 3, SharedMode, timeout) })
+	waitQueued(table, "a", 2)
+	table.Unlock("a", 1)
+	assert.Nil(t, <-writer)
+	select {
+	case <-reader:
+		t.Fatal("reader acquired lock held by writer")
+	case <-time.After(10 * time.Millisecond):
+	}
+	table.Unlock("a", 2)
+	assert.Nil(t, <-reader)
+	table.Unlock("a", 3)
+	assert.Empty(t, table.table)
+}
@@ -0,0 +1,75 @@
+package concurrency
+
+// waitForGraph tracks blocked transactions; edges lead from blocked transaction
+// to transactions holding requested lock or queued for it earlier, so cycle in graph means deadlock;
+// edges are not stored, but calculated with actual lock table state, so they are never stale
+type waitForGraph struct {
+	waiting map[int]*lockRequest
+	// victims are transactions chosen to be aborted to break cycles
+	victims map[int]struct{}
+}
+
+func newWaitForGraph() *waitForGraph {
+	g := new(waitForGraph)
+	g.waiting = make(map[int]*lockRequest)
+	g.victims = make(map[int]struct{})
+	return g
+}
+
+func (g *waitForGraph) Wait(txId int, req *lockRequest) {
+	g.waiting[txId] = req
+}
+
+func (g *waitForGraph) StopWaiting(txId int) {
+	delete(g.waiting, txId)
+	delete(g.victims, txId)
+}
+
+func (g *waitForGraph) SetVictim(txId int) {
+	g.victims[txId] = struct{}{}
+}
+
+func (g *waitForGraph) Victim(txId int) bool {
+	_, found := g.victims[txId]
+	return found
+}
+
+// FindCycle returns transactions forming cycle which passes through start transaction;
+// nil is returned if there is no such cycle
+func (g *waitForGraph) FindCycle(start int, waitsFor func(txId int) []int) []int {
+	visited := make(map[int]struct{})
+	var path []int
+	var visit func(txId int) bool
+	visit = func(txId int) bool {
+		path = append(path, txId)
+		visited[txId] = struct{}{}
+		for _, next := range waitsFor(txId) {
+			if next == start {
+				return true
+			}
+			if _, found := visited[next]; found {
+				continue
+			}
+			if visit(next) {
+				return true
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+	if visit(start) {
+		return path
+	}
+	return nil
+}
+
+// chooseVictim picks the youngest transaction, because it has done less work
+func chooseVictim(cycle []int) int {
+	victim := cycle[0]
+	for _, txId := range cycle {
+		if txId > victim {
+			victim = txId
+		}
+	}
+	return victim
+}
@@ -14,8 +14,8 @@ import (
 
 const workers = 1
 
-func txInsert(tx transaction.Tx, key string, value int64) {
-	tree := bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx))
+func txInsert(tx transaction.Tx, cfg *config.CoreConfig, key string, value int64) {
+	tree := bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, cfg.MaxKeyLength))
 	defer tx.Commit()
 	tree.Insert(key, int64(1))
 }
@@ -45,6 +45,7 @@ func Benchmark_CoreInsert(b *testing.B) {
 			go func(key string){
 				txInsert(
 					coreFactory.TxMgr().InitTx(concurrency.ExclusiveMode),
+					cfgLdr.CoreCfg(),
 					key,
 					int64(1),
 				)
@@ -2,11 +2,26 @@ package core
 
 import (
 	"testing"
+	"errors"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"strconv"
+	"strings"
+	"time"
 	"dbms/internal/config"
 	"dbms/internal/core/concurrency"
+	"dbms/internal/core/access/bp_tree"
+	"dbms/internal/core/logging"
+	"dbms/internal/core/restore"
+	"dbms/internal/core/storage"
+	"dbms/internal/core/transaction"
+	"dbms/internal/utils"
+	bpAdapter "dbms/internal/core/storage/adapters/bp_tree"
+	dataAdapter "dbms/internal/core/storage/adapters/data"
+	"github.com/stretchr/testify/assert"
 )
 
 func Test_CoreInsert(t *testing.T) {
@@ -32,6 +47,7 @@ func Test_CoreInsert(t *testing.T) {
 		go func(key string){
 			txInsert(
 				coreFactory.TxMgr().InitTx(concurrency.ExclusiveMode),
+				cfgLdr.CoreCfg(),
 				key,
 				int64(1),
 			)
@@ -40,3 +56,997 @@ func Test_CoreInsert(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+// Test_CorePagesReuse checks released pages are reused instead of storage extension
+func Test_CorePagesReuse(t *testing.T) {
+	// prepare
+	tc := newTestCore(t, nil)
+	// test
+	keys := 500
+	insertAll := func(prefix string) {
+		tx := tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+		tree := bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, tc.cfg.MaxKeyLength))
+		da := dataAdapter.NewDataAdapter(tx)
+		for i := 0; i < keys; i++ {
+			key := prefix + strconv.Itoa(i)
+			pos, err := da.Write(key, []byte(key))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tree.Insert(key, pos)
+		}
+		tx.Commit()
+	}
+	deleteAll := func(prefix string) {
+		tx := tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+		tree := bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, tc.cfg.MaxKeyLength))
+		da := dataAdapter.NewDataAdapter(tx)
+		for i := 0; i < keys; i++ {
+			key := prefix + strconv.Itoa(i)
+			pos, err := tree.Delete(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := da.DeleteAtPos(key, pos); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tx.Commit()
+	}
+	storageSize := func() int64 {
+		info, err := os.Stat(tc.cfg.DataPath())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Size()
+	}
+	insertAll("a")
+	deleteAll("a")
+	size := storageSize()
+	insertAll("b")
+	assert.Equal(t, size, storageSize())
+}
+
+// Test_CoreRecordsPacking checks small records share data pages
+func Test_CoreRecordsPacking(t *testing.T) {
+	// prepare
+	tc := newTestCore(t, nil)
+	// test
+	keys := 1000
+	tx := tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+	tree := bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, tc.cfg.MaxKeyLength))
+	da := dataAdapter.NewDataAdapter(tx)
+	positions := make(map[int64]struct{})
+	for i := 0; i < keys; i++ {
+		key := strconv.Itoa(i)
+		pos, err := da.Write(key, []byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree.Insert(key, pos)
+		positions[pos] = struct{}{}
+	}
+	// grow one record, so it doesn't fit its page anymore
+	pos, err := tree.Find("0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// value is still small enough to be stored inline
+	bigValue := make([]byte, tc.cfg.PageSize/5)
+	newPos, err := da.WriteAtPos("0", bigValue, pos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree.Insert("0", newPos)
+	tx.Commit()
+	assert.Less(t, len(positions), keys/50)
+	assert.NotEqual(t, pos, newPos)
+	tx = tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+	defer tx.Commit()
+	data, err := dataAdapter.NewDataAdapter(tx).FindAtPos("0", newPos)
+	assert.Nil(t, err)
+	assert.Equal(t, bigValue, data)
+}
+
+// Test_CoreFreeSpaceReuse checks space freed in the first data page is found after pages are filled
+func Test_CoreFreeSpaceReuse(t *testing.T) {
+	// prepare
+	tc := newTestCore(t, nil)
+	// test
+	keys := 200
+	value := make([]byte, tc.cfg.PageSize/5)
+	tx := tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+	da := dataAdapter.NewDataAdapter(tx)
+	positions := make([]int64, keys)
+	for i := 0; i < keys; i++ {
+		pos, err := da.Write(strconv.Itoa(i), value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		positions[i] = pos
+	}
+	tx.Commit()
+	tx = tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+	defer tx.Commit()
+	da = dataAdapter.NewDataAdapter(tx)
+	if err := da.DeleteAtPos("0", positions[0]); err != nil {
+		t.Fatal(err)
+	}
+	pos, err := da.Write(strconv.Itoa(keys), value)
+	assert.Nil(t, err)
+	assert.Equal(t, positions[0], pos)
+	assert.Equal(t, tx.ReadMetaPage().FreeSpaceMapHead, tx.ReadMetaPage().FreeSpaceMapHint)
+}
+
+// Test_CoreLongKeys checks nodes filled with keys of max length fit pages and longer keys are rejected
+func Test_CoreLongKeys(t *testing.T) {
+	// prepare
+	tc := newTestCore(t, nil)
+	// test
+	keys := 1000
+	maxKeyLength := tc.cfg.MaxKeyLength
+	longKey := func(i int) string {
+		key := strconv.Itoa(i)
+		return strings.Repeat("k", maxKeyLength-len(key)) + key
+	}
+	tx := tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+	defer tx.Commit()
+	tree := bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, maxKeyLength))
+	for i := 0; i < keys; i++ {
+		assert.Nil(t, tree.Insert(longKey(i), int64(i)))
+	}
+	for i := 0; i < keys; i++ {
+		pos, err := tree.Find(longKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, int64(i), pos)
+	}
+	assert.Equal(t, bp_tree.ErrKeyTooLong, tree.Insert(longKey(0)+"k", 0))
+}
+
+// Test_CoreVacuum checks vacuum keeps versions visible to snapshots and removes deleted records afterwards
+func Test_CoreVacuum(t *testing.T) {
+	// prepare
+	tc := newTestCore(t, nil)
+	// test
+	maxKeyLength := tc.cfg.MaxKeyLength
+	txMgr := tc.factory.TxMgr()
+	tx := txMgr.InitTx(concurrency.ExclusiveMode)
+	tree := bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, maxKeyLength))
+	pos, err := dataAdapter.NewDataAdapter(tx).Write("key", []byte("old"))
+	assert.Nil(t, err)
+	assert.Nil(t, tree.Insert("key", pos))
+	tx.Commit()
+	snapshot := txMgr.InitTx(concurrency.SnapshotMode)
+	tx = txMgr.InitTx(concurrency.ExclusiveMode)
+	pos, err = bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, maxKeyLength)).Find("key")
+	assert.Nil(t, err)
+	newPos, err := dataAdapter.NewDataAdapter(tx).MarkDeletedAtPos("key", pos)
+	assert.Nil(t, err)
+	assert.Equal(t, pos, newPos)
+	tx.Commit()
+	// deleted record is still visible to snapshot
+	tc.factory.VacuumMgr().Vacuum()
+	data, err := dataAdapter.NewDataAdapter(snapshot).FindAtPos("key", pos)
+	snapshot.DowngradeLocks()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("old"), data)
+	snapshot.Commit()
+	tc.factory.VacuumMgr().Vacuum()
+	tx = txMgr.InitTx(concurrency.ExclusiveMode)
+	defer tx.Commit()
+	_, err = bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, maxKeyLength)).Find("key")
+	assert.Equal(t, bp_tree.ErrKeyNotFound, err)
+}
+
+// Test_CoreRecoveryUndo checks changes of unfinished transaction, which are flushed by commit of another one,
+// are rolled back during recovery
+func Test_CoreRecoveryUndo(t *testing.T) {
+	// prepare
+	tc := newTestCore(t, nil)
+	// test
+	maxKeyLength := tc.cfg.MaxKeyLength
+	write := func(tx transaction.Tx, key string) {
+		tx.LockKey(key, true)
+		pos, err := dataAdapter.NewDataAdapter(tx).Write(key, []byte(key))
+		assert.Nil(t, err)
+		assert.Nil(t, bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, maxKeyLength)).Insert(key, pos))
+		tx.DowngradeLocks()
+	}
+	unfinished := tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+	write(unfinished, "unfinished")
+	tx := tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+	write(tx, "committed")
+	tx.Commit()
+	// crash with unfinished transaction
+	tc.restart()
+	tx = tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+	defer tx.Commit()
+	tree := bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, maxKeyLength))
+	_, err := tree.Find("unfinished")
+	assert.Equal(t, bp_tree.ErrKeyNotFound, err)
+	pos, err := tree.Find("committed")
+	assert.Nil(t, err)
+	data, err := dataAdapter.NewDataAdapter(tx).FindAtPos("committed", pos)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("committed"), data)
+}
+
+// Test_CorePreparedRecovery checks prepared transaction survives restart with its locks
+func Test_CorePreparedRecovery(t *testing.T) {
+	// prepare
+	tc := newTestCore(t, nil)
+	// test
+	maxKeyLength := tc.cfg.MaxKeyLength
+	tx := tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+	tx.LockKey("prepared", true)
+	pos, err := dataAdapter.NewDataAdapter(tx).Write("prepared", []byte("prepared"))
+	assert.Nil(t, err)
+	assert.Nil(t, bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, maxKeyLength)).Insert("prepared", pos))
+	assert.Nil(t, tx.Prepare("gid"))
+	tc.restart()
+	assert.Equal(t, []string{"gid"}, tc.factory.TxMgr().Prepared())
+	tx = tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+	assert.False(t, tx.TryLockKey("prepared"))
+	tx.Commit()
+	assert.Nil(t, tc.factory.TxMgr().CommitPrepared("gid"))
+	assert.Empty(t, tc.factory.TxMgr().Prepared())
+	tx = tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+	defer tx.Commit()
+	tx.LockKey("prepared", false)
+	pos, err = bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, maxKeyLength)).Find("prepared")
+	assert.Nil(t, err)
+	data, err := dataAdapter.NewDataAdapter(tx).FindAtPos("prepared", pos)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("prepared"), data)
+}
+
+// Test_CoreCheckpointRecovery checks journal before checkpoint is removed while transactions are active,
+// and their changes are still rolled back or restored after restart
+func Test_CoreCheckpointRecovery(t *testing.T) {
+	// prepare
+	tc := newTestCore(t, func(cfg *config.CoreConfig) {
+		cfg.LogSegCap = 16 * config.KB
+		cfg.CheckpointIntervalMs = 0
+	})
+	// test
+	maxKeyLength := tc.cfg.MaxKeyLength
+	write := func(tx transaction.Tx, key string) {
+		tx.LockKey(key, true)
+		pos, err := dataAdapter.NewDataAdapter(tx).Write(key, []byte(key))
+		assert.Nil(t, err)
+		assert.Nil(t, bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, maxKeyLength)).Insert(key, pos))
+		tx.DowngradeLocks()
+	}
+	unfinished := tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+	write(unfinished, "unfinished")
+	preparedTx := tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+	write(preparedTx, "prepared")
+	assert.Nil(t, preparedTx.Prepare("gid"))
+	for i := 0; i < 10; i++ {
+		tx := tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+		write(tx, "committed"+strconv.Itoa(i))
+		tx.Commit()
+	}
+	segments := func() int {
+		segIter := tc.factory.LogMgr().SegmentIterator()
+		n := 0
+		for seg := segIter.Next(); seg != nil; seg = segIter.Next() {
+			n++
+		}
+		return n
+	}
+	assert.Greater(t, segments(), 2)
+	tc.factory.TxMgr().Checkpoint()
+	assert.LessOrEqual(t, segments(), 2)
+	write(unfinished, "unfinished2")
+	// crash with unfinished and prepared transactions
+	tc.restart()
+	assert.Equal(t, []string{"gid"}, tc.factory.TxMgr().Prepared())
+	assert.Nil(t, tc.factory.TxMgr().CommitPrepared("gid"))
+	tx := tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+	defer tx.Commit()
+	tree := bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, maxKeyLength))
+	for _, key := range []string{"unfinished", "unfinished2"} {
+		_, err := tree.Find(key)
+		assert.Equal(t, bp_tree.ErrKeyNotFound, err)
+	}
+	for _, key := range []string{"prepared", "committed0", "committed9"} {
+		pos, err := tree.Find(key)
+		assert.Nil(t, err)
+		data, err := dataAdapter.NewDataAdapter(tx).FindAtPos(key, pos)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte(key), data)
+	}
+}
+
+// Test_CoreFuzzyCheckpoint checks changes committed while checkpoints flush pages survive restart
+func Test_CoreFuzzyCheckpoint(t *testing.T) {
+	// prepare
+	tc := newTestCore(t, func(cfg *config.CoreConfig) {
+		cfg.CheckpointIntervalMs = 0
+		// pages are evicted while checkpoints flush them
+		cfg.BufCap = 16
+	})
+	// test
+	maxKeyLength := tc.cfg.MaxKeyLength
+	keys := 300
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < keys; i++ {
+			key := "fuzzy" + strconv.Itoa(i)
+			tx := tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+			tx.LockKey(key, true)
+			pos, err := dataAdapter.NewDataAdapter(tx).Write(key, []byte(key))
+			assert.Nil(t, err)
+			assert.Nil(t, bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, maxKeyLength)).Insert(key, pos))
+			tx.DowngradeLocks()
+			tx.Commit()
+		}
+	}()
+	for checkpoints := true; checkpoints; {
+		select {
+		case <-done:
+			checkpoints = false
+		default:
+			tc.factory.TxMgr().Checkpoint()
+		}
+	}
+	tc.restart()
+	tx := tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+	defer tx.Commit()
+	tree := bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, maxKeyLength))
+	for i := 0; i < keys; i++ {
+		key := "fuzzy" + strconv.Itoa(i)
+		pos, err := tree.Find(key)
+		assert.Nil(t, err, key)
+		data, err := dataAdapter.NewDataAdapter(tx).FindAtPos(key, pos)
+		assert.Nil(t, err, key)
+		assert.Equal(t, []byte(key), data)
+	}
+}
+
+// Test_CoreGroupCommit checks concurrent commits are flushed in batches and all of them are durable
+func Test_CoreGroupCommit(t *testing.T) {
+	// prepare
+	tc := newTestCore(t, func(cfg *config.CoreConfig) {
+		// commits are recovered from journal after restart
+		cfg.CheckpointIntervalMs = 0
+	})
+	// test
+	maxKeyLength := tc.cfg.MaxKeyLength
+	txs := 32
+	before := tc.factory.TxMgr().GroupCommitStats()
+	var wg sync.WaitGroup
+	for i := 0; i < txs; i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			tx := tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+			tx.LockKey(key, true)
+			pos, err := dataAdapter.NewDataAdapter(tx).Write(key, []byte(key))
+			assert.Nil(t, err)
+			assert.Nil(t, bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, maxKeyLength)).Insert(key, pos))
+			tx.DowngradeLocks()
+			tx.Commit()
+		}("key" + strconv.Itoa(i))
+	}
+	wg.Wait()
+	after := tc.factory.TxMgr().GroupCommitStats()
+	assert.Equal(t, txs, after.Records-before.Records)
+	assert.LessOrEqual(t, after.Batches-before.Batches, txs)
+	assert.GreaterOrEqual(t, after.MaxBatch, 1)
+	batches := 0
+	for bucket, n := range after.BatchSizes {
+		batches += n - before.BatchSizes[bucket]
+	}
+	assert.Equal(t, after.Batches-before.Batches, batches)
+	tc.restart()
+	tx := tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+	defer tx.Commit()
+	tree := bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, maxKeyLength))
+	for i := 0; i < txs; i++ {
+		key := "key" + strconv.Itoa(i)
+		pos, err := tree.Find(key)
+		assert.Nil(t, err)
+		data, err := dataAdapter.NewDataAdapter(tx).FindAtPos(key, pos)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte(key), data)
+	}
+}
+
+// Test_CoreCommitJournalsChangedPages checks commit journals pages changed since the previous commit only,
+// so its journal size doesn't depend on the number of changed pages kept by buffer
+func Test_CoreCommitJournalsChangedPages(t *testing.T) {
+	// prepare
+	tc := newTestCore(t, func(cfg *config.CoreConfig) {
+		cfg.CheckpointIntervalMs = 0
+	})
+	// test
+	maxKeyLength := tc.cfg.MaxKeyLength
+	write := func(keys int) {
+		tx := tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+		tree := bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, maxKeyLength))
+		da := dataAdapter.NewDataAdapter(tx)
+		for i := 0; i < keys; i++ {
+			key := strconv.Itoa(i)
+			pos, err := da.Write(key, make([]byte, tc.cfg.PageSize/5))
+			assert.Nil(t, err)
+			assert.Nil(t, tree.Insert(key, pos))
+		}
+		tx.Commit()
+	}
+	journalSize := func() int64 {
+		files, err := ioutil.ReadDir(tc.cfg.LogPath())
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := int64(0)
+		for _, f := range files {
+			if strings.HasPrefix(f.Name(), "segment") {
+				size += f.Size()
+			}
+		}
+		return size
+	}
+	write(200)
+	size := journalSize()
+	write(1)
+	// data page, tree leaf, meta and free space map pages at most
+	assert.Less(t, journalSize()-size, int64(5*tc.cfg.PageSize))
+}
+
+// Test_CoreDurabilityModes checks commits which don't wait for sync survive restart and don't write storage
+// ahead of journal sync
+func Test_CoreDurabilityModes(t *testing.T) {
+	for _, mode := range []string{config.DurabilitySync, config.DurabilityInterval, config.DurabilityOff} {
+		// prepare
+		tc := newTestCore(t, func(cfg *config.CoreConfig) {
+			cfg.Durability = mode
+			cfg.CheckpointIntervalMs = 0
+		})
+		strg, err := ioutil.ReadFile(tc.cfg.DataPath())
+		assert.Nil(t, err)
+		// test
+		maxKeyLength := tc.cfg.MaxKeyLength
+		for _, key := range []string{"commit", "nowait"} {
+			tx := tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+			tx.LockKey(key, true)
+			pos, err := dataAdapter.NewDataAdapter(tx).Write(key, []byte(key))
+			assert.Nil(t, err)
+			assert.Nil(t, bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, maxKeyLength)).Insert(key, pos))
+			tx.DowngradeLocks()
+			if key == "commit" {
+				tx.Commit()
+			} else {
+				tx.CommitNoWait()
+			}
+		}
+		committed, err := ioutil.ReadFile(tc.cfg.DataPath())
+		assert.Nil(t, err)
+		// allocated pages are appended to storage empty, written pages are left to checkpoints
+		assert.Equal(t, strg, committed[:len(strg)], mode)
+		tc.restart()
+		tx := tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+		tree := bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, maxKeyLength))
+		for _, key := range []string{"commit", "nowait"} {
+			pos, err := tree.Find(key)
+			assert.Nil(t, err, mode)
+			data, err := dataAdapter.NewDataAdapter(tx).FindAtPos(key, pos)
+			assert.Nil(t, err, mode)
+			assert.Equal(t, []byte(key), data, mode)
+		}
+		tx.Commit()
+		tc.stop()
+	}
+}
+
+// Test_CoreStorageFormat checks storage is stamped with format version and settings it is created with,
+// and file of another format is refused
+func Test_CoreStorageFormat(t *testing.T) {
+	// prepare
+	tc := newTestCore(t, nil)
+	tc.stop()
+	// test
+	file, err := os.Open(tc.cfg.DataPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := storage.ReadFileMetaPage(file)
+	file.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, storage.StorageVersion, meta.Version)
+	assert.Equal(t, tc.cfg.PageSize, int(meta.PageSize))
+	assert.Equal(t, tc.cfg.MaxKeyLength, int(meta.MaxKeyLength))
+	// meta page of format without magic and version
+	page := storage.NewHeapPageAllocator(tc.cfg.PageSize).AllocatePage()
+	page.AppendData(make([]byte, 24))
+	block, _ := page.MarshalBinary()
+	foreignPath := filepath.Join(t.TempDir(), "data.bin")
+	if err := ioutil.WriteFile(foreignPath, block, 0666); err != nil {
+		t.Fatal(err)
+	}
+	file, err = os.Open(foreignPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	_, err = storage.ReadFileMetaPage(file)
+	assert.True(t, errors.Is(err, storage.ErrStorageFormat))
+}
+
+// Test_CoreTornJournal checks server starts after crash in the middle of journal append,
+// and pages keep lsn of their last journaled images
+func Test_CoreTornJournal(t *testing.T) {
+	// prepare
+	tc := newTestCore(t, nil)
+	// test
+	maxKeyLength := tc.cfg.MaxKeyLength
+	tx := tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+	tx.LockKey("key", true)
+	pos, err := dataAdapter.NewDataAdapter(tx).Write("key", []byte("value"))
+	assert.Nil(t, err)
+	assert.Nil(t, bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, maxKeyLength)).Insert("key", pos))
+	tx.Commit()
+	tx = tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+	lsn := tx.ReadPageAtPos(pos).Lsn()
+	assert.Greater(t, lsn, int64(0))
+	tx.Commit()
+	segIter := tc.factory.LogMgr().SegmentIterator()
+	var lastSegName string
+	for seg := segIter.Next(); seg != nil; seg = segIter.Next() {
+		lastSegName = seg.Name()
+	}
+	tc.stop()
+	// partially written record
+	segFile, err := os.OpenFile(lastSegName, os.O_WRONLY|os.O_APPEND, 0666)
+	assert.Nil(t, err)
+	_, err = segFile.Write([]byte{0xff, 0xff, 0, 0, 1, 2})
+	assert.Nil(t, err)
+	segFile.Close()
+	tc.start()
+	tx = tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+	tx.LockKey("key", true)
+	pos, err = bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, maxKeyLength)).Find("key")
+	assert.Nil(t, err)
+	data, err := dataAdapter.NewDataAdapter(tx).FindAtPos("key", pos)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), data)
+	// record may be moved to another page
+	pos, err = dataAdapter.NewDataAdapter(tx).WriteAtPos("key", []byte("new-value"), pos)
+	assert.Nil(t, err)
+	tx.Commit()
+	tx = tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+	defer tx.Commit()
+	assert.Greater(t, tx.ReadPageAtPos(pos).Lsn(), lsn)
+}
+
+// Test_CoreStealRecovery checks transactions larger than buffer are committed, and pages evicted
+// by unfinished transaction are restored after restart
+func Test_CoreStealRecovery(t *testing.T) {
+	// prepare
+	tc := newTestCore(t, func(cfg *config.CoreConfig) {
+		cfg.BufCap = 8
+		cfg.CheckpointIntervalMs = 0
+	})
+	// test
+	maxKeyLength := tc.cfg.MaxKeyLength
+	value := func(key string) []byte {
+		return []byte(strings.Repeat(key, 100))
+	}
+	write := func(tx transaction.Tx, prefix string) {
+		for i := 0; i < 400; i++ {
+			key := prefix + strconv.Itoa(i)
+			tx.LockKey(key, true)
+			pos, err := dataAdapter.NewDataAdapter(tx).Write(key, value(key))
+			assert.Nil(t, err)
+			assert.Nil(t, bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, maxKeyLength)).Insert(key, pos))
+			tx.DowngradeLocks()
+		}
+	}
+	check := func(tx transaction.Tx, key string) {
+		pos, err := bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, maxKeyLength)).Find(key)
+		assert.Nil(t, err)
+		data, err := dataAdapter.NewDataAdapter(tx).FindAtPos(key, pos)
+		assert.Nil(t, err)
+		assert.Equal(t, value(key), data)
+	}
+	tx := tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+	write(tx, "committed")
+	tx.Commit()
+	unfinished := tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+	write(unfinished, "unfinished")
+	// snapshot transaction reads evicted pages as of the last commit
+	snapshot := tc.factory.TxMgr().InitTx(concurrency.SnapshotMode)
+	snapshot.Latch(false)
+	check(snapshot, "committed399")
+	snapshot.Commit()
+	// crash with unfinished transaction
+	tc.restart()
+	tx = tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+	defer tx.Commit()
+	tree := bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, maxKeyLength))
+	for _, key := range []string{"unfinished0", "unfinished399"} {
+		_, err := tree.Find(key)
+		assert.Equal(t, bp_tree.ErrKeyNotFound, err)
+	}
+	for _, key := range []string{"committed0", "committed399"} {
+		check(tx, key)
+	}
+}
+
+// Test_CorePointInTimeRestore checks base backup is restored with archived journal up to transaction or time
+// before mass removal of keys
+func Test_CorePointInTimeRestore(t *testing.T) {
+	// prepare
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "archive")
+	backup := filepath.Join(dir, "backup")
+	tc := newTestCore(t, func(cfg *config.CoreConfig) {
+		cfg.LogSegCap = 16 * config.KB
+		cfg.CheckpointIntervalMs = 0
+		cfg.ArchivePath = archive
+	})
+	tc.stop()
+	// base backup of stopped server
+	assert.Nil(t, os.MkdirAll(backup, 0777))
+	assert.Nil(t, utils.CopyFile(tc.cfg.DataPath(), filepath.Join(backup, "data.bin")))
+	assert.Nil(t, logging.CopySegments(filepath.Join(backup, "log"), 0, tc.cfg.LogPath()))
+	assert.Nil(t, utils.CopyFile(filepath.Join(tc.cfg.LogPath(), "checkpoint.bin"), filepath.Join(backup, "log", "checkpoint.bin")))
+	tc.start()
+	// test
+	maxKeyLength := tc.cfg.MaxKeyLength
+	value := func(key string) []byte {
+		return []byte(strings.Repeat(key, 100))
+	}
+	var lastTxId int
+	for i := 0; i < 20; i++ {
+		key := "key" + strconv.Itoa(i)
+		tx := tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+		tx.LockKey(key, true)
+		pos, err := dataAdapter.NewDataAdapter(tx).Write(key, value(key))
+		assert.Nil(t, err)
+		assert.Nil(t, bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, maxKeyLength)).Insert(key, pos))
+		tx.Commit()
+		lastTxId = tx.Id()
+	}
+	lastTime := time.Now()
+	tc.factory.TxMgr().Checkpoint()
+	// accidental removal of all keys
+	tx := tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+	for i := 0; i < 20; i++ {
+		key := "key" + strconv.Itoa(i)
+		tx.LockKey(key, true)
+		pos, err := bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, maxKeyLength)).Find(key)
+		assert.Nil(t, err)
+		_, err = dataAdapter.NewDataAdapter(tx).MarkDeletedAtPos(key, pos)
+		assert.Nil(t, err)
+		tx.DowngradeLocks()
+	}
+	tx.Commit()
+	tc.factory.TxMgr().Checkpoint()
+	tc.stop()
+	archived, err := os.ReadDir(archive)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, archived)
+	for _, target := range []restore.Target{{TxId: lastTxId}, {Time: lastTime}} {
+		restoredCfg := *tc.cfg
+		restoredCfg.FilesPath = filepath.Join(dir, "restored")
+		restoredCfg.ArchivePath = ""
+		assert.Nil(t, restore.Restore(&restoredCfg, []string{backup}, target, archive, tc.cfg.LogPath()))
+		restored := startTestCore(t, &restoredCfg)
+		tx = restored.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+		tree := bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, maxKeyLength))
+		for _, key := range []string{"key0", "key19"} {
+			pos, err := tree.Find(key)
+			assert.Nil(t, err)
+			data, err := dataAdapter.NewDataAdapter(tx).FindAtPos(key, pos)
+			assert.Nil(t, err)
+			assert.Equal(t, value(key), data)
+		}
+		tx.Commit()
+		restored.stop()
+		if err := os.RemoveAll(restoredCfg.FilesPath); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Test_CoreHotBackup checks backup made while transactions proceed is recovered with all transactions committed
+// before its start, and changes of unfinished ones are rolled back
+func Test_CoreHotBackup(t *testing.T) {
+	// prepare
+	tc := newTestCore(t, func(cfg *config.CoreConfig) {
+		cfg.CheckpointIntervalMs = 0
+	})
+	backupCfg := *tc.cfg
+	backupCfg.FilesPath = filepath.Join(t.TempDir(), "backup")
+	// test
+	maxKeyLength := tc.cfg.MaxKeyLength
+	write := func(tx transaction.Tx, key string) {
+		tx.LockKey(key, true)
+		pos, err := dataAdapter.NewDataAdapter(tx).Write(key, []byte(key))
+		assert.Nil(t, err)
+		assert.Nil(t, bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, maxKeyLength)).Insert(key, pos))
+		tx.DowngradeLocks()
+	}
+	unfinished := tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+	write(unfinished, "unfinished")
+	// transactions are committed during backup
+	var wg sync.WaitGroup
+	var started sync.WaitGroup
+	stop := make(chan struct{})
+	committed := make([]int64, workers)
+	wg.Add(workers)
+	started.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				tx := tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+				write(tx, "key"+strconv.Itoa(w)+"_"+strconv.Itoa(i))
+				tx.Commit()
+				atomic.StoreInt64(&committed[w], int64(i+1))
+				if i == 0 {
+					started.Done()
+				}
+			}
+		}(w)
+	}
+	started.Wait()
+	before := make([]int, workers)
+	for w := range committed {
+		before[w] = int(atomic.LoadInt64(&committed[w]))
+	}
+	assert.Nil(t, tc.factory.TxMgr().Backup(backupCfg.DataPath(), backupCfg.LogPath()))
+	assert.True(t, errors.Is(tc.factory.TxMgr().Backup(backupCfg.DataPath(), backupCfg.LogPath()), transaction.ErrBackupExists))
+	close(stop)
+	wg.Wait()
+	unfinished.Commit()
+	tc.stop()
+	// start server on backup
+	tx := startTestCore(t, &backupCfg).factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+	defer tx.Commit()
+	tree := bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, maxKeyLength))
+	_, err := tree.Find("unfinished")
+	assert.Equal(t, bp_tree.ErrKeyNotFound, err)
+	for w := 0; w < workers; w++ {
+		for i := 0; i < before[w]; i++ {
+			key := "key" + strconv.Itoa(w) + "_" + strconv.Itoa(i)
+			pos, err := tree.Find(key)
+			assert.Nil(t, err)
+			data, err := dataAdapter.NewDataAdapter(tx).FindAtPos(key, pos)
+			assert.Nil(t, err)
+			assert.Equal(t, []byte(key), data)
+		}
+	}
+}
+
+// Test_CoreIncrementalBackup checks chain of full and incremental backups is restored with changes of all
+// of them, and incremental backup contains only changed pages
+func Test_CoreIncrementalBackup(t *testing.T) {
+	// prepare
+	tc := newTestCore(t, func(cfg *config.CoreConfig) {
+		cfg.CheckpointIntervalMs = 0
+	})
+	dir := t.TempDir()
+	backupPath := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+	backupCfg := func(name string) *config.CoreConfig {
+		cfg := *tc.cfg
+		cfg.FilesPath = backupPath(name)
+		return &cfg
+	}
+	// test
+	maxKeyLength := tc.cfg.MaxKeyLength
+	value := func(key string) []byte {
+		return []byte(strings.Repeat(key, 100))
+	}
+	write := func(tx transaction.Tx, key string) {
+		tx.LockKey(key, true)
+		pos, err := dataAdapter.NewDataAdapter(tx).Write(key, value(key))
+		assert.Nil(t, err)
+		assert.Nil(t, bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, maxKeyLength)).Insert(key, pos))
+		tx.DowngradeLocks()
+	}
+	writeCommitted := func(prefix string, n int) {
+		tx := tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+		for i := 0; i < n; i++ {
+			write(tx, prefix+strconv.Itoa(i))
+		}
+		tx.Commit()
+	}
+	writeCommitted("a", 100)
+	assert.Nil(t, tc.factory.TxMgr().Backup(backupCfg("full").DataPath(), backupCfg("full").LogPath()))
+	tx := tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+	for i := 0; i < 10; i++ {
+		key := "a" + strconv.Itoa(i)
+		tx.LockKey(key, true)
+		pos, err := bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, maxKeyLength)).Find(key)
+		assert.Nil(t, err)
+		_, err = dataAdapter.NewDataAdapter(tx).MarkDeletedAtPos(key, pos)
+		assert.Nil(t, err)
+		tx.DowngradeLocks()
+	}
+	tx.Commit()
+	writeCommitted("b", 10)
+	assert.Nil(t, tc.factory.TxMgr().IncrementalBackup(backupCfg("inc1").PagesPath(), backupCfg("inc1").LogPath(), backupCfg("full").LogPath()))
+	writeCommitted("c", 10)
+	unfinished := tc.factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+	write(unfinished, "unfinished")
+	assert.Nil(t, tc.factory.TxMgr().IncrementalBackup(backupCfg("inc2").PagesPath(), backupCfg("inc2").LogPath(), backupCfg("inc1").LogPath()))
+	unfinished.Commit()
+	tc.stop()
+	fullInfo, err := os.Stat(backupCfg("full").DataPath())
+	assert.Nil(t, err)
+	incInfo, err := os.Stat(backupCfg("inc1").PagesPath())
+	assert.Nil(t, err)
+	assert.Less(t, incInfo.Size(), fullInfo.Size()/2)
+	// increment can't be applied to backup it isn't based on
+	assert.True(t, errors.Is(restore.Restore(backupCfg("restored"), []string{backupPath("full"), backupPath("inc2")}, restore.Target{}), storage.ErrIncrementBase))
+	assert.Nil(t, os.RemoveAll(backupPath("restored")))
+	assert.Nil(t, restore.Restore(backupCfg("restored"), []string{backupPath("full"), backupPath("inc1"), backupPath("inc2")}, restore.Target{}))
+	tx = startTestCore(t, backupCfg("restored")).factory.TxMgr().InitTx(concurrency.ExclusiveMode)
+	defer tx.Commit()
+	tree := bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, maxKeyLength))
+	for _, key := range []string{"a10", "a99", "b0", "b9", "c0", "c9"} {
+		pos, err := tree.Find(key)
+		assert.Nil(t, err)
+		data, err := dataAdapter.NewDataAdapter(tx).FindAtPos(key, pos)
+		assert.Nil(t, err)
+		assert.Equal(t, value(key), data)
+	}
+	pos, err := tree.Find("a0")
+	assert.Nil(t, err)
+	_, err = dataAdapter.NewDataAdapter(tx).FindAtPos("a0", pos)
+	assert.NotNil(t, err)
+	_, err = tree.Find("unfinished")
+	assert.Equal(t, bp_tree.ErrKeyNotFound, err)
+}
+
+// Test_CoreApplyChangesStop checks replicated changes wait for key locked by local transaction without
+// spinning and aren't applied if node stops meanwhile
+func Test_CoreApplyChangesStop(t *testing.T) {
+	// prepare
+	tc := newTestCore(t, func(cfg *config.CoreConfig) {
+		cfg.LockTimeoutMs = 10
+	})
+	// test
+	txMgr := tc.factory.TxMgr()
+	changes := []transaction.KeyChange{{Key: "key", Image: transaction.KeyImage{Value: []byte("replicated")}}}
+	local := txMgr.InitTx(concurrency.ExclusiveMode)
+	local.LockKey("key", true)
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- txMgr.ApplyChanges(changes, stop)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	close(stop)
+	assert.Equal(t, transaction.ErrApplyStopped, <-done)
+	go func() {
+		done <- txMgr.ApplyChanges(changes, make(chan struct{}))
+	}()
+	time.Sleep(50 * time.Millisecond)
+	local.Commit()
+	assert.Nil(t, <-done)
+	tx := txMgr.InitTx(concurrency.ExclusiveMode)
+	defer tx.Commit()
+	pos, err := bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, tc.cfg.MaxKeyLength)).Find("key")
+	assert.Nil(t, err)
+	data, err := dataAdapter.NewDataAdapter(tx).FindAtPos("key", pos)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("replicated"), data)
+}
+
+// testCore is a core started on temporary files path, which is finalized at the end of test
+type testCore struct {
+	cfg     *config.CoreConfig
+	factory *DefaultDBMSCoreFactory
+}
+
+// newTestCore starts core with default settings changed by tweak (it may be nil) on temporary files path
+func newTestCore(t *testing.T, tweak func(*config.CoreConfig)) *testCore {
+	cfgLdr := new(config.DefaultConfigLoader)
+	cfgLdr.Load()
+	cfg := cfgLdr.CoreCfg()
+	cfg.FilesPath = t.TempDir()
+	if tweak != nil {
+		tweak(cfg)
+	}
+	return startTestCore(t, cfg)
+}
+
+// startTestCore starts core on existing files path of cfg
+func startTestCore(t *testing.T, cfg *config.CoreConfig) *testCore {
+	tc := &testCore{cfg: cfg}
+	tc.start()
+	t.Cleanup(tc.stop)
+	return tc
+}
+
+func (tc *testCore) start() {
+	tc.factory = NewDefaultDBMSCoreFactory(tc.cfg)
+	tc.factory.BtstpMgr().Init()
+}
+
+// stop finalizes core; it is a no-op for stopped core
+func (tc *testCore) stop() {
+	if tc.factory != nil {
+		tc.factory.BtstpMgr().Finalize()
+		tc.factory = nil
+	}
+}
+
+// restart finalizes core leaving unfinished transactions as after crash and starts it again on the same files
+func (tc *testCore) restart() {
+	tc.stop()
+	tc.start()
+}
+
+// Test_CoreConcurrentSets checks set of key doesn't wait for set of another key, which latches its record page
+func Test_CoreConcurrentSets(t *testing.T) {
+	// prepare
+	tc := newTestCore(t, nil)
+	txMgr := tc.factory.TxMgr()
+	set := func(tx transaction.Tx, key string, value []byte, latched func()) {
+		tree := bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, tc.cfg.MaxKeyLength))
+		da := dataAdapter.NewDataAdapter(tx)
+		tx.LockKey(key, true)
+		pos, err := tree.FindLatched(key, true)
+		if err == bp_tree.ErrKeyNotFound {
+			if pos, err = da.Write(key, value); err == nil {
+				err = tree.Insert(key, pos)
+			}
+		} else if err == nil {
+			if latched != nil {
+				latched()
+			}
+			var writePos int64
+			if writePos, err = da.WriteAtPos(key, value, pos); err == nil && writePos != pos {
+				err = tree.Insert(key, writePos)
+			}
+		}
+		assert.Nil(t, err)
+		tx.DowngradeLocks()
+	}
+	tx := txMgr.InitTx(concurrency.ExclusiveMode)
+	set(tx, "a", []byte("1"), nil)
+	tx.Commit()
+	// test
+	tx1 := txMgr.InitTx(concurrency.ExclusiveMode)
+	tx2 := txMgr.InitTx(concurrency.ExclusiveMode)
+	done := make(chan struct{})
+	set(tx1, "a", []byte("2"), func() {
+		// the first set holds page of "a" latched while the second one runs
+		go func() {
+			set(tx2, "b", []byte("3"), nil)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Error("set of another key is serialized")
+		}
+	})
+	<-done
+	tx1.Commit()
+	tx2.Commit()
+	tx = txMgr.InitTx(concurrency.ExclusiveMode)
+	defer tx.Commit()
+	tree := bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, tc.cfg.MaxKeyLength))
+	da := dataAdapter.NewDataAdapter(tx)
+	for key, value := range map[string]string{"a": "2", "b": "3"} {
+		pos, err := tree.Find(key)
+		assert.Nil(t, err)
+		data, err := da.FindAtPos(key, pos)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte(value), data)
+	}
+}
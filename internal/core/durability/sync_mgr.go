@@ -0,0 +1,32 @@
+package durability
+
+import (
+	"dbms/internal/core/transaction"
+	"dbms/internal/utils"
+	"time"
+)
+
+// SyncManager syncs journal periodically, so commits which don't wait for sync
+// are lost by OS crash only if they are made during the last interval
+type SyncManager struct {
+	txMgr  *transaction.TxManager
+	runner *utils.PeriodicRunner
+}
+
+func NewSyncManager(txMgr *transaction.TxManager, interval time.Duration) *SyncManager {
+	m := new(SyncManager)
+	m.txMgr = txMgr
+	m.runner = utils.NewPeriodicRunner(interval, txMgr.Sync)
+	return m
+}
+
+// Start runs sync periodically in background; non-positive interval disables it
+func (m *SyncManager) Start() {
+	m.runner.Start()
+}
+
+// Stop syncs changes made since the last sync, so they are durable after clean shutdown in any mode
+func (m *SyncManager) Stop() {
+	m.runner.Stop()
+	m.txMgr.Sync()
+}
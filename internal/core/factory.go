@@ -2,20 +2,34 @@ package core
 
 import (
 	"dbms/internal/config"
+	"dbms/internal/core/checkpoint"
 	"dbms/internal/core/concurrency"
+	"dbms/internal/core/durability"
 	"dbms/internal/core/logging"
+	"dbms/internal/core/raft"
 	"dbms/internal/core/recovery"
+	"dbms/internal/core/replication"
 	"dbms/internal/core/storage"
 	"dbms/internal/core/transaction"
+	"dbms/internal/core/vacuum"
 	"os"
+	"time"
 )
 
 type DBMSCoreFactory interface {
+	CoreCfg() *config.CoreConfig
 	TxMgr() *transaction.TxManager
 	SegMgr() *logging.SegmentManager
 	LogMgr() *logging.LogManager
 	RecMgr() *recovery.RecoveryManager
+	VacuumMgr() *vacuum.VacuumManager
+	CheckpointMgr() *checkpoint.CheckpointManager
+	SyncMgr() *durability.SyncManager
 	BtstpMgr() *BootstrapManager
+	Replica() *recovery.Replica
+	Receiver() *replication.Receiver
+	Replicas() *replication.Replicas
+	RaftNode() *raft.Node
 }
 
 // dataFile must be unique per configuration to prevent multiple access to same files
@@ -28,6 +42,13 @@ type DefaultDBMSCoreFactory struct {
 	segMgr     *logging.SegmentManager
 	logMgr     *logging.LogManager
 	btstpMgr   *BootstrapManager
+	vacuumMgr  *vacuum.VacuumManager
+	chkptMgr   *checkpoint.CheckpointManager
+	syncMgr    *durability.SyncManager
+	replica    *recovery.Replica
+	receiver   *replication.Receiver
+	replicas   *replication.Replicas
+	raftNode   *raft.Node
 }
 
 func NewDefaultDBMSCoreFactory(cfg *config.CoreConfig) *DefaultDBMSCoreFactory {
@@ -37,6 +58,10 @@ func NewDefaultDBMSCoreFactory(cfg *config.CoreConfig) *DefaultDBMSCoreFactory {
 	return c
 }
 
+func (c *DefaultDBMSCoreFactory) CoreCfg() *config.CoreConfig {
+	return c.cfg
+}
+
 func (c *DefaultDBMSCoreFactory) TxMgr() *transaction.TxManager {
 	// singleton
 	if c.txMgr == nil {
@@ -52,7 +77,18 @@ func (c *DefaultDBMSCoreFactory) TxMgr() *transaction.TxManager {
 			c.LogMgr(),
 			concurrency.NewLockTable(),
 			storage.NewHeapPageAllocator(c.cfg.PageSize),
+			c.cfg.LockTimeout(),
 		)
+		c.txMgr.SetUndo(newKeysUndo(c.cfg.MaxKeyLength))
+		c.txMgr.SetSyncCommits(c.cfg.DurabilityMode() == config.DurabilitySync)
+		if c.cfg.SyncReplicas > 0 {
+			c.txMgr.SetReplicaAcks(c.Replicas())
+		}
+		if len(c.cfg.Cluster) != 0 {
+			txLog := newTxLog(c.RaftNode(), c.txMgr, c.cfg.ElectionTimeout())
+			c.txMgr.SetConsensus(txLog)
+			c.RaftNode().SetStateMachine(txLog)
+		}
 	}
 	return c.txMgr
 }
@@ -61,6 +97,7 @@ func (c *DefaultDBMSCoreFactory) SegMgr() *logging.SegmentManager {
 	// singleton
 	if c.segMgr == nil {
 		c.segMgr = logging.NewSegmentManager(c.cfg.LogPath(), c.cfg.LogSegCap)
+		c.segMgr.SetArchiveDir(c.cfg.ArchiveDir())
 	}
 	return c.segMgr
 }
@@ -77,6 +114,39 @@ func (c *DefaultDBMSCoreFactory) RecMgr() *recovery.RecoveryManager {
 	return recovery.NewRecoveryManager(c.LogMgr())
 }
 
+func (c *DefaultDBMSCoreFactory) VacuumMgr() *vacuum.VacuumManager {
+	// singleton
+	if c.vacuumMgr == nil {
+		c.vacuumMgr = vacuum.NewVacuumManager(c.TxMgr(), c.cfg.MaxKeyLength, c.cfg.VacuumInterval())
+	}
+	return c.vacuumMgr
+}
+
+func (c *DefaultDBMSCoreFactory) CheckpointMgr() *checkpoint.CheckpointManager {
+	// singleton
+	if c.chkptMgr == nil {
+		c.chkptMgr = checkpoint.NewCheckpointManager(c.TxMgr(), c.cfg.CheckpointInterval())
+		if len(c.cfg.Cluster) != 0 {
+			// entries applied by node are committed to storage durably, so checkpoint keeps them
+			c.chkptMgr.SetCompaction(c.RaftNode().Compact)
+		}
+	}
+	return c.chkptMgr
+}
+
+func (c *DefaultDBMSCoreFactory) SyncMgr() *durability.SyncManager {
+	// singleton
+	if c.syncMgr == nil {
+		// background sync is required in interval mode only
+		var interval time.Duration
+		if c.cfg.DurabilityMode() == config.DurabilityInterval {
+			interval = c.cfg.DurabilityInterval()
+		}
+		c.syncMgr = durability.NewSyncManager(c.TxMgr(), interval)
+	}
+	return c.syncMgr
+}
+
 func (c *DefaultDBMSCoreFactory) BtstpMgr() *BootstrapManager {
 	// singleton
 	if c.btstpMgr == nil {
@@ -84,3 +154,45 @@ func (c *DefaultDBMSCoreFactory) BtstpMgr() *BootstrapManager {
 	}
 	return c.btstpMgr
 }
+
+func (c *DefaultDBMSCoreFactory) Replica() *recovery.Replica {
+	// singleton
+	if c.replica == nil {
+		c.replica = recovery.NewReplica(c.RecMgr(), c.TxMgr())
+	}
+	return c.replica
+}
+
+func (c *DefaultDBMSCoreFactory) Receiver() *replication.Receiver {
+	// singleton
+	if c.receiver == nil {
+		c.receiver = replication.NewReceiver(c.cfg.ReplicaOf, c.cfg.ReplicaName, c.Replica())
+	}
+	return c.receiver
+}
+
+func (c *DefaultDBMSCoreFactory) Replicas() *replication.Replicas {
+	// singleton
+	if c.replicas == nil {
+		c.replicas = replication.NewReplicas(
+			c.cfg.SyncReplicas,
+			c.cfg.SyncReplicationTimeout(),
+			c.cfg.SyncFallbackMode() == config.SyncFallbackError,
+		)
+	}
+	return c.replicas
+}
+
+func (c *DefaultDBMSCoreFactory) RaftNode() *raft.Node {
+	// singleton
+	if c.raftNode == nil {
+		c.raftNode = raft.NewNode(
+			c.cfg.ClusterAddr,
+			c.cfg.Cluster,
+			c.cfg.RaftPath(),
+			c.cfg.ElectionTimeout(),
+			raft.NewTCPTransport(c.cfg.ElectionTimeout()),
+		)
+	}
+	return c.raftNode
+}
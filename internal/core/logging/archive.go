@@ -0,0 +1,167 @@
+package logging
+
+import (
+	"dbms/internal/utils"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+var ErrNoCheckpoint = errors.New("journal has no checkpoint")
+
+// SetArchiveDir makes checkpoints copy journal segments to passed directory before their removal, so journal
+// can be replayed on top of base backup; segments aren't archived if directory is empty
+func (m *SegmentManager) SetArchiveDir(archiveDir string) {
+	m.archiveDir = archiveDir
+}
+
+// archive copies completed segment to archive directory
+func (s *Segment) archive(archiveDir string) {
+	if err := os.MkdirAll(archiveDir, 0777); err != nil {
+		log.Panic(err)
+	}
+	if err := utils.CopyFile(s.Name(), filepath.Join(archiveDir, filepath.Base(s.Name()))); err != nil {
+		log.Panic(err)
+	}
+}
+
+// segmentFiles maps ids of segments stored in directory to their files names
+func segmentFiles(dir string) (map[int]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	files := make(map[int]string)
+	for _, e := range entries {
+		if match := segmentFileRegex.FindStringSubmatch(e.Name()); match != nil && !e.IsDir() {
+			segId, err := strconv.Atoi(match[1])
+			if err != nil {
+				return nil, err
+			}
+			files[segId] = filepath.Join(dir, e.Name())
+		}
+	}
+	return files, nil
+}
+
+// CopySegments copies segments starting from passed id from source directories to destination one;
+// segment found in several directories is copied from the one where it is the longest, because it is
+// archived when completed, while its prefix may be kept by backup
+func CopySegments(dstDir string, minSegId int, srcDirs ...string) error {
+	sources := make(map[int]string)
+	sizes := make(map[int]int64)
+	for _, dir := range srcDirs {
+		files, err := segmentFiles(dir)
+		if err != nil {
+			return err
+		}
+		for segId, name := range files {
+			info, err := os.Stat(name)
+			if err != nil {
+				return err
+			}
+			if size, found := sizes[segId]; segId >= minSegId && (!found || info.Size() > size) {
+				sources[segId] = name
+				sizes[segId] = info.Size()
+			}
+		}
+	}
+	if err := os.MkdirAll(dstDir, 0777); err != nil {
+		return err
+	}
+	for segId, name := range sources {
+		if err := utils.CopyFile(name, filepath.Join(dstDir, fmt.Sprintf("segment%d.bin", segId))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CopyTo copies journal starting from segment of passed checkpoint up to its current end and persists
+// checkpoint position in the copy; segments must not be removed by checkpoints meanwhile
+func (m *LogManager) CopyTo(dir string, checkpoint LogPos) error {
+	type segmentPrefix struct {
+		name string
+		size int64
+	}
+	prefixes := make([]segmentPrefix, 0)
+	m.logLock.Lock()
+	for _, seg := range m.segMgr.segments {
+		if seg.Id() >= checkpoint.SegId {
+			prefixes = append(prefixes, segmentPrefix{seg.Name(), int64(seg.sizeNoLock())})
+		}
+	}
+	m.logLock.Unlock()
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	// records are appended only, so prefixes of segments are stable
+	for _, p := range prefixes {
+		if err := utils.CopyFilePrefix(p.name, filepath.Join(dir, filepath.Base(p.name)), p.size); err != nil {
+			return err
+		}
+	}
+	NewSegmentManager(dir, m.segMgr.segCap).SaveCheckpoint(checkpoint)
+	return nil
+}
+
+// CheckpointLsn returns lsn of checkpoint record of journal stored in directory, e.g. copied by backup;
+// storage pages changed after backup have greater lsn
+func CheckpointLsn(dir string, segCap int) (int64, error) {
+	segMgr := NewSegmentManager(dir, segCap)
+	checkpoint, found := segMgr.LoadCheckpoint()
+	if !found {
+		return 0, fmt.Errorf("%w: %s", ErrNoCheckpoint, dir)
+	}
+	segMgr.LoadSegments()
+	defer segMgr.CloseSegments()
+	for _, seg := range segMgr.segments {
+		if seg.Id() == checkpoint.SegId {
+			r, err := seg.readAt(checkpoint.Offset)
+			if err != nil {
+				return 0, fmt.Errorf("journal segment %s: %w", seg.Name(), err)
+			}
+			return r.Lsn(), nil
+		}
+	}
+	return 0, fmt.Errorf("journal segment %d is missing", checkpoint.SegId)
+}
+
+// BackupLsn returns lsn of checkpoint record of journal copied to directory by CopyTo
+func (m *LogManager) BackupLsn(dir string) (int64, error) {
+	return CheckpointLsn(dir, m.segMgr.segCap)
+}
+
+// TruncateAt removes records starting from passed position; it is used to restore journal to a point in time
+func (m *LogManager) TruncateAt(pos LogPos) {
+	m.logLock.Lock()
+	defer m.logLock.Unlock()
+	m.segMgr.truncateAt(pos)
+}
+
+func (m *SegmentManager) truncateAt(pos LogPos) {
+	segments := make([]*Segment, 0, len(m.segments))
+	for _, seg := range m.segments {
+		if seg.Id() > pos.SegId {
+			seg.CloseAndRemoveFile()
+			continue
+		}
+		if seg.Id() == pos.SegId {
+			if err := seg.file.Truncate(pos.Offset); err != nil {
+				log.Panic(err)
+			}
+			if _, err := seg.file.Seek(0, io.SeekEnd); err != nil {
+				log.Panic(err)
+			}
+			m.activeSeg = seg
+		}
+		segments = append(segments, seg)
+	}
+	m.segments = segments
+}
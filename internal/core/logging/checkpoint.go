@@ -0,0 +1,103 @@
+package logging
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// CheckpointTx is an active transaction journaled by checkpoint record; keys changed by transaction
+// are rolled back during recovery unless transaction is prepared
+type CheckpointTx struct {
+	TxId int
+	// Gid is a global id of prepared transaction; empty for unprepared one
+	Gid  string
+	Keys []string
+}
+
+// LogPos is a position of record in journal
+type LogPos struct {
+	SegId  int
+	Offset int64
+}
+
+func writeBytes(w io.Writer, data []byte) error {
+	if writeErr := binary.Write(w, binary.LittleEndian, int64(len(data))); writeErr != nil {
+		return writeErr
+	}
+	_, writeErr := w.Write(data)
+	return writeErr
+}
+
+func readBytes(r io.Reader) ([]byte, error) {
+	var dataLen int64
+	if readErr := binary.Read(r, binary.LittleEndian, &dataLen); readErr != nil {
+		return nil, readErr
+	}
+	data := make([]byte, dataLen)
+	if _, readErr := io.ReadFull(r, data); readErr != nil {
+		return nil, readErr
+	}
+	return data, nil
+}
+
+func writeString(w io.Writer, s string) error {
+	return writeBytes(w, []byte(s))
+}
+
+func readString(r io.Reader) (string, error) {
+	data, readErr := readBytes(r)
+	return string(data), readErr
+}
+
+func writeCheckpointTxs(w io.Writer, txs []CheckpointTx) error {
+	if writeErr := binary.Write(w, binary.LittleEndian, int64(len(txs))); writeErr != nil {
+		return writeErr
+	}
+	for _, tx := range txs {
+		if writeErr := binary.Write(w, binary.LittleEndian, int64(tx.TxId)); writeErr != nil {
+			return writeErr
+		}
+		if writeErr := writeString(w, tx.Gid); writeErr != nil {
+			return writeErr
+		}
+		if writeErr := binary.Write(w, binary.LittleEndian, int64(len(tx.Keys))); writeErr != nil {
+			return writeErr
+		}
+		for _, key := range tx.Keys {
+			if writeErr := writeString(w, key); writeErr != nil {
+				return writeErr
+			}
+		}
+	}
+	return nil
+}
+
+func readCheckpointTxs(r io.Reader) ([]CheckpointTx, error) {
+	var txsLen int64
+	if readErr := binary.Read(r, binary.LittleEndian, &txsLen); readErr != nil {
+		return nil, readErr
+	}
+	txs := make([]CheckpointTx, txsLen)
+	for i := range txs {
+		var txId, keysLen int64
+		if readErr := binary.Read(r, binary.LittleEndian, &txId); readErr != nil {
+			return nil, readErr
+		}
+		txs[i].TxId = int(txId)
+		gid, readErr := readString(r)
+		if readErr != nil {
+			return nil, readErr
+		}
+		txs[i].Gid = gid
+		if readErr := binary.Read(r, binary.LittleEndian, &keysLen); readErr != nil {
+			return nil, readErr
+		}
+		txs[i].Keys = make([]string, keysLen)
+		for j := range txs[i].Keys {
+			if txs[i].Keys[j], readErr = readString(r); readErr != nil {
+				return nil, readErr
+			}
+		}
+	}
+	return txs, nil
+}
@@ -3,30 +3,45 @@ package logging
 import (
 	"bytes"
 	"encoding/binary"
+	"io"
 	"log"
 	"sync"
+	"time"
 )
 
-// uint8 + int64 + int64
-const snapshotRecMetaSize = 17
-
-// uint8 + int64
-const commitAbortRecSize = 9
-
-// NOTE: not using lsn, before/after images here;
-// use only dumb page snapshots processing to simplify implementation;
+// NOTE: use only dumb page snapshots processing to simplify implementation;
 // log can be large (stores whole page's snapshot instead of segment)
 // but implementation is relatively easy;
-// implementation by-design relies on fact that with no-steal strategy for whole
-// page's snapshots dumb REDO logging and roll forward recovery is sufficient;
-// so only roll forward pages snapshots until stable storage is in required state
+// pages changed by unfinished transactions may be flushed (steal strategy): commits journal images of pages
+// changed since the previous commit without flush, checkpoints flush them, and buffer evicts changed pages
+// journaling their before and after images;
+// keys of transactions are journaled too and rolled back logically after roll forward of pages snapshots;
+// record is framed with its length and checksum by segment, and its lsn is stored in headers
+// of journaled pages
 type LogRecord struct {
+	lsn     int64
 	recType uint8
 	txId    int64
 	// snapshot specific fields
 	Pos         int64
 	snapshotLen int64
 	Snapshot    []byte
+	// snapshot makes Snapshot with lsn assigned to record
+	snapshot func(lsn int64) []byte
+	// steal specific fields; Snapshot keeps after image of evicted page
+	Before []byte
+	// key specific fields; prepare record keeps global transaction id here
+	keyLen int64
+	Key    []byte
+	// checkpoint specific fields
+	Txs []CheckpointTx
+	// commit specific fields; time is used to restore journal to a point in time
+	time int64
+}
+
+// Lsn is a log sequence number; it grows with each record
+func (r *LogRecord) Lsn() int64 {
+	return r.lsn
 }
 
 func (r *LogRecord) TxId() int {
@@ -37,15 +52,44 @@ func (r *LogRecord) Type() int {
 	return int(r.recType)
 }
 
+// Time returns time of commit
+func (r *LogRecord) Time() time.Time {
+	return time.Unix(0, r.time)
+}
+
 func (r *LogRecord) MarshalBinary() ([]byte, error) {
 	buf := new(bytes.Buffer)
+	if writeErr := binary.Write(buf, binary.LittleEndian, r.lsn); writeErr != nil {
+		return nil, writeErr
+	}
 	if writeErr := binary.Write(buf, binary.LittleEndian, r.recType); writeErr != nil {
 		return nil, writeErr
 	}
 	if writeErr := binary.Write(buf, binary.LittleEndian, r.txId); writeErr != nil {
 		return nil, writeErr
 	}
-	if r.recType != UpdateRecord {
+	if r.recType == CommitRecord {
+		if writeErr := binary.Write(buf, binary.LittleEndian, r.time); writeErr != nil {
+			return nil, writeErr
+		}
+		return buf.Bytes(), nil
+	}
+	if r.recType == KeyRecord || r.recType == PrepareRecord {
+		if writeErr := binary.Write(buf, binary.LittleEndian, r.keyLen); writeErr != nil {
+			return nil, writeErr
+		}
+		if _, writeErr := buf.Write(r.Key); writeErr != nil {
+			return nil, writeErr
+		}
+		return buf.Bytes(), nil
+	}
+	if r.recType == CheckpointRecord {
+		if writeErr := writeCheckpointTxs(buf, r.Txs); writeErr != nil {
+			return nil, writeErr
+		}
+		return buf.Bytes(), nil
+	}
+	if r.recType != UpdateRecord && r.recType != StealRecord {
 		return buf.Bytes(), nil
 	}
 	// log snapshot specific fields
@@ -58,9 +102,63 @@ func (r *LogRecord) MarshalBinary() ([]byte, error) {
 	if _, writeErr := buf.Write(r.Snapshot); writeErr != nil {
 		return nil, writeErr
 	}
+	if r.recType == StealRecord {
+		if writeErr := writeBytes(buf, r.Before); writeErr != nil {
+			return nil, writeErr
+		}
+	}
 	return buf.Bytes(), nil
 }
 
+func (r *LogRecord) UnmarshalBinary(data []byte) error {
+	buf := bytes.NewReader(data)
+	if readErr := binary.Read(buf, binary.LittleEndian, &r.lsn); readErr != nil {
+		return readErr
+	}
+	if readErr := binary.Read(buf, binary.LittleEndian, &r.recType); readErr != nil {
+		return readErr
+	}
+	if readErr := binary.Read(buf, binary.LittleEndian, &r.txId); readErr != nil {
+		return readErr
+	}
+	if r.recType == CommitRecord {
+		return binary.Read(buf, binary.LittleEndian, &r.time)
+	}
+	if r.recType == KeyRecord || r.recType == PrepareRecord {
+		if readErr := binary.Read(buf, binary.LittleEndian, &r.keyLen); readErr != nil {
+			return readErr
+		}
+		r.Key = make([]byte, r.keyLen)
+		_, readErr := io.ReadFull(buf, r.Key)
+		return readErr
+	}
+	if r.recType == CheckpointRecord {
+		txs, readErr := readCheckpointTxs(buf)
+		r.Txs = txs
+		return readErr
+	}
+	if r.recType != UpdateRecord && r.recType != StealRecord {
+		return nil
+	}
+	// extract snapshot specific fields
+	if readErr := binary.Read(buf, binary.LittleEndian, &r.Pos); readErr != nil {
+		return readErr
+	}
+	if readErr := binary.Read(buf, binary.LittleEndian, &r.snapshotLen); readErr != nil {
+		return readErr
+	}
+	r.Snapshot = make([]byte, r.snapshotLen)
+	if _, readErr := io.ReadFull(buf, r.Snapshot); readErr != nil {
+		return readErr
+	}
+	if r.recType == StealRecord {
+		before, readErr := readBytes(buf)
+		r.Before = before
+		return readErr
+	}
+	return nil
+}
+
 type SegmentIterator struct {
 	segments []*Segment
 	curIdx   int
@@ -83,6 +181,19 @@ const (
 	UpdateRecord = 0
 	CommitRecord = 1
 	AbortRecord  = 2
+	// KeyRecord journals key changed by transaction, so changes of unfinished transaction
+	// which are flushed by commits of others are rolled back during recovery
+	KeyRecord = 3
+	// PrepareRecord marks transaction prepared by external coordinator;
+	// such transaction survives restart until it is committed or rolled back by coordinator
+	PrepareRecord = 4
+	// CheckpointRecord lists active transactions with their keys; pages changed before it are flushed after it,
+	// and then recovery starts from the last checkpoint and the journal before it is removed
+	CheckpointRecord = 5
+	// StealRecord journals before and after images of changed page evicted from buffer; after images are
+	// applied by recovery with images of the next commit, abort, prepare or checkpoint record, and before
+	// images of trailing ones are applied in reverse order, so storage returns to state of the last such record
+	StealRecord = 6
 )
 
 type LogManager struct {
@@ -96,38 +207,89 @@ func NewLogManager(segMgr *SegmentManager) *LogManager {
 	return m
 }
 
-func (m *LogManager) log(txId int, r *LogRecord) {
+func (m *LogManager) log(r *LogRecord) LogPos {
 	m.logLock.Lock()
 	defer m.logLock.Unlock()
+	r.lsn = m.segMgr.nextLsn()
+	if r.snapshot != nil {
+		r.Snapshot = r.snapshot(r.lsn)
+		r.snapshotLen = int64(len(r.Snapshot))
+	}
 	data, err := r.MarshalBinary()
 	if err != nil {
 		log.Panic(err)
 	}
-	m.segMgr.Log(txId, data)
+	return m.segMgr.Log(data)
 }
 
-func (m *LogManager) LogSnapshot(txId int, pos int64, snapshotData []byte) {
+// LogSnapshot journals page snapshot made by passed function and returns position of its record; snapshot is made
+// with lsn of its record, so page header keeps lsn of its last journaled modification
+func (m *LogManager) LogSnapshot(txId int, pos int64, snapshot func(lsn int64) []byte) LogPos {
 	rec := new(LogRecord)
 	rec.recType = UpdateRecord
 	rec.txId = int64(txId)
 	rec.Pos = pos
-	rec.snapshotLen = int64(len(snapshotData))
-	rec.Snapshot = snapshotData
-	m.log(txId, rec)
+	rec.snapshot = snapshot
+	return m.log(rec)
+}
+
+// LogSteal journals images of evicted page; after image is made by passed function with lsn of record
+func (m *LogManager) LogSteal(pos int64, before []byte, after func(lsn int64) []byte) LogPos {
+	rec := new(LogRecord)
+	rec.recType = StealRecord
+	rec.Pos = pos
+	rec.snapshot = after
+	rec.Before = before
+	return m.log(rec)
+}
+
+// ReadRecord reads record at passed position; it is used to read journaled images of pages
+func (m *LogManager) ReadRecord(pos LogPos) *LogRecord {
+	m.logLock.Lock()
+	defer m.logLock.Unlock()
+	return m.segMgr.readRecord(pos)
 }
 
 func (m *LogManager) LogCommit(txId int) {
 	rec := new(LogRecord)
 	rec.recType = CommitRecord
 	rec.txId = int64(txId)
-	m.log(txId, rec)
+	rec.time = time.Now().UnixNano()
+	m.log(rec)
 }
 
 func (m *LogManager) LogAbort(txId int) {
 	rec := new(LogRecord)
 	rec.recType = AbortRecord
 	rec.txId = int64(txId)
-	m.log(txId, rec)
+	m.log(rec)
+}
+
+func (m *LogManager) LogKey(txId int, key string) {
+	rec := new(LogRecord)
+	rec.recType = KeyRecord
+	rec.txId = int64(txId)
+	rec.keyLen = int64(len(key))
+	rec.Key = []byte(key)
+	m.log(rec)
+}
+
+func (m *LogManager) LogPrepare(txId int, gid string) {
+	rec := new(LogRecord)
+	rec.recType = PrepareRecord
+	rec.txId = int64(txId)
+	rec.keyLen = int64(len(gid))
+	rec.Key = []byte(gid)
+	m.log(rec)
+}
+
+// LogCheckpoint returns position of checkpoint record, which is passed to Truncate after changed pages are flushed
+func (m *LogManager) LogCheckpoint(txId int, txs []CheckpointTx) LogPos {
+	rec := new(LogRecord)
+	rec.recType = CheckpointRecord
+	rec.txId = int64(txId)
+	rec.Txs = txs
+	return m.log(rec)
 }
 
 func (m *LogManager) Flush() {
@@ -136,10 +298,26 @@ func (m *LogManager) Flush() {
 	m.segMgr.Flush()
 }
 
-func (m *LogManager) Release(txId int) {
+// FlushTo syncs journal unless records up to passed lsn are synced already
+func (m *LogManager) FlushTo(lsn int64) {
 	m.logLock.Lock()
 	defer m.logLock.Unlock()
-	m.segMgr.Unpin(txId)
+	if m.segMgr.flushedLsn < lsn {
+		m.segMgr.Flush()
+	}
+}
+
+// Truncate persists checkpoint position and removes segments before it; journal must be flushed before
+func (m *LogManager) Truncate(checkpoint LogPos) {
+	m.logLock.Lock()
+	defer m.logLock.Unlock()
+	m.segMgr.SaveCheckpoint(checkpoint)
+	m.segMgr.pruneOldSegments(checkpoint.SegId)
+}
+
+// Checkpoint returns position of the last persisted checkpoint; found is false if there were no checkpoints
+func (m *LogManager) Checkpoint() (checkpoint LogPos, found bool) {
+	return m.segMgr.LoadCheckpoint()
 }
 
 func (m *LogManager) SegmentIterator() *SegmentIterator {
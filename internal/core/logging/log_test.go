@@ -2,6 +2,7 @@ package logging
 
 import (
 	"dbms/internal/utils"
+	"errors"
 	"github.com/stretchr/testify/assert"
 	"io"
 	"log"
@@ -21,7 +22,9 @@ func TestLogManager_Log(t *testing.T) {
 		snapshot := make([]byte, pageSize, pageSize)
 		for i := 0; i < keys; i++ {
 			snapshot[0] = byte(i)
-			logMgr.LogSnapshot(i, 0, snapshot)
+			logMgr.LogSnapshot(i, 0, func(int64) []byte {
+				return snapshot
+			})
 		}
 		logMgr.Flush()
 		segIter := SegmentIterator{segments: segMgr.segments}
@@ -32,7 +35,7 @@ func TestLogManager_Log(t *testing.T) {
 				assert.Equal(t, r.Type(), UpdateRecord)
 				assert.Equal(t, r.TxId(), i)
 				assert.Equal(t, r.Snapshot[0], byte(i))
-				logMgr.Release(i)
+				assert.Equal(t, int64(i+1), r.Lsn())
 				i++
 			}
 		}
@@ -42,3 +45,206 @@ func TestLogManager_Log(t *testing.T) {
 		log.Panic(execErr)
 	}
 }
+
+func TestLogManager_LogKeys(t *testing.T) {
+	defer os.RemoveAll("./log_segments")
+	segMgr := NewSegmentManager("./log_segments", 128)
+	segMgr.LoadSegments()
+	defer segMgr.CloseSegments()
+	logMgr := NewLogManager(segMgr)
+	logMgr.LogKey(1, "key")
+	logMgr.LogPrepare(1, "global-id")
+	logMgr.LogCommit(1)
+	logMgr.Flush()
+	expected := []*LogRecord{
+		{recType: KeyRecord, txId: 1, Key: []byte("key")},
+		{recType: PrepareRecord, txId: 1, Key: []byte("global-id")},
+		{recType: CommitRecord, txId: 1},
+	}
+	records := make([]*LogRecord, 0)
+	segIter := SegmentIterator{segments: segMgr.segments}
+	for seg := segIter.Next(); seg != nil; seg = segIter.Next() {
+		logIter := seg.LogIterator()
+		for r, err := logIter.Next(); err != io.EOF; r, err = logIter.Next() {
+			records = append(records, r)
+		}
+	}
+	assert.Equal(t, len(expected), len(records))
+	for i, r := range records {
+		assert.Equal(t, expected[i].Type(), r.Type())
+		assert.Equal(t, expected[i].TxId(), r.TxId())
+		assert.Equal(t, string(expected[i].Key), string(r.Key))
+	}
+}
+
+func TestLogManager_Checkpoint(t *testing.T) {
+	defer os.RemoveAll("./log_segments")
+	segMgr := NewSegmentManager("./log_segments", 64)
+	segMgr.LoadSegments()
+	defer segMgr.CloseSegments()
+	logMgr := NewLogManager(segMgr)
+	_, found := logMgr.Checkpoint()
+	assert.False(t, found)
+	// fill several segments before checkpoint
+	for i := 0; i < 8; i++ {
+		logMgr.LogCommit(i)
+	}
+	txs := []CheckpointTx{
+		{TxId: 1, Keys: []string{"a", "b"}},
+		{TxId: 2, Gid: "global-id", Keys: []string{"c"}},
+	}
+	pos := logMgr.LogCheckpoint(8, txs)
+	logMgr.LogCommit(9)
+	logMgr.Flush()
+	logMgr.Truncate(pos)
+	checkpoint, found := logMgr.Checkpoint()
+	assert.True(t, found)
+	assert.Equal(t, pos, checkpoint)
+	segIter := logMgr.SegmentIterator()
+	seg := segIter.Next()
+	assert.Equal(t, checkpoint.SegId, seg.Id())
+	logIter := seg.LogIteratorAt(checkpoint.Offset)
+	r, err := logIter.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, CheckpointRecord, r.Type())
+	assert.Equal(t, 8, r.TxId())
+	assert.Equal(t, txs, r.Txs)
+}
+
+func TestLogManager_Steal(t *testing.T) {
+	defer os.RemoveAll("./log_segments")
+	segMgr := NewSegmentManager("./log_segments", 64)
+	segMgr.LoadSegments()
+	defer segMgr.CloseSegments()
+	logMgr := NewLogManager(segMgr)
+	logMgr.LogCommit(1)
+	var stealLsn int64
+	pos := logMgr.LogSteal(8, []byte("before"), func(lsn int64) []byte {
+		stealLsn = lsn
+		return []byte("after")
+	})
+	// steal record is read while records are appended to the same segment
+	logMgr.LogCommit(2)
+	r := logMgr.ReadRecord(pos)
+	assert.Equal(t, StealRecord, r.Type())
+	assert.Equal(t, stealLsn, r.Lsn())
+	assert.Equal(t, int64(8), r.Pos)
+	assert.Equal(t, []byte("after"), r.Snapshot)
+	assert.Equal(t, []byte("before"), r.Before)
+	logMgr.LogCommit(3)
+	logMgr.Flush()
+	segIter := logMgr.SegmentIterator()
+	types := make([]int, 0)
+	for seg := segIter.Next(); seg != nil; seg = segIter.Next() {
+		logIter := seg.LogIterator()
+		for r, err := logIter.Next(); err != io.EOF; r, err = logIter.Next() {
+			assert.Nil(t, err)
+			types = append(types, r.Type())
+		}
+	}
+	assert.Equal(t, []int{CommitRecord, StealRecord, CommitRecord, CommitRecord}, types)
+}
+
+func TestLogManager_TornTail(t *testing.T) {
+	defer os.RemoveAll("./log_segments")
+	segMgr := NewSegmentManager("./log_segments", 1024)
+	segMgr.LoadSegments()
+	logMgr := NewLogManager(segMgr)
+	logMgr.LogKey(1, "key")
+	logMgr.LogCommit(1)
+	logMgr.Flush()
+	// crash in the middle of append
+	completeSize := segMgr.activeSeg.sizeNoLock()
+	logMgr.LogKey(2, "torn")
+	assert.Nil(t, segMgr.activeSeg.file.Truncate(int64(segMgr.activeSeg.sizeNoLock()-2)))
+	segMgr.CloseSegments()
+	segMgr = NewSegmentManager("./log_segments", 1024)
+	segMgr.LoadSegments()
+	defer segMgr.CloseSegments()
+	assert.Equal(t, completeSize, segMgr.activeSeg.sizeNoLock())
+	logMgr = NewLogManager(segMgr)
+	logMgr.LogCommit(3)
+	logMgr.Flush()
+	lsns := make([]int64, 0)
+	logIter := segMgr.activeSeg.LogIterator()
+	for r, err := logIter.Next(); err != io.EOF; r, err = logIter.Next() {
+		assert.Nil(t, err)
+		lsns = append(lsns, r.Lsn())
+	}
+	assert.Equal(t, []int64{1, 2, 3}, lsns)
+}
+
+func TestLogManager_CorruptedRecord(t *testing.T) {
+	defer os.RemoveAll("./log_segments")
+	segMgr := NewSegmentManager("./log_segments", 1024)
+	segMgr.LoadSegments()
+	defer segMgr.CloseSegments()
+	logMgr := NewLogManager(segMgr)
+	logMgr.LogKey(1, "key")
+	logMgr.Flush()
+	// damage the last byte of key
+	_, err := segMgr.activeSeg.file.WriteAt([]byte{0}, int64(segMgr.activeSeg.sizeNoLock()-1))
+	assert.Nil(t, err)
+	logIter := segMgr.activeSeg.LogIterator()
+	_, err = logIter.Next()
+	assert.Equal(t, ErrCorruptedRecord, err)
+}
+
+func TestLogManager_ShipFrames(t *testing.T) {
+	defer os.RemoveAll("./log_segments")
+	defer os.RemoveAll("./replica_segments")
+	segMgr := NewSegmentManager("./log_segments", 128)
+	segMgr.LoadSegments()
+	defer segMgr.CloseSegments()
+	logMgr := NewLogManager(segMgr)
+	replicaSegMgr := NewSegmentManager("./replica_segments", 128)
+	replicaSegMgr.LoadSegments()
+	defer replicaSegMgr.CloseSegments()
+	replicaLogMgr := NewLogManager(replicaSegMgr)
+	for i := 1; i <= 10; i++ {
+		logMgr.LogKey(i, "key")
+		logMgr.LogCommit(i)
+	}
+	logMgr.Flush()
+	// records which are not flushed are not shipped
+	logMgr.LogKey(11, "unflushed")
+	pos := replicaLogMgr.End()
+	for {
+		s, err := logMgr.ReadFrames(pos, 64)
+		assert.Nil(t, err)
+		if len(s.Data) == 0 {
+			assert.Equal(t, int64(20), s.EndLsn)
+			break
+		}
+		data, err := s.MarshalBinary()
+		assert.Nil(t, err)
+		shipped := new(Shipment)
+		assert.Nil(t, shipped.UnmarshalBinary(data))
+		_, _, err = replicaLogMgr.AppendFrames(shipped)
+		assert.Nil(t, err)
+		pos = shipped.Next()
+	}
+	assert.Equal(t, int64(20), replicaLogMgr.LastLsn())
+	assert.Equal(t, len(segMgr.segments), len(replicaSegMgr.segments))
+	// replica journal repeats flushed journal of primary, so positions have the same meaning
+	for i, seg := range segMgr.segments {
+		assert.Equal(t, seg.Id(), replicaSegMgr.segments[i].Id())
+		logIter, replicaIter := seg.LogIterator(), replicaSegMgr.segments[i].LogIterator()
+		for r, err := replicaIter.Next(); err != io.EOF; r, err = replicaIter.Next() {
+			expected, _ := logIter.Next()
+			assert.Equal(t, expected, r)
+		}
+	}
+	s, err := logMgr.ReadFrames(LogPos{pos.SegId, pos.Offset + 1024}, 64)
+	assert.Nil(t, s)
+	assert.True(t, errors.Is(err, ErrJournalPosition))
+	_, _, err = replicaLogMgr.AppendFrames(&Shipment{Pos: LogPos{0, 0}, Data: segmentData(t, segMgr.segments[0])})
+	assert.True(t, errors.Is(err, ErrJournalPosition))
+}
+
+func segmentData(t *testing.T, seg *Segment) []byte {
+	data := make([]byte, seg.sizeNoLock())
+	_, err := seg.file.ReadAt(data, 0)
+	assert.Nil(t, err)
+	return data
+}
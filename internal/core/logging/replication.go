@@ -0,0 +1,211 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+)
+
+// ErrJournalPosition is returned for position which isn't the end of replica journal or isn't kept by primary
+var ErrJournalPosition = errors.New("journal position not found")
+
+func (p LogPos) MarshalBinary() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, []int64{int64(p.SegId), p.Offset}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (p *LogPos) UnmarshalBinary(data []byte) error {
+	fields := make([]int64, 2)
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, fields); err != nil {
+		return err
+	}
+	p.SegId = int(fields[0])
+	p.Offset = fields[1]
+	return nil
+}
+
+// Shipment is a batch of journal frames shipped from primary to replica
+type Shipment struct {
+	// Pos is a position of the first frame in primary journal
+	Pos LogPos
+	// Data keeps frames as they are stored in segment
+	Data []byte
+	// EndLsn is an lsn of the last record flushed by primary
+	EndLsn int64
+}
+
+// Next returns position after frames of shipment
+func (s *Shipment) Next() LogPos {
+	return LogPos{s.Pos.SegId, s.Pos.Offset + int64(len(s.Data))}
+}
+
+func (s *Shipment) MarshalBinary() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, []int64{int64(s.Pos.SegId), s.Pos.Offset, s.EndLsn}); err != nil {
+		return nil, err
+	}
+	buf.Write(s.Data)
+	return buf.Bytes(), nil
+}
+
+func (s *Shipment) UnmarshalBinary(data []byte) error {
+	buf := bytes.NewBuffer(data)
+	hdr := make([]int64, 3)
+	if err := binary.Read(buf, binary.LittleEndian, hdr); err != nil {
+		return err
+	}
+	s.Pos = LogPos{int(hdr[0]), hdr[1]}
+	s.EndLsn = hdr[2]
+	s.Data = buf.Bytes()
+	return nil
+}
+
+// records decodes frames of shipment with their positions
+func (s *Shipment) records() ([]*LogRecord, []LogPos, error) {
+	records := make([]*LogRecord, 0)
+	positions := make([]LogPos, 0)
+	reader := bytes.NewReader(s.Data)
+	for {
+		offset := int64(len(s.Data) - reader.Len())
+		r, err := readFrame(reader, int64(reader.Len()))
+		if err == io.EOF {
+			return records, positions, nil
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("shipment at %d:%d: %w", s.Pos.SegId, s.Pos.Offset+offset, err)
+		}
+		records = append(records, r)
+		positions = append(positions, LogPos{s.Pos.SegId, s.Pos.Offset + offset})
+	}
+}
+
+// LastLsn returns lsn of the last record of journal
+func (m *LogManager) LastLsn() int64 {
+	m.logLock.Lock()
+	defer m.logLock.Unlock()
+	return int64(m.segMgr.lsnCtr.Value())
+}
+
+// End returns position after the last record of journal
+func (m *LogManager) End() LogPos {
+	m.logLock.Lock()
+	defer m.logLock.Unlock()
+	return LogPos{m.segMgr.activeSeg.Id(), int64(m.segMgr.activeSeg.sizeNoLock())}
+}
+
+// ReadFrames returns frames flushed to journal starting from passed position up to maxSize bytes
+// (the first frame is returned anyway); shipment is empty if there are no flushed frames after position
+func (m *LogManager) ReadFrames(pos LogPos, maxSize int) (*Shipment, error) {
+	m.logLock.Lock()
+	defer m.logLock.Unlock()
+	return m.segMgr.readFrames(pos, maxSize)
+}
+
+// AppendFrames appends frames shipped from primary and flushes them, so replica journal repeats the primary one
+// record by record and its positions and checkpoints have the same meaning; shipment must start at the end
+// of journal or at the beginning of the next segment; appended records are returned with their positions
+func (m *LogManager) AppendFrames(s *Shipment) ([]*LogRecord, []LogPos, error) {
+	records, positions, err := s.records()
+	if err != nil || len(records) == 0 {
+		return nil, nil, err
+	}
+	m.logLock.Lock()
+	defer m.logLock.Unlock()
+	if err := m.segMgr.appendFrames(s.Pos, s.Data); err != nil {
+		return nil, nil, err
+	}
+	m.segMgr.lsnCtr.Init(int(records[len(records)-1].Lsn()))
+	m.segMgr.Flush()
+	return records, positions, nil
+}
+
+func (m *SegmentManager) readFrames(pos LogPos, maxSize int) (*Shipment, error) {
+	s := &Shipment{Pos: pos, EndLsn: m.flushedLsn}
+	// frames of segments before the last flushed one are flushed before switch to the next segment
+	for segPos := pos; segPos.SegId <= m.flushed.SegId; segPos = (LogPos{segPos.SegId + 1, 0}) {
+		seg := m.segment(segPos.SegId)
+		if seg == nil {
+			return nil, fmt.Errorf("%w: segment %d is removed", ErrJournalPosition, segPos.SegId)
+		}
+		end := int64(seg.sizeNoLock())
+		if segPos.Offset > end {
+			return nil, fmt.Errorf("%w: %d:%d is after the end of journal", ErrJournalPosition, segPos.SegId, segPos.Offset)
+		}
+		if seg.Id() == m.flushed.SegId {
+			// replica restored from backup may have frames which are not flushed by primary yet
+			if segPos.Offset >= m.flushed.Offset {
+				return s, nil
+			}
+			end = m.flushed.Offset
+		}
+		if segPos.Offset < end {
+			data, err := seg.readFramesAt(segPos.Offset, end, maxSize)
+			if err != nil {
+				return nil, err
+			}
+			s.Pos = segPos
+			s.Data = data
+			return s, nil
+		}
+	}
+	return s, nil
+}
+
+func (m *SegmentManager) appendFrames(pos LogPos, data []byte) error {
+	size := int64(m.activeSeg.sizeNoLock())
+	if pos.SegId == m.activeSeg.Id()+1 && pos.Offset == 0 {
+		m.activeSeg.Flush()
+		m.activeSeg = m.allocateNewSegment()
+	} else if pos.SegId != m.activeSeg.Id() || pos.Offset != size {
+		return fmt.Errorf("%w: shipment starts at %d:%d, but journal ends at %d:%d",
+			ErrJournalPosition, pos.SegId, pos.Offset, m.activeSeg.Id(), size)
+	}
+	if _, err := m.activeSeg.file.Write(data); err != nil {
+		log.Panic(err)
+	}
+	return nil
+}
+
+func (m *SegmentManager) segment(segId int) *Segment {
+	for _, seg := range m.segments {
+		if seg.Id() == segId {
+			return seg
+		}
+	}
+	return nil
+}
+
+// readFramesAt reads complete frames between offsets up to maxSize bytes; the first frame is read anyway
+func (s *Segment) readFramesAt(offset int64, end int64, maxSize int) ([]byte, error) {
+	hdr := make([]byte, frameHeaderSize)
+	if _, err := s.file.ReadAt(hdr, offset); err != nil {
+		return nil, err
+	}
+	size := end - offset
+	if first := int64(frameHeaderSize + binary.LittleEndian.Uint32(hdr)); first > size {
+		return nil, fmt.Errorf("journal segment %s: %w", s.Name(), ErrCorruptedRecord)
+	} else if size > int64(maxSize) {
+		size = int64(maxSize)
+		if first > size {
+			size = first
+		}
+	}
+	data := make([]byte, size)
+	if _, err := s.file.ReadAt(data, offset); err != nil {
+		return nil, err
+	}
+	var n int64
+	for n+frameHeaderSize <= size {
+		frameSize := int64(frameHeaderSize + binary.LittleEndian.Uint32(data[n:]))
+		if n+frameSize > size {
+			break
+		}
+		n += frameSize
+	}
+	return data[:n], nil
+}
@@ -3,7 +3,9 @@ package logging
 import (
 	"dbms/internal/atomic"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"io/fs"
 	"log"
@@ -15,39 +17,22 @@ import (
 	"sync"
 )
 
-// NOTE: pls, dudes, add generics to Golang 2
-type IntHashSet struct {
-	set map[int]struct{}
-}
-
-func NewIntHashSet() *IntHashSet {
-	s := new(IntHashSet)
-	s.set = make(map[int]struct{})
-	return s
-}
-
-func (s *IntHashSet) Empty() bool {
-	return len(s.set) == 0
-}
+// uint32 length + uint32 checksum
+const frameHeaderSize = 8
 
-func (s *IntHashSet) Add(value int) {
-	s.set[value] = struct{}{}
-}
-
-func (s *IntHashSet) Remove(value int) {
-	delete(s.set, value)
-}
-
-func (s *IntHashSet) Has(value int) bool {
-	_, found := s.set[value]
-	return found
-}
+// ErrCorruptedRecord is returned for record which is written partially or damaged
+var ErrCorruptedRecord = errors.New("corrupted journal record: torn write or checksum mismatch")
 
 // NOTE: not thread-safe
 type LogIterator struct {
 	seg *Segment
 }
 
+// Offset returns offset of the next record in segment
+func (i *LogIterator) Offset() int64 {
+	return i.seg.offsetNoLock()
+}
+
 func (i *LogIterator) Next() (*LogRecord, error) {
 	if i.seg.sizeNoLock() == 0 {
 		return nil, io.EOF
@@ -60,7 +45,6 @@ type Segment struct {
 	cap      int
 	fileLock sync.Mutex
 	file     *os.File
-	txRefSet *IntHashSet
 }
 
 func NewSegment(id int, cap int, file *os.File) *Segment {
@@ -68,7 +52,6 @@ func NewSegment(id int, cap int, file *os.File) *Segment {
 	s.id = id
 	s.cap = cap
 	s.file = file
-	s.txRefSet = NewIntHashSet()
 	if _, seekErr := s.file.Seek(0, io.SeekEnd); seekErr != nil {
 		log.Panic(seekErr)
 	}
@@ -83,23 +66,16 @@ func (s *Segment) Id() int {
 	return s.id
 }
 
-func (s *Segment) Pin(txId int) {
-	s.txRefSet.Add(txId)
-}
-
-func (s *Segment) Unpin(txId int) {
-	s.txRefSet.Remove(txId)
-}
-
-func (s *Segment) Used() bool {
-	return !s.txRefSet.Empty()
-}
-
+// Append writes data framed with its length and checksum if it fits segment; empty segment accepts data
+// of any size, e.g. checkpoint record of many active keys
 func (s *Segment) Append(data []byte) bool {
-	if len(data)+s.sizeNoLock() > s.cap {
+	if size := s.sizeNoLock(); size != 0 && frameHeaderSize+len(data)+size > s.cap {
 		return false
 	}
-	if _, writeErr := s.file.Write(data); writeErr != nil {
+	frame := make([]byte, frameHeaderSize, frameHeaderSize+len(data))
+	binary.LittleEndian.PutUint32(frame, uint32(len(data)))
+	binary.LittleEndian.PutUint32(frame[4:], crc32.ChecksumIEEE(data))
+	if _, writeErr := s.file.Write(append(frame, data...)); writeErr != nil {
 		log.Panic(writeErr)
 	}
 	return true
@@ -126,9 +102,14 @@ func (s *Segment) Close() {
 }
 
 func (s *Segment) LogIterator() *LogIterator {
+	return s.LogIteratorAt(0)
+}
+
+// LogIteratorAt iterates records starting from passed offset in segment
+func (s *Segment) LogIteratorAt(offset int64) *LogIterator {
 	i := new(LogIterator)
 	i.seg = s
-	if _, seekErr := s.file.Seek(0, io.SeekStart); seekErr != nil {
+	if _, seekErr := s.file.Seek(offset, io.SeekStart); seekErr != nil {
 		log.Panic(seekErr)
 	}
 	return i
@@ -142,41 +123,97 @@ func (s *Segment) sizeNoLock() int {
 	return int(info.Size())
 }
 
-func (s *Segment) readNoLock() (*LogRecord, error) {
-	r := new(LogRecord)
-	if readErr := binary.Read(s.file, binary.LittleEndian, &r.recType); readErr != nil {
-		if readErr == io.EOF {
-			return nil, io.EOF
-		}
-		log.Panic(readErr)
+func (s *Segment) offsetNoLock() int64 {
+	offset, seekErr := s.file.Seek(0, io.SeekCurrent)
+	if seekErr != nil {
+		log.Panic(seekErr)
 	}
-	if readErr := binary.Read(s.file, binary.LittleEndian, &r.txId); readErr != nil {
+	return offset
+}
+
+// readNoLock returns io.EOF at the end of segment and ErrCorruptedRecord if frame is incomplete
+// or its checksum mismatches
+func (s *Segment) readNoLock() (*LogRecord, error) {
+	return readFrame(s.file, int64(s.sizeNoLock())-s.offsetNoLock())
+}
+
+// readAt reads record at passed offset without moving file offset used by appends
+func (s *Segment) readAt(offset int64) (*LogRecord, error) {
+	size := int64(s.sizeNoLock())
+	return readFrame(io.NewSectionReader(s.file, offset, size-offset), size-offset)
+}
+
+// readFrame reads record framed by Append; remaining bounds record length, which is garbage in torn frame
+func readFrame(reader io.Reader, remaining int64) (*LogRecord, error) {
+	frame := make([]byte, frameHeaderSize)
+	if _, readErr := io.ReadFull(reader, frame); readErr == io.EOF {
+		return nil, io.EOF
+	} else if readErr == io.ErrUnexpectedEOF {
+		return nil, ErrCorruptedRecord
+	} else if readErr != nil {
 		log.Panic(readErr)
 	}
-	if r.recType != UpdateRecord {
-		return r, nil
+	dataLen := int64(binary.LittleEndian.Uint32(frame))
+	// length of torn frame may be garbage, so it is checked before allocation
+	if dataLen > remaining-frameHeaderSize {
+		return nil, ErrCorruptedRecord
 	}
-	// extract snapshot specific fields
-	if readErr := binary.Read(s.file, binary.LittleEndian, &r.Pos); readErr != nil {
+	data := make([]byte, dataLen)
+	if _, readErr := io.ReadFull(reader, data); readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+		return nil, ErrCorruptedRecord
+	} else if readErr != nil {
 		log.Panic(readErr)
 	}
-	if readErr := binary.Read(s.file, binary.LittleEndian, &r.snapshotLen); readErr != nil {
-		log.Panic(readErr)
+	if binary.LittleEndian.Uint32(frame[4:]) != crc32.ChecksumIEEE(data) {
+		return nil, ErrCorruptedRecord
 	}
-	r.Snapshot = make([]byte, r.snapshotLen, r.snapshotLen)
-	if _, err := s.file.Read(r.Snapshot); err != nil {
-		log.Panic(err)
+	r := new(LogRecord)
+	if unmarshalErr := r.UnmarshalBinary(data); unmarshalErr != nil {
+		return nil, ErrCorruptedRecord
 	}
 	return r, nil
 }
 
+// lastLsn returns lsn of the last record in segment (0 if segment is empty); if tail is corrupted
+// (e.g. append is interrupted by crash), segment is truncated to the last complete record
+// when truncate is set, otherwise journal is considered damaged
+func (s *Segment) lastLsn(truncate bool) int64 {
+	var lsn int64
+	i := s.LogIterator()
+	for {
+		offset := s.offsetNoLock()
+		r, err := i.Next()
+		if err == io.EOF {
+			return lsn
+		} else if err == ErrCorruptedRecord && truncate {
+			log.Printf("Truncated corrupted tail of journal segment %s at %d", s.Name(), offset)
+			if truncErr := s.file.Truncate(offset); truncErr != nil {
+				log.Panic(truncErr)
+			}
+			if _, seekErr := s.file.Seek(0, io.SeekEnd); seekErr != nil {
+				log.Panic(seekErr)
+			}
+			return lsn
+		} else if err != nil {
+			log.Panicf("journal segment %s: %v", s.Name(), err)
+		}
+		lsn = r.Lsn()
+	}
+}
+
 // NOTE: not thread-safe; used only with LogManager in concurrent mode
 type SegmentManager struct {
 	segDir    string
 	segCap    int
 	segments  []*Segment
 	segIdCtr  atomic.AtomicCounter
+	lsnCtr    atomic.AtomicCounter
 	activeSeg *Segment
+	// archiveDir is a directory where segments are copied before removal
+	archiveDir string
+	// flushed is a position after the last synced record; only synced records are shipped to replicas
+	flushed    LogPos
+	flushedLsn int64
 }
 
 type SegmentsToSort []*Segment
@@ -185,12 +222,13 @@ func (s SegmentsToSort) Len() int           { return len(s) }
 func (s SegmentsToSort) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
 func (s SegmentsToSort) Less(i, j int) bool { return s[i].Id() < s[j].Id() }
 
+var segmentFileRegex = regexp.MustCompile(`^segment([0-9]+)\.bin$`)
+
 func (m *SegmentManager) loadSegments(segDir string, segCap int) {
-	logFileRegex := regexp.MustCompile(`^segment([0-9]+)\.bin$`)
 	m.segments = make([]*Segment, 0)
 	err := filepath.WalkDir(segDir, func(path string, _ fs.DirEntry, err error) error {
 		baseName := filepath.Base(path)
-		if match := logFileRegex.FindStringSubmatch(baseName); match != nil {
+		if match := segmentFileRegex.FindStringSubmatch(baseName); match != nil {
 			segId, err := strconv.Atoi(match[1])
 			if err != nil {
 				return err
@@ -229,30 +267,48 @@ func (m *SegmentManager) LoadSegments() {
 	}
 	m.activeSeg = m.segments[len(m.segments)-1]
 	m.segIdCtr.Init(m.activeSeg.Id())
+	// only active segment may be appended partially; lsn sequence continues from the last record
+	var lastLsn int64
+	for segIdx := len(m.segments) - 1; segIdx >= 0 && lastLsn == 0; segIdx-- {
+		lastLsn = m.segments[segIdx].lastLsn(m.segments[segIdx] == m.activeSeg)
+	}
+	m.lsnCtr.Init(int(lastLsn))
+	m.flushed = LogPos{m.activeSeg.Id(), int64(m.activeSeg.sizeNoLock())}
+	m.flushedLsn = lastLsn
 }
 
-// Log returns segId in which data was logged to
-func (m *SegmentManager) Log(txId int, data []byte) {
-	m.activeSeg.Pin(txId)
+func (m *SegmentManager) readRecord(pos LogPos) *LogRecord {
+	for _, seg := range m.segments {
+		if seg.Id() == pos.SegId {
+			r, err := seg.readAt(pos.Offset)
+			if err != nil {
+				log.Panicf("journal segment %s: %v", seg.Name(), err)
+			}
+			return r
+		}
+	}
+	log.Panicf("journal segment %d not found", pos.SegId)
+	return nil
+}
+
+func (m *SegmentManager) nextLsn() int64 {
+	return int64(m.lsnCtr.Incr())
+}
+
+// Log returns position of logged data
+func (m *SegmentManager) Log(data []byte) LogPos {
 	if !m.activeSeg.Append(data) {
 		m.activeSeg.Flush()
 		m.activeSeg = m.allocateNewSegment()
-		m.activeSeg.Pin(txId)
-		// new segment is expected to fit new record
 		m.activeSeg.Append(data)
-		m.pruneOldSegments()
-	}
-}
-
-func (m *SegmentManager) Unpin(txId int) {
-	for _, seg := range m.segments {
-		seg.Unpin(txId)
 	}
-	m.pruneOldSegments()
+	return LogPos{m.activeSeg.Id(), int64(m.activeSeg.sizeNoLock() - frameHeaderSize - len(data))}
 }
 
 func (m *SegmentManager) Flush() {
 	m.activeSeg.Flush()
+	m.flushed = LogPos{m.activeSeg.Id(), int64(m.activeSeg.sizeNoLock())}
+	m.flushedLsn = int64(m.lsnCtr.Value())
 }
 
 func (m *SegmentManager) CloseSegments() {
@@ -274,12 +330,56 @@ func (m *SegmentManager) allocateNewSegment() *Segment {
 	return seg
 }
 
-func (m *SegmentManager) pruneOldSegments() {
+// pruneOldSegments removes segments before segment with passed id; active segment is kept anyway;
+// removed segments are archived if archive directory is set
+func (m *SegmentManager) pruneOldSegments(segId int) {
 	for segIdx, seg := range m.segments {
-		if seg.Used() || seg.Id() == m.activeSeg.Id() {
+		if seg.Id() >= segId || seg.Id() == m.activeSeg.Id() {
 			m.segments = m.segments[segIdx:]
 			return
 		}
+		if m.archiveDir != "" {
+			seg.archive(m.archiveDir)
+		}
 		seg.CloseAndRemoveFile()
 	}
 }
+
+func (m *SegmentManager) checkpointPath() string {
+	return filepath.Join(m.segDir, "checkpoint.bin")
+}
+
+// SaveCheckpoint persists checkpoint position atomically: new position is written to temporary file,
+// which replaces the previous one
+func (m *SegmentManager) SaveCheckpoint(checkpoint LogPos) {
+	tmpName := m.checkpointPath() + ".tmp"
+	file, err := os.OpenFile(tmpName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		log.Panic(err)
+	}
+	if writeErr := binary.Write(file, binary.LittleEndian, []int64{int64(checkpoint.SegId), checkpoint.Offset}); writeErr != nil {
+		log.Panic(writeErr)
+	}
+	if syncErr := file.Sync(); syncErr != nil {
+		log.Panic(syncErr)
+	}
+	file.Close()
+	if renameErr := os.Rename(tmpName, m.checkpointPath()); renameErr != nil {
+		log.Panic(renameErr)
+	}
+}
+
+func (m *SegmentManager) LoadCheckpoint() (LogPos, bool) {
+	file, err := os.Open(m.checkpointPath())
+	if os.IsNotExist(err) {
+		return LogPos{}, false
+	} else if err != nil {
+		log.Panic(err)
+	}
+	defer file.Close()
+	fields := make([]int64, 2)
+	if readErr := binary.Read(file, binary.LittleEndian, fields); readErr != nil {
+		log.Panic(readErr)
+	}
+	return LogPos{int(fields[0]), fields[1]}, true
+}
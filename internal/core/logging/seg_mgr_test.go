@@ -21,7 +21,7 @@ func TestSegmentManager_Log(t *testing.T) {
 		log.Panic(err)
 	}
 	for i := 0; i < 5; i++ {
-		segMgr.Log(txId, data)
+		segMgr.Log(data)
 	}
 	segIter := SegmentIterator{segments: segMgr.segments}
 	for seg := segIter.Next(); seg != nil; seg = segIter.Next() {
@@ -30,5 +30,4 @@ func TestSegmentManager_Log(t *testing.T) {
 			log.Print(r)
 		}
 	}
-	segMgr.Unpin(txId)
 }
@@ -0,0 +1,87 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	voteRequest   = 0
+	voteReply     = 1
+	appendRequest = 2
+	appendReply   = 3
+)
+
+// message is a request or a reply of raft RPC; fields meaning depends on message type:
+// vote request passes index and term of the last entry of candidate; append request passes index and term
+// of the entry preceding passed entries and commit index of leader; replies report success and term
+// of receiver, and append reply passes index of the last entry matching leader log (or a hint where
+// leader should continue from on failure)
+type message struct {
+	Type    byte
+	Term    int64
+	From    string
+	Index   int64
+	LogTerm int64
+	Commit  int64
+	Success bool
+	Entries []Entry
+}
+
+func (m *message) MarshalBinary() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	var success byte
+	if m.Success {
+		success = 1
+	}
+	mustWrite(buf, m.Type)
+	mustWrite(buf, []int64{m.Term, m.Index, m.LogTerm, m.Commit})
+	mustWrite(buf, success)
+	writeBytes(buf, []byte(m.From))
+	mustWrite(buf, uint32(len(m.Entries)))
+	for _, e := range m.Entries {
+		mustWrite(buf, e.Term)
+		writeBytes(buf, e.Data)
+	}
+	return buf.Bytes(), nil
+}
+
+func (m *message) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	fields := make([]int64, 4)
+	var success byte
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &m.Type); err != nil {
+		return err
+	}
+	if err := binary.Read(r, binary.LittleEndian, fields); err != nil {
+		return err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &success); err != nil {
+		return err
+	}
+	from, err := readBytes(r)
+	if err != nil {
+		return err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return err
+	}
+	if int64(count) > int64(r.Len()) {
+		return fmt.Errorf("raft message declares %d entries in %d bytes", count, r.Len())
+	}
+	m.Term, m.Index, m.LogTerm, m.Commit = fields[0], fields[1], fields[2], fields[3]
+	m.Success = success == 1
+	m.From = string(from)
+	m.Entries = make([]Entry, count)
+	for i := range m.Entries {
+		if err := binary.Read(r, binary.LittleEndian, &m.Entries[i].Term); err != nil {
+			return err
+		}
+		if m.Entries[i].Data, err = readBytes(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,646 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var ErrStopped = errors.New("raft node is stopped")
+
+// NotLeaderError is returned by node which doesn't accept changes; Leader is an address of the current leader
+// known to node, it is empty during election
+type NotLeaderError struct {
+	Leader string
+}
+
+func (e *NotLeaderError) Error() string {
+	if e.Leader == "" {
+		return "node is not a leader, leader is unknown"
+	}
+	return "node is not a leader, leader is " + e.Leader
+}
+
+const (
+	Follower  = "follower"
+	Candidate = "candidate"
+	Leader    = "leader"
+)
+
+const (
+	// tickInterval is a period of election timeout checks
+	tickInterval = 10 * time.Millisecond
+	// maxAppendEntries bounds entries sent by single append request
+	maxAppendEntries = 256
+	// retainedEntries is a number of applied entries kept by compaction, so followers lagging behind
+	// by fewer entries catch up from log
+	retainedEntries = 1000
+)
+
+// StateMachine applies committed entries
+type StateMachine interface {
+	// Apply is called for committed entries in log order; entries applied before restart may be applied again,
+	// so entry must define state it changes regardless of the previous one; entry which Apply returns from
+	// after node stops isn't marked applied
+	Apply(index int64, term int64, data []byte)
+}
+
+// Status describes node state
+type Status struct {
+	Addr   string
+	Role   string
+	Term   int64
+	Leader string
+	// LastIndex is an index of the last entry of node log
+	LastIndex int64
+	// Compacted is an index of the last entry removed from log by compaction
+	Compacted   int64
+	CommitIndex int64
+	Applied     int64
+}
+
+// Node replicates log of entries across cluster by Raft consensus: leader elected by majority of nodes appends
+// proposed entries to its log and replicates them to followers; entry is committed when majority of nodes
+// keeps it, and committed entries are applied to state machine of each node in log order; nodes are identified
+// by their addresses, and set of nodes is fixed
+type Node struct {
+	addr              string
+	peers             []string
+	transport         Transport
+	electionTimeout   time.Duration
+	heartbeatInterval time.Duration
+	sm                StateMachine
+	mux               sync.Mutex
+	store             *storage
+	role              string
+	// leader is an address of the current leader; leader sets it to its own address when it is ready
+	// to accept proposals
+	leader      string
+	commitIndex int64
+	// termStart is an index of empty entry appended by leader at the beginning of its term; leader accepts
+	// proposals when entries up to it are applied, so entries of the previous terms are applied before new ones
+	termStart        int64
+	electionDeadline time.Time
+	nextIndex        map[string]int64
+	matchIndex       map[string]int64
+	// lagging marks peers which need entries removed from log by compaction
+	lagging map[string]bool
+	// retained is a number of applied entries kept by compaction
+	retained int64
+	// replicate wakes replication to peer before the next heartbeat
+	replicate map[string]chan struct{}
+	// applied wakes applying of committed entries
+	applied chan struct{}
+	rand    *rand.Rand
+	running bool
+	stop    chan struct{}
+	done    sync.WaitGroup
+}
+
+// NewNode creates node with passed address; nodes lists addresses of all nodes of cluster
+func NewNode(
+	addr string,
+	nodes []string,
+	dir string,
+	electionTimeout time.Duration,
+	transport Transport,
+) *Node {
+	n := new(Node)
+	n.addr = addr
+	for _, node := range nodes {
+		if node != addr {
+			n.peers = append(n.peers, node)
+		}
+	}
+	n.transport = transport
+	n.electionTimeout = electionTimeout
+	n.heartbeatInterval = electionTimeout / 5
+	n.store = newStorage(dir)
+	n.role = Follower
+	n.nextIndex = make(map[string]int64)
+	n.matchIndex = make(map[string]int64)
+	n.lagging = make(map[string]bool)
+	n.retained = retainedEntries
+	n.replicate = make(map[string]chan struct{})
+	for _, peer := range n.peers {
+		n.replicate[peer] = make(chan struct{}, 1)
+	}
+	n.applied = make(chan struct{}, 1)
+	n.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	return n
+}
+
+func (n *Node) SetStateMachine(sm StateMachine) {
+	n.sm = sm
+}
+
+// Start loads log and state of node and runs it in background till Stop; node starts as a follower
+func (n *Node) Start() {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	if n.running {
+		return
+	}
+	n.store.load()
+	// compacted entries are committed
+	n.commitIndex = n.store.compacted
+	n.running = true
+	n.resetElectionDeadline()
+	n.stop = make(chan struct{})
+	n.done.Add(2 + len(n.peers))
+	go n.tickLoop()
+	go n.applyLoop()
+	for _, peer := range n.peers {
+		go n.replicateLoop(peer)
+	}
+	log.Printf("Raft node %s starts at term %d with %d log entries (%d applied, %d compacted)",
+		n.addr, n.store.term, n.store.lastIndex(), n.store.applied, n.store.compacted)
+}
+
+func (n *Node) Stop() {
+	n.mux.Lock()
+	if !n.running {
+		n.mux.Unlock()
+		return
+	}
+	n.running = false
+	n.role = Follower
+	n.leader = ""
+	close(n.stop)
+	n.mux.Unlock()
+	n.done.Wait()
+	n.transport.Close()
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.store.close()
+}
+
+// Propose appends entry to log of leader and returns its index and term; entry is applied when it is committed,
+// unless it is replaced by entry of another leader with the same index
+func (n *Node) Propose(data []byte) (int64, int64, error) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	if n.role != Leader || n.leader != n.addr {
+		return 0, 0, &NotLeaderError{Leader: n.leader}
+	}
+	n.store.append([]Entry{{Term: n.store.term, Data: data}})
+	n.advanceCommit()
+	n.triggerReplication()
+	return n.store.lastIndex(), n.store.term, nil
+}
+
+// Leader returns address of the current leader known to node; it is empty during election
+func (n *Node) Leader() string {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	return n.leader
+}
+
+// Addr returns address of node
+func (n *Node) Addr() string {
+	return n.addr
+}
+
+// Done returns channel which is closed when node stops, so state machine stops waiting for entry to be applied
+func (n *Node) Done() <-chan struct{} {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	return n.stop
+}
+
+// Compact removes applied entries from log except the last retained ones; state machine must keep applied
+// entries durable, so it is called after checkpoint of storage
+func (n *Node) Compact() {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	if !n.running {
+		return
+	}
+	// applied index loaded on start may be ahead of commit index known to node
+	index := n.store.applied
+	if index > n.commitIndex {
+		index = n.commitIndex
+	}
+	index -= n.retained
+	if index <= n.store.compacted {
+		return
+	}
+	n.store.compact(index)
+	log.Printf("Raft node %s compacted log up to entry %d", n.addr, index)
+}
+
+// CopyLog writes log of node compacted at applied index to passed directory; node started on it with storage
+// copied after that catches up from leader, so it restores node which lags behind compacted log of leader
+func (n *Node) CopyLog(dir string) error {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	if !n.running {
+		return ErrStopped
+	}
+	return n.store.copyLog(dir)
+}
+
+func (n *Node) Status() Status {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	return Status{
+		Addr:        n.addr,
+		Role:        n.role,
+		Term:        n.store.term,
+		Leader:      n.leader,
+		LastIndex:   n.store.lastIndex(),
+		Compacted:   n.store.compacted,
+		CommitIndex: n.commitIndex,
+		Applied:     n.store.applied,
+	}
+}
+
+// Handle processes request sent by another node and returns reply
+func (n *Node) Handle(data []byte) ([]byte, error) {
+	req := new(message)
+	if err := req.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	if !n.running {
+		return nil, ErrStopped
+	}
+	switch req.Type {
+	case voteRequest:
+		return n.handleVote(req).MarshalBinary()
+	case appendRequest:
+		return n.handleAppend(req).MarshalBinary()
+	}
+	return nil, fmt.Errorf("unexpected raft message type %d", req.Type)
+}
+
+func (n *Node) quorum() int {
+	return (len(n.peers)+1)/2 + 1
+}
+
+// resetElectionDeadline randomizes election timeout, so nodes rarely become candidates at the same time
+func (n *Node) resetElectionDeadline() {
+	n.electionDeadline = time.Now().Add(n.electionTimeout + time.Duration(n.rand.Int63n(int64(n.electionTimeout))))
+}
+
+func (n *Node) call(peer string, req []byte) (*message, error) {
+	data, err := n.transport.Call(peer, req)
+	if err != nil {
+		return nil, err
+	}
+	reply := new(message)
+	if err := reply.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func mustMarshal(m *message) []byte {
+	data, err := m.MarshalBinary()
+	if err != nil {
+		log.Panic(err)
+	}
+	return data
+}
+
+func (n *Node) tickLoop() {
+	defer n.done.Done()
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+		}
+		n.mux.Lock()
+		if n.role != Leader && time.Now().After(n.electionDeadline) {
+			n.startElection()
+		}
+		n.mux.Unlock()
+	}
+}
+
+// startElection makes node a candidate of the next term, which requests votes of peers; must be called
+// with mutex held
+func (n *Node) startElection() {
+	n.store.term++
+	n.store.votedFor = n.addr
+	n.store.saveState(true)
+	n.role = Candidate
+	n.leader = ""
+	n.resetElectionDeadline()
+	term := n.store.term
+	votes := 1
+	if votes >= n.quorum() {
+		n.becomeLeader()
+		return
+	}
+	last := n.store.lastIndex()
+	req := mustMarshal(&message{Type: voteRequest, Term: term, From: n.addr, Index: last, LogTerm: n.store.termAt(last)})
+	for _, peer := range n.peers {
+		go func(peer string) {
+			reply, err := n.call(peer, req)
+			if err != nil {
+				return
+			}
+			n.mux.Lock()
+			defer n.mux.Unlock()
+			if !n.running {
+				return
+			}
+			if reply.Term > n.store.term {
+				n.stepDown(reply.Term)
+				return
+			}
+			if n.role != Candidate || n.store.term != term || !reply.Success {
+				return
+			}
+			votes++
+			if votes == n.quorum() {
+				n.becomeLeader()
+			}
+		}(peer)
+	}
+}
+
+// becomeLeader starts term of leader with empty entry, which commits entries of the previous terms;
+// must be called with mutex held
+func (n *Node) becomeLeader() {
+	n.role = Leader
+	n.leader = ""
+	last := n.store.lastIndex()
+	for _, peer := range n.peers {
+		n.nextIndex[peer] = last + 1
+		n.matchIndex[peer] = 0
+		n.lagging[peer] = false
+	}
+	n.store.append([]Entry{{Term: n.store.term}})
+	n.termStart = last + 1
+	log.Printf("Raft node %s is elected as leader at term %d", n.addr, n.store.term)
+	n.advanceCommit()
+	n.triggerReplication()
+}
+
+// stepDown makes node a follower; term is advanced if passed one is newer; must be called with mutex held
+func (n *Node) stepDown(term int64) {
+	if term > n.store.term {
+		n.store.term = term
+		n.store.votedFor = ""
+		n.store.saveState(true)
+	}
+	if n.role == Leader {
+		log.Printf("Raft node %s isn't a leader since term %d", n.addr, n.store.term)
+	}
+	if n.role != Follower {
+		n.role = Follower
+		n.resetElectionDeadline()
+	}
+	n.leader = ""
+}
+
+func (n *Node) handleVote(req *message) *message {
+	if req.Term > n.store.term {
+		n.stepDown(req.Term)
+	}
+	last := n.store.lastIndex()
+	lastTerm := n.store.termAt(last)
+	// candidate must have all committed entries, so its log must be at least as up-to-date as the voter one
+	upToDate := req.LogTerm > lastTerm || (req.LogTerm == lastTerm && req.Index >= last)
+	granted := req.Term == n.store.term && (n.store.votedFor == "" || n.store.votedFor == req.From) && upToDate
+	if granted {
+		if n.store.votedFor != req.From {
+			n.store.votedFor = req.From
+			n.store.saveState(true)
+		}
+		n.resetElectionDeadline()
+	}
+	return &message{Type: voteReply, Term: n.store.term, From: n.addr, Success: granted}
+}
+
+func (n *Node) handleAppend(req *message) *message {
+	reply := &message{Type: appendReply, Term: n.store.term, From: n.addr}
+	if req.Term < n.store.term {
+		return reply
+	}
+	if req.Term > n.store.term || n.role != Follower {
+		n.stepDown(req.Term)
+	}
+	n.leader = req.From
+	n.resetElectionDeadline()
+	reply.Term = n.store.term
+	last := n.store.lastIndex()
+	if req.Index > last {
+		reply.Index = last
+		return reply
+	}
+	if compacted := n.store.compacted; req.Index < compacted {
+		// compacted entries are committed, so they match entries of leader and are skipped
+		skip := compacted - req.Index
+		if skip > int64(len(req.Entries)) {
+			skip = int64(len(req.Entries))
+		}
+		req.Entries = req.Entries[skip:]
+		req.Index, req.LogTerm = compacted, n.store.termAt(compacted)
+	}
+	if conflictTerm := n.store.termAt(req.Index); conflictTerm != req.LogTerm {
+		// entries of conflicting term are skipped at once
+		index := req.Index - 1
+		for index > n.commitIndex && n.store.termAt(index) == conflictTerm {
+			index--
+		}
+		reply.Index = index
+		return reply
+	}
+	for i, e := range req.Entries {
+		index := req.Index + 1 + int64(i)
+		if index <= n.store.lastIndex() {
+			if n.store.termAt(index) == e.Term {
+				continue
+			}
+			n.store.truncate(index)
+		}
+		n.store.append(req.Entries[i:])
+		break
+	}
+	match := req.Index + int64(len(req.Entries))
+	if commit := req.Commit; commit > n.commitIndex {
+		if commit > match {
+			commit = match
+		}
+		if commit > n.commitIndex {
+			n.commitIndex = commit
+			n.notifyApply()
+		}
+	}
+	reply.Success = true
+	reply.Index = match
+	return reply
+}
+
+func (n *Node) triggerReplication() {
+	for _, peer := range n.peers {
+		select {
+		case n.replicate[peer] <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (n *Node) notifyApply() {
+	select {
+	case n.applied <- struct{}{}:
+	default:
+	}
+}
+
+// replicateLoop sends entries or heartbeats to peer while node is a leader
+func (n *Node) replicateLoop(peer string) {
+	defer n.done.Done()
+	ticker := time.NewTicker(n.heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-n.replicate[peer]:
+		case <-ticker.C:
+		}
+		n.sendAppend(peer)
+	}
+}
+
+func (n *Node) sendAppend(peer string) {
+	n.mux.Lock()
+	if n.role != Leader {
+		n.mux.Unlock()
+		return
+	}
+	term := n.store.term
+	prev := n.nextIndex[peer] - 1
+	if prev < n.store.compacted {
+		// peer which has entries up to compacted index catches up anyway
+		prev = n.store.compacted
+	}
+	// request is marshaled with mutex held, because entries share memory with log
+	req := mustMarshal(&message{
+		Type:    appendRequest,
+		Term:    term,
+		From:    n.addr,
+		Index:   prev,
+		LogTerm: n.store.termAt(prev),
+		Commit:  n.commitIndex,
+		Entries: n.store.slice(prev+1, maxAppendEntries),
+	})
+	n.mux.Unlock()
+	reply, err := n.call(peer, req)
+	if err != nil {
+		return
+	}
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	if !n.running {
+		return
+	}
+	if reply.Term > n.store.term {
+		n.stepDown(reply.Term)
+		return
+	}
+	if n.role != Leader || n.store.term != term {
+		return
+	}
+	if reply.Success {
+		if reply.Index > n.matchIndex[peer] {
+			n.matchIndex[peer] = reply.Index
+		}
+		n.nextIndex[peer] = n.matchIndex[peer] + 1
+		n.lagging[peer] = false
+		n.advanceCommit()
+	} else {
+		// follower log is shorter or conflicts with leader one, so leader continues from follower hint
+		next := reply.Index + 1
+		if next >= n.nextIndex[peer] {
+			next = n.nextIndex[peer] - 1
+		}
+		if next < 1 {
+			next = 1
+		}
+		n.nextIndex[peer] = next
+		// peer doesn't have entry preceding the ones of request, so it needs compacted entries
+		if prev <= n.store.compacted && !n.lagging[peer] {
+			n.lagging[peer] = true
+			log.Printf("Raft node %s lags behind log compacted up to entry %d, restore it from backup",
+				peer, n.store.compacted)
+		}
+	}
+	if n.nextIndex[peer] <= n.store.lastIndex() && !n.lagging[peer] {
+		select {
+		case n.replicate[peer] <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// advanceCommit commits the last entry of leader term kept by majority of nodes (with entries before it);
+// entries of the previous terms are committed by it only, because their majority may be overwritten
+// by another leader; must be called with mutex held
+func (n *Node) advanceCommit() {
+	for index := n.store.lastIndex(); index > n.commitIndex; index-- {
+		if n.store.termAt(index) != n.store.term {
+			return
+		}
+		count := 1
+		for _, peer := range n.peers {
+			if n.matchIndex[peer] >= index {
+				count++
+			}
+		}
+		if count >= n.quorum() {
+			n.commitIndex = index
+			n.notifyApply()
+			return
+		}
+	}
+}
+
+// applyLoop applies committed entries to state machine in log order
+func (n *Node) applyLoop() {
+	defer n.done.Done()
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-n.applied:
+		}
+		for n.applyNext() {
+		}
+	}
+}
+
+func (n *Node) applyNext() bool {
+	n.mux.Lock()
+	if !n.running || n.store.applied >= n.commitIndex {
+		n.mux.Unlock()
+		return false
+	}
+	index := n.store.applied + 1
+	e := n.store.entry(index)
+	n.mux.Unlock()
+	n.sm.Apply(index, e.Term, e.Data)
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	if !n.running {
+		return false
+	}
+	n.store.applied = index
+	n.store.saveState(false)
+	if n.role == Leader && n.leader == "" && index >= n.termStart {
+		n.leader = n.addr
+		log.Printf("Raft node %s leads cluster at term %d", n.addr, n.store.term)
+	}
+	return true
+}
@@ -0,0 +1,331 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+const electionTimeout = 50 * time.Millisecond
+
+var errDisconnected = errors.New("node is disconnected")
+
+// network delivers messages between nodes in memory; disconnected nodes neither send nor receive messages
+type network struct {
+	mux          sync.Mutex
+	nodes        map[string]*Node
+	disconnected map[string]bool
+}
+
+type memTransport struct {
+	net  *network
+	from string
+}
+
+func (t *memTransport) Call(addr string, msg []byte) ([]byte, error) {
+	t.net.mux.Lock()
+	node := t.net.nodes[addr]
+	disconnected := t.net.disconnected[t.from] || t.net.disconnected[addr]
+	t.net.mux.Unlock()
+	if disconnected || node == nil {
+		return nil, errDisconnected
+	}
+	return node.Handle(msg)
+}
+
+func (t *memTransport) Close() {}
+
+func (net *network) setConnected(addr string, connected bool) {
+	net.mux.Lock()
+	defer net.mux.Unlock()
+	net.disconnected[addr] = !connected
+}
+
+// machine records applied entries; entries applied again after restart are skipped
+type machine struct {
+	mux     sync.Mutex
+	entries []string
+	last    int64
+}
+
+func (m *machine) Apply(index int64, _ int64, data []byte) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if index <= m.last {
+		return
+	}
+	m.last = index
+	if len(data) != 0 {
+		m.entries = append(m.entries, string(data))
+	}
+}
+
+// clone copies state of machine, the same way as backup copies storage
+func (m *machine) clone() *machine {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return &machine{entries: append([]string(nil), m.entries...), last: m.last}
+}
+
+func (m *machine) applied() []string {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return append([]string(nil), m.entries...)
+}
+
+type cluster struct {
+	net      *network
+	nodes    []*Node
+	machines []*machine
+}
+
+func newCluster(t *testing.T, size int) *cluster {
+	c := &cluster{net: &network{nodes: make(map[string]*Node), disconnected: make(map[string]bool)}}
+	var addrs []string
+	for i := 0; i < size; i++ {
+		addrs = append(addrs, fmt.Sprintf("node-%d", i))
+	}
+	for _, addr := range addrs {
+		n := NewNode(addr, addrs, t.TempDir(), electionTimeout, &memTransport{net: c.net, from: addr})
+		m := new(machine)
+		n.SetStateMachine(m)
+		c.nodes = append(c.nodes, n)
+		c.machines = append(c.machines, m)
+		c.net.nodes[addr] = n
+	}
+	for _, n := range c.nodes {
+		n.Start()
+	}
+	t.Cleanup(func() {
+		for _, n := range c.nodes {
+			n.Stop()
+		}
+	})
+	return c
+}
+
+// leader waits for the only ready leader among connected nodes
+func (c *cluster) leader(t *testing.T) *Node {
+	var leader *Node
+	assert.Eventually(t, func() bool {
+		leader = nil
+		for _, n := range c.nodes {
+			c.net.mux.Lock()
+			disconnected := c.net.disconnected[n.Addr()]
+			c.net.mux.Unlock()
+			if disconnected || n.Leader() != n.Addr() {
+				continue
+			}
+			if leader != nil {
+				return false
+			}
+			leader = n
+		}
+		return leader != nil
+	}, 20*electionTimeout, electionTimeout/5)
+	return leader
+}
+
+func TestNode_Election(t *testing.T) {
+	c := newCluster(t, 3)
+	leader := c.leader(t)
+	assert.Eventually(t, func() bool {
+		for _, n := range c.nodes {
+			if n.Leader() != leader.Addr() {
+				return false
+			}
+		}
+		return true
+	}, 20*electionTimeout, electionTimeout/5)
+	for _, n := range c.nodes {
+		if n != leader {
+			_, _, err := n.Propose([]byte("x"))
+			assert.Equal(t, &NotLeaderError{Leader: leader.Addr()}, err)
+		}
+	}
+}
+
+func TestNode_Replication(t *testing.T) {
+	c := newCluster(t, 3)
+	leader := c.leader(t)
+	var expected []string
+	for i := 0; i < 10; i++ {
+		expected = append(expected, fmt.Sprintf("entry-%d", i))
+		_, _, err := leader.Propose([]byte(expected[i]))
+		assert.Nil(t, err)
+	}
+	for _, m := range c.machines {
+		m := m
+		assert.Eventually(t, func() bool {
+			return assert.ObjectsAreEqual(expected, m.applied())
+		}, 20*electionTimeout, electionTimeout/5)
+	}
+	status := leader.Status()
+	assert.Equal(t, Leader, status.Role)
+	assert.Equal(t, status.LastIndex, status.CommitIndex)
+}
+
+func TestNode_Failover(t *testing.T) {
+	c := newCluster(t, 3)
+	old := c.leader(t)
+	_, _, err := old.Propose([]byte("committed"))
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		return old.Status().Applied == old.Status().LastIndex
+	}, 20*electionTimeout, electionTimeout/5)
+	c.net.setConnected(old.Addr(), false)
+	// entry proposed by isolated leader is never committed
+	_, _, err = old.Propose([]byte("lost"))
+	assert.Nil(t, err)
+	leader := c.leader(t)
+	assert.NotEqual(t, old.Addr(), leader.Addr())
+	_, _, err = leader.Propose([]byte("after failover"))
+	assert.Nil(t, err)
+	c.net.setConnected(old.Addr(), true)
+	expected := []string{"committed", "after failover"}
+	for _, m := range c.machines {
+		m := m
+		assert.Eventually(t, func() bool {
+			return assert.ObjectsAreEqual(expected, m.applied())
+		}, 20*electionTimeout, electionTimeout/5)
+	}
+	assert.Eventually(t, func() bool {
+		return old.Leader() == leader.Addr()
+	}, 20*electionTimeout, electionTimeout/5)
+}
+
+func TestNode_Restart(t *testing.T) {
+	dir := t.TempDir()
+	net := &network{nodes: make(map[string]*Node), disconnected: make(map[string]bool)}
+	addrs := []string{"single"}
+	n := NewNode("single", addrs, dir, electionTimeout, &memTransport{net: net, from: "single"})
+	n.SetStateMachine(new(machine))
+	n.Start()
+	assert.Eventually(t, func() bool {
+		_, _, err := n.Propose([]byte("a"))
+		return err == nil
+	}, 20*electionTimeout, electionTimeout/5)
+	term := n.Status().Term
+	n.Stop()
+	m := new(machine)
+	n = NewNode("single", addrs, dir, electionTimeout, &memTransport{net: net, from: "single"})
+	n.SetStateMachine(m)
+	n.Start()
+	defer n.Stop()
+	assert.Eventually(t, func() bool {
+		return n.Leader() == "single"
+	}, 20*electionTimeout, electionTimeout/5)
+	status := n.Status()
+	assert.Less(t, term, status.Term)
+	// entries of the previous term are kept in log
+	assert.Equal(t, int64(3), status.LastIndex)
+}
+
+func TestNode_Compaction(t *testing.T) {
+	c := newCluster(t, 3)
+	leader := c.leader(t)
+	lagging := 0
+	for i, n := range c.nodes {
+		if n != leader {
+			lagging = i
+		}
+	}
+	c.net.setConnected(c.nodes[lagging].Addr(), false)
+	var expected []string
+	for i := 0; i < 20; i++ {
+		expected = append(expected, fmt.Sprintf("entry-%d", i))
+		_, _, err := leader.Propose([]byte(expected[i]))
+		assert.Nil(t, err)
+	}
+	for i, m := range c.machines {
+		m := m
+		if i == lagging {
+			continue
+		}
+		assert.Eventually(t, func() bool {
+			return assert.ObjectsAreEqual(expected, m.applied())
+		}, 20*electionTimeout, electionTimeout/5)
+	}
+	for i, n := range c.nodes {
+		if i == lagging {
+			continue
+		}
+		n.retained = 5
+		n.Compact()
+		status := n.Status()
+		assert.Equal(t, status.CommitIndex-5, status.Compacted)
+	}
+	// entries removed from log of leader aren't sent to disconnected follower
+	c.net.setConnected(c.nodes[lagging].Addr(), true)
+	time.Sleep(5 * electionTimeout)
+	assert.Less(t, c.nodes[lagging].Status().LastIndex, leader.Status().Compacted)
+	assert.Empty(t, c.machines[lagging].applied())
+
+	// follower is restored from log and state copied by leader
+	c.nodes[lagging].Stop()
+	dir := t.TempDir()
+	assert.Nil(t, leader.CopyLog(dir))
+	assert.NotNil(t, leader.CopyLog(dir))
+	addr := c.nodes[lagging].Addr()
+	restored := NewNode(addr, []string{c.nodes[0].Addr(), c.nodes[1].Addr(), c.nodes[2].Addr()}, dir,
+		electionTimeout, &memTransport{net: c.net, from: addr})
+	c.machines[lagging] = c.machines[(lagging+1)%3].clone()
+	restored.SetStateMachine(c.machines[lagging])
+	c.net.mux.Lock()
+	c.net.nodes[addr] = restored
+	c.net.mux.Unlock()
+	c.nodes[lagging] = restored
+	restored.Start()
+	expected = append(expected, "after restore")
+	_, _, err := leader.Propose([]byte("after restore"))
+	assert.Nil(t, err)
+	for _, m := range c.machines {
+		m := m
+		assert.Eventually(t, func() bool {
+			return assert.ObjectsAreEqual(expected, m.applied())
+		}, 20*electionTimeout, electionTimeout/5)
+	}
+}
+
+func TestNode_RestartCompacted(t *testing.T) {
+	dir := t.TempDir()
+	net := &network{nodes: make(map[string]*Node), disconnected: make(map[string]bool)}
+	addrs := []string{"single"}
+	n := NewNode("single", addrs, dir, electionTimeout, &memTransport{net: net, from: "single"})
+	m := new(machine)
+	n.SetStateMachine(m)
+	n.Start()
+	assert.Eventually(t, func() bool {
+		_, _, err := n.Propose([]byte("a"))
+		return err == nil
+	}, 20*electionTimeout, electionTimeout/5)
+	for i := 0; i < 9; i++ {
+		_, _, err := n.Propose([]byte("b"))
+		assert.Nil(t, err)
+	}
+	assert.Eventually(t, func() bool {
+		return len(m.applied()) == 10
+	}, 20*electionTimeout, electionTimeout/5)
+	n.retained = 3
+	n.Compact()
+	n.Stop()
+	n = NewNode("single", addrs, dir, electionTimeout, &memTransport{net: net, from: "single"})
+	n.SetStateMachine(m)
+	n.Start()
+	defer n.Stop()
+	status := n.Status()
+	assert.Equal(t, int64(8), status.Compacted)
+	assert.Equal(t, int64(11), status.LastIndex)
+	assert.Equal(t, int64(11), status.Applied)
+	assert.Eventually(t, func() bool {
+		_, _, err := n.Propose([]byte("c"))
+		return err == nil
+	}, 20*electionTimeout, electionTimeout/5)
+	assert.Eventually(t, func() bool {
+		return len(m.applied()) == 11
+	}, 20*electionTimeout, electionTimeout/5)
+	assert.Equal(t, int64(13), n.Status().LastIndex)
+}
@@ -0,0 +1,359 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+const (
+	stateFileName = "raft.state"
+	logFileName   = "raft.log"
+	// frameHeaderSize is a size of entry length and checksum preceding entry in log file
+	frameHeaderSize = 8
+	// logHeaderSize is a size of index and term of the last compacted entry at the beginning of log file
+	logHeaderSize = 16
+)
+
+var errCorruptedEntry = errors.New("corrupted raft log entry")
+
+// Entry is a record of replicated log; entries with empty data are appended by new leaders
+type Entry struct {
+	Term int64
+	Data []byte
+}
+
+// storage persists term, vote, applied index and log entries of node; entries are kept in memory too,
+// and entries up to compacted index are removed from both, because state machine keeps them
+type storage struct {
+	dir string
+	// term, votedFor and applied are persisted in state file
+	term     int64
+	votedFor string
+	applied  int64
+	// compacted and compactedTerm are index and term of the last removed entry, they are persisted in log file
+	// header; compacted index is saved in state file too, so log replaced by the one of backup is detected
+	compacted     int64
+	compactedTerm int64
+	file          *os.File
+	entries       []Entry
+	// offsets keeps offset of each entry frame in log file, so log is truncated from any entry
+	offsets []int64
+}
+
+func newStorage(dir string) *storage {
+	s := new(storage)
+	s.dir = dir
+	return s
+}
+
+// load reads state and log files; torn tail of log (e.g. append interrupted by crash) is truncated,
+// because its entries weren't acknowledged to leader; if log is compacted at another index than state file
+// records (log is restored from backup or compaction is interrupted by crash), entries after compacted index
+// are applied again
+func (s *storage) load() {
+	if err := os.MkdirAll(s.dir, 0777); err != nil {
+		log.Panic(err)
+	}
+	var stateCompacted int64
+	if data, err := ioutil.ReadFile(filepath.Join(s.dir, stateFileName)); err == nil {
+		if stateCompacted, err = s.unmarshalState(data); err != nil {
+			log.Panicf("raft state %s: %v", filepath.Join(s.dir, stateFileName), err)
+		}
+	} else if !os.IsNotExist(err) {
+		log.Panic(err)
+	}
+	file, err := os.OpenFile(filepath.Join(s.dir, logFileName), os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		log.Panic(err)
+	}
+	s.file = file
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		log.Panic(err)
+	}
+	if len(data) < logHeaderSize {
+		// log is created now or its creation is interrupted by crash
+		data = make([]byte, logHeaderSize)
+		if _, err := file.WriteAt(data, 0); err != nil {
+			log.Panic(err)
+		}
+		if err := file.Sync(); err != nil {
+			log.Panic(err)
+		}
+	}
+	s.compacted = int64(binary.LittleEndian.Uint64(data))
+	s.compactedTerm = int64(binary.LittleEndian.Uint64(data[8:]))
+	if s.compacted != stateCompacted || s.applied < s.compacted {
+		s.applied = s.compacted
+	}
+	offset := int64(logHeaderSize)
+	for offset < int64(len(data)) {
+		e, size, err := readEntry(data[offset:])
+		if err != nil {
+			log.Printf("Truncated corrupted tail of raft log %s at %d", file.Name(), offset)
+			if truncErr := file.Truncate(offset); truncErr != nil {
+				log.Panic(truncErr)
+			}
+			break
+		}
+		s.entries = append(s.entries, e)
+		s.offsets = append(s.offsets, offset)
+		offset += size
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		log.Panic(err)
+	}
+}
+
+func (s *storage) close() {
+	if s.file != nil {
+		s.file.Close()
+	}
+}
+
+func (s *storage) lastIndex() int64 {
+	return s.compacted + int64(len(s.entries))
+}
+
+// termAt returns term of entry with passed index, which must not be less than compacted index;
+// zero index has zero term
+func (s *storage) termAt(index int64) int64 {
+	if index == s.compacted {
+		return s.compactedTerm
+	}
+	return s.entries[index-s.compacted-1].Term
+}
+
+func (s *storage) entry(index int64) Entry {
+	return s.entries[index-s.compacted-1]
+}
+
+// slice returns up to maxCount entries starting from passed index, which must be greater than compacted index
+func (s *storage) slice(index int64, maxCount int) []Entry {
+	end := s.lastIndex()
+	if end-index+1 > int64(maxCount) {
+		end = index - 1 + int64(maxCount)
+	}
+	return s.entries[index-s.compacted-1 : end-s.compacted]
+}
+
+// append writes entries to the end of log and syncs it, so they survive crash before they are acknowledged
+func (s *storage) append(entries []Entry) {
+	if len(entries) == 0 {
+		return
+	}
+	offset, err := s.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		log.Panic(err)
+	}
+	buf := new(bytes.Buffer)
+	for _, e := range entries {
+		s.offsets = append(s.offsets, offset+int64(buf.Len()))
+		writeEntry(buf, e)
+	}
+	if _, err := s.file.Write(buf.Bytes()); err != nil {
+		log.Panic(err)
+	}
+	if err := s.file.Sync(); err != nil {
+		log.Panic(err)
+	}
+	s.entries = append(s.entries, entries...)
+}
+
+// truncate removes entries starting from passed index; they conflict with log of leader
+func (s *storage) truncate(index int64) {
+	pos := index - s.compacted - 1
+	offset := s.offsets[pos]
+	if err := s.file.Truncate(offset); err != nil {
+		log.Panic(err)
+	}
+	if _, err := s.file.Seek(offset, io.SeekStart); err != nil {
+		log.Panic(err)
+	}
+	s.entries = s.entries[:pos]
+	s.offsets = s.offsets[:pos]
+}
+
+// compact removes entries up to passed index from log; log file is rewritten by the rest of entries
+// and replaced atomically, so crash leaves either old or new one
+func (s *storage) compact(index int64) {
+	term := s.termAt(index)
+	entries := append([]Entry(nil), s.entries[index-s.compacted:]...)
+	name := filepath.Join(s.dir, logFileName)
+	offsets, err := writeLog(name, index, term, entries)
+	if err != nil {
+		log.Panic(err)
+	}
+	s.file.Close()
+	file, err := os.OpenFile(name, os.O_RDWR, 0666)
+	if err != nil {
+		log.Panic(err)
+	}
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		log.Panic(err)
+	}
+	s.file = file
+	s.compacted, s.compactedTerm = index, term
+	s.entries, s.offsets = entries, offsets
+	s.saveState(true)
+}
+
+// copyLog writes log compacted at applied index to file of passed directory, so node started on it with
+// storage copied after that catches up from entries of leader; file must not exist
+func (s *storage) copyLog(dir string) error {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	name := filepath.Join(dir, logFileName)
+	if _, err := os.Stat(name); err == nil {
+		return os.ErrExist
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	index := s.applied
+	if index > s.lastIndex() {
+		index = s.lastIndex()
+	}
+	_, err := writeLog(name, index, s.termAt(index), nil)
+	return err
+}
+
+// saveState writes state file; term and vote must be synced before node replies with them,
+// but applied index is a hint only, because applying entries again doesn't change storage
+func (s *storage) saveState(sync bool) {
+	name := filepath.Join(s.dir, stateFileName)
+	tmpName := name + ".tmp"
+	file, err := os.OpenFile(tmpName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		log.Panic(err)
+	}
+	if _, err := file.Write(s.marshalState()); err != nil {
+		log.Panic(err)
+	}
+	if sync {
+		if err := file.Sync(); err != nil {
+			log.Panic(err)
+		}
+	}
+	file.Close()
+	if err := os.Rename(tmpName, name); err != nil {
+		log.Panic(err)
+	}
+}
+
+func (s *storage) marshalState() []byte {
+	buf := new(bytes.Buffer)
+	mustWrite(buf, []int64{s.term, s.applied})
+	writeBytes(buf, []byte(s.votedFor))
+	mustWrite(buf, s.compacted)
+	return buf.Bytes()
+}
+
+// unmarshalState reads state and returns compacted index recorded by it; state written before compaction
+// was supported ends with vote
+func (s *storage) unmarshalState(data []byte) (int64, error) {
+	r := bytes.NewReader(data)
+	fields := make([]int64, 2)
+	if err := binary.Read(r, binary.LittleEndian, fields); err != nil {
+		return 0, err
+	}
+	votedFor, err := readBytes(r)
+	if err != nil {
+		return 0, err
+	}
+	var compacted int64
+	if r.Len() != 0 {
+		if err := binary.Read(r, binary.LittleEndian, &compacted); err != nil {
+			return 0, err
+		}
+	}
+	s.term, s.applied, s.votedFor = fields[0], fields[1], string(votedFor)
+	return compacted, nil
+}
+
+// writeLog writes log file with passed header and entries through temporary file and returns offsets of entries
+func writeLog(name string, compacted int64, compactedTerm int64, entries []Entry) ([]int64, error) {
+	buf := new(bytes.Buffer)
+	mustWrite(buf, []int64{compacted, compactedTerm})
+	offsets := make([]int64, len(entries))
+	for i, e := range entries {
+		offsets[i] = int64(buf.Len())
+		writeEntry(buf, e)
+	}
+	tmpName := name + ".tmp"
+	file, err := os.OpenFile(tmpName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return nil, err
+	}
+	_, err = file.Write(buf.Bytes())
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+	return offsets, os.Rename(tmpName, name)
+}
+
+// writeEntry frames entry with its length and checksum the same way as journal records
+func writeEntry(buf *bytes.Buffer, e Entry) {
+	data := make([]byte, 8, 8+len(e.Data))
+	binary.LittleEndian.PutUint64(data, uint64(e.Term))
+	data = append(data, e.Data...)
+	frame := make([]byte, frameHeaderSize)
+	binary.LittleEndian.PutUint32(frame, uint32(len(data)))
+	binary.LittleEndian.PutUint32(frame[4:], crc32.ChecksumIEEE(data))
+	buf.Write(frame)
+	buf.Write(data)
+}
+
+// readEntry returns entry framed by writeEntry at the beginning of data and size of its frame
+func readEntry(data []byte) (Entry, int64, error) {
+	if len(data) < frameHeaderSize {
+		return Entry{}, 0, errCorruptedEntry
+	}
+	size := int64(binary.LittleEndian.Uint32(data))
+	if size < 8 || size > int64(len(data)-frameHeaderSize) {
+		return Entry{}, 0, errCorruptedEntry
+	}
+	payload := data[frameHeaderSize : frameHeaderSize+size]
+	if binary.LittleEndian.Uint32(data[4:]) != crc32.ChecksumIEEE(payload) {
+		return Entry{}, 0, errCorruptedEntry
+	}
+	e := Entry{Term: int64(binary.LittleEndian.Uint64(payload))}
+	e.Data = append([]byte(nil), payload[8:]...)
+	return e, frameHeaderSize + size, nil
+}
+
+func mustWrite(buf io.Writer, value interface{}) {
+	if err := binary.Write(buf, binary.LittleEndian, value); err != nil {
+		log.Panic(err)
+	}
+}
+
+func writeBytes(buf io.Writer, data []byte) {
+	mustWrite(buf, uint32(len(data)))
+	if _, err := buf.Write(data); err != nil {
+		log.Panic(err)
+	}
+}
+
+func readBytes(r io.Reader) ([]byte, error) {
+	var size uint32
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return nil, err
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
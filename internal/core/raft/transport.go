@@ -0,0 +1,112 @@
+package raft
+
+import (
+	"bufio"
+	"dbms/internal/transfer"
+	"net"
+	"sync"
+	"time"
+)
+
+// Transport delivers messages between nodes
+type Transport interface {
+	// Call sends message to node with passed address and returns its reply
+	Call(addr string, msg []byte) ([]byte, error)
+	Close()
+}
+
+// TCPTransport sends messages as raft commands to servers of nodes; each node is called through single
+// connection, which is reestablished after failure
+type TCPTransport struct {
+	// timeout bounds single call, so unreachable node doesn't delay others
+	timeout time.Duration
+	mux     sync.Mutex
+	conns   map[string]*peerConn
+}
+
+type peerConn struct {
+	mux    sync.Mutex
+	conn   net.Conn
+	writer *bufio.Writer
+	send   transfer.ObjectWriter
+	recv   transfer.ObjectReader
+}
+
+func NewTCPTransport(timeout time.Duration) *TCPTransport {
+	t := new(TCPTransport)
+	t.timeout = timeout
+	t.conns = make(map[string]*peerConn)
+	return t
+}
+
+func (t *TCPTransport) peer(addr string) *peerConn {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	p, found := t.conns[addr]
+	if !found {
+		p = new(peerConn)
+		t.conns[addr] = p
+	}
+	return p
+}
+
+func (t *TCPTransport) Call(addr string, msg []byte) ([]byte, error) {
+	p := t.peer(addr)
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	res, err := p.call(addr, msg, t.timeout)
+	if err != nil {
+		p.close()
+		return nil, err
+	}
+	if !res.Ok() {
+		return nil, res
+	}
+	return res.Value(), nil
+}
+
+func (t *TCPTransport) Close() {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	for _, p := range t.conns {
+		p.mux.Lock()
+		p.close()
+		p.mux.Unlock()
+	}
+}
+
+func (p *peerConn) call(addr string, msg []byte, timeout time.Duration) (*transfer.Result, error) {
+	if p.conn == nil {
+		conn, err := net.DialTimeout("tcp", addr, timeout)
+		if err != nil {
+			return nil, err
+		}
+		p.conn = conn
+		p.writer = bufio.NewWriter(conn)
+		p.send = transfer.NewLEObjectWriter(p.writer)
+		p.recv = transfer.NewLEObjectReader(bufio.NewReader(conn))
+	}
+	if err := p.conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	cmdObj := new(transfer.CmdObject)
+	cmdObj.FromCmd(transfer.RaftCmd(msg))
+	if err := p.send.WriteObject(cmdObj); err != nil {
+		return nil, err
+	}
+	if err := p.writer.Flush(); err != nil {
+		return nil, err
+	}
+	resObj := new(transfer.ResultObject)
+	if err := p.recv.ReadObject(resObj); err != nil {
+		return nil, err
+	}
+	return resObj.ToResult(), nil
+}
+
+func (p *peerConn) close() {
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+}
@@ -18,44 +18,177 @@ func NewRecoveryManager(logMgr *logging.LogManager) *RecoveryManager {
 	return m
 }
 
+// replay is a state of journal replay; it is shared by recovery and replica
+type replay struct {
+	txMgr    *transaction.TxManager
+	maxTxId  int
+	steals   []*logging.LogRecord
+	images   map[int][]*logging.LogRecord
+	keys     map[int][]string
+	prepared map[int]string
+}
+
+func newReplay(txMgr *transaction.TxManager) *replay {
+	p := new(replay)
+	p.txMgr = txMgr
+	p.images = make(map[int][]*logging.LogRecord)
+	p.keys = make(map[int][]string)
+	p.prepared = make(map[int]string)
+	return p
+}
+
+// redo applies pages flushed with record of transaction
+func (p *replay) redo(txId int) {
+	applyImages(p.txMgr, append(p.steals, p.images[txId]...))
+	p.steals = nil
+	delete(p.images, txId)
+}
+
+func (p *replay) apply(r *logging.LogRecord) {
+	if r.TxId() > p.maxTxId {
+		p.maxTxId = r.TxId()
+	}
+	switch r.Type() {
+	case logging.UpdateRecord:
+		p.images[r.TxId()] = append(p.images[r.TxId()], r)
+		break
+	case logging.StealRecord:
+		p.steals = append(p.steals, r)
+		break
+	case logging.KeyRecord:
+		// key is journaled before its changes, so they are invisible to snapshots till transaction is finished
+		p.txMgr.BeginReplayed(r.TxId())
+		p.keys[r.TxId()] = append(p.keys[r.TxId()], string(r.Key))
+		break
+	case logging.PrepareRecord:
+		p.redo(r.TxId())
+		p.prepared[r.TxId()] = string(r.Key)
+		break
+	case logging.CommitRecord, logging.AbortRecord:
+		// aborted transaction journals its rolled back pages, so they are applied the same way
+		p.redo(r.TxId())
+		p.txMgr.FinishReplayed(r.TxId(), r.Type() == logging.CommitRecord)
+		delete(p.keys, r.TxId())
+		delete(p.prepared, r.TxId())
+		break
+	case logging.CheckpointRecord:
+		p.redo(r.TxId())
+		// all journaled images are flushed, and checkpoint lists all unfinished transactions
+		p.images = make(map[int][]*logging.LogRecord)
+		p.keys = make(map[int][]string)
+		p.prepared = make(map[int]string)
+		for _, tx := range r.Txs {
+			if tx.TxId > p.maxTxId {
+				p.maxTxId = tx.TxId
+			}
+			p.txMgr.BeginReplayed(tx.TxId)
+			p.keys[tx.TxId] = tx.Keys
+			if tx.Gid != "" {
+				p.prepared[tx.TxId] = tx.Gid
+			}
+		}
+		break
+	}
+}
+
+// RollForward applies pages images journaled by finished and prepared transactions and rolls back keys changed
+// by unfinished ones; images are journaled right before commit, abort, prepare or checkpoint record, so trailing
+// images are not flushed to storage and are dropped; pages evicted from buffer are applied with images of the next
+// such record, and trailing ones are restored from their before images in reverse order (undo pass);
+// prepared transactions are restored with their locks;
+// storage is consistent as of the last checkpoint, so journal is replayed starting from its record
 func (m *RecoveryManager) RollForward(txMgr *transaction.TxManager) {
-	var maxTxId int
-	txs := make(map[int]transaction.Tx)
+	p := newReplay(txMgr)
+	txMgr.SetJournalSteals(false)
+	m.replayJournal(p)
+	m.undoSteals(txMgr, p.steals)
+	txMgr.SetJournalSteals(true)
+	for txId, gid := range p.prepared {
+		txMgr.RestorePrepared(txId, gid, p.keys[txId])
+		delete(p.keys, txId)
+		log.Printf("Restored prepared transaction %s", gid)
+	}
+	// changes of trailing transactions may be flushed by commits of others, so they are rolled back
+	for txId, txKeys := range p.keys {
+		tx := txMgr.InitTxWithId(txId, concurrency.ExclusiveMode)
+		for _, key := range txKeys {
+			tx.MarkWritten(key)
+		}
+		tx.Abort()
+	}
+	txMgr.SetIdCounter(p.maxTxId)
+}
+
+// replayJournal replays records starting from the last checkpoint; it returns position of the last
+// replayed checkpoint record (found is false if there are no checkpoint records)
+func (m *RecoveryManager) replayJournal(p *replay) (lastCheckpoint logging.LogPos, found bool) {
+	checkpoint, found := m.logMgr.Checkpoint()
+	lastCheckpoint = checkpoint
 	segIter := m.logMgr.SegmentIterator()
 	for seg := segIter.Next(); seg != nil; seg = segIter.Next() {
-		logIter := seg.LogIterator()
+		var logIter *logging.LogIterator
+		if !found || seg.Id() > checkpoint.SegId {
+			logIter = seg.LogIterator()
+		} else if seg.Id() == checkpoint.SegId {
+			logIter = seg.LogIteratorAt(checkpoint.Offset)
+		} else {
+			// segment is to be removed by the next checkpoint
+			continue
+		}
+		offset := logIter.Offset()
 		for r, err := logIter.Next(); err != io.EOF; r, err = logIter.Next() {
-			if r.TxId() > maxTxId {
-				maxTxId = r.TxId()
-			}
-			tx, found := txs[r.TxId()]
-			if !found {
-				tx = txMgr.InitTxWithId(r.TxId(), concurrency.ExclusiveMode)
-				txs[r.TxId()] = tx
+			if err != nil {
+				// torn tail of active segment is truncated at load, so other records are complete
+				log.Panicf("journal segment %s: %v", seg.Name(), err)
 			}
-			switch r.Type() {
-			case logging.UpdateRecord:
-				page := tx.AllocatePage()
-				if err := page.UnmarshalBinary(r.Snapshot); err != nil {
-					log.Panic(err)
-				}
-				tx.WritePageAtPos(page, r.Pos)
-				break
-			case logging.CommitRecord:
-				tx.CommitNoLog()
-				delete(txs, tx.Id())
-				break
-			case logging.AbortRecord:
-				tx.Abort()
-				delete(txs, tx.Id())
-				break
+			p.apply(r)
+			if r.Type() == logging.CheckpointRecord {
+				lastCheckpoint = logging.LogPos{SegId: seg.Id(), Offset: offset}
+				found = true
 			}
+			offset = logIter.Offset()
 		}
 		log.Printf("Recovered from journal segment %s", seg.Name())
 	}
-	// abort trailing transactions
-	for _, tx := range txs {
-		tx.Abort()
+	return lastCheckpoint, found
+}
+
+// undoSteals restores pages evicted after the last flush from their before images; page may be evicted
+// several times, so images are applied in reverse order and the first one wins
+func (m *RecoveryManager) undoSteals(txMgr *transaction.TxManager, steals []*logging.LogRecord) {
+	if len(steals) == 0 {
+		return
+	}
+	// steals are journaled on behalf of no transaction
+	tx := txMgr.InitTxWithId(0, concurrency.ExclusiveMode)
+	tx.Latch(true)
+	for i := len(steals) - 1; i >= 0; i-- {
+		page := tx.AllocatePage()
+		if err := page.UnmarshalBinary(steals[i].Before); err != nil {
+			log.Panic(err)
+		}
+		// restored page is newer than its before image for incremental backups
+		page.SetLsn(steals[i].Lsn())
+		txMgr.ExtendStorage(steals[i].Pos)
+		tx.WritePageAtPos(page, steals[i].Pos)
+	}
+	tx.CommitNoLog()
+	log.Printf("Restored %d pages evicted by unfinished transactions", len(steals))
+}
+
+// applyImages writes journaled pages on behalf of no transaction, because images are journaled on behalf of the first
+// transaction of batch, which record may be abort or prepare one, and replayed transactions are finished by their
+// records only; images are applied with exclusive latch, so readers of replica don't see part of them
+func applyImages(txMgr *transaction.TxManager, images []*logging.LogRecord) {
+	tx := txMgr.InitTxWithId(0, concurrency.ExclusiveMode)
+	tx.Latch(true)
+	for _, image := range images {
+		page := tx.AllocatePage()
+		if err := page.UnmarshalBinary(image.Snapshot); err != nil {
+			log.Panic(err)
+		}
+		txMgr.ExtendStorage(image.Pos)
+		tx.WritePageAtPos(page, image.Pos)
 	}
-	txMgr.SetIdCounter(maxTxId)
+	tx.CommitNoLog()
 }
@@ -0,0 +1,99 @@
+package recovery
+
+import (
+	"dbms/internal/core/logging"
+	"dbms/internal/core/transaction"
+	"sync"
+	"time"
+)
+
+// ReplicationLag describes how far replica is behind primary
+type ReplicationLag struct {
+	// Records is a number of records flushed by primary, but not replayed by replica yet
+	Records int64
+	// Delay is a time since replica replayed all records flushed by primary; it is zero while replica keeps up
+	Delay time.Duration
+}
+
+// Replica replays journal shipped by primary the same way as recovery does, but unfinished transactions
+// aren't rolled back, because the following records finish them; shipped records are appended to journal
+// of replica before replay, so it repeats the primary one, and checkpoints of primary are persisted as they
+// are replayed: restarted replica continues from its journal, and stopped one may be started as primary
+type Replica struct {
+	m          *RecoveryManager
+	txMgr      *transaction.TxManager
+	replay     *replay
+	lagMux     sync.Mutex
+	appliedLsn int64
+	primaryLsn int64
+	caughtUpAt time.Time
+}
+
+func NewReplica(m *RecoveryManager, txMgr *transaction.TxManager) *Replica {
+	r := new(Replica)
+	r.m = m
+	r.txMgr = txMgr
+	r.replay = newReplay(txMgr)
+	return r
+}
+
+// Init replays journal of replica from its checkpoint and returns position which shipment continues from;
+// replica storage must be restored from backup of primary, because primary keeps journal since its last
+// checkpoint only
+func (r *Replica) Init() logging.LogPos {
+	// pages evicted by replay are not journaled, because journal repeats the primary one
+	r.txMgr.SetJournalSteals(false)
+	if checkpoint, found := r.m.replayJournal(r.replay); found {
+		r.m.logMgr.Truncate(checkpoint)
+	}
+	r.lagMux.Lock()
+	r.appliedLsn = r.m.logMgr.LastLsn()
+	r.caughtUpAt = time.Now()
+	r.lagMux.Unlock()
+	return r.m.logMgr.End()
+}
+
+// Apply appends shipped records to journal and replays them
+func (r *Replica) Apply(s *logging.Shipment) error {
+	records, positions, err := r.m.logMgr.AppendFrames(s)
+	if err != nil {
+		return err
+	}
+	for i, rec := range records {
+		r.replay.apply(rec)
+		if rec.Type() == logging.CheckpointRecord {
+			r.m.logMgr.Truncate(positions[i])
+		}
+	}
+	r.lagMux.Lock()
+	defer r.lagMux.Unlock()
+	if len(records) != 0 {
+		r.appliedLsn = records[len(records)-1].Lsn()
+	}
+	if s.EndLsn > r.primaryLsn {
+		r.primaryLsn = s.EndLsn
+	}
+	if r.appliedLsn >= r.primaryLsn {
+		r.caughtUpAt = time.Now()
+	}
+	return nil
+}
+
+// Pos returns position of primary journal which shipment continues from
+func (r *Replica) Pos() logging.LogPos {
+	return r.m.logMgr.End()
+}
+
+// Lsn returns lsn of the last record of replica journal
+func (r *Replica) Lsn() int64 {
+	return r.m.logMgr.LastLsn()
+}
+
+func (r *Replica) Lag() ReplicationLag {
+	r.lagMux.Lock()
+	defer r.lagMux.Unlock()
+	if r.appliedLsn >= r.primaryLsn {
+		return ReplicationLag{}
+	}
+	return ReplicationLag{Records: r.primaryLsn - r.appliedLsn, Delay: time.Since(r.caughtUpAt)}
+}
@@ -0,0 +1,147 @@
+package replication
+
+import (
+	"bufio"
+	"dbms/internal/core/logging"
+	"dbms/internal/core/recovery"
+	"dbms/internal/transfer"
+	"encoding/binary"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// retryInterval is a pause between failed shipment and the next connection to primary
+const retryInterval = time.Second
+
+// Receiver receives journal shipped by primary and applies it to replica; connection is reestablished
+// after failures, and shipment continues from the end of replica journal; each shipment is acknowledged
+// with lsn of the last record of replica journal, so primary may wait for it
+type Receiver struct {
+	addr string
+	// name identifies replica on primary
+	name    string
+	replica *recovery.Replica
+	stop    chan struct{}
+	done    chan struct{}
+	// conn is closed by Stop, so receiver doesn't wait for the next shipment
+	conn    net.Conn
+	connMux sync.Mutex
+}
+
+func NewReceiver(addr string, name string, replica *recovery.Replica) *Receiver {
+	r := new(Receiver)
+	r.addr = addr
+	r.name = name
+	r.replica = replica
+	return r
+}
+
+// Start receives shipments in background till Stop
+func (r *Receiver) Start() {
+	if r.stop != nil {
+		return
+	}
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+	go func() {
+		defer close(r.done)
+		for {
+			err := r.receive()
+			select {
+			case <-r.stop:
+				return
+			default:
+			}
+			log.Printf("Replication from %s is interrupted: %v", r.addr, err)
+			select {
+			case <-r.stop:
+				return
+			case <-time.After(retryInterval):
+			}
+		}
+	}()
+}
+
+func (r *Receiver) Stop() {
+	if r.stop == nil {
+		return
+	}
+	close(r.stop)
+	r.setConn(nil)
+	<-r.done
+	r.stop = nil
+}
+
+// setConn replaces connection closing the previous one; it returns false if receiver is stopped
+func (r *Receiver) setConn(conn net.Conn) bool {
+	r.connMux.Lock()
+	defer r.connMux.Unlock()
+	if r.conn != nil {
+		r.conn.Close()
+		r.conn = nil
+	}
+	select {
+	case <-r.stop:
+		return false
+	default:
+	}
+	r.conn = conn
+	return true
+}
+
+// receive requests journal starting from the end of replica journal and applies shipments till failure
+func (r *Receiver) receive() error {
+	conn, err := net.Dial("tcp", r.addr)
+	if err != nil {
+		return err
+	}
+	if !r.setConn(conn) {
+		conn.Close()
+		return nil
+	}
+	defer r.setConn(nil)
+	pos, err := r.replica.Pos().MarshalBinary()
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(conn)
+	send := transfer.NewLEObjectWriter(writer)
+	sendCmd := func(cmd transfer.Cmd) error {
+		cmdObj := new(transfer.CmdObject)
+		cmdObj.FromCmd(cmd)
+		if err := send.WriteObject(cmdObj); err != nil {
+			return err
+		}
+		return writer.Flush()
+	}
+	if err := sendCmd(transfer.ReplicateCmd(r.name, pos)); err != nil {
+		return err
+	}
+	log.Printf("Replication from %s is started", r.addr)
+	lsn := make([]byte, 8)
+	recv := transfer.NewLEObjectReader(bufio.NewReader(conn))
+	for {
+		resObj := new(transfer.ResultObject)
+		if err := recv.ReadObject(resObj); err != nil {
+			return err
+		}
+		res := resObj.ToResult()
+		if !res.Ok() {
+			return res
+		}
+		s := new(logging.Shipment)
+		if err := s.UnmarshalBinary(res.Value()); err != nil {
+			return err
+		}
+		if err := r.replica.Apply(s); err != nil {
+			return err
+		}
+		// shipped records are synced to replica journal before replay
+		binary.LittleEndian.PutUint64(lsn, uint64(r.replica.Lsn()))
+		if err := sendCmd(transfer.ReplicaAckCmd(lsn)); err != nil {
+			return err
+		}
+	}
+}
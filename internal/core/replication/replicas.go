@@ -0,0 +1,187 @@
+package replication
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrAckTimeout is returned by synchronous commit which isn't acknowledged in time; transaction is committed
+// on primary anyway
+var ErrAckTimeout = errors.New("commit is not acknowledged by enough replicas in time")
+
+// ReplicaStatus describes replica connected to primary
+type ReplicaStatus struct {
+	Name string
+	Addr string
+	// AckedLsn is an lsn of the last record durably received by replica
+	AckedLsn int64
+	// Lag is a number of records flushed by primary, but not acknowledged by replica yet
+	Lag int64
+	// AckedAt is a time of the last acknowledgement
+	AckedAt time.Time
+}
+
+// ReplicaConn is a registered connection of replica
+type ReplicaConn struct {
+	name     string
+	addr     string
+	ackedLsn int64
+	ackedAt  time.Time
+}
+
+// Replicas tracks replicas connected to primary and their acknowledgements; it makes replication synchronous
+// if quorum is positive: commit waits till quorum of replicas acknowledges its records
+type Replicas struct {
+	mux sync.Mutex
+	// conns maps replica name to its connection
+	conns   map[string]*ReplicaConn
+	quorum  int
+	timeout time.Duration
+	// failOnTimeout defines if commit fails on timeout; otherwise replication becomes asynchronous
+	failOnTimeout bool
+	// flushedLsn is an lsn of the last record flushed by primary as of the last shipment
+	flushedLsn int64
+	// degraded is set by async fallback; commits don't wait till quorum acknowledges waitLsn
+	degraded bool
+	waitLsn  int64
+	// acked is closed and replaced on each acknowledgement
+	acked chan struct{}
+	// flushed is closed and replaced when commit waits, so its records are shipped without polling delay
+	flushed chan struct{}
+}
+
+func NewReplicas(quorum int, timeout time.Duration, failOnTimeout bool) *Replicas {
+	r := new(Replicas)
+	r.conns = make(map[string]*ReplicaConn)
+	r.quorum = quorum
+	r.timeout = timeout
+	r.failOnTimeout = failOnTimeout
+	r.acked = make(chan struct{})
+	r.flushed = make(chan struct{})
+	return r
+}
+
+// Register adds connection of replica; connection of replica with the same name replaces the previous one
+func (r *Replicas) Register(name string, addr string) *ReplicaConn {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	conn := &ReplicaConn{name: name, addr: addr}
+	r.conns[name] = conn
+	log.Printf("Replica %s is connected from %s", name, addr)
+	return conn
+}
+
+func (r *Replicas) Unregister(conn *ReplicaConn) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.conns[conn.name] == conn {
+		delete(r.conns, conn.name)
+		log.Printf("Replica %s is disconnected", conn.name)
+	}
+}
+
+// Shipped registers lsn of the last record flushed by primary as of shipment
+func (r *Replicas) Shipped(flushedLsn int64) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if flushedLsn > r.flushedLsn {
+		r.flushedLsn = flushedLsn
+	}
+}
+
+// Ack registers lsn of the last record durably received by replica and wakes waiting commits
+func (r *Replicas) Ack(conn *ReplicaConn, lsn int64) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if lsn > conn.ackedLsn {
+		conn.ackedLsn = lsn
+	}
+	conn.ackedAt = time.Now()
+	if r.degraded && r.ackedCount(r.waitLsn) >= r.quorum {
+		r.degraded = false
+		log.Printf("Replication is synchronous again: %d replicas acknowledged journal", r.quorum)
+	}
+	close(r.acked)
+	r.acked = make(chan struct{})
+}
+
+// Flushed returns channel which is closed when commit waits for its records
+func (r *Replicas) Flushed() <-chan struct{} {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.flushed
+}
+
+func (r *Replicas) ackedCount(lsn int64) int {
+	n := 0
+	for _, conn := range r.conns {
+		if conn.ackedLsn >= lsn {
+			n++
+		}
+	}
+	return n
+}
+
+// WaitAcks implements transaction.ReplicaAcks; on timeout it returns ErrAckTimeout if commit fails on timeout,
+// otherwise replication becomes asynchronous, so commits don't wait till quorum acknowledges the awaited records
+func (r *Replicas) WaitAcks(lsn int64) error {
+	if r.quorum <= 0 {
+		return nil
+	}
+	var deadline <-chan time.Time
+	if r.timeout > 0 {
+		timer := time.NewTimer(r.timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if lsn > r.waitLsn {
+		r.waitLsn = lsn
+	}
+	close(r.flushed)
+	r.flushed = make(chan struct{})
+	for r.ackedCount(lsn) < r.quorum {
+		if r.degraded {
+			return nil
+		}
+		acked := r.acked
+		r.mux.Unlock()
+		select {
+		case <-acked:
+			r.mux.Lock()
+		case <-deadline:
+			r.mux.Lock()
+			if r.failOnTimeout {
+				return ErrAckTimeout
+			}
+			if !r.degraded {
+				r.degraded = true
+				log.Printf("Replication is asynchronous: less than %d replicas acknowledged journal in %v", r.quorum, r.timeout)
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// Statuses returns replicas connected to primary in name order
+func (r *Replicas) Statuses() []ReplicaStatus {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	statuses := make([]ReplicaStatus, 0, len(r.conns))
+	for _, conn := range r.conns {
+		status := ReplicaStatus{Name: conn.name, Addr: conn.addr, AckedLsn: conn.ackedLsn, AckedAt: conn.ackedAt}
+		if r.flushedLsn > conn.ackedLsn {
+			status.Lag = r.flushedLsn - conn.ackedLsn
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
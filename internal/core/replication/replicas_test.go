@@ -0,0 +1,69 @@
+package replication
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+const ackTimeout = 50 * time.Millisecond
+
+// waitAsync runs WaitAcks in background and sends its result to channel
+func waitAsync(r *Replicas, lsn int64) chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- r.WaitAcks(lsn)
+	}()
+	return done
+}
+
+func TestReplicas_WaitAcksQuorum(t *testing.T) {
+	r := NewReplicas(2, time.Minute, true)
+	a := r.Register("a", "addr-a")
+	b := r.Register("b", "addr-b")
+	flushed := r.Flushed()
+	done := waitAsync(r, 10)
+	<-flushed
+	r.Ack(a, 10)
+	r.Ack(b, 9)
+	select {
+	case <-done:
+		t.Fatal("commit is acknowledged by less than quorum")
+	case <-time.After(ackTimeout):
+	}
+	r.Ack(b, 10)
+	assert.Nil(t, <-done)
+	r.Shipped(12)
+	statuses := r.Statuses()
+	assert.Equal(t, []string{"a", "b"}, []string{statuses[0].Name, statuses[1].Name})
+	assert.Equal(t, int64(2), statuses[0].Lag)
+}
+
+func TestReplicas_WaitAcksTimeout(t *testing.T) {
+	r := NewReplicas(1, ackTimeout, true)
+	assert.Equal(t, ErrAckTimeout, r.WaitAcks(1))
+	conn := r.Register("a", "addr-a")
+	r.Ack(conn, 1)
+	assert.Nil(t, r.WaitAcks(1))
+	r.Unregister(conn)
+	assert.Equal(t, ErrAckTimeout, r.WaitAcks(1))
+}
+
+func TestReplicas_AsyncFallback(t *testing.T) {
+	r := NewReplicas(1, ackTimeout, false)
+	assert.Nil(t, r.WaitAcks(5))
+	// replication is asynchronous till replica catches up, so commits don't wait
+	start := time.Now()
+	assert.Nil(t, r.WaitAcks(6))
+	assert.True(t, time.Since(start) < ackTimeout)
+	conn := r.Register("a", "addr-a")
+	r.Ack(conn, 5)
+	assert.True(t, r.degraded)
+	r.Ack(conn, 6)
+	assert.False(t, r.degraded)
+	flushed := r.Flushed()
+	done := waitAsync(r, 7)
+	<-flushed
+	r.Ack(conn, 7)
+	assert.Nil(t, <-done)
+}
@@ -0,0 +1,132 @@
+package restore
+
+import (
+	"dbms/internal/config"
+	"dbms/internal/core/logging"
+	"dbms/internal/core/storage"
+	"dbms/internal/utils"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	ErrInvalidTarget  = errors.New("either transaction id or time may be passed")
+	ErrNoBackup       = errors.New("full backup must be passed")
+	ErrStorageExists  = errors.New("storage already exists")
+	ErrTargetNotFound = errors.New("commit of transaction not found in journal after base backup")
+)
+
+// Target is a point in time which journal is restored to; zero target means the end of journal
+type Target struct {
+	// TxId is an id of the last restored transaction
+	TxId int
+	// Time bounds time of restored commits
+	Time time.Time
+}
+
+func (t Target) isZero() bool {
+	return t.TxId == 0 && t.Time.IsZero()
+}
+
+// Restore copies storage of full backup to files path of configuration, applies pages of incremental backups
+// following it in order, and copies journal segments of the last backup, archive and other directories
+// (e.g. journal of damaged server) to its journal directory; journal is cut right after target commit,
+// so the next start recovers storage as of target and rolls back later transactions as unfinished ones;
+// full backup is made by BACKUP command or is a copy of storage and journal directory of stopped server
+func Restore(cfg *config.CoreConfig, backupPaths []string, target Target, segDirs ...string) error {
+	if target.TxId != 0 && !target.Time.IsZero() {
+		return ErrInvalidTarget
+	}
+	if len(backupPaths) == 0 {
+		return ErrNoBackup
+	}
+	for _, p := range []string{cfg.DataPath(), cfg.LogPath()} {
+		if _, err := os.Stat(p); err == nil {
+			return fmt.Errorf("%w: %s", ErrStorageExists, p)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(cfg.DataPath()), 0777); err != nil {
+		return err
+	}
+	if err := utils.CopyFile(filepath.Join(backupPaths[0], "data.bin"), cfg.DataPath()); err != nil {
+		return err
+	}
+	for i := 1; i < len(backupPaths); i++ {
+		baseLsn, err := logging.CheckpointLsn(filepath.Join(backupPaths[i-1], "log"), cfg.LogSegCap)
+		if err != nil {
+			return err
+		}
+		if err := storage.ApplyIncrement(cfg.DataPath(), filepath.Join(backupPaths[i], "pages.bin"), baseLsn); err != nil {
+			return err
+		}
+	}
+	backupLogPath := filepath.Join(backupPaths[len(backupPaths)-1], "log")
+	checkpoint, found := logging.NewSegmentManager(backupLogPath, cfg.LogSegCap).LoadCheckpoint()
+	segDirs = append([]string{backupLogPath}, segDirs...)
+	if err := logging.CopySegments(cfg.LogPath(), checkpoint.SegId, segDirs...); err != nil {
+		return err
+	}
+	segMgr := logging.NewSegmentManager(cfg.LogPath(), cfg.LogSegCap)
+	segMgr.LoadSegments()
+	defer segMgr.CloseSegments()
+	if found {
+		segMgr.SaveCheckpoint(checkpoint)
+	}
+	if target.isZero() {
+		return nil
+	}
+	logMgr := logging.NewLogManager(segMgr)
+	cut, found, err := findCut(logMgr, checkpoint, target)
+	if err != nil {
+		return err
+	}
+	if found {
+		logMgr.TruncateAt(cut)
+	} else {
+		log.Printf("No commits after %s found, journal is restored completely", target.Time.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// findCut returns position of the first record after target; segments must follow each other without gaps
+func findCut(logMgr *logging.LogManager, checkpoint logging.LogPos, target Target) (logging.LogPos, bool, error) {
+	// journal is replayed from the beginning if backup has no checkpoint
+	nextSegId := checkpoint.SegId
+	segIter := logMgr.SegmentIterator()
+	for seg := segIter.Next(); seg != nil; seg = segIter.Next() {
+		if nextSegId != 0 && seg.Id() != nextSegId {
+			return logging.LogPos{}, false, fmt.Errorf("journal segment %d is missing", nextSegId)
+		}
+		nextSegId = seg.Id() + 1
+		var logIter *logging.LogIterator
+		if seg.Id() == checkpoint.SegId {
+			logIter = seg.LogIteratorAt(checkpoint.Offset)
+		} else {
+			logIter = seg.LogIterator()
+		}
+		offset := logIter.Offset()
+		for r, err := logIter.Next(); err != io.EOF; r, err = logIter.Next() {
+			if err != nil {
+				return logging.LogPos{}, false, fmt.Errorf("journal segment %s: %w", seg.Name(), err)
+			}
+			if r.Type() == logging.CommitRecord {
+				if target.TxId != 0 && r.TxId() == target.TxId {
+					return logging.LogPos{SegId: seg.Id(), Offset: logIter.Offset()}, true, nil
+				}
+				if target.TxId == 0 && r.Time().After(target.Time) {
+					return logging.LogPos{SegId: seg.Id(), Offset: offset}, true, nil
+				}
+			}
+			offset = logIter.Offset()
+		}
+	}
+	if target.TxId != 0 {
+		return logging.LogPos{}, false, ErrTargetNotFound
+	}
+	return logging.LogPos{}, false, nil
+}
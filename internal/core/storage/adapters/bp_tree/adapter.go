@@ -1,19 +1,37 @@
 package bp_tree
 
 import (
+	"dbms/internal/core/storage"
 	"dbms/internal/core/transaction"
+	"log"
 )
 
 type BPTreeAdapter struct {
-	tx transaction.Tx
+	tx           transaction.Tx
+	maxKeyLength int
 }
 
-func NewBPTreeAdapter(tx transaction.Tx) *BPTreeAdapter {
+func NewBPTreeAdapter(tx transaction.Tx, maxKeyLength int) *BPTreeAdapter {
 	var ba BPTreeAdapter
 	ba.tx = tx
+	ba.maxKeyLength = maxKeyLength
 	return &ba
 }
 
+func (ba *BPTreeAdapter) MaxKeyLength() int {
+	return ba.maxKeyLength
+}
+
+// Order returns the largest B+ tree order t, such that node with 2*t keys
+// of max length (node state right before split) fits a single page
+func (ba *BPTreeAdapter) Order() int {
+	t := NodeOrder(ba.tx.PageSize(), ba.maxKeyLength)
+	if t < 2 {
+		log.Panicf("max key length %d is too large for page size %d", ba.maxKeyLength, ba.tx.PageSize())
+	}
+	return t
+}
+
 func (ba *BPTreeAdapter) ReadNodeAtPos(pos int64) *BPTreeNode {
 	page := ba.tx.ReadPageAtPos(pos)
 	bpa := newBPTreePageAdapter(page)
@@ -34,6 +52,39 @@ func (ba *BPTreeAdapter) WriteNode(node *BPTreeNode) int64 {
 	return ba.tx.WritePage(page)
 }
 
+// ReleaseNode returns node's page to storage free pages list
+func (ba *BPTreeAdapter) ReleaseNode(pos int64) {
+	ba.tx.ReleasePage(pos)
+}
+
+// LatchTree protects tree from concurrent structure changes (see transaction.Tx LatchTree)
+func (ba *BPTreeAdapter) LatchTree(exclusive bool) {
+	ba.tx.LatchTree(exclusive)
+}
+
+func (ba *BPTreeAdapter) UnlatchTree() {
+	ba.tx.UnlatchTree()
+}
+
+// LatchPage latches page which tree entry refers to (see transaction.Tx LatchPage)
+func (ba *BPTreeAdapter) LatchPage(pos int64, exclusive bool) {
+	ba.tx.LatchPage(pos, exclusive)
+}
+
+func (ba *BPTreeAdapter) TryLatchPage(pos int64, exclusive bool) bool {
+	return ba.tx.TryLatchPage(pos, exclusive)
+}
+
+func (ba *BPTreeAdapter) UnlatchPages() {
+	ba.tx.UnlatchPages()
+}
+
+// HeaderPos returns position of B+ tree header node;
+// header is the first page allocated after storage meta page
+func (ba *BPTreeAdapter) HeaderPos() int64 {
+	return storage.MetaPagePos + int64(ba.tx.PageSize())
+}
+
 func (ba *BPTreeAdapter) NoDataFound() bool {
 	return ba.tx.NoDataFound()
 }
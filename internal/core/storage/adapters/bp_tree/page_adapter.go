@@ -11,6 +11,18 @@ var (
 	bpTreePointerSize = 8
 )
 
+// NodeOrder calculates the largest B+ tree order t for page size and max key length;
+// node with 2*t keys and 2*t+1 pointers is stored in a page (each as separate heap record)
+func NodeOrder(pageSize int, maxKeyLength int) int {
+	free := storage.GetHeapPageCapacity(pageSize)
+	// header and the last pointer
+	free -= storage.HeapRecordPointerSize + bpTreeNodeHeaderSize
+	free -= storage.HeapRecordPointerSize + bpTreePointerSize
+	// key and pointer pair
+	pairSize := 2*storage.HeapRecordPointerSize + bpTreePointerSize + maxKeyLength
+	return free / (2 * pairSize)
+}
+
 type bpTreePageAdapter struct {
 	page *storage.HeapPage
 }
@@ -32,7 +44,7 @@ func (bpa *bpTreePageAdapter) WriteNode(node *BPTreeNode) {
 	for i := 0; i < int(node.Size); i++ {
 		bpa.page.AppendData([]byte(node.Keys[i]))
 		if bpa.page.FreeSpace() < 0 {
-			log.Panic("implementation limitation: can't fit index")
+			log.Panic("node doesn't fit page")
 		}
 	}
 	ptrBuf := make([]byte, bpTreePointerSize, bpTreePointerSize)
@@ -44,7 +56,7 @@ func (bpa *bpTreePageAdapter) WriteNode(node *BPTreeNode) {
 		}
 		bpa.page.AppendData(ptrBuf)
 		if bpa.page.FreeSpace() < 0 {
-			log.Panic("implementation limitation: can't fit index")
+			log.Panic("node doesn't fit page")
 		}
 		writer.Reset()
 	}
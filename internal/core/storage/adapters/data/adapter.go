@@ -1,8 +1,10 @@
 package data
 
 import (
+	"dbms/internal/core/storage"
 	"dbms/internal/core/transaction"
 	"errors"
+	"log"
 )
 
 var (
@@ -10,55 +12,360 @@ var (
 	ErrPageIsFull     = errors.New("page is full")
 )
 
+// DataAdapter reads and writes records at pages latched by transaction (see BPTree FindLatched),
+// so concurrent commands don't change them meanwhile
 type DataAdapter struct {
-	tx transaction.Tx
+	tx  transaction.Tx
+	fsm *freeSpaceMap
+	// maxInlineSize bounds record size which is stored in data page;
+	// larger records keep their data in overflow pages
+	maxInlineSize int
 }
 
 func NewDataAdapter(tx transaction.Tx) *DataAdapter {
 	var da DataAdapter
 	da.tx = tx
+	da.fsm = newFreeSpaceMap(tx)
+	// quarter of page keeps data pages dense enough
+	da.maxInlineSize = storage.GetHeapPageCapacity(tx.PageSize()) / 4
 	return &da
 }
 
+// FindAtPos returns the newest value version which is visible to transaction
 func (da *DataAdapter) FindAtPos(key string, pos int64) ([]byte, error) {
-	page := da.tx.ReadPageAtPos(pos)
-	dpa := newDataPageAdapter(page)
-	rec, _ := dpa.FindRecordByKey([]byte(key))
-	if rec == nil {
-		return nil, ErrRecordNotFound
+	_, chain, findErr := da.readChain(key, pos)
+	if findErr != nil {
+		return nil, findErr
+	}
+	for _, v := range chain {
+		if !da.tx.Visible(int(v.TxId)) {
+			continue
+		}
+		if v.Tombstone() {
+			return nil, ErrRecordNotFound
+		}
+		return da.versionData(v), nil
+	}
+	return nil, ErrRecordNotFound
+}
+
+// ExistsAtPos checks if record is visible to transaction and isn't deleted; value itself is not read
+func (da *DataAdapter) ExistsAtPos(key string, pos int64) bool {
+	_, chain, findErr := da.readChain(key, pos)
+	if findErr != nil {
+		return false
+	}
+	for _, v := range chain {
+		if da.tx.Visible(int(v.TxId)) {
+			return !v.Tombstone()
+		}
+	}
+	return false
+}
+
+// OwnVersionAtPos returns version of record made by transaction itself and reports if it is a tombstone;
+// ErrRecordNotFound is returned if transaction hasn't changed record
+func (da *DataAdapter) OwnVersionAtPos(key string, pos int64) ([]byte, bool, error) {
+	_, chain, findErr := da.readChain(key, pos)
+	if findErr != nil {
+		return nil, false, findErr
+	}
+	for _, v := range chain {
+		if int(v.TxId) != da.tx.Id() {
+			continue
+		}
+		if v.Tombstone() {
+			return nil, true, nil
+		}
+		return da.versionData(v), false, nil
+	}
+	return nil, false, ErrRecordNotFound
+}
+
+// HeadTxId returns id of transaction which created the newest version of record
+func (da *DataAdapter) HeadTxId(key string, pos int64) (int, error) {
+	_, chain, findErr := da.readChain(key, pos)
+	if findErr != nil {
+		return -1, findErr
+	}
+	return int(chain[0].TxId), nil
+}
+
+// WriteAtPos adds new version to record at pos; if record doesn't fit the page anymore,
+// then it is moved to another page; returns record's actual position
+func (da *DataAdapter) WriteAtPos(key string, data []byte, pos int64) (int64, error) {
+	return da.pushVersion(key, da.createVersion(data), pos)
+}
+
+// MarkDeletedAtPos adds tombstone version to record at pos, so record stays visible to older snapshots
+// until vacuum removes it; returns record's actual position
+func (da *DataAdapter) MarkDeletedAtPos(key string, pos int64) (int64, error) {
+	_, chain, findErr := da.readChain(key, pos)
+	if findErr != nil {
+		return -1, findErr
+	}
+	if chain[0].Tombstone() {
+		return -1, ErrRecordNotFound
+	}
+	tombstone := new(version)
+	tombstone.TxId = int64(da.tx.Id())
+	tombstone.SetTombstone(true)
+	return da.pushVersion(key, tombstone, pos)
+}
+
+// Write puts record into a page with enough free space or into a new page
+func (da *DataAdapter) Write(key string, data []byte) (int64, error) {
+	return da.writeRecord(da.createRecord(key, da.marshalChain(versionChain{da.createVersion(data)})))
+}
+
+// DeleteAtPos removes record with all its versions
+func (da *DataAdapter) DeleteAtPos(key string, pos int64) error {
+	_, chain, findErr := da.readChain(key, pos)
+	if findErr != nil {
+		return findErr
+	}
+	rec, delErr := da.removeRecordAtPos(key, pos)
+	if delErr != nil {
+		return delErr
+	}
+	if rec.Overflow() {
+		da.releaseOverflow(unmarshalOverflowRef(rec.Data))
+	}
+	da.releaseVersions(chain)
+	return nil
+}
+
+// Vacuum removes versions which are invisible to all transactions; record is removed completely
+// if its only version is an obsolete tombstone; returns record's actual position (-1 if it is removed)
+// and reports if record still has old versions (they are kept for active snapshots)
+func (da *DataAdapter) Vacuum(key string, pos int64) (int64, bool, error) {
+	_, chain, findErr := da.readChain(key, pos)
+	if findErr != nil {
+		return -1, false, findErr
+	}
+	pruned := da.prune(chain)
+	if len(pruned) == 1 && pruned[0].Tombstone() && da.tx.Obsolete(int(pruned[0].TxId)) {
+		return -1, false, da.DeleteAtPos(key, pos)
+	}
+	pending := len(pruned) > 1 || pruned[0].Tombstone()
+	if len(pruned) == len(chain) {
+		return pos, pending, nil
+	}
+	da.releaseVersions(chain[len(pruned):])
+	newPos, writeErr := da.rewriteRecord(key, pruned, pos)
+	return newPos, pending, writeErr
+}
+
+// RollbackAtPos removes versions created by transaction; record is removed completely if it has no other versions;
+// returns record's actual position (-1 if it is removed)
+func (da *DataAdapter) RollbackAtPos(key string, pos int64) (int64, error) {
+	_, chain, findErr := da.readChain(key, pos)
+	if findErr != nil {
+		return -1, findErr
+	}
+	kept := make(versionChain, 0, len(chain))
+	dropped := make(versionChain, 0)
+	for _, v := range chain {
+		// versions of other shared transactions may be put over ours, so the whole chain is checked
+		if int(v.TxId) == da.tx.Id() {
+			dropped = append(dropped, v)
+		} else {
+			kept = append(kept, v)
+		}
+	}
+	if len(dropped) == 0 {
+		return pos, nil
+	}
+	if len(kept) == 0 {
+		return -1, da.DeleteAtPos(key, pos)
+	}
+	da.releaseVersions(dropped)
+	return da.rewriteRecord(key, kept, pos)
+}
+
+// pushVersion puts version at the head of record's chain; version of the same transaction is replaced
+func (da *DataAdapter) pushVersion(key string, v *version, pos int64) (int64, error) {
+	_, chain, findErr := da.readChain(key, pos)
+	if findErr != nil {
+		return -1, findErr
+	}
+	if chain[0].TxId == v.TxId {
+		da.releaseVersions(chain[:1])
+		chain = chain[1:]
+	}
+	chain = append(versionChain{v}, chain...)
+	pruned := da.prune(chain)
+	da.releaseVersions(chain[len(pruned):])
+	if len(pruned) > 1 || v.Tombstone() {
+		da.tx.AddGarbage(key)
+	}
+	return da.rewriteRecord(key, pruned, pos)
+}
+
+// prune cuts versions which are older than the newest obsolete one, because nobody can see them
+func (da *DataAdapter) prune(chain versionChain) versionChain {
+	for i, v := range chain {
+		if da.tx.Obsolete(int(v.TxId)) {
+			return chain[:i+1]
+		}
 	}
-	return rec.Data, nil
+	return chain
 }
 
-func (da *DataAdapter) WriteAtPos(key string, data []byte, pos int64) error {
+// rewriteRecord overwrites record's versions chain at pos; record is moved if it doesn't fit the page
+func (da *DataAdapter) rewriteRecord(key string, chain versionChain, pos int64) (int64, error) {
+	oldRec, _ := newDataPageAdapter(da.tx.ReadPageAtPos(pos)).FindRecordByKey([]byte(key))
+	if oldRec != nil && oldRec.Overflow() {
+		da.releaseOverflow(unmarshalOverflowRef(oldRec.Data))
+	}
+	rec := da.createRecord(key, da.marshalChain(chain))
 	page := da.tx.ReadPageAtPos(pos)
 	dpa := newDataPageAdapter(page)
-	if writeErr := dpa.WriteRecordByKey([]byte(key), data); writeErr != nil {
-		return writeErr
+	writeErr := dpa.WriteRecord(rec)
+	if writeErr == ErrPageIsFull {
+		if _, delErr := da.removeRecordAtPos(key, pos); delErr != nil {
+			return -1, delErr
+		}
+		return da.writeRecord(rec)
+	} else if writeErr != nil {
+		return -1, writeErr
 	}
 	da.tx.WritePageAtPos(page, pos)
-	return nil
+	da.fsm.Update(pos, page.FreeSpace())
+	return pos, nil
 }
 
-func (da *DataAdapter) Write(key string, data []byte) (int64, error) {
-	var rec record
-	rec.Key = []byte(key)
-	rec.Data = data
-	// TODO: write at free page
+// readChain finds record by key and unmarshals its versions chain
+func (da *DataAdapter) readChain(key string, pos int64) (*record, versionChain, error) {
+	rec, _ := newDataPageAdapter(da.tx.ReadPageAtPos(pos)).FindRecordByKey([]byte(key))
+	if rec == nil {
+		return nil, nil, ErrRecordNotFound
+	}
+	data := rec.Data
+	if rec.Overflow() {
+		data = da.readOverflow(unmarshalOverflowRef(rec.Data))
+	}
+	var chain versionChain
+	if unmarshalErr := chain.UnmarshalBinary(data); unmarshalErr != nil {
+		log.Panic(unmarshalErr)
+	}
+	return rec, chain, nil
+}
+
+func (da *DataAdapter) marshalChain(chain versionChain) []byte {
+	data, marshalErr := chain.MarshalBinary()
+	if marshalErr != nil {
+		log.Panic(marshalErr)
+	}
+	return data
+}
+
+// createVersion moves data to overflow pages if it is too large to be stored inline
+func (da *DataAdapter) createVersion(data []byte) *version {
+	v := new(version)
+	v.TxId = int64(da.tx.Id())
+	v.Data = data
+	if len(data) <= da.maxInlineSize {
+		return v
+	}
+	refData, marshalErr := da.writeOverflow(data).MarshalBinary()
+	if marshalErr != nil {
+		log.Panic(marshalErr)
+	}
+	v.SetOverflow(true)
+	v.Data = refData
+	return v
+}
+
+func (da *DataAdapter) versionData(v *version) []byte {
+	if v.Overflow() {
+		return da.readOverflow(unmarshalOverflowRef(v.Data))
+	}
+	return v.Data
+}
+
+// releaseVersions returns overflow pages of dropped versions to storage
+func (da *DataAdapter) releaseVersions(chain versionChain) {
+	for _, v := range chain {
+		if v.Overflow() {
+			da.releaseOverflow(unmarshalOverflowRef(v.Data))
+		}
+	}
+}
+
+// createRecord moves versions chain to overflow pages if record is too large to be stored inline
+func (da *DataAdapter) createRecord(key string, data []byte) *record {
+	rec := NewRecord([]byte(key), data)
+	if rec.Size() <= da.maxInlineSize {
+		return rec
+	}
+	refData, marshalErr := da.writeOverflow(data).MarshalBinary()
+	if marshalErr != nil {
+		log.Panic(marshalErr)
+	}
+	rec.SetOverflow(true)
+	rec.Data = refData
+	return rec
+}
+
+func (da *DataAdapter) writeRecord(rec *record) (int64, error) {
+	if pos := da.findPage(rec.Size() + storage.HeapRecordPointerSize); pos != -1 {
+		page := da.tx.ReadPageAtPos(pos)
+		dpa := newDataPageAdapter(page)
+		// map is conservative, so record is expected to fit
+		if writeErr := dpa.WriteRecord(rec); writeErr == nil {
+			da.tx.WritePageAtPos(page, pos)
+			da.fsm.Update(pos, page.FreeSpace())
+			return pos, nil
+		}
+	}
 	page := da.tx.AllocatePage()
 	dpa := newDataPageAdapter(page)
-	if writeErr := dpa.WriteRecordByKey([]byte(key), data); writeErr != nil {
+	if writeErr := dpa.WriteRecord(rec); writeErr != nil {
 		return -1, writeErr
 	}
-	return da.tx.WritePage(page), nil
+	pos := da.tx.WritePage(page)
+	da.fsm.Update(pos, page.FreeSpace())
+	return pos, nil
 }
 
-func (da *DataAdapter) DeleteAtPos(key string, pos int64) error {
+// findPage returns exclusively latched data page with at least size free bytes; -1 if there is no such page;
+// page latched by concurrent command is skipped, because command doesn't wait for latch of the second page;
+// page is found and latched with meta latched, so it isn't released meanwhile
+func (da *DataAdapter) findPage(size int) int64 {
+	da.tx.LatchMeta()
+	defer da.tx.UnlatchMeta()
+	pos := da.fsm.FindPage(size)
+	if pos == -1 || !da.tx.TryLatchPage(pos, true) {
+		return -1
+	}
+	return pos
+}
+
+// removeRecordAtPos removes record from page without overflow pages release; returns removed record
+func (da *DataAdapter) removeRecordAtPos(key string, pos int64) (*record, error) {
 	page := da.tx.ReadPageAtPos(pos)
 	dpa := newDataPageAdapter(page)
-	if found := dpa.DeleteRecordByKey([]byte(key)); !found {
-		return ErrRecordNotFound
+	rec, _ := dpa.FindRecordByKey([]byte(key))
+	if rec == nil {
+		return nil, ErrRecordNotFound
+	}
+	dpa.DeleteRecordByKey([]byte(key))
+	if page.Records() == 0 {
+		// recycle emptied page
+		da.fsm.Update(pos, 0)
+		da.tx.ReleasePage(pos)
+		return rec, nil
 	}
 	da.tx.WritePageAtPos(page, pos)
-	return nil
+	da.fsm.Update(pos, page.FreeSpace())
+	return rec, nil
+}
+
+func unmarshalOverflowRef(data []byte) *overflowRef {
+	ref := new(overflowRef)
+	if unmarshalErr := ref.UnmarshalBinary(data); unmarshalErr != nil {
+		log.Panic(unmarshalErr)
+	}
+	return ref
 }
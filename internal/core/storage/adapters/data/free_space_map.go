@@ -0,0 +1,194 @@
+package data
+
+import (
+	"bytes"
+	"dbms/internal/core/storage"
+	"dbms/internal/core/transaction"
+	"encoding/binary"
+	"log"
+)
+
+// int64 next pointer, int64 map page number and byte of max category
+const fsmHeaderSize = 8 + 8 + 1
+
+// fsmCategories is a number of free space categories; category fits one byte
+const fsmCategories = 256
+
+// freeSpaceMap tracks approximate free space of data pages;
+// each page is described with one byte category (free space divided by category step),
+// so map is conservative: page has at least category * step free bytes;
+// pages which are not data pages have zero category;
+// map is stored in pages chain anchored at storage meta page; k-th map page
+// describes k-th range of pages numbers and keeps max category of its range,
+// so search skips map pages without enough space; meta page keeps hint of the first map page
+// with free space, so full pages at the beginning of storage are not searched at all;
+// map and meta page are changed with meta latched
+type freeSpaceMap struct {
+	tx       transaction.Tx
+	pageSize int
+	// entries is a number of pages described with single map page
+	entries int
+	step    int
+}
+
+type freeSpaceMapPage struct {
+	next int64
+	// num is a number of map page in chain
+	num int64
+	// maxCategory is a max category of pages described with map page
+	maxCategory byte
+	categories  []byte
+}
+
+func newFreeSpaceMap(tx transaction.Tx) *freeSpaceMap {
+	m := new(freeSpaceMap)
+	m.tx = tx
+	m.pageSize = tx.PageSize()
+	capacity := storage.GetHeapPageCapacity(m.pageSize)
+	m.entries = capacity - fsmHeaderSize - 2*storage.HeapRecordPointerSize
+	m.step = (capacity + fsmCategories - 1) / fsmCategories
+	return m
+}
+
+// FindPage returns position of data page with at least size free bytes; -1 if there is no such page
+func (m *freeSpaceMap) FindPage(size int) int64 {
+	reqCategory := (size + m.step - 1) / m.step
+	if reqCategory >= fsmCategories {
+		return -1
+	}
+	m.tx.LatchMeta()
+	defer m.tx.UnlatchMeta()
+	meta := m.tx.ReadMetaPage()
+	pos := meta.FreeSpaceMapHint
+	// map pages without free space which lead search are skipped by the next searches
+	hint, leading := pos, true
+	defer func() {
+		if hint != meta.FreeSpaceMapHint {
+			meta.FreeSpaceMapHint = hint
+			m.tx.WriteMetaPage(meta)
+		}
+	}()
+	for pos != -1 {
+		mapPage := m.readMapPage(pos)
+		if leading && mapPage.maxCategory == 0 {
+			hint = mapPage.next
+		} else {
+			leading = false
+		}
+		if int(mapPage.maxCategory) >= reqCategory {
+			for i, category := range mapPage.categories {
+				if int(category) >= reqCategory {
+					return (mapPage.num*int64(m.entries) + int64(i)) * int64(m.pageSize)
+				}
+			}
+		}
+		pos = mapPage.next
+	}
+	return -1
+}
+
+// Update sets free space of page at pos; map is extended on demand
+func (m *freeSpaceMap) Update(pos int64, freeSpace int) {
+	pageNum := int(pos / int64(m.pageSize))
+	category := freeSpace / m.step
+	if category >= fsmCategories {
+		category = fsmCategories - 1
+	}
+	m.tx.LatchMeta()
+	defer m.tx.UnlatchMeta()
+	mapPos := m.tx.ReadMetaPage().FreeSpaceMapHead
+	if mapPos == -1 {
+		if category == 0 {
+			// untracked pages are treated as full ones
+			return
+		}
+		mapPos = m.appendMapPage(0)
+		// meta is read again, because allocation may change it
+		meta := m.tx.ReadMetaPage()
+		meta.FreeSpaceMapHead = mapPos
+		m.tx.WriteMetaPage(meta)
+	}
+	mapPage := m.readMapPage(mapPos)
+	for mapIdx := 0; mapIdx < pageNum/m.entries; mapIdx++ {
+		if mapPage.next == -1 {
+			if category == 0 {
+				return
+			}
+			mapPage.next = m.appendMapPage(mapPage.num + 1)
+			m.writeMapPage(mapPage, mapPos)
+		}
+		mapPos = mapPage.next
+		mapPage = m.readMapPage(mapPos)
+	}
+	entry := pageNum % m.entries
+	if int(mapPage.categories[entry]) == category {
+		// prevent page from being dirty
+		return
+	}
+	mapPage.categories[entry] = byte(category)
+	mapPage.maxCategory = 0
+	for _, c := range mapPage.categories {
+		if c > mapPage.maxCategory {
+			mapPage.maxCategory = c
+		}
+	}
+	m.writeMapPage(mapPage, mapPos)
+	if category != 0 {
+		m.lowerHint(mapPage.num, mapPos)
+	}
+}
+
+// lowerHint moves hint to map page at pos if it precedes hinted one
+func (m *freeSpaceMap) lowerHint(num int64, pos int64) {
+	meta := m.tx.ReadMetaPage()
+	if meta.FreeSpaceMapHint == pos {
+		return
+	}
+	if meta.FreeSpaceMapHint != -1 && m.readMapPage(meta.FreeSpaceMapHint).num < num {
+		return
+	}
+	meta.FreeSpaceMapHint = pos
+	m.tx.WriteMetaPage(meta)
+}
+
+func (m *freeSpaceMap) appendMapPage(num int64) int64 {
+	mapPage := new(freeSpaceMapPage)
+	mapPage.next = -1
+	mapPage.num = num
+	mapPage.categories = make([]byte, m.entries, m.entries)
+	page := m.tx.AllocatePage()
+	m.marshalMapPage(mapPage, page)
+	return m.tx.WritePage(page)
+}
+
+func (m *freeSpaceMap) readMapPage(pos int64) *freeSpaceMapPage {
+	page := m.tx.ReadPageAtPos(pos)
+	mapPage := new(freeSpaceMapPage)
+	reader := bytes.NewReader(page.ReadData(0))
+	for _, field := range []interface{}{&mapPage.next, &mapPage.num, &mapPage.maxCategory} {
+		if readErr := binary.Read(reader, binary.LittleEndian, field); readErr != nil {
+			log.Panic(readErr)
+		}
+	}
+	// copy categories, so page's data is not modified in place
+	mapPage.categories = make([]byte, m.entries, m.entries)
+	copy(mapPage.categories, page.ReadData(1))
+	return mapPage
+}
+
+func (m *freeSpaceMap) writeMapPage(mapPage *freeSpaceMapPage, pos int64) {
+	page := m.tx.AllocatePage()
+	m.marshalMapPage(mapPage, page)
+	m.tx.WritePageAtPos(page, pos)
+}
+
+func (m *freeSpaceMap) marshalMapPage(mapPage *freeSpaceMapPage, page *storage.HeapPage) {
+	buf := new(bytes.Buffer)
+	for _, field := range []interface{}{mapPage.next, mapPage.num, mapPage.maxCategory} {
+		if writeErr := binary.Write(buf, binary.LittleEndian, field); writeErr != nil {
+			log.Panic(writeErr)
+		}
+	}
+	page.AppendData(buf.Bytes())
+	page.AppendData(mapPage.categories)
+}
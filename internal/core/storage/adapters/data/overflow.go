@@ -0,0 +1,90 @@
+package data
+
+import (
+	"bytes"
+	"dbms/internal/core/storage"
+	"encoding/binary"
+	"log"
+)
+
+// int64
+const overflowNextPtrSize = 8
+
+// overflowRef is stored as record's data when value is moved to overflow pages chain;
+// each chain page stores next page position and a chunk of value
+type overflowRef struct {
+	Pos  int64
+	Size int64
+}
+
+func (ref *overflowRef) MarshalBinary() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if writeErr := binary.Write(buf, binary.LittleEndian, ref); writeErr != nil {
+		return nil, writeErr
+	}
+	return buf.Bytes(), nil
+}
+
+func (ref *overflowRef) UnmarshalBinary(data []byte) error {
+	return binary.Read(bytes.NewReader(data), binary.LittleEndian, ref)
+}
+
+// overflowChunkSize is a value chunk size which fits single overflow page
+func (da *DataAdapter) overflowChunkSize() int {
+	return storage.GetHeapPageCapacity(da.tx.PageSize()) - overflowNextPtrSize - 2*storage.HeapRecordPointerSize
+}
+
+// writeOverflow spreads data over pages chain; returns reference to chain
+func (da *DataAdapter) writeOverflow(data []byte) *overflowRef {
+	chunkSize := da.overflowChunkSize()
+	chunks := (len(data) + chunkSize - 1) / chunkSize
+	next := int64(-1)
+	// write chain from tail, so each page knows position of the next one
+	for chunk := chunks - 1; chunk >= 0; chunk-- {
+		chunkEnd := (chunk + 1) * chunkSize
+		if chunkEnd > len(data) {
+			chunkEnd = len(data)
+		}
+		page := da.tx.AllocatePage()
+		buf := new(bytes.Buffer)
+		if writeErr := binary.Write(buf, binary.LittleEndian, next); writeErr != nil {
+			log.Panic(writeErr)
+		}
+		page.AppendData(buf.Bytes())
+		page.AppendData(data[chunk*chunkSize : chunkEnd])
+		next = da.tx.WritePage(page)
+	}
+	ref := new(overflowRef)
+	ref.Pos = next
+	ref.Size = int64(len(data))
+	return ref
+}
+
+// readOverflow reassembles data from pages chain
+func (da *DataAdapter) readOverflow(ref *overflowRef) []byte {
+	data := make([]byte, 0, ref.Size)
+	for pos := ref.Pos; pos != -1; {
+		page := da.tx.ReadPageAtPos(pos)
+		data = append(data, page.ReadData(1)...)
+		pos = overflowNextPos(page)
+	}
+	return data
+}
+
+// releaseOverflow returns chain pages to storage free pages list
+func (da *DataAdapter) releaseOverflow(ref *overflowRef) {
+	for pos := ref.Pos; pos != -1; {
+		next := overflowNextPos(da.tx.ReadPageAtPos(pos))
+		da.tx.ReleasePage(pos)
+		pos = next
+	}
+}
+
+func overflowNextPos(page *storage.HeapPage) int64 {
+	var next int64
+	reader := bytes.NewReader(page.ReadData(0))
+	if readErr := binary.Read(reader, binary.LittleEndian, &next); readErr != nil {
+		log.Panic(readErr)
+	}
+	return next
+}
@@ -46,7 +46,6 @@ func (dpa *dataPageAdapter) DeleteRecordByKey(key []byte) bool {
 	return false
 }
 
-// TODO: spanning records
 func (dpa *dataPageAdapter) WriteRecord(record *record) error {
 	// get free space with potentially removed record
 	expSpace := dpa.page.FreeSpace()
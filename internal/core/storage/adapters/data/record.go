@@ -2,11 +2,14 @@ package data
 
 import (
 	"bytes"
+	"dbms/internal/core/storage"
 	"encoding/binary"
 	"log"
 )
 
 const (
+	// uint8
+	recFlagsSize = 1
 	// int32
 	keyLenSize = 4
 	// int32
@@ -14,8 +17,9 @@ const (
 )
 
 type record struct {
-	Key  []byte
-	Data []byte
+	Flags storage.BitArray
+	Key   []byte
+	Data  []byte
 }
 
 func NewRecord(key []byte, data []byte) *record {
@@ -25,12 +29,24 @@ func NewRecord(key []byte, data []byte) *record {
 	return &rec
 }
 
+// Overflow reports if record's data is a reference to overflow pages chain
+func (r *record) Overflow() bool {
+	return r.Flags.Get(0)
+}
+
+func (r *record) SetOverflow(value bool) {
+	r.Flags.Set(value, 0)
+}
+
 func (r *record) Size() int {
-	return len(r.Key) + len(r.Data) + keyLenSize + dataLenSize
+	return len(r.Key) + len(r.Data) + recFlagsSize + keyLenSize + dataLenSize
 }
 
 func (r *record) MarshalBinary() ([]byte, error) {
 	recBuf := new(bytes.Buffer)
+	if writeErr := binary.Write(recBuf, binary.LittleEndian, r.Flags); writeErr != nil {
+		log.Panic(writeErr)
+	}
 	keySize := int32(len(r.Key))
 	if writeErr := binary.Write(recBuf, binary.LittleEndian, keySize); writeErr != nil {
 		log.Panic(writeErr)
@@ -50,6 +66,9 @@ func (r *record) MarshalBinary() ([]byte, error) {
 
 func (r *record) UnmarshalBinary(data []byte) error {
 	recBuf := bytes.NewBuffer(data)
+	if readErr := binary.Read(recBuf, binary.LittleEndian, &r.Flags); readErr != nil {
+		log.Panic(readErr)
+	}
 	keySize := new(int32)
 	if readErr := binary.Read(recBuf, binary.LittleEndian, keySize); readErr != nil {
 		log.Panic(readErr)
@@ -0,0 +1,87 @@
+package data
+
+import (
+	"bytes"
+	"dbms/internal/core/storage"
+	"encoding/binary"
+	"log"
+)
+
+// version is a value of record created by transaction;
+// record keeps chain of versions ordered from the newest to the oldest one
+type version struct {
+	Flags storage.BitArray
+	// TxId is an id of transaction which created version
+	TxId int64
+	Data []byte
+}
+
+// Overflow reports if version's data is a reference to overflow pages chain
+func (v *version) Overflow() bool {
+	return v.Flags.Get(0)
+}
+
+func (v *version) SetOverflow(value bool) {
+	v.Flags.Set(value, 0)
+}
+
+// Tombstone reports if version marks record as deleted
+func (v *version) Tombstone() bool {
+	return v.Flags.Get(1)
+}
+
+func (v *version) SetTombstone(value bool) {
+	v.Flags.Set(value, 1)
+}
+
+type versionChain []*version
+
+func (c versionChain) MarshalBinary() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if writeErr := binary.Write(buf, binary.LittleEndian, int32(len(c))); writeErr != nil {
+		log.Panic(writeErr)
+	}
+	for _, v := range c {
+		if writeErr := binary.Write(buf, binary.LittleEndian, v.Flags); writeErr != nil {
+			log.Panic(writeErr)
+		}
+		if writeErr := binary.Write(buf, binary.LittleEndian, v.TxId); writeErr != nil {
+			log.Panic(writeErr)
+		}
+		if writeErr := binary.Write(buf, binary.LittleEndian, int32(len(v.Data))); writeErr != nil {
+			log.Panic(writeErr)
+		}
+		if _, writeErr := buf.Write(v.Data); writeErr != nil {
+			log.Panic(writeErr)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *versionChain) UnmarshalBinary(data []byte) error {
+	buf := bytes.NewBuffer(data)
+	var versions int32
+	if readErr := binary.Read(buf, binary.LittleEndian, &versions); readErr != nil {
+		return readErr
+	}
+	*c = make(versionChain, versions)
+	for i := range *c {
+		v := new(version)
+		if readErr := binary.Read(buf, binary.LittleEndian, &v.Flags); readErr != nil {
+			return readErr
+		}
+		if readErr := binary.Read(buf, binary.LittleEndian, &v.TxId); readErr != nil {
+			return readErr
+		}
+		var dataSize int32
+		if readErr := binary.Read(buf, binary.LittleEndian, &dataSize); readErr != nil {
+			return readErr
+		}
+		v.Data = make([]byte, dataSize)
+		if _, readErr := buf.Read(v.Data); readErr != nil && dataSize != 0 {
+			return readErr
+		}
+		(*c)[i] = v
+	}
+	return nil
+}
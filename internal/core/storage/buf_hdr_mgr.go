@@ -45,9 +45,15 @@ func (m *bufferHeaderManager) getHdrBySlotId(slotId int) *bufferHeader {
 	return e.Value.(*bufferHeader)
 }
 
-func (m *bufferHeaderManager) pin(slotId int) {
-	hdr := m.getHdrBySlotId(slotId)
-	hdr.refCtr.Incr()
+// pin pins page described by descriptor unless it is evicted
+func (m *bufferHeaderManager) pin(desc *bufferSlotDescriptor) bool {
+	desc.pinMux.Lock()
+	defer desc.pinMux.Unlock()
+	if desc.evicted {
+		return false
+	}
+	m.getHdrBySlotId(desc.slotId).refCtr.Incr()
+	return true
 }
 
 func (m *bufferHeaderManager) unpin(slotId int) {
@@ -55,33 +61,45 @@ func (m *bufferHeaderManager) unpin(slotId int) {
 	hdr.refCtr.Decr()
 }
 
-// victim uses an optimistic LRU prune algorithm
-// returned slotId maybe already acquired, so new victim retrieval round will be started
+// victim uses an optimistic LRU prune algorithm;
+// returned descriptor is pinned, locked exclusively and marked evicted, so it is neither pinned nor latched by others;
+// nil is returned if all pages are pinned or locked
 func (m *bufferHeaderManager) victim() *bufferSlotDescriptor {
-	// returns slotId
 	m.modLock.RLock()
 	defer m.modLock.RUnlock()
 	for e := m.hdrs.Back(); e != nil; e = e.Prev() {
 		hdr := e.Value.(*bufferHeader)
-		if hdr.refCtr.Value() == 0 {
-			// pin
-			hdr.refCtr.Incr()
+		if hdr.refCtr.Value() == 0 && m.evict(hdr) {
 			return hdr.desc
 		}
 	}
 	return nil
 }
 
-func (m *bufferHeaderManager) replaceAndElevateSlot(slotId int, pos int64) {
+func (m *bufferHeaderManager) evict(hdr *bufferHeader) bool {
+	desc := hdr.desc
+	desc.pinMux.Lock()
+	defer desc.pinMux.Unlock()
+	if desc.evicted || hdr.refCtr.Value() != 0 || !desc.lock.TryLock(concurrency.ExclusiveMode) {
+		return false
+	}
+	hdr.refCtr.Incr()
+	desc.evicted = true
+	return true
+}
+
+// replaceAndElevateSlot makes header of page described by descriptor; page is pinned
+func (m *bufferHeaderManager) replaceAndElevateSlot(desc *bufferSlotDescriptor) {
 	m.modLock.Lock()
 	defer m.modLock.Unlock()
-	e := m.idx[slotId]
+	e := m.idx[desc.slotId]
 	if e != nil {
 		m.hdrs.Remove(e)
 	}
 	var hdr bufferHeader
-	hdr.desc = &bufferSlotDescriptor{pos, slotId, concurrency.NewLock()}
-	m.idx[slotId] = m.hdrs.PushFront(&hdr)
+	hdr.desc = desc
+	hdr.refCtr.Init(1)
+	m.idx[desc.slotId] = m.hdrs.PushFront(&hdr)
 }
 
 func (m *bufferHeaderManager) elevateSlot(slotId int) {
@@ -3,13 +3,23 @@ package storage
 import (
 	"dbms/internal/core/concurrency"
 	"log"
+	"runtime"
 	"sync"
 )
 
+// bufferSlotDescriptor describes page residing in slot; lock is held shared or exclusively while page is copied
+// or latched (see LatchPage), so page can't be evicted meanwhile; descriptor is marked evicted under pinMux,
+// so page isn't pinned in slot which is reused for another page
 type bufferSlotDescriptor struct {
-	pos    int64
-	slotId int
-	lock   *concurrency.Lock
+	pos     int64
+	slotId  int
+	lock    *concurrency.Lock
+	pinMux  sync.Mutex
+	evicted bool
+}
+
+func newBufferSlotDescriptor(pos int64, slotId int) *bufferSlotDescriptor {
+	return &bufferSlotDescriptor{pos: pos, slotId: slotId, lock: concurrency.NewLock()}
 }
 
 type BufferSlotManager struct {
@@ -19,6 +29,8 @@ type BufferSlotManager struct {
 	slotSize     int
 	storage      *StorageManager
 	posToSlotMap sync.Map
+	// stealHandler journals changed page evicted from buffer and returns block to be written to storage
+	stealHandler func(pos int64, block []byte) []byte
 }
 
 func NewBufferSlotManager(storage *StorageManager, slots int, slotSize int) *BufferSlotManager {
@@ -31,36 +43,63 @@ func NewBufferSlotManager(storage *StorageManager, slots int, slotSize int) *Buf
 	return &m
 }
 
-// TODO: make transaction-safe (pos lock is required at the moment)
+// SetStealHandler sets function which is called before changed page is evicted from buffer,
+// so page is journaled before storage is overwritten by uncommitted changes
+func (m *BufferSlotManager) SetStealHandler(handler func(pos int64, block []byte) []byte) {
+	m.stealHandler = handler
+}
+
+// Fetch reads page to buffer and pins it, so it isn't evicted till Unpin; page evicted before it is pinned
+// is fetched again
 func (m *BufferSlotManager) Fetch(pos int64) {
-	if desc := m.storeOrWaitDesc(pos); desc != nil {
-		return
+	for {
+		desc := m.storeOrWaitDesc(pos)
+		if desc == nil {
+			m.load(pos)
+			return
+		}
+		if m.bufHdrMgr.pin(desc) {
+			return
+		}
+		// wait till evicted page is removed from map
+		runtime.Gosched()
 	}
+}
+
+// load reads page to free or evicted slot; slot is pinned for caller
+func (m *BufferSlotManager) load(pos int64) {
 	slotId := m.bufHdrMgr.allocateSlot()
 	if slotId == -1 {
 		var desc *bufferSlotDescriptor
 		for {
-			desc = m.bufHdrMgr.victim()
-			// TODO: busy waiting here. Better use cond var
-			if desc == nil {
-				continue
-			}
-			slotId = desc.slotId
-			if !desc.lock.TryLock(concurrency.ExclusiveMode) {
-				m.bufHdrMgr.unpin(slotId)
-				continue
+			// victim is pinned and exclusively locked, so it isn't read or latched by others
+			if desc = m.bufHdrMgr.victim(); desc != nil {
+				break
 			}
-			break
+			// TODO: busy waiting here. Better use cond var
+			runtime.Gosched()
+		}
+		slotId = desc.slotId
+		if hdr := m.bufHdrMgr.getHdrBySlotId(slotId); hdr.dirty {
+			m.steal(desc.pos, m.getBlockBySlotId(slotId))
 		}
-		defer desc.lock.Unlock()
-		defer m.bufHdrMgr.unpin(slotId)
 		m.posToSlotMap.Delete(desc.pos)
+		desc.lock.Unlock()
 	}
-	m.bufHdrMgr.replaceAndElevateSlot(slotId, pos)
+	desc := newBufferSlotDescriptor(pos, slotId)
+	m.bufHdrMgr.replaceAndElevateSlot(desc)
 	// read block to slot
 	m.storage.ReadBlock(pos, m.getBlockBySlotId(slotId))
 	// slotId is has exclusive access here and is pinned
-	m.posToSlotMap.Store(pos, &bufferSlotDescriptor{pos, slotId, concurrency.NewLock()})
+	m.posToSlotMap.Store(pos, desc)
+}
+
+// steal writes changed page to storage before its slot is reused
+func (m *BufferSlotManager) steal(pos int64, block []byte) {
+	if m.stealHandler != nil {
+		block = m.stealHandler(pos, block)
+	}
+	m.storage.WriteBlock(pos, block)
 }
 
 func (m *BufferSlotManager) Flush(pos int64) {
@@ -78,30 +117,69 @@ func (m *BufferSlotManager) Deallocate(pos int64) {
 	desc.lock.Lock(concurrency.ExclusiveMode)
 	defer desc.lock.Unlock()
 	if hdr := m.bufHdrMgr.getHdrBySlotId(desc.slotId); hdr.refCtr.Value() != 0 {
+		// page is still pinned by readers, so slot is kept, but its changes are discarded
+		if hdr.dirty {
+			m.storage.ReadBlock(pos, m.getBlockBySlotId(desc.slotId))
+			hdr.dirty = false
+		}
 		return
 	}
 	m.bufHdrMgr.deallocateSlot(desc.slotId)
 	m.posToSlotMap.Delete(desc.pos)
 }
 
-func (m *BufferSlotManager) Pin(pos int64) {
+func (m *BufferSlotManager) Unpin(pos int64) {
 	desc := m.waitNotNilDesc(pos)
-	desc.lock.Lock(concurrency.SharedMode)
-	defer desc.lock.Unlock()
-	m.bufHdrMgr.pin(desc.slotId)
+	m.bufHdrMgr.unpin(desc.slotId)
 }
 
-func (m *BufferSlotManager) Unpin(pos int64) {
+// LatchPage locks pinned page till UnlatchPage, so latched page is read and written by ReadLatchedPageAtPos
+// and WriteLatchedPageAtPos only, while others wait for latch to copy page
+func (m *BufferSlotManager) LatchPage(pos int64, exclusive bool) {
+	m.waitNotNilDesc(pos).lock.Lock(lockMode(exclusive))
+}
+
+// TryLatchPage latches pinned page if it isn't latched in incompatible mode
+func (m *BufferSlotManager) TryLatchPage(pos int64, exclusive bool) bool {
+	return m.waitNotNilDesc(pos).lock.TryLock(lockMode(exclusive))
+}
+
+func (m *BufferSlotManager) UnlatchPage(pos int64) {
+	m.waitNotNilDesc(pos).lock.Unlock()
+}
+
+func lockMode(exclusive bool) int {
+	if exclusive {
+		return concurrency.ExclusiveMode
+	}
+	return concurrency.SharedMode
+}
+
+func (m *BufferSlotManager) ReadPageAtPos(pos int64) *HeapPage {
 	desc := m.waitNotNilDesc(pos)
 	desc.lock.Lock(concurrency.SharedMode)
 	defer desc.lock.Unlock()
-	m.bufHdrMgr.unpin(desc.slotId)
+	return m.readSlot(desc)
 }
 
-func (m *BufferSlotManager) ReadPageAtPos(pos int64) *HeapPage {
+func (m *BufferSlotManager) WritePageAtPos(page *HeapPage, pos int64) {
 	desc := m.waitNotNilDesc(pos)
-	desc.lock.Lock(concurrency.SharedMode)
+	desc.lock.Lock(concurrency.ExclusiveMode)
 	defer desc.lock.Unlock()
+	m.writeSlot(desc, page)
+}
+
+// ReadLatchedPageAtPos reads page latched by caller
+func (m *BufferSlotManager) ReadLatchedPageAtPos(pos int64) *HeapPage {
+	return m.readSlot(m.waitNotNilDesc(pos))
+}
+
+// WriteLatchedPageAtPos writes page latched exclusively by caller
+func (m *BufferSlotManager) WriteLatchedPageAtPos(page *HeapPage, pos int64) {
+	m.writeSlot(m.waitNotNilDesc(pos), page)
+}
+
+func (m *BufferSlotManager) readSlot(desc *bufferSlotDescriptor) *HeapPage {
 	block := m.getBlockBySlotId(desc.slotId)
 	page := new(HeapPage)
 	if unmarshalErr := page.UnmarshalBinary(block); unmarshalErr != nil {
@@ -110,35 +188,16 @@ func (m *BufferSlotManager) ReadPageAtPos(pos int64) *HeapPage {
 	return page
 }
 
-func (m *BufferSlotManager) WritePageAtPos(page *HeapPage, pos int64) {
+func (m *BufferSlotManager) writeSlot(desc *bufferSlotDescriptor, page *HeapPage) {
 	newBlock, marshalErr := page.MarshalBinary()
 	if marshalErr != nil {
 		log.Panic(marshalErr)
 	}
-	desc := m.waitNotNilDesc(pos)
-	desc.lock.Lock(concurrency.ExclusiveMode)
-	defer desc.lock.Unlock()
 	m.bufHdrMgr.getHdrBySlotId(desc.slotId).dirty = true
 	oldBlock := m.getBlockBySlotId(desc.slotId)
 	copy(oldBlock, newBlock)
 }
 
-// ReadPageAtPos modification; returns nil if page is not dirty (for logging purposes)
-func (m *BufferSlotManager) ReadPageIfDirty(pos int64) *HeapPage {
-	desc := m.waitNotNilDesc(pos)
-	desc.lock.Lock(concurrency.SharedMode)
-	defer desc.lock.Unlock()
-	if hdr := m.bufHdrMgr.getHdrBySlotId(desc.slotId); !hdr.dirty {
-		return nil
-	}
-	block := m.getBlockBySlotId(desc.slotId)
-	page := new(HeapPage)
-	if unmarshalErr := page.UnmarshalBinary(block); unmarshalErr != nil {
-		log.Panic(unmarshalErr)
-	}
-	return page
-}
-
 func (m *BufferSlotManager) getBlockBySlotId(slotId int) []byte {
 	pageStart := slotId * m.slotSize
 	pageEnd := pageStart + m.slotSize
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+)
+
+// MetaPagePos is a position of storage meta page;
+// meta page is always the first page of heap file
+const MetaPagePos = 0
+
+// storageMagic marks heap files of dbms ("DBMS" in little endian)
+const storageMagic uint32 = 0x534d4244
+
+// StorageVersion is a version of heap file format; it must be increased on any change of pages layout
+// (page header with lsn, records with flags byte, free space map pages and meta page itself),
+// so storage of another format is refused instead of being misread
+const StorageVersion uint32 = 1
+
+var ErrStorageFormat = errors.New("storage has unsupported format")
+
+// MetaPage anchors storage level structures stored in heap pages;
+// it is modified only via transactions, so changes are journaled as any other page
+type MetaPage struct {
+	// Magic and Version identify format of heap file
+	Magic   uint32
+	Version uint32
+	// PageSize and MaxKeyLength are settings storage is created with; pages and tree nodes layouts depend on them
+	PageSize     int32
+	MaxKeyLength int32
+	// FreeListHead is a position of the first page in free pages list; -1 if list is empty
+	FreeListHead int64
+	// FreeSpaceMapHead is a position of the first free space map page; -1 if map is empty
+	FreeSpaceMapHead int64
+	// FreeSpaceMapHint is a position of the first free space map page which describes page with free space;
+	// -1 if there is no such map page
+	FreeSpaceMapHint int64
+	// TxIdBase is a lower bound of transaction ids of the next run;
+	// ids are stored in records versions, so they must not be reused after restart
+	TxIdBase int64
+}
+
+func NewMetaPage(pageSize int) *MetaPage {
+	m := new(MetaPage)
+	m.Magic = storageMagic
+	m.Version = StorageVersion
+	m.PageSize = int32(pageSize)
+	m.FreeListHead = -1
+	m.FreeSpaceMapHead = -1
+	m.FreeSpaceMapHint = -1
+	return m
+}
+
+// ReadMetaPage extracts meta from heap page
+func ReadMetaPage(page *HeapPage) *MetaPage {
+	m := new(MetaPage)
+	reader := bytes.NewReader(page.ReadData(0))
+	if readErr := binary.Read(reader, binary.LittleEndian, m); readErr != nil {
+		log.Panic(readErr)
+	}
+	return m
+}
+
+// ReadFileMetaPage extracts meta from heap file without knowing its pages layout, so it checks format before
+// any page is read; nil is returned if meta page is not written yet
+func ReadFileMetaPage(file io.ReaderAt) (*MetaPage, error) {
+	// meta is the only record of the first page, so it is found by the first record pointer
+	hdr := make([]byte, heapPageHeaderSize+HeapRecordPointerSize)
+	if n, readErr := file.ReadAt(hdr, MetaPagePos); n == 0 && readErr == io.EOF {
+		return nil, nil
+	} else if readErr != nil {
+		return nil, ErrStorageFormat
+	}
+	var records, recStart int32
+	if readErr := binary.Read(bytes.NewReader(hdr[1:]), binary.LittleEndian, &records); readErr != nil {
+		log.Panic(readErr)
+	}
+	if records == 0 {
+		return nil, nil
+	}
+	if readErr := binary.Read(bytes.NewReader(hdr[heapPageHeaderSize:]), binary.LittleEndian, &recStart); readErr != nil {
+		log.Panic(readErr)
+	}
+	m := new(MetaPage)
+	data := make([]byte, binary.Size(m))
+	if recStart < 0 {
+		return nil, ErrStorageFormat
+	}
+	if _, readErr := file.ReadAt(data, MetaPagePos+heapPageHeaderSize+int64(recStart)); readErr != nil {
+		return nil, ErrStorageFormat
+	}
+	if readErr := binary.Read(bytes.NewReader(data), binary.LittleEndian, m); readErr != nil {
+		log.Panic(readErr)
+	}
+	if m.Magic != storageMagic {
+		return nil, ErrStorageFormat
+	}
+	if m.Version != StorageVersion {
+		return nil, fmt.Errorf("%w: version %d, supported version %d", ErrStorageFormat, m.Version, StorageVersion)
+	}
+	return m, nil
+}
+
+// WriteToPage puts meta into freshly allocated heap page
+func (m *MetaPage) WriteToPage(page *HeapPage) {
+	buf := new(bytes.Buffer)
+	if writeErr := binary.Write(buf, binary.LittleEndian, m); writeErr != nil {
+		log.Panic(writeErr)
+	}
+	page.AppendData(buf.Bytes())
+}
+
+// AllocateFreePage creates page to be linked into free pages list before next one
+func (a *HeapPageAllocator) AllocateFreePage(next int64) *HeapPage {
+	page := a.AllocatePage()
+	page.SetFree(true)
+	buf := new(bytes.Buffer)
+	if writeErr := binary.Write(buf, binary.LittleEndian, next); writeErr != nil {
+		log.Panic(writeErr)
+	}
+	page.AppendData(buf.Bytes())
+	return page
+}
+
+// NextFreePos returns position of the next page in free pages list
+func (p *HeapPage) NextFreePos() int64 {
+	if !p.Free() {
+		log.Panic("page is not free")
+	}
+	var next int64
+	reader := bytes.NewReader(p.ReadData(0))
+	if readErr := binary.Read(reader, binary.LittleEndian, &next); readErr != nil {
+		log.Panic(readErr)
+	}
+	return next
+}
@@ -17,6 +17,16 @@ type heapPageHeader struct {
 	Flags     BitArray
 	records   int32
 	freeSpace int32
+	// lsn is a log sequence number of the last journaled image of page
+	lsn int64
+}
+
+func (ph *heapPageHeader) Lsn() int64 {
+	return ph.lsn
+}
+
+func (ph *heapPageHeader) SetLsn(lsn int64) {
+	ph.lsn = lsn
 }
 
 func (ph *heapPageHeader) Records() int {
@@ -35,9 +45,18 @@ func (ph *heapPageHeader) SetUsed(value bool) {
 	ph.Flags.Set(value, 0)
 }
 
+// Free reports if page is released and linked into free pages list
+func (ph *heapPageHeader) Free() bool {
+	return ph.Flags.Get(1)
+}
+
+func (ph *heapPageHeader) SetFree(value bool) {
+	ph.Flags.Set(value, 1)
+}
+
 const (
-	// uint8 + int32 + int32
-	heapPageHeaderSize = 9
+	// uint8 + int32 + int32 + int64
+	heapPageHeaderSize = 17
 	// int32
 	heapPageChecksumSize = 4
 	// int32
@@ -61,7 +80,8 @@ func (p *HeapPage) MarshalBinary() ([]byte, error) {
 		Flags     BitArray
 		Records   int32
 		FreeSpace int32
-	}{p.heapPageHeader.Flags, p.heapPageHeader.records, p.heapPageHeader.freeSpace}
+		Lsn       int64
+	}{p.heapPageHeader.Flags, p.heapPageHeader.records, p.heapPageHeader.freeSpace, p.heapPageHeader.lsn}
 	if writeErr := binary.Write(buf, binary.LittleEndian, hdr); writeErr != nil {
 		log.Panic(writeErr)
 	}
@@ -89,6 +109,7 @@ func (p *HeapPage) UnmarshalBinary(data []byte) error {
 		Flags     BitArray
 		Records   int32
 		FreeSpace int32
+		Lsn       int64
 	}{}
 	if readErr := binary.Read(buf, binary.LittleEndian, &hdr); readErr != nil {
 		log.Panic(readErr)
@@ -96,6 +117,7 @@ func (p *HeapPage) UnmarshalBinary(data []byte) error {
 	p.heapPageHeader.Flags = hdr.Flags
 	p.heapPageHeader.records = hdr.Records
 	p.heapPageHeader.freeSpace = hdr.FreeSpace
+	p.heapPageHeader.lsn = hdr.Lsn
 	p.Data = buf.Bytes()
 	return nil
 }
@@ -180,6 +202,10 @@ func NewHeapPageAllocator(pageSize int) *HeapPageAllocator {
 	return a
 }
 
+func (a *HeapPageAllocator) PageSize() int {
+	return a.pageSize
+}
+
 func (a *HeapPageAllocator) AllocatePage() *HeapPage {
 	var page HeapPage
 	page.records = 0
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var ErrIncrementBase = errors.New("increment is not based on passed backup")
+
+// CopyChangedTo copies blocks changed after passed lsn to increment file while storage is changed;
+// page lsn is the lsn of its last journaled image, and changes made after checkpoint are journaled after
+// its record, so blocks with older lsn are kept by base backup unless they are never written (zero lsn);
+// increment starts with base lsn and block size, and each block is preceded by its position
+func (m *StorageManager) CopyChangedTo(name string, lsn int64) error {
+	tmpName := name + ".tmp"
+	file, err := os.OpenFile(tmpName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	block := make([]byte, len(m.emptyBlock))
+	if err := binary.Write(w, binary.LittleEndian, []int64{lsn, int64(len(block))}); err != nil {
+		return err
+	}
+	page := new(HeapPage)
+	for pos := int64(0); ; pos += int64(len(block)) {
+		m.fileLock.Lock()
+		end := pos >= m.sizeNoLock()
+		if !end {
+			_, err = m.file.ReadAt(block, pos)
+		}
+		m.fileLock.Unlock()
+		if end {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		// block which can't be decoded is copied too, so restored storage is the same as the original one
+		if unmarshalErr := page.UnmarshalBinary(block); unmarshalErr == nil && page.Lsn() != 0 && page.Lsn() <= lsn {
+			continue
+		}
+		if err := binary.Write(w, binary.LittleEndian, pos); err != nil {
+			return err
+		}
+		if _, err := w.Write(block); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, name)
+}
+
+// ApplyIncrement writes blocks of increment file to storage file; increment must be based on lsn
+// of the backup which storage file is restored from
+func ApplyIncrement(dataName string, name string, baseLsn int64) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+	hdr := make([]int64, 2)
+	if err := binary.Read(r, binary.LittleEndian, hdr); err != nil {
+		return fmt.Errorf("increment %s: %w", name, err)
+	}
+	if hdr[0] != baseLsn {
+		return fmt.Errorf("%w: increment %s is based on lsn %d instead of %d", ErrIncrementBase, name, hdr[0], baseLsn)
+	}
+	dataFile, err := os.OpenFile(dataName, os.O_RDWR, 0666)
+	if err != nil {
+		return err
+	}
+	defer dataFile.Close()
+	block := make([]byte, hdr[1])
+	for {
+		var pos int64
+		if err := binary.Read(r, binary.LittleEndian, &pos); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("increment %s: %w", name, err)
+		}
+		if _, err := io.ReadFull(r, block); err != nil {
+			return fmt.Errorf("increment %s: %w", name, err)
+		}
+		if _, err := dataFile.WriteAt(block, pos); err != nil {
+			return err
+		}
+	}
+	return dataFile.Sync()
+}
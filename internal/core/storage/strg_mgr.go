@@ -66,6 +66,48 @@ func (m *StorageManager) Extend() int64 {
 	return pos
 }
 
+// ExtendTo extends storage with empty blocks, so block at passed position exists
+func (m *StorageManager) ExtendTo(pos int64) {
+	m.fileLock.Lock()
+	defer m.fileLock.Unlock()
+	for size := m.sizeNoLock(); size <= pos; size += int64(len(m.emptyBlock)) {
+		m.writeNoLock(size, m.emptyBlock)
+	}
+}
+
+// CopyTo copies storage to file while it is changed; each block is copied under lock, so blocks aren't torn,
+// but the copy is consistent only together with journal of changes made meanwhile
+func (m *StorageManager) CopyTo(name string) error {
+	tmpName := name + ".tmp"
+	file, err := os.OpenFile(tmpName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	block := make([]byte, len(m.emptyBlock))
+	for pos := int64(0); ; pos += int64(len(block)) {
+		m.fileLock.Lock()
+		end := pos >= m.sizeNoLock()
+		if !end {
+			_, err = m.file.ReadAt(block, pos)
+		}
+		m.fileLock.Unlock()
+		if end {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := file.Write(block); err != nil {
+			return err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, name)
+}
+
 func (m *StorageManager) Flush() {
 	// durability aspect;
 	// ensures all fs caches are flushed on disk
@@ -0,0 +1,64 @@
+package transaction
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var ErrBackupExists = errors.New("backup already exists")
+
+// Backup copies storage and journal which makes the copy consistent while transactions proceed: storage is copied
+// after checkpoint, and journal is copied from checkpoint record up to the end of storage copy, so recovery
+// of backup replays changes made during the copy; checkpoints wait for backup, so copied journal isn't removed
+func (m *TxManager) Backup(dataPath string, logPath string) error {
+	if m.readOnly {
+		// backup makes checkpoint, which journal of replica can't have
+		return ErrReadOnly
+	}
+	m.checkpointMux.Lock()
+	defer m.checkpointMux.Unlock()
+	if err := prepareBackup(dataPath, logPath); err != nil {
+		return err
+	}
+	checkpoint := m.checkpoint()
+	if err := m.strgMgr.CopyTo(dataPath); err != nil {
+		return err
+	}
+	return m.logMgr.CopyTo(logPath, checkpoint)
+}
+
+// IncrementalBackup copies pages changed since checkpoint of base backup, which journal is stored in passed
+// directory, and journal the same way as Backup; base backup may be incremental too, so backups form a chain
+// which is restored by applying their pages in order to storage of the full one
+func (m *TxManager) IncrementalBackup(pagesPath string, logPath string, baseLogPath string) error {
+	if m.readOnly {
+		// backup makes checkpoint, which journal of replica can't have
+		return ErrReadOnly
+	}
+	m.checkpointMux.Lock()
+	defer m.checkpointMux.Unlock()
+	baseLsn, err := m.logMgr.BackupLsn(baseLogPath)
+	if err != nil {
+		return err
+	}
+	if err := prepareBackup(pagesPath, logPath); err != nil {
+		return err
+	}
+	checkpoint := m.checkpoint()
+	if err := m.strgMgr.CopyChangedTo(pagesPath, baseLsn); err != nil {
+		return err
+	}
+	return m.logMgr.CopyTo(logPath, checkpoint)
+}
+
+// prepareBackup creates directory of backup files unless they exist
+func prepareBackup(dataPath string, logPath string) error {
+	for _, p := range []string{dataPath, logPath} {
+		if _, err := os.Stat(p); err == nil {
+			return fmt.Errorf("%w: %s", ErrBackupExists, p)
+		}
+	}
+	return os.MkdirAll(filepath.Dir(dataPath), 0777)
+}
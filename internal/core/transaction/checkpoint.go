@@ -0,0 +1,61 @@
+package transaction
+
+import (
+	"dbms/internal/core/concurrency"
+	"dbms/internal/core/logging"
+	"sort"
+)
+
+// Checkpoint journals active transactions with their keys and flushes pages changed before checkpoint record,
+// so recovery starts from it and the journal before it is removed; checkpoint is fuzzy: transactions aren't
+// finished by it, and they are blocked by exclusive latch only while changed pages are journaled and dirty
+// pages table is taken, but not while pages are flushed
+func (m *TxManager) Checkpoint() {
+	m.checkpointMux.Lock()
+	defer m.checkpointMux.Unlock()
+	m.checkpoint()
+}
+
+// checkpoint returns position of checkpoint record; must be called with checkpointMux held
+func (m *TxManager) checkpoint() logging.LogPos {
+	tx := m.InitTx(concurrency.ExclusiveMode).(*concreteTx)
+	tx.Latch(true)
+	// pages are journaled before flush, so partially flushed storage is repaired by recovery
+	m.journalChangedPages(tx.id)
+	tx.deactivate()
+	m.activeMux.Lock()
+	checkpoint := tx.logMgr.LogCheckpoint(tx.id, m.checkpointTxs())
+	m.activeMux.Unlock()
+	tx.logMgr.Flush()
+	dirty := m.dirtyPages()
+	tx.versions.finish(tx.id, true)
+	tx.Unlatch()
+	tx.release()
+	tx.status = committed
+	m.flushDirtyPages(dirty)
+	tx.strgMgr.Flush()
+	// pages which are journaled again meanwhile aren't flushed, so their images must be durable before
+	// journal is truncated
+	tx.logMgr.Flush()
+	tx.logMgr.Truncate(checkpoint)
+	return checkpoint
+}
+
+// checkpointTxs returns transactions which have to be rolled back or restored after crash;
+// must be called with activeMux held
+func (m *TxManager) checkpointTxs() []logging.CheckpointTx {
+	txs := make([]logging.CheckpointTx, 0, len(m.activeTxs))
+	for id, tx := range m.activeTxs {
+		if len(tx.writtenKeys) == 0 && tx.gid == "" {
+			continue
+		}
+		keys := make([]string, 0, len(tx.writtenKeys))
+		for key := range tx.writtenKeys {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		txs = append(txs, logging.CheckpointTx{TxId: id, Gid: tx.gid, Keys: keys})
+	}
+	sort.Slice(txs, func(i, j int) bool { return txs[i].TxId < txs[j].TxId })
+	return txs
+}
@@ -0,0 +1,106 @@
+package transaction
+
+import (
+	"dbms/internal/core/concurrency"
+	"errors"
+	"log"
+	"sort"
+	"time"
+)
+
+var (
+	// ErrClusterPrepare is returned for prepare in cluster, because prepared changes aren't replicated
+	ErrClusterPrepare = errors.New("transaction can't be prepared in cluster")
+	// ErrApplyStopped is returned if changes aren't applied, because node of cluster stops
+	ErrApplyStopped = errors.New("changes are not applied: node is stopped")
+)
+
+const (
+	// applyRetryMin and applyRetryMax bound pause between attempts to apply changes, which keys are locked
+	// by other transactions (e.g. by leader transaction waiting for consensus)
+	applyRetryMin = time.Millisecond
+	applyRetryMax = 100 * time.Millisecond
+)
+
+// Consensus replicates changes of transactions across cluster before they are committed
+type Consensus interface {
+	// Replicate returns when changes are committed by cluster and transaction may commit them locally;
+	// done must be called after local commit, so changes of the following entries see them; error means
+	// that changes are not committed (or it isn't known), so transaction is rolled back
+	Replicate(changes []KeyChange) (done func(), err error)
+}
+
+// KeyChange is a version of key left by committed transaction
+type KeyChange struct {
+	Key   string
+	Image KeyImage
+}
+
+// SetConsensus makes commits of changes wait for cluster consensus
+func (m *TxManager) SetConsensus(consensus Consensus) {
+	m.consensus = consensus
+}
+
+// replicate passes versions of keys written by transaction to consensus; versions are taken with pages
+// latched, but consensus is awaited without latch, because changes of the preceding entries may be applied
+// meanwhile
+func (tx *concreteTx) replicate() (func(), error) {
+	keys := make([]string, 0, len(tx.writtenKeys))
+	for key := range tx.writtenKeys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	changes := make([]KeyChange, 0, len(keys))
+	tx.Latch(false)
+	for _, key := range keys {
+		// key rolled back to savepoint is not changed
+		if image := tx.undo.Image(tx, key); image != nil {
+			changes = append(changes, KeyChange{Key: key, Image: *image})
+		}
+	}
+	tx.Unlatch()
+	if len(changes) == 0 {
+		return func() {}, nil
+	}
+	return tx.consensus.Replicate(changes)
+}
+
+// ApplyChanges commits changes replicated by consensus; they are applied by exclusive transaction, which is
+// retried with growing pauses if it fails to lock keys (e.g. they are locked by local readers), till stop is closed
+func (m *TxManager) ApplyChanges(changes []KeyChange, stop <-chan struct{}) error {
+	pause := applyRetryMin
+	for !m.applyChanges(changes) {
+		select {
+		case <-stop:
+			return ErrApplyStopped
+		case <-time.After(pause):
+		}
+		if pause *= 2; pause > applyRetryMax {
+			pause = applyRetryMax
+		}
+	}
+	return nil
+}
+
+func (m *TxManager) applyChanges(changes []KeyChange) (applied bool) {
+	tx := m.InitTx(concurrency.ExclusiveMode).(*concreteTx)
+	tx.replicated = true
+	defer func() {
+		if err := recover(); err == concurrency.ErrTxLockTimeout || err == concurrency.ErrDeadlock {
+			tx.Abort()
+			applied = false
+		} else if err != nil {
+			log.Panic(err)
+		}
+	}()
+	// changes are sorted by key, so they are locked in the same order as by concurrent commits
+	for _, change := range changes {
+		tx.LockKey(change.Key, true)
+	}
+	tx.Latch(false)
+	for i := range changes {
+		m.undo.Restore(tx, changes[i].Key, &changes[i].Image)
+	}
+	tx.commit(true)
+	return true
+}
@@ -0,0 +1,146 @@
+package transaction
+
+import (
+	"dbms/internal/core/logging"
+	"math/bits"
+	"sync"
+)
+
+// BatchSizeBuckets is a number of buckets of batch sizes histogram
+const BatchSizeBuckets = 10
+
+// GroupCommitStats describes batches of records flushed by group commit
+type GroupCommitStats struct {
+	Batches  int
+	Records  int
+	MaxBatch int
+	// BatchSizes counts batches by size: bucket i counts batches of 2^(i-1)+1 to 2^i records,
+	// and the last bucket counts larger batches too
+	BatchSizes [BatchSizeBuckets]int
+}
+
+// batchSizeBucket returns bucket of batch sizes histogram which counts batch of passed size
+func batchSizeBucket(size int) int {
+	bucket := bits.Len(uint(size - 1))
+	if bucket >= BatchSizeBuckets {
+		return BatchSizeBuckets - 1
+	}
+	return bucket
+}
+
+// groupRequest is a commit, abort or prepare record waiting for flush
+type groupRequest struct {
+	tx      *concreteTx
+	recType int
+	gid     string
+	// durable means waiter is released after its record is synced
+	durable bool
+	// lead receives false when record is flushed and true when waiter becomes leader of the next batch
+	lead chan bool
+}
+
+// groupCommit collects records of concurrent transactions, so they are journaled and flushed by single
+// leader at once; journal is synced once per batch instead of once per transaction
+type groupCommit struct {
+	mux      sync.Mutex
+	queue    []*groupRequest
+	flushing bool
+	stats    GroupCommitStats
+}
+
+// GroupCommitStats returns number of batches and records flushed since start and histogram of batch sizes
+func (m *TxManager) GroupCommitStats() GroupCommitStats {
+	m.group.mux.Lock()
+	defer m.group.mux.Unlock()
+	return m.group.stats
+}
+
+// SetSyncCommits defines if commits wait for journal sync; otherwise they are synced by Sync, checkpoints,
+// evictions or the next synced commit
+func (m *TxManager) SetSyncCommits(sync bool) {
+	m.syncCommits = sync
+}
+
+// Sync makes journal durable; storage is synced by checkpoints, because commits don't write it
+func (m *TxManager) Sync() {
+	m.logMgr.Flush()
+}
+
+// finishInGroup journals finishing record of transaction and returns when it is written (and synced if required);
+// transaction must be unlatched, because leader latches journal exclusively while it journals batch
+func (m *TxManager) finishInGroup(tx *concreteTx, recType int, gid string, durable bool) {
+	req := &groupRequest{tx: tx, recType: recType, gid: gid, durable: durable, lead: make(chan bool, 1)}
+	g := &m.group
+	g.mux.Lock()
+	g.queue = append(g.queue, req)
+	lead := !g.flushing
+	g.flushing = true
+	g.mux.Unlock()
+	if !lead && !<-req.lead {
+		return
+	}
+	g.mux.Lock()
+	batch := g.queue
+	g.queue = nil
+	g.mux.Unlock()
+	m.flushBatch(batch)
+	g.mux.Lock()
+	g.stats.Batches++
+	g.stats.Records += len(batch)
+	if len(batch) > g.stats.MaxBatch {
+		g.stats.MaxBatch = len(batch)
+	}
+	g.stats.BatchSizes[batchSizeBucket(len(batch))]++
+	// records queued during flush are flushed by the first of their waiters
+	if len(g.queue) != 0 {
+		g.queue[0].lead <- true
+	} else {
+		g.flushing = false
+	}
+	g.mux.Unlock()
+	for _, r := range batch {
+		if r != req {
+			r.lead <- false
+		}
+	}
+}
+
+// flushBatch journals pages changed since the previous batch with records of batch; images are journaled on behalf
+// of the first transaction, so they are applied by recovery at its record, and pages are written to storage
+// by checkpoints and evictions only after their images are synced; journal is synced if any of records
+// requires it (records which don't are synced too then); sync doesn't hold latch, so transactions change pages
+// meanwhile, and their changes are made visible to snapshots only after it, while locks of batch are still held
+func (m *TxManager) flushBatch(batch []*groupRequest) {
+	durable := false
+	m.journalLatch.Lock()
+	m.journalChangedPages(batch[0].tx.id)
+	for _, r := range batch {
+		durable = durable || r.durable
+		switch r.recType {
+		case logging.CommitRecord:
+			m.logMgr.LogCommit(r.tx.id)
+			r.tx.deactivate()
+		case logging.AbortRecord:
+			m.logMgr.LogAbort(r.tx.id)
+			r.tx.deactivate()
+		case logging.PrepareRecord:
+			r.tx.gid = r.gid
+			m.logMgr.LogPrepare(r.tx.id, r.gid)
+		}
+	}
+	if m.replicaAcks != nil {
+		lsn := m.logMgr.LastLsn()
+		for _, r := range batch {
+			r.tx.finishLsn = lsn
+		}
+	}
+	m.journalLatch.Unlock()
+	if durable {
+		m.logMgr.Flush()
+	}
+	for _, r := range batch {
+		if r.recType != logging.PrepareRecord {
+			m.versions.finish(r.tx.id, r.recType == logging.CommitRecord)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package transaction
+
+import (
+	"dbms/internal/core/logging"
+	"log"
+)
+
+// journalChangedPages logs images of pages changed since their last journaled images on behalf of passed
+// transaction; must be called with exclusive latch held; recovery applies images together with pages evicted
+// since the previous batch at the next record of transaction, so storage keeps committed images of evicted pages
+// since then; buffered pages keep lsn of their images, so it is written to storage with them
+func (m *TxManager) journalChangedPages(txId int) {
+	m.pagesMux.Lock()
+	defer m.pagesMux.Unlock()
+	for pos := range m.stolen {
+		delete(m.journaled, pos)
+	}
+	m.stolen = make(map[int64]struct{})
+	for pos := range m.unjournaled {
+		page := m.bufSlotMgr.ReadPageAtPos(pos)
+		m.journaled[pos] = m.logMgr.LogSnapshot(txId, pos, func(lsn int64) []byte {
+			page.SetLsn(lsn)
+			snapshot, err := page.MarshalBinary()
+			if err != nil {
+				log.Panic(err)
+			}
+			return snapshot
+		})
+		m.bufSlotMgr.WritePageAtPos(page, pos)
+	}
+	m.unjournaled = make(map[int64]struct{})
+}
+
+// dirtyPages returns dirty pages table: positions of pages journaled since the last checkpoint, but not written
+// to storage yet, with their last journaled images; must be called with exclusive latch held
+func (m *TxManager) dirtyPages() map[int64]logging.LogPos {
+	m.pagesMux.Lock()
+	defer m.pagesMux.Unlock()
+	dirty := make(map[int64]logging.LogPos, len(m.journaled))
+	for pos, rec := range m.journaled {
+		dirty[pos] = rec
+	}
+	return dirty
+}
+
+// flushDirtyPages writes journaled images of dirty pages table to storage without latch; page is skipped
+// if it is evicted or journaled again since the table is taken, because its later image is written by eviction
+// or is journaled after checkpoint record then
+func (m *TxManager) flushDirtyPages(dirty map[int64]logging.LogPos) {
+	for pos, rec := range dirty {
+		m.pagesMux.Lock()
+		_, stolen := m.stolen[pos]
+		if last, found := m.journaled[pos]; found && last == rec && !stolen {
+			m.strgMgr.WriteBlock(pos, m.logMgr.ReadRecord(rec).Snapshot)
+			delete(m.journaled, pos)
+		}
+		m.pagesMux.Unlock()
+	}
+}
+
+// flushUnjournaledPages writes pages changed since their last journaled images to storage; it is used by replay,
+// which writes journaled images, so they are not journaled again
+func (m *TxManager) flushUnjournaledPages() {
+	m.pagesMux.Lock()
+	defer m.pagesMux.Unlock()
+	for pos := range m.unjournaled {
+		m.bufSlotMgr.Flush(pos)
+		delete(m.journaled, pos)
+	}
+	m.unjournaled = make(map[int64]struct{})
+}
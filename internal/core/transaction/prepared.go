@@ -0,0 +1,96 @@
+package transaction
+
+import (
+	"dbms/internal/core/concurrency"
+	"dbms/internal/core/logging"
+	"errors"
+	"log"
+	"sort"
+)
+
+var (
+	ErrPreparedExists   = errors.New("prepared transaction with the same id already exists")
+	ErrPreparedNotFound = errors.New("prepared transaction not found")
+	ErrSnapshotPrepare  = errors.New("snapshot transaction can't be prepared")
+)
+
+// Prepare journals all changed pages and prepare record; locks are kept till commit or rollback by coordinator
+func (tx *concreteTx) Prepare(gid string) error {
+	tx.validateTxStatus()
+	if tx.snapshot() {
+		return ErrSnapshotPrepare
+	}
+	if tx.readOnly {
+		return ErrReadOnly
+	}
+	if tx.consensus != nil {
+		return ErrClusterPrepare
+	}
+	tx.preparedMux.Lock()
+	defer tx.preparedMux.Unlock()
+	if _, found := tx.preparedTxs[gid]; found {
+		return ErrPreparedExists
+	}
+	tx.Unlatch()
+	// coordinator relies on prepared changes, so they are synced regardless of durability mode
+	tx.finishInGroup(tx, logging.PrepareRecord, gid, true)
+	tx.preparedTxs[gid] = tx
+	tx.status = prepared
+	return nil
+}
+
+// RestorePrepared recreates transaction prepared before restart; its keys are locked and journaled again
+// with prepare record
+func (m *TxManager) RestorePrepared(id int, gid string, keys []string) {
+	tx := m.InitTxWithId(id, concurrency.ExclusiveMode)
+	for _, key := range keys {
+		tx.LockKey(key, true)
+	}
+	if err := tx.Prepare(gid); err != nil {
+		log.Panic(err)
+	}
+}
+
+// takePrepared unregisters prepared transaction, so it can be finished
+func (m *TxManager) takePrepared(gid string) (*concreteTx, error) {
+	m.preparedMux.Lock()
+	defer m.preparedMux.Unlock()
+	tx, found := m.preparedTxs[gid]
+	if !found {
+		return nil, ErrPreparedNotFound
+	}
+	delete(m.preparedTxs, gid)
+	// rollback reads and writes pages as processing transaction
+	tx.status = processing
+	return tx, nil
+}
+
+func (m *TxManager) CommitPrepared(gid string) error {
+	tx, err := m.takePrepared(gid)
+	if err != nil {
+		return err
+	}
+	tx.Commit()
+	return tx.ReplicationErr()
+}
+
+func (m *TxManager) RollbackPrepared(gid string) error {
+	tx, err := m.takePrepared(gid)
+	if err != nil {
+		return err
+	}
+	tx.Abort()
+	return nil
+}
+
+// Prepared returns global ids of in-doubt transactions in ascending order
+func (m *TxManager) Prepared() []string {
+	m.preparedMux.Lock()
+	defer m.preparedMux.Unlock()
+	gids := make([]string, 0, len(m.preparedTxs))
+	for gid := range m.preparedTxs {
+		gids = append(gids, gid)
+	}
+	sort.Strings(gids)
+	return gids
+}
@@ -0,0 +1,28 @@
+package transaction
+
+// ReplicaAcks is a synchronous replication policy of primary: commits wait till replicas acknowledge their records
+type ReplicaAcks interface {
+	// WaitAcks returns when journal records up to passed lsn are durably received by enough replicas;
+	// error means that commit isn't acknowledged by them in time
+	WaitAcks(lsn int64) error
+}
+
+// SetReplicaAcks makes commits which wait for journal sync wait for replicas acknowledgements too
+func (m *TxManager) SetReplicaAcks(acks ReplicaAcks) {
+	m.replicaAcks = acks
+}
+
+// ReplicationErr returns error of synchronous replication of committed transaction; transaction is committed
+// on primary anyway, but its changes may be lost if primary is lost before replicas receive them; in cluster
+// it returns error of consensus, and transaction is rolled back then
+func (tx *concreteTx) ReplicationErr() error {
+	return tx.replicationErr
+}
+
+// waitReplicas waits for acknowledgements of records journaled by transaction; transaction which changed
+// nothing has nothing to lose, so it doesn't wait
+func (tx *concreteTx) waitReplicas() {
+	if tx.replicaAcks != nil && len(tx.writtenKeys) != 0 {
+		tx.replicationErr = tx.replicaAcks.WaitAcks(tx.finishLsn)
+	}
+}
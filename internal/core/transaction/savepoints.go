@@ -0,0 +1,95 @@
+package transaction
+
+import "errors"
+
+var (
+	ErrSavepointNotFound = errors.New("savepoint not found")
+)
+
+// savepoint keeps images of keys which are changed after it; image is taken before the first change
+type savepoint struct {
+	name   string
+	images map[string]*KeyImage
+}
+
+func newSavepoint(name string) *savepoint {
+	sp := new(savepoint)
+	sp.name = name
+	sp.images = make(map[string]*KeyImage)
+	return sp
+}
+
+// saveImage takes image of key before its first change after the latest savepoint
+func (tx *concreteTx) saveImage(key string) {
+	if len(tx.savepoints) == 0 || tx.snapshot() {
+		return
+	}
+	sp := tx.savepoints[len(tx.savepoints)-1]
+	if _, found := sp.images[key]; found {
+		return
+	}
+	tx.Latch(false)
+	sp.images[key] = tx.undo.Image(tx, key)
+	tx.Unlatch()
+}
+
+func (tx *concreteTx) Savepoint(name string) {
+	tx.validateTxStatus()
+	tx.savepoints = append(tx.savepoints, newSavepoint(name))
+}
+
+// find returns index of the latest savepoint with passed name
+func (tx *concreteTx) find(name string) int {
+	for i := len(tx.savepoints) - 1; i >= 0; i-- {
+		if tx.savepoints[i].name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (tx *concreteTx) RollbackTo(name string) error {
+	tx.validateTxStatus()
+	idx := tx.find(name)
+	if idx == -1 {
+		return ErrSavepointNotFound
+	}
+	rolledBack := tx.savepoints[idx:]
+	// images are locked before pages are latched; they are write locked already,
+	// but locks of shared transaction are downgraded at the end of command
+	for _, sp := range rolledBack {
+		for key := range sp.images {
+			tx.lockKey(key, true)
+		}
+	}
+	tx.Latch(false)
+	// images of older savepoints are restored last, so they win
+	for i := len(rolledBack) - 1; i >= 0; i-- {
+		for key, image := range rolledBack[i].images {
+			tx.undo.Restore(tx, key, image)
+		}
+	}
+	tx.savepoints = append(tx.savepoints[:idx], newSavepoint(name))
+	return nil
+}
+
+func (tx *concreteTx) Release(name string) error {
+	tx.validateTxStatus()
+	idx := tx.find(name)
+	if idx == -1 {
+		return ErrSavepointNotFound
+	}
+	if idx != 0 {
+		// outer savepoint takes images of keys which it hasn't changed itself yet
+		outer := tx.savepoints[idx-1]
+		for _, sp := range tx.savepoints[idx:] {
+			for key, image := range sp.images {
+				if _, found := outer.images[key]; !found {
+					outer.images[key] = image
+				}
+			}
+		}
+	}
+	tx.savepoints = tx.savepoints[:idx]
+	return nil
+}
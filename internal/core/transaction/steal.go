@@ -0,0 +1,62 @@
+package transaction
+
+import (
+	"dbms/internal/core/storage"
+	"log"
+)
+
+// steal journals changed page evicted from buffer and returns its block with lsn of steal record;
+// the last committed image of page is journaled as before image, so recovery restores it unless commit, abort,
+// prepare or checkpoint record follows; page which isn't changed since its last journaled image is written
+// as is, because that image is already journaled
+func (m *TxManager) steal(pos int64, block []byte) []byte {
+	m.pagesMux.Lock()
+	defer m.pagesMux.Unlock()
+	_, changed := m.unjournaled[pos]
+	delete(m.unjournaled, pos)
+	if !m.journalSteals {
+		return block
+	}
+	page := new(storage.HeapPage)
+	if err := page.UnmarshalBinary(block); err != nil {
+		log.Panic(err)
+	}
+	if !changed {
+		// write-ahead: journaled image must be durable before it overwrites storage
+		m.logMgr.FlushTo(page.Lsn())
+		delete(m.journaled, pos)
+		return block
+	}
+	before := m.committedImage(pos)
+	var after []byte
+	m.logMgr.LogSteal(pos, before, func(lsn int64) []byte {
+		page.SetLsn(lsn)
+		snapshot, err := page.MarshalBinary()
+		if err != nil {
+			log.Panic(err)
+		}
+		after = snapshot
+		return snapshot
+	})
+	// write-ahead: storage is overwritten only after its image is durable
+	m.logMgr.Flush()
+	m.stolen[pos] = struct{}{}
+	return after
+}
+
+// committedImage returns image of page as of the last journaled batch; it is the last journaled image of page
+// unless page is flushed since then; must be called with pagesMux held
+func (m *TxManager) committedImage(pos int64) []byte {
+	if rec, found := m.journaled[pos]; found {
+		return m.logMgr.ReadRecord(rec).Snapshot
+	}
+	block := make([]byte, m.a.PageSize())
+	m.strgMgr.ReadBlock(pos, block)
+	return block
+}
+
+// SetJournalSteals defines if evicted pages are journaled; recovery doesn't journal them while journal
+// is replayed, because replay is repeatable and journal must not grow under its iterator
+func (m *TxManager) SetJournalSteals(journal bool) {
+	m.journalSteals = journal
+}
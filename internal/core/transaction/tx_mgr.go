@@ -5,34 +5,113 @@ import (
 	"dbms/internal/core/concurrency"
 	"dbms/internal/core/logging"
 	"dbms/internal/core/storage"
+	"errors"
 	"log"
 	"sync"
+	"time"
 )
 
 type DataCommands interface {
 	// props
 	NoDataFound() bool
+	PageSize() int
 	// methods
 	AllocatePage() *storage.HeapPage
 	ReadPageAtPos(pos int64) *storage.HeapPage
 	WritePageAtPos(page *storage.HeapPage, pos int64)
 	WritePage(page *storage.HeapPage) int64
+	ReleasePage(pos int64)
+	ReadMetaPage() *storage.MetaPage
+	WriteMetaPage(meta *storage.MetaPage)
 }
 
 type ConcurrencyControlCommands interface {
+	// LockKey locks key till the end of transaction; it must be called before command accesses pages,
+	// because waiting for lock with latch held blocks other commands
+	LockKey(key string, write bool)
+	// TryLockKey locks key for reading without waiting, so it can be called with latch held
+	TryLockKey(key string) bool
+	// Latch starts command over pages till the end of command; commands hold shared latch and run concurrently,
+	// so they latch pages they change, while exclusive latch waits for running commands and stops new ones,
+	// e.g. to apply journaled images; shared latch is taken on the first access to pages by default
+	Latch(exclusive bool)
+	// Unlatch releases latch and latched pages in the middle of command, e.g. to wait for key lock
+	Unlatch()
+	// LatchPage latches page of record accessed by command till UnlatchPages or the end of command, so concurrent
+	// commands don't change page meanwhile; latches are taken in fixed order: key locks, latch, page of record,
+	// tree latch, meta latch; command waits for page latch with no other pages latched, and it latches other pages
+	// (e.g. page which record is moved to) by TryLatchPage only, so waits for page latches don't make cycles
+	LatchPage(pos int64, exclusive bool)
+	// TryLatchPage latches page without waiting; page latched by transaction already is latched again
+	// unless shared latch is upgraded
+	TryLatchPage(pos int64, exclusive bool) bool
+	// UnlatchPages releases latched pages
+	UnlatchPages()
+	// LatchTree protects index tree till UnlatchTree; searches latch it in shared mode, while inserts and deletes,
+	// which split and merge nodes, latch it exclusively
+	LatchTree(exclusive bool)
+	UnlatchTree()
+	// LatchMeta protects meta page and pages chains anchored at it (free pages list and free space map)
+	// till UnlatchMeta; calls may be nested
+	LatchMeta()
+	UnlatchMeta()
+	// DowngradeLocks ends command: latch is released and write locks of shared transaction are downgraded
 	DowngradeLocks()
+	// SetLockTimeout bounds time which transaction waits for each lock
+	SetLockTimeout(timeout time.Duration)
+}
+
+type VersionCommands interface {
+	// Visible checks if record version created by passed transaction is visible to this one
+	Visible(creatorId int) bool
+	// Obsolete checks if record version is visible to all transactions, so older versions can be removed
+	Obsolete(creatorId int) bool
+	// AddGarbage reports key with old versions; it is passed to vacuum after commit
+	AddGarbage(key string)
 }
 
 type TxCommands interface {
+	// MarkWritten registers key changed by transaction before crash, so it is rolled back by Abort
+	MarkWritten(key string)
+	// Savepoint marks state which can be restored by RollbackTo; savepoint with the same name hides the older one
+	Savepoint(name string)
+	// RollbackTo undoes changes made after savepoint; savepoint itself is kept
+	RollbackTo(name string) error
+	// Release removes savepoint and all savepoints made after it keeping changes
+	Release(name string) error
+	// Prepare makes transaction changes durable without commit; transaction is detached from its owner
+	// and is finished by TxManager.CommitPrepared or TxManager.RollbackPrepared with the same global id
+	Prepare(gid string) error
 	CommitNoLog()
 	Commit()
+	// CommitNoWait commits without waiting for journal sync, so commit may be lost by crash till the next sync
+	CommitNoWait()
+	// ReplicationErr returns error of synchronous replication of committed transaction; in cluster
+	// transaction is rolled back if its changes aren't committed by cluster
+	ReplicationErr() error
 	Abort()
 }
 
+// KeyImage is a version of key made by transaction itself
+type KeyImage struct {
+	Value   []byte
+	Deleted bool
+}
+
+type Undo interface {
+	// Rollback removes changes of keys made by transaction
+	Rollback(tx Tx, keys []string)
+	// Image returns version of key made by transaction; nil means transaction hasn't changed key
+	Image(tx Tx, key string) *KeyImage
+	// Restore returns key to image taken before; nil image removes changes of key made by transaction
+	Restore(tx Tx, key string, image *KeyImage)
+}
+
 type Tx interface {
 	Id() int
 	DataCommands
 	ConcurrencyControlCommands
+	VersionCommands
 	TxCommands
 }
 
@@ -43,14 +122,57 @@ type TxManager struct {
 	logMgr          *logging.LogManager
 	sharedLockTable *concurrency.LockTable
 	a               *storage.HeapPageAllocator
+	// lockTimeout is a default lock timeout of transactions
+	lockTimeout time.Duration
+	versions    *versionRegistry
+	// journalLatch keeps pages consistent for journal: commands hold it in shared mode and run concurrently,
+	// while changed pages are journaled or journaled images are applied with it held exclusively
+	journalLatch sync.RWMutex
+	// treeLatch protects index tree from concurrent structure changes (see LatchTree)
+	treeLatch sync.RWMutex
+	// metaLatch protects meta page, free pages list and free space map from concurrent allocations (see LatchMeta)
+	metaLatch  sync.Mutex
+	garbageMux sync.Mutex
+	garbage    map[string]struct{}
+	undo       Undo
+	// prepared maps global transaction id to prepared transaction
+	preparedTxs map[string]*concreteTx
+	preparedMux sync.Mutex
+	// activeTxs maps id to unfinished transaction which may change pages; they are journaled by checkpoints
+	activeTxs map[int]*concreteTx
+	activeMux sync.Mutex
+	group     groupCommit
+	// syncCommits defines if commits wait for journal sync
+	syncCommits bool
+	// unjournaled is a set of buffered pages changed since their last journaled images
+	unjournaled map[int64]struct{}
+	// journaled maps position of page changed since the last checkpoint to its last journaled image
+	journaled map[int64]logging.LogPos
+	// stolen is a set of pages evicted since the last journaled batch
+	stolen   map[int64]struct{}
+	pagesMux sync.Mutex
+	// journalSteals defines if evicted pages are journaled
+	journalSteals bool
+	// checkpointMux serializes checkpoints and backups, which copy journal after their checkpoint
+	checkpointMux sync.Mutex
+	// readOnly defines if transactions started by InitTx only read pages (see SetReadOnly)
+	readOnly bool
+	// replicaAcks is nil unless replication is synchronous
+	replicaAcks ReplicaAcks
+	// consensus is nil unless server is a node of cluster
+	consensus Consensus
 }
 
+// ErrReadOnly is returned for changes requested from read-only server
+var ErrReadOnly = errors.New("server is read-only")
+
 func NewTxManager(
 	strgMgr *storage.StorageManager,
 	bufSlotMgr *storage.BufferSlotManager,
 	logMgr *logging.LogManager,
 	sharedLockTable *concurrency.LockTable,
 	a *storage.HeapPageAllocator,
+	lockTimeout time.Duration,
 ) *TxManager {
 	txMgr := new(TxManager)
 	txMgr.strgMgr = strgMgr
@@ -58,135 +180,574 @@ func NewTxManager(
 	txMgr.logMgr = logMgr
 	txMgr.sharedLockTable = sharedLockTable
 	txMgr.a = a
+	txMgr.lockTimeout = lockTimeout
+	txMgr.versions = newVersionRegistry()
+	txMgr.garbage = make(map[string]struct{})
+	txMgr.preparedTxs = make(map[string]*concreteTx)
+	txMgr.activeTxs = make(map[int]*concreteTx)
+	txMgr.syncCommits = true
+	txMgr.unjournaled = make(map[int64]struct{})
+	txMgr.journaled = make(map[int64]logging.LogPos)
+	txMgr.stolen = make(map[int64]struct{})
+	txMgr.journalSteals = true
+	bufSlotMgr.SetStealHandler(txMgr.steal)
 	return txMgr
 }
 
+// ExtendStorage makes page at passed position exist; it is used by recovery, because storage restored
+// from base backup may be shorter than journal
+func (m *TxManager) ExtendStorage(pos int64) {
+	m.strgMgr.ExtendTo(pos)
+}
+
+// SetUndo sets keys rollback procedures; they are implemented by access layer, which knows records layout
+func (m *TxManager) SetUndo(undo Undo) {
+	m.undo = undo
+}
+
+// SetReadOnly makes transactions started by InitTx read-only; it is used by replica, which journal repeats
+// the primary one, so they journal nothing; their ids are negative, so they don't clash with ids
+// of replayed transactions, which are assigned by primary; they see records versions as snapshots do,
+// because replayed transactions don't lock keys on replica, so changes are visible after their commit records only
+func (m *TxManager) SetReadOnly(readOnly bool) {
+	m.readOnly = readOnly
+}
+
 func (m *TxManager) SetIdCounter(idCounter int) {
 	m.idCtr.Init(idCounter)
 }
 
 func (m *TxManager) InitTx(lockMode int) Tx {
+	if m.readOnly {
+		tx := m.initTx(-m.idCtr.Incr(), lockMode, true)
+		tx.readOnly = true
+		return tx
+	}
 	return m.InitTxWithId(m.idCtr.Incr(), lockMode)
 }
 
 func (m *TxManager) InitTxWithId(id int, lockMode int) Tx {
+	return m.initTx(id, lockMode, lockMode == concurrency.SnapshotMode)
+}
+
+// initTx starts transaction which sees records versions as of its start if snapshot is set
+func (m *TxManager) initTx(id int, lockMode int, snapshot bool) *concreteTx {
 	tx := new(concreteTx)
 	tx.id = id
 	tx.lockMode = lockMode
+	tx.lockTimeout = m.lockTimeout
 	tx.TxManager = m
+	tx.lockedKeys = make(map[string]struct{})
+	tx.writtenKeys = make(map[string]struct{})
+	tx.garbage = make(map[string]struct{})
+	tx.latchedPages = make(map[int64]bool)
+	m.versions.begin(id, snapshot)
+	if !tx.snapshot() {
+		m.activeMux.Lock()
+		m.activeTxs[id] = tx
+		m.activeMux.Unlock()
+	}
 	return tx
 }
 
+// BeginReplayed makes records versions of replayed transaction invisible to snapshots till FinishReplayed;
+// replay calls it before changes of transaction are applied, i.e. for its key records and for checkpoint
+// records listing it
+func (m *TxManager) BeginReplayed(id int) {
+	m.versions.begin(id, false)
+}
+
+// FinishReplayed makes records versions of replayed transaction visible to snapshots started since then
+// if it is committed; replay calls it for commit and abort records after pages are applied
+func (m *TxManager) FinishReplayed(id int, committed bool) {
+	m.versions.finish(id, committed)
+}
+
+// idsPerRun bounds the number of transactions started by single run of server
+const idsPerRun = 1 << 32
+
+// RestoreIdCounter continues transaction ids sequence of the previous runs and reserves ids for the current one;
+// it is called after recovery, so ids of journaled transactions are not reused too
+func (m *TxManager) RestoreIdCounter() {
+	tx := m.InitTx(concurrency.ExclusiveMode)
+	tx.LatchMeta()
+	meta := tx.ReadMetaPage()
+	if base := int(meta.TxIdBase); base > m.idCtr.Value() {
+		m.idCtr.Init(base)
+	}
+	meta.TxIdBase = int64(m.idCtr.Value() + idsPerRun)
+	tx.WriteMetaPage(meta)
+	tx.UnlatchMeta()
+	tx.Commit()
+}
+
+// TakeGarbage returns keys reported by committed transactions since the previous call
+func (m *TxManager) TakeGarbage() []string {
+	m.garbageMux.Lock()
+	defer m.garbageMux.Unlock()
+	keys := make([]string, 0, len(m.garbage))
+	for key := range m.garbage {
+		keys = append(keys, key)
+	}
+	m.garbage = make(map[string]struct{})
+	return keys
+}
+
+func (m *TxManager) addGarbage(keys map[string]struct{}) {
+	m.garbageMux.Lock()
+	defer m.garbageMux.Unlock()
+	for key := range keys {
+		m.garbage[key] = struct{}{}
+	}
+}
+
 const (
 	processing = 0
 	committed  = 1
 	aborted    = 2
+	prepared   = 3
+)
+
+const (
+	unlatched      = 0
+	sharedLatch    = 1
+	exclusiveLatch = 2
 )
 
 type concreteTx struct {
 	*TxManager
-	id       int
-	lockMode int
-	status   int
-	// lockedPages is a set of pages positions
-	// TODO: use regular map
-	lockedPages sync.Map
+	id          int
+	lockMode    int
+	lockTimeout time.Duration
+	status      int
+	lockedKeys  map[string]struct{}
+	// writtenKeys is a set of keys changed by transaction; they are rolled back on abort
+	writtenKeys map[string]struct{}
+	latchMode   int
+	// latchedPages maps position of page latched by command to latch mode (true for exclusive one)
+	latchedPages map[int64]bool
+	// releasedPages are latched pages released by command; they are linked into free pages list when unlatched
+	releasedPages []int64
+	treeLatchMode int
+	// metaLatches is a number of nested meta latches
+	metaLatches int
+	garbage     map[string]struct{}
+	savepoints  []*savepoint
+	// gid is a global id of prepared transaction
+	gid string
+	// readOnly is set for transactions of read-only server
+	readOnly bool
+	// finishLsn is an lsn of the last record of batch which journals commit of transaction
+	finishLsn      int64
+	replicationErr error
+	// replicated is set for transactions which apply changes committed by cluster
+	replicated bool
 }
 
 func (t *concreteTx) Id() int {
 	return t.id
 }
 
+func (tx *concreteTx) snapshot() bool {
+	return tx.lockMode == concurrency.SnapshotMode
+}
+
 func (tx *concreteTx) validateTxStatus() {
 	if tx.status != processing {
 		log.Panic("transaction processing finished")
 	}
 }
 
-func (tx *concreteTx) fetchAndLockPage(pos int64) {
-	if _, found := tx.lockedPages.Load(pos); found {
-		tx.sharedLockTable.UpgradeLock(pos, tx.id)
+func (tx *concreteTx) validateWritable() {
+	if tx.snapshot() {
+		log.Panic("snapshot transaction is read-only")
+	}
+	if tx.readOnly {
+		log.Panic(ErrReadOnly)
+	}
+}
+
+func (tx *concreteTx) LockKey(key string, write bool) {
+	tx.lockKey(key, write)
+	if write {
+		tx.saveImage(key)
+	}
+}
+
+func (tx *concreteTx) lockKey(key string, write bool) {
+	tx.validateTxStatus()
+	if tx.snapshot() {
+		// snapshot transaction selects visible records versions by itself
+		return
+	}
+	if tx.latchMode != unlatched {
+		log.Panic("key is locked with latch held")
+	}
+	if _, found := tx.lockedKeys[key]; !found {
+		tx.sharedLockTable.Lock(key, tx.id, tx.lockMode, tx.lockTimeout)
+		tx.lockedKeys[key] = struct{}{}
+	}
+	if !write {
+		return
+	}
+	tx.sharedLockTable.UpgradeLock(key, tx.id, tx.lockTimeout)
+	if _, found := tx.writtenKeys[key]; !found {
+		// key is journaled before its changes, so they can be rolled back after crash;
+		// checkpoint sees either key in written keys or key record after checkpoint record
+		tx.activeMux.Lock()
+		tx.writtenKeys[key] = struct{}{}
+		tx.logMgr.LogKey(tx.id, key)
+		tx.activeMux.Unlock()
+	}
+}
+
+func (tx *concreteTx) TryLockKey(key string) bool {
+	tx.validateTxStatus()
+	if _, found := tx.lockedKeys[key]; found || tx.snapshot() {
+		return true
+	}
+	if !tx.sharedLockTable.TryLock(key, tx.id, tx.lockMode) {
+		return false
+	}
+	tx.lockedKeys[key] = struct{}{}
+	return true
+}
+
+func (tx *concreteTx) Latch(exclusive bool) {
+	if tx.latchMode == exclusiveLatch || (tx.latchMode == sharedLatch && !exclusive) {
+		return
+	}
+	if tx.latchMode == sharedLatch {
+		log.Panic("shared latch can't be upgraded")
+	}
+	if exclusive && !tx.snapshot() {
+		tx.journalLatch.Lock()
+		tx.latchMode = exclusiveLatch
+		return
+	}
+	tx.journalLatch.RLock()
+	tx.latchMode = sharedLatch
+}
+
+func (tx *concreteTx) Unlatch() {
+	tx.UnlatchPages()
+	switch tx.latchMode {
+	case sharedLatch:
+		tx.journalLatch.RUnlock()
+	case exclusiveLatch:
+		tx.journalLatch.Unlock()
+	}
+	tx.latchMode = unlatched
+}
+
+func (tx *concreteTx) LatchPage(pos int64, exclusive bool) {
+	if len(tx.latchedPages) != 0 {
+		log.Panic("page is latched with other pages latched")
+	}
+	tx.fetchAndPinPage(pos)
+	tx.bufSlotMgr.LatchPage(pos, exclusive)
+	tx.latchedPages[pos] = exclusive
+}
+
+func (tx *concreteTx) TryLatchPage(pos int64, exclusive bool) bool {
+	if latchedExclusive, found := tx.latchedPages[pos]; found {
+		return latchedExclusive || !exclusive
+	}
+	tx.fetchAndPinPage(pos)
+	if !tx.bufSlotMgr.TryLatchPage(pos, exclusive) {
+		tx.bufSlotMgr.Unpin(pos)
+		return false
+	}
+	tx.latchedPages[pos] = exclusive
+	return true
+}
+
+// UnlatchPages links pages released by command into free pages list before they are unlatched, so index
+// doesn't refer to them when they are reused
+func (tx *concreteTx) UnlatchPages() {
+	for _, pos := range tx.releasedPages {
+		tx.linkFreePage(pos)
+	}
+	tx.releasedPages = nil
+	for pos := range tx.latchedPages {
+		// latched page stays pinned till it is unlatched
+		tx.bufSlotMgr.UnlatchPage(pos)
+		tx.bufSlotMgr.Unpin(pos)
+	}
+	tx.latchedPages = make(map[int64]bool)
+}
+
+func (tx *concreteTx) LatchTree(exclusive bool) {
+	tx.latchJournal()
+	if exclusive {
+		tx.treeLatch.Lock()
+		tx.treeLatchMode = exclusiveLatch
 		return
 	}
+	tx.treeLatch.RLock()
+	tx.treeLatchMode = sharedLatch
+}
+
+func (tx *concreteTx) UnlatchTree() {
+	switch tx.treeLatchMode {
+	case sharedLatch:
+		tx.treeLatch.RUnlock()
+	case exclusiveLatch:
+		tx.treeLatch.Unlock()
+	}
+	tx.treeLatchMode = unlatched
+}
+
+func (tx *concreteTx) LatchMeta() {
+	if tx.metaLatches == 0 {
+		tx.latchJournal()
+		tx.metaLatch.Lock()
+	}
+	tx.metaLatches++
+}
+
+func (tx *concreteTx) UnlatchMeta() {
+	if tx.metaLatches--; tx.metaLatches == 0 {
+		tx.metaLatch.Unlock()
+	}
+}
+
+// fetchAndPinPage fetches page to buffer and pins it; page is pinned by single access or till it is unlatched only,
+// so pages changed by transaction may be evicted and transaction size isn't bounded by buffer capacity
+func (tx *concreteTx) fetchAndPinPage(pos int64) {
+	tx.latchJournal()
 	tx.bufSlotMgr.Fetch(pos)
-	tx.bufSlotMgr.Pin(pos)
-	tx.sharedLockTable.Lock(pos, tx.lockMode)
-	tx.lockedPages.Store(pos, struct{}{})
+}
+
+// latchJournal latches journal shared unless it is latched already; journal is latched before other latches, else
+// command holding them would wait for checkpoint waiting for command latching journal shared before it
+func (tx *concreteTx) latchJournal() {
+	if tx.latchMode == unlatched {
+		tx.Latch(false)
+	}
 }
 
 func (tx *concreteTx) DowngradeLocks() {
-	tx.lockedPages.Range(func(pos, _ interface{}) bool {
-		tx.sharedLockTable.DowngradeLock(pos.(int64))
-		return true
-	})
+	tx.Unlatch()
+	for key := range tx.lockedKeys {
+		tx.sharedLockTable.DowngradeLock(key)
+	}
+}
+
+func (tx *concreteTx) SetLockTimeout(timeout time.Duration) {
+	tx.lockTimeout = timeout
+}
+
+func (tx *concreteTx) Visible(creatorId int) bool {
+	return tx.versions.visible(tx.id, creatorId)
+}
+
+func (tx *concreteTx) Obsolete(creatorId int) bool {
+	return tx.versions.obsolete(creatorId)
+}
+
+func (tx *concreteTx) AddGarbage(key string) {
+	tx.garbage[key] = struct{}{}
 }
 
 func (tx *concreteTx) AllocatePage() *storage.HeapPage {
 	return tx.a.AllocatePage()
 }
 
+// ReadPageAtPos reads buffered page; page which isn't latched by transaction is copied under its latch,
+// so page written concurrently isn't torn; snapshot transaction selects visible records versions by itself
 func (tx *concreteTx) ReadPageAtPos(pos int64) *storage.HeapPage {
 	tx.validateTxStatus()
-	tx.fetchAndLockPage(pos)
+	if _, latched := tx.latchedPages[pos]; latched {
+		return tx.bufSlotMgr.ReadLatchedPageAtPos(pos)
+	}
+	tx.fetchAndPinPage(pos)
+	defer tx.bufSlotMgr.Unpin(pos)
 	return tx.bufSlotMgr.ReadPageAtPos(pos)
 }
 
 func (tx *concreteTx) WritePageAtPos(page *storage.HeapPage, pos int64) {
 	tx.validateTxStatus()
-	tx.fetchAndLockPage(pos)
-	tx.sharedLockTable.UpgradeLock(pos, tx.id)
-	tx.bufSlotMgr.WritePageAtPos(page, pos)
+	tx.validateWritable()
+	if exclusive, latched := tx.latchedPages[pos]; !latched {
+		tx.fetchAndPinPage(pos)
+		defer tx.bufSlotMgr.Unpin(pos)
+		tx.bufSlotMgr.WritePageAtPos(page, pos)
+	} else if exclusive {
+		tx.bufSlotMgr.WriteLatchedPageAtPos(page, pos)
+	} else {
+		log.Panic("page is written under shared latch")
+	}
+	tx.pagesMux.Lock()
+	tx.unjournaled[pos] = struct{}{}
+	tx.pagesMux.Unlock()
 }
 
+// WritePage writes page to a free position; storage is extended only if there are no free pages
 func (tx *concreteTx) WritePage(page *storage.HeapPage) int64 {
 	tx.validateTxStatus()
-	pos := tx.strgMgr.Extend()
+	tx.validateWritable()
+	pos := tx.allocatePos()
 	tx.WritePageAtPos(page, pos)
 	return pos
 }
 
+// ReleasePage links page into free pages list, so it can be reused by WritePage; page latched by transaction
+// is linked when it is unlatched, because concurrent commands may find it by index till then
+func (tx *concreteTx) ReleasePage(pos int64) {
+	tx.validateTxStatus()
+	tx.validateWritable()
+	if _, latched := tx.latchedPages[pos]; latched {
+		tx.releasedPages = append(tx.releasedPages, pos)
+		return
+	}
+	tx.linkFreePage(pos)
+}
+
+func (tx *concreteTx) linkFreePage(pos int64) {
+	tx.LatchMeta()
+	defer tx.UnlatchMeta()
+	meta := tx.ReadMetaPage()
+	tx.WritePageAtPos(tx.a.AllocateFreePage(meta.FreeListHead), pos)
+	meta.FreeListHead = pos
+	tx.WriteMetaPage(meta)
+}
+
+func (tx *concreteTx) allocatePos() int64 {
+	tx.LatchMeta()
+	defer tx.UnlatchMeta()
+	if tx.strgMgr.Empty() {
+		// first page of storage is reserved for meta
+		metaPage := tx.a.AllocatePage()
+		storage.NewMetaPage(tx.a.PageSize()).WriteToPage(metaPage)
+		tx.WritePageAtPos(metaPage, tx.strgMgr.Extend())
+	}
+	meta := tx.ReadMetaPage()
+	if meta.FreeListHead == -1 {
+		return tx.strgMgr.Extend()
+	}
+	pos := meta.FreeListHead
+	meta.FreeListHead = tx.ReadPageAtPos(pos).NextFreePos()
+	tx.WriteMetaPage(meta)
+	return pos
+}
+
+func (tx *concreteTx) ReadMetaPage() *storage.MetaPage {
+	return storage.ReadMetaPage(tx.ReadPageAtPos(storage.MetaPagePos))
+}
+
+func (tx *concreteTx) WriteMetaPage(meta *storage.MetaPage) {
+	page := tx.a.AllocatePage()
+	meta.WriteToPage(page)
+	tx.WritePageAtPos(page, storage.MetaPagePos)
+}
+
+func (tx *concreteTx) MarkWritten(key string) {
+	tx.activeMux.Lock()
+	defer tx.activeMux.Unlock()
+	tx.writtenKeys[key] = struct{}{}
+}
+
+func (tx *concreteTx) release() {
+	for key := range tx.lockedKeys {
+		tx.sharedLockTable.Unlock(key, tx.id)
+	}
+}
+
+// deactivate removes transaction from active ones; finished transaction is deactivated right after its commit
+// or abort record under exclusive latch, so checkpoint doesn't journal it after that record
+func (tx *concreteTx) deactivate() {
+	tx.activeMux.Lock()
+	defer tx.activeMux.Unlock()
+	delete(tx.activeTxs, tx.id)
+}
+
+// CommitNoLog writes changed pages to storage without journaling them; it is used by replay of journal,
+// which pages images are journaled already
 func (tx *concreteTx) CommitNoLog() {
-	tx.lockedPages.Range(func(ipos, _ interface{}) bool {
-		pos := ipos.(int64)
-		tx.bufSlotMgr.Flush(pos)
-		tx.bufSlotMgr.Unpin(pos)
-		tx.sharedLockTable.Unlock(pos)
-		return true
-	})
+	tx.Unlatch()
+	tx.Latch(true)
+	tx.flushUnjournaledPages()
+	tx.deactivate()
+	tx.versions.finish(tx.id, true)
+	tx.Unlatch()
 	tx.strgMgr.Flush()
-	tx.logMgr.Release(tx.Id())
+	tx.release()
+	tx.addGarbage(tx.garbage)
 }
 
 func (tx *concreteTx) Commit() {
-	tx.lockedPages.Range(func(ipos, _ interface{}) bool {
-		pos := ipos.(int64)
-		if page := tx.bufSlotMgr.ReadPageIfDirty(pos); page != nil {
-			snapshot, err := page.MarshalBinary()
-			if err != nil {
-				log.Panic(err)
-			}
-			tx.logMgr.LogSnapshot(tx.id, pos, snapshot)
+	tx.commit(tx.syncCommits)
+}
+
+func (tx *concreteTx) CommitNoWait() {
+	tx.commit(false)
+}
+
+func (tx *concreteTx) commit(durable bool) {
+	if tx.snapshot() {
+		// snapshot transaction changes nothing, so there is nothing to journal
+		tx.Unlatch()
+		tx.versions.finish(tx.id, true)
+		tx.status = committed
+		return
+	}
+	if tx.readOnly {
+		// pages are not changed, so only locks are released
+		tx.Unlatch()
+		tx.deactivate()
+		tx.versions.finish(tx.id, true)
+		tx.release()
+		tx.status = committed
+		return
+	}
+	tx.Unlatch()
+	if tx.consensus != nil && !tx.replicated {
+		done, err := tx.replicate()
+		if err != nil {
+			tx.Abort()
+			tx.replicationErr = err
+			return
 		}
-		return true
-	})
-	tx.logMgr.LogCommit(tx.id)
-	tx.logMgr.Flush()
-	tx.CommitNoLog()
+		defer done()
+		// changes committed by cluster are considered applied by node, so they must survive crash
+		durable = true
+	}
+	tx.finishInGroup(tx, logging.CommitRecord, "", durable)
+	if durable {
+		// commit which doesn't wait for sync isn't shipped till the next sync, so it doesn't wait for replicas
+		tx.waitReplicas()
+	}
+	tx.release()
+	tx.addGarbage(tx.garbage)
 	tx.status = committed
 }
 
+// Abort rolls back changed keys; pages are shared with other transactions, so rollback is logical,
+// and its result is journaled and flushed as commit, because pages may be already flushed by others
 func (tx *concreteTx) Abort() {
-	tx.lockedPages.Range(func(ipos, _ interface{}) bool {
-		pos := ipos.(int64)
-		tx.bufSlotMgr.Unpin(pos)
-		tx.bufSlotMgr.Deallocate(pos)
-		tx.sharedLockTable.Unlock(pos)
-		return true
-	})
-	tx.logMgr.Release(tx.Id())
+	tx.Unlatch()
+	if len(tx.writtenKeys) != 0 {
+		keys := make([]string, 0, len(tx.writtenKeys))
+		for key := range tx.writtenKeys {
+			keys = append(keys, key)
+		}
+		tx.Latch(false)
+		tx.undo.Rollback(tx, keys)
+		tx.Unlatch()
+		tx.finishInGroup(tx, logging.AbortRecord, "", tx.syncCommits)
+	} else {
+		tx.deactivate()
+		tx.versions.finish(tx.id, false)
+	}
+	tx.release()
 	tx.status = aborted
 }
 
 func (tx *concreteTx) NoDataFound() bool {
 	return tx.strgMgr.Empty()
 }
+
+func (tx *concreteTx) PageSize() int {
+	return tx.a.PageSize()
+}
@@ -0,0 +1,98 @@
+package transaction
+
+import "sync"
+
+// txState describes finished transaction
+type txState struct {
+	// csn is a commit sequence number; it orders transactions finishes
+	csn       int
+	committed bool
+}
+
+// versionRegistry decides which records versions are visible to transactions;
+// snapshot sees versions of transactions which are committed before its start,
+// other transactions see the latest versions (they are isolated by locks)
+type versionRegistry struct {
+	mux    sync.Mutex
+	csnCtr int
+	active map[int]struct{}
+	// finished keeps states of transactions which are not yet visible to all snapshots;
+	// transactions which are absent here and are not active are treated as committed long ago
+	finished map[int]txState
+	// snapshots maps snapshot transaction id to csn of its start
+	snapshots map[int]int
+}
+
+func newVersionRegistry() *versionRegistry {
+	r := new(versionRegistry)
+	r.active = make(map[int]struct{})
+	r.finished = make(map[int]txState)
+	r.snapshots = make(map[int]int)
+	return r
+}
+
+func (r *versionRegistry) begin(txId int, snapshot bool) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.active[txId] = struct{}{}
+	if snapshot {
+		r.snapshots[txId] = r.csnCtr
+	}
+}
+
+func (r *versionRegistry) finish(txId int, committed bool) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if _, found := r.active[txId]; !found {
+		return
+	}
+	delete(r.active, txId)
+	delete(r.snapshots, txId)
+	r.csnCtr++
+	r.finished[txId] = txState{r.csnCtr, committed}
+	horizon := r.horizon()
+	for id, state := range r.finished {
+		if state.csn <= horizon {
+			delete(r.finished, id)
+		}
+	}
+}
+
+// horizon is a csn of the oldest snapshot; changes committed before it are visible to all transactions
+func (r *versionRegistry) horizon() int {
+	horizon := r.csnCtr
+	for _, csn := range r.snapshots {
+		if csn < horizon {
+			horizon = csn
+		}
+	}
+	return horizon
+}
+
+func (r *versionRegistry) visible(txId int, creatorId int) bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	snapshotCsn, snapshot := r.snapshots[txId]
+	if !snapshot || creatorId == txId {
+		return true
+	}
+	if _, found := r.active[creatorId]; found {
+		return false
+	}
+	if state, found := r.finished[creatorId]; found {
+		return state.committed && state.csn <= snapshotCsn
+	}
+	return true
+}
+
+func (r *versionRegistry) obsolete(creatorId int) bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if _, found := r.active[creatorId]; found {
+		return false
+	}
+	if state, found := r.finished[creatorId]; found {
+		return state.committed && state.csn <= r.horizon()
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"dbms/internal/core/access/bp_tree"
+	bpAdapter "dbms/internal/core/storage/adapters/bp_tree"
+	dataAdapter "dbms/internal/core/storage/adapters/data"
+	"dbms/internal/core/transaction"
+	"log"
+)
+
+// keysUndo rolls back changes of keys made by transaction; index entries of removed or moved records are fixed
+type keysUndo struct {
+	maxKeyLength int
+}
+
+func newKeysUndo(maxKeyLength int) *keysUndo {
+	u := new(keysUndo)
+	u.maxKeyLength = maxKeyLength
+	return u
+}
+
+func (u *keysUndo) index(tx transaction.Tx) *bp_tree.BPTree {
+	return bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, u.maxKeyLength))
+}
+
+func (u *keysUndo) Rollback(tx transaction.Tx, keys []string) {
+	index := u.index(tx)
+	da := dataAdapter.NewDataAdapter(tx)
+	for _, key := range keys {
+		pos, findErr := index.FindLatched(key, true)
+		if findErr == bp_tree.ErrKeyNotFound {
+			continue
+		} else if findErr != nil {
+			log.Panic(findErr)
+		}
+		newPos, rollbackErr := da.RollbackAtPos(key, pos)
+		if rollbackErr != nil && rollbackErr != dataAdapter.ErrRecordNotFound {
+			log.Panic(rollbackErr)
+		}
+		u.fixIndex(index, key, pos, newPos)
+	}
+}
+
+func (u *keysUndo) Image(tx transaction.Tx, key string) *transaction.KeyImage {
+	pos, findErr := u.index(tx).FindLatched(key, false)
+	if findErr == bp_tree.ErrKeyNotFound {
+		return nil
+	} else if findErr != nil {
+		log.Panic(findErr)
+	}
+	data, deleted, ownErr := dataAdapter.NewDataAdapter(tx).OwnVersionAtPos(key, pos)
+	if ownErr == dataAdapter.ErrRecordNotFound {
+		return nil
+	} else if ownErr != nil {
+		log.Panic(ownErr)
+	}
+	// data may refer to buffer slot, which is changed later
+	return &transaction.KeyImage{Value: append([]byte(nil), data...), Deleted: deleted}
+}
+
+// Restore removes all versions of transaction and puts image as a new one
+func (u *keysUndo) Restore(tx transaction.Tx, key string, image *transaction.KeyImage) {
+	u.Rollback(tx, []string{key})
+	if image == nil {
+		return
+	}
+	index := u.index(tx)
+	da := dataAdapter.NewDataAdapter(tx)
+	pos, findErr := index.FindLatched(key, true)
+	if findErr == bp_tree.ErrKeyNotFound {
+		if image.Deleted {
+			// key created and deleted by transaction is absent anyway
+			return
+		}
+		writePos, writeErr := da.Write(key, image.Value)
+		if writeErr != nil {
+			log.Panic(writeErr)
+		}
+		if insertErr := index.Insert(key, writePos); insertErr != nil {
+			log.Panic(insertErr)
+		}
+		return
+	} else if findErr != nil {
+		log.Panic(findErr)
+	}
+	var newPos int64
+	var writeErr error
+	if image.Deleted {
+		newPos, writeErr = da.MarkDeletedAtPos(key, pos)
+		if writeErr == dataAdapter.ErrRecordNotFound {
+			return
+		}
+	} else {
+		newPos, writeErr = da.WriteAtPos(key, image.Value, pos)
+	}
+	if writeErr != nil {
+		log.Panic(writeErr)
+	}
+	u.fixIndex(index, key, pos, newPos)
+}
+
+// fixIndex deletes entry of removed record (-1 position) or points entry to moved record
+func (u *keysUndo) fixIndex(index *bp_tree.BPTree, key string, pos int64, newPos int64) {
+	if newPos == -1 {
+		if _, delErr := index.Delete(key); delErr != nil {
+			log.Panic(delErr)
+		}
+	} else if newPos != pos {
+		if insertErr := index.Insert(key, newPos); insertErr != nil {
+			log.Panic(insertErr)
+		}
+	}
+}
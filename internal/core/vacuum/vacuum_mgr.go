@@ -0,0 +1,111 @@
+package vacuum
+
+import (
+	"dbms/internal/core/access/bp_tree"
+	"dbms/internal/core/concurrency"
+	bpAdapter "dbms/internal/core/storage/adapters/bp_tree"
+	dataAdapter "dbms/internal/core/storage/adapters/data"
+	"dbms/internal/core/transaction"
+	"dbms/internal/utils"
+	"log"
+	"sync"
+	"time"
+)
+
+// batchSize bounds keys processed by single transaction, so vacuum doesn't hold pages latch for long
+const batchSize = 100
+
+// VacuumManager removes records versions which are invisible to all transactions
+// and records deleted before the oldest snapshot
+type VacuumManager struct {
+	txMgr        *transaction.TxManager
+	maxKeyLength int
+	runner       *utils.PeriodicRunner
+	// mux serializes vacuum runs
+	mux sync.Mutex
+	// pending keeps keys with versions which are still visible to snapshots
+	pending map[string]struct{}
+}
+
+func NewVacuumManager(txMgr *transaction.TxManager, maxKeyLength int, interval time.Duration) *VacuumManager {
+	m := new(VacuumManager)
+	m.txMgr = txMgr
+	m.maxKeyLength = maxKeyLength
+	m.runner = utils.NewPeriodicRunner(interval, m.Vacuum)
+	m.pending = make(map[string]struct{})
+	return m
+}
+
+// Start runs vacuum periodically in background; non-positive interval disables it
+func (m *VacuumManager) Start() {
+	m.runner.Start()
+}
+
+func (m *VacuumManager) Stop() {
+	m.runner.Stop()
+}
+
+// Vacuum processes keys reported by committed transactions
+func (m *VacuumManager) Vacuum() {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	for _, key := range m.txMgr.TakeGarbage() {
+		m.pending[key] = struct{}{}
+	}
+	batch := make([]string, 0, batchSize)
+	for key := range m.pending {
+		batch = append(batch, key)
+		if len(batch) == batchSize {
+			m.vacuumBatch(batch)
+			batch = batch[:0]
+		}
+	}
+	if len(batch) != 0 {
+		m.vacuumBatch(batch)
+	}
+}
+
+func (m *VacuumManager) vacuumBatch(keys []string) {
+	tx := m.txMgr.InitTx(concurrency.ExclusiveMode)
+	defer func() {
+		// keys stay pending, so they are processed by the next run
+		if err := recover(); err == concurrency.ErrTxLockTimeout || err == concurrency.ErrDeadlock {
+			tx.Abort()
+		} else if err != nil {
+			log.Panic(err)
+		}
+	}()
+	index := bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, m.maxKeyLength))
+	da := dataAdapter.NewDataAdapter(tx)
+	cleaned := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pos, findErr := index.FindLatched(key, true)
+		if findErr == bp_tree.ErrKeyNotFound {
+			cleaned = append(cleaned, key)
+			continue
+		} else if findErr != nil {
+			log.Panic(findErr)
+		}
+		newPos, pending, vacuumErr := da.Vacuum(key, pos)
+		if vacuumErr != nil {
+			log.Panic(vacuumErr)
+		}
+		if newPos == -1 {
+			if _, delErr := index.Delete(key); delErr != nil {
+				log.Panic(delErr)
+			}
+		} else if newPos != pos {
+			// record is moved to another page
+			if insertErr := index.Insert(key, newPos); insertErr != nil {
+				log.Panic(insertErr)
+			}
+		}
+		if !pending {
+			cleaned = append(cleaned, key)
+		}
+	}
+	tx.Commit()
+	for _, key := range cleaned {
+		delete(m.pending, key)
+	}
+}
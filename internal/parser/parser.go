@@ -3,7 +3,9 @@ package parser
 import (
 	"dbms/internal/transfer"
 	"errors"
+	"log"
 	"regexp"
+	"strconv"
 )
 
 var (
@@ -38,6 +40,39 @@ func twoArgsParseStrategy(cmdType int, args []string) *transfer.Cmd {
 	return cmd
 }
 
+// rangeArgsParseStrategy expects key, value and optional positive limit (LIMIT 0 is rejected by pattern,
+// because zero limit means no limit)
+// (start and end keys for SCAN; prefix and optional last seen key for KEYS)
+func rangeArgsParseStrategy(cmdType int, args []string) *transfer.Cmd {
+	cmd := new(transfer.Cmd)
+	cmd.Type = cmdType
+	cmd.Key = args[0]
+	cmd.Value = []byte(args[1])
+	if args[2] != "" {
+		limit, err := strconv.Atoi(args[2])
+		if err != nil {
+			log.Panic(err)
+		}
+		cmd.Limit = limit
+	}
+	return cmd
+}
+
+// beginArgsParseStrategy expects optional positive lock timeout in milliseconds (TIMEOUT 0 is rejected by pattern,
+// because zero timeout means lockTimeoutMs setting)
+func beginArgsParseStrategy(cmdType int, args []string) *transfer.Cmd {
+	cmd := new(transfer.Cmd)
+	cmd.Type = cmdType
+	if args[0] != "" {
+		timeout, err := strconv.Atoi(args[0])
+		if err != nil {
+			log.Panic(err)
+		}
+		cmd.Limit = timeout
+	}
+	return cmd
+}
+
 type DumbSingleLineParser struct {
 	patterns        map[int]*regexp.Regexp
 	parseStrategies map[int]parseStrategy
@@ -46,24 +81,60 @@ type DumbSingleLineParser struct {
 func NewDumbSingleLineParser() *DumbSingleLineParser {
 	p := new(DumbSingleLineParser)
 	p.patterns = map[int]*regexp.Regexp{
-		transfer.GetCmdType:    regexp.MustCompile(`^GET ([^\s]+)$`),
-		transfer.SetCmdType:    regexp.MustCompile(`^SET ([^\s]+) ([^\s]+)$`),
-		transfer.DelCmdType:    regexp.MustCompile(`^DEL ([^\s]+)$`),
-		transfer.BegShCmdType:  regexp.MustCompile(`^BEGIN SHARED$`),
-		transfer.BegExCmdType:  regexp.MustCompile(`^BEGIN EXCLUSIVE$`),
-		transfer.CommitCmdType: regexp.MustCompile(`^COMMIT$`),
-		transfer.AbortCmdType:  regexp.MustCompile(`^ABORT$`),
-		transfer.HelpCmdType:   regexp.MustCompile(`^HELP$`),
+		transfer.GetCmdType:               regexp.MustCompile(`^GET ([^\s]+)$`),
+		transfer.SetCmdType:               regexp.MustCompile(`^SET ([^\s]+) ([^\s]+)$`),
+		transfer.DelCmdType:               regexp.MustCompile(`^DEL ([^\s]+)$`),
+		transfer.BegShCmdType:             regexp.MustCompile(`^BEGIN SHARED(?: TIMEOUT ([1-9][0-9]{0,8}))?$`),
+		transfer.BegExCmdType:             regexp.MustCompile(`^BEGIN EXCLUSIVE(?: TIMEOUT ([1-9][0-9]{0,8}))?$`),
+		transfer.BegSnCmdType:             regexp.MustCompile(`^BEGIN SNAPSHOT(?: TIMEOUT ([1-9][0-9]{0,8}))?$`),
+		transfer.BegSrCmdType:             regexp.MustCompile(`^BEGIN SERIALIZABLE(?: TIMEOUT ([1-9][0-9]{0,8}))?$`),
+		transfer.CommitCmdType:            regexp.MustCompile(`^COMMIT$`),
+		transfer.CommitNoWaitCmdType:      regexp.MustCompile(`^COMMIT NOWAIT$`),
+		transfer.AbortCmdType:             regexp.MustCompile(`^ABORT$`),
+		transfer.HelpCmdType:              regexp.MustCompile(`^HELP$`),
+		transfer.ScanCmdType:              regexp.MustCompile(`^SCAN ([^\s]+) ([^\s]+)(?: LIMIT ([1-9][0-9]{0,8}))?$`),
+		transfer.KeysCmdType:              regexp.MustCompile(`^KEYS ([^\s*]*)\*(?: AFTER ([^\s]+))?(?: LIMIT ([1-9][0-9]{0,8}))?$`),
+		transfer.SavepointCmdType:         regexp.MustCompile(`^SAVEPOINT ([^\s]+)$`),
+		transfer.RollbackToCmdType:        regexp.MustCompile(`^ROLLBACK TO ([^\s]+)$`),
+		transfer.ReleaseCmdType:           regexp.MustCompile(`^RELEASE ([^\s]+)$`),
+		transfer.PrepareCmdType:           regexp.MustCompile(`^PREPARE TRANSACTION ([^\s]+)$`),
+		transfer.CommitPreparedCmdType:    regexp.MustCompile(`^COMMIT PREPARED ([^\s]+)$`),
+		transfer.RollbackPreparedCmdType:  regexp.MustCompile(`^ROLLBACK PREPARED ([^\s]+)$`),
+		transfer.ListPreparedCmdType:      regexp.MustCompile(`^LIST PREPARED$`),
+		transfer.BackupCmdType:            regexp.MustCompile(`^BACKUP ([^\s]+)$`),
+		transfer.IncrementalBackupCmdType: regexp.MustCompile(`^BACKUP ([^\s]+) INCREMENTAL ([^\s]+)$`),
+		transfer.ReplicationLagCmdType:    regexp.MustCompile(`^REPLICATION LAG$`),
+		transfer.ReplicasCmdType:          regexp.MustCompile(`^REPLICAS$`),
+		transfer.ClusterCmdType:           regexp.MustCompile(`^CLUSTER$`),
+		transfer.StatsCmdType:             regexp.MustCompile(`^STATS$`),
 	}
 	p.parseStrategies = map[int]parseStrategy{
-		transfer.GetCmdType:    oneArgParseStrategy,
-		transfer.SetCmdType:    twoArgsParseStrategy,
-		transfer.DelCmdType:    oneArgParseStrategy,
-		transfer.BegShCmdType:  noArgsParseStrategy,
-		transfer.BegExCmdType:  noArgsParseStrategy,
-		transfer.CommitCmdType: noArgsParseStrategy,
-		transfer.AbortCmdType:  noArgsParseStrategy,
-		transfer.HelpCmdType:   noArgsParseStrategy,
+		transfer.GetCmdType:               oneArgParseStrategy,
+		transfer.SetCmdType:               twoArgsParseStrategy,
+		transfer.DelCmdType:               oneArgParseStrategy,
+		transfer.BegShCmdType:             beginArgsParseStrategy,
+		transfer.BegExCmdType:             beginArgsParseStrategy,
+		transfer.BegSnCmdType:             beginArgsParseStrategy,
+		transfer.BegSrCmdType:             beginArgsParseStrategy,
+		transfer.CommitCmdType:            noArgsParseStrategy,
+		transfer.CommitNoWaitCmdType:      noArgsParseStrategy,
+		transfer.AbortCmdType:             noArgsParseStrategy,
+		transfer.HelpCmdType:              noArgsParseStrategy,
+		transfer.ScanCmdType:              rangeArgsParseStrategy,
+		transfer.KeysCmdType:              rangeArgsParseStrategy,
+		transfer.SavepointCmdType:         oneArgParseStrategy,
+		transfer.RollbackToCmdType:        oneArgParseStrategy,
+		transfer.ReleaseCmdType:           oneArgParseStrategy,
+		transfer.PrepareCmdType:           oneArgParseStrategy,
+		transfer.CommitPreparedCmdType:    oneArgParseStrategy,
+		transfer.RollbackPreparedCmdType:  oneArgParseStrategy,
+		transfer.ListPreparedCmdType:      noArgsParseStrategy,
+		transfer.BackupCmdType:            oneArgParseStrategy,
+		transfer.IncrementalBackupCmdType: twoArgsParseStrategy,
+		transfer.ReplicationLagCmdType:    noArgsParseStrategy,
+		transfer.ReplicasCmdType:          noArgsParseStrategy,
+		transfer.ClusterCmdType:           noArgsParseStrategy,
+		transfer.StatsCmdType:             noArgsParseStrategy,
 	}
 	return p
 }
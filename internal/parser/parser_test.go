@@ -0,0 +1,26 @@
+package parser
+
+import (
+	"dbms/internal/transfer"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDumbSingleLineParser_BeginTimeout(t *testing.T) {
+	p := NewDumbSingleLineParser()
+	cmd, err := p.Parse("BEGIN EXCLUSIVE TIMEOUT 250")
+	assert.Nil(t, err)
+	assert.Equal(t, &transfer.Cmd{Type: transfer.BegExCmdType, Args: transfer.Args{Limit: 250}}, cmd)
+	cmd, err = p.Parse("BEGIN SNAPSHOT")
+	assert.Nil(t, err)
+	assert.Equal(t, &transfer.Cmd{Type: transfer.BegSnCmdType}, cmd)
+	for _, raw := range []string{
+		"BEGIN SHARED TIMEOUT 0",
+		"BEGIN EXCLUSIVE TIMEOUT 0",
+		"BEGIN SNAPSHOT TIMEOUT 010",
+		"BEGIN SERIALIZABLE TIMEOUT 1234567890",
+	} {
+		_, err := p.Parse(raw)
+		assert.Equal(t, ErrInvalidCmdStruct, err, raw)
+	}
+}
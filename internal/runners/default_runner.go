@@ -44,6 +44,9 @@ func (r *DefaultScopedServerRunner) Finalize() {
 	if err := os.RemoveAll(r.cfgLdr.CoreCfg().LogPath()); err != nil {
 		panic(err)
 	}
+	if err := os.RemoveAll(r.cfgLdr.CoreCfg().BackupRootDir()); err != nil {
+		panic(err)
+	}
 }
 
 func (r *DefaultScopedServerRunner) BuildUrl() string {
@@ -1,55 +1,180 @@
 package server
 
 import (
+	"dbms/internal/config"
 	"dbms/internal/core/access/bp_tree"
 	"dbms/internal/core/concurrency"
+	"dbms/internal/core/raft"
+	"dbms/internal/core/recovery"
+	"dbms/internal/core/replication"
 	bpAdapter "dbms/internal/core/storage/adapters/bp_tree"
 	dataAdapter "dbms/internal/core/storage/adapters/data"
+	"dbms/internal/core/transaction"
 	"dbms/internal/transfer"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Command func() *transfer.Result
 
+// maxKeysPageSize bounds KEYS result, so huge key sets are listed page by page
+const maxKeysPageSize = 1000
+
+var (
+	ErrNotReplica     = errors.New("server is not a replica")
+	ErrNotClusterNode = errors.New("server is not a node of cluster")
+	// ErrBackupPath is returned for backup path outside of backupRoot setting, so clients can't write files
+	// anywhere on server
+	ErrBackupPath = errors.New("backup path must be inside backup root")
+)
+
 type CommandFactory struct {
 	txProxy *TxProxy
+	cfg     *config.CoreConfig
+	// replica is nil if server is a primary
+	replica  *recovery.Replica
+	replicas *replication.Replicas
+	// node is nil if server is not a node of cluster
+	node *raft.Node
 }
 
-func NewCommandFactory(txProxy *TxProxy) *CommandFactory {
+func NewCommandFactory(
+	txProxy *TxProxy,
+	cfg *config.CoreConfig,
+	replica *recovery.Replica,
+	replicas *replication.Replicas,
+	node *raft.Node,
+) *CommandFactory {
 	f := new(CommandFactory)
 	f.txProxy = txProxy
+	f.cfg = cfg
+	f.replica = replica
+	f.replicas = replicas
+	f.node = node
 	return f
 }
 
 func (f *CommandFactory) Create(cmd transfer.Cmd) Command {
+	if f.replica != nil {
+		if err := validateReplicaCmd(cmd); err != nil {
+			return createErrCommand(err)
+		}
+	}
+	if f.node != nil {
+		if err := validateClusterCmd(f.node, cmd); err != nil {
+			return createErrCommand(err)
+		}
+	}
 	switch cmd.Type {
 	case transfer.BegShCmdType:
-		return createBeginCommand(f.txProxy, concurrency.SharedMode)
+		return createBeginCommand(f.txProxy, concurrency.SharedMode, cmd.Limit)
 	case transfer.BegExCmdType:
-		return createBeginCommand(f.txProxy, concurrency.ExclusiveMode)
+		return createBeginCommand(f.txProxy, concurrency.ExclusiveMode, cmd.Limit)
+	case transfer.BegSnCmdType:
+		return createBeginCommand(f.txProxy, concurrency.SnapshotMode, cmd.Limit)
+	case transfer.BegSrCmdType:
+		return createBeginCommand(f.txProxy, concurrency.SerializableMode, cmd.Limit)
 	case transfer.CommitCmdType:
-		return createCommitCommand(f.txProxy)
+		return createCommitCommand(f.txProxy.Commit)
+	case transfer.CommitNoWaitCmdType:
+		return createCommitCommand(f.txProxy.CommitNoWait)
 	case transfer.AbortCmdType:
 		return createAbortCommand(f.txProxy)
+	case transfer.SavepointCmdType:
+		return createNamedTxCommand(f.txProxy, f.txProxy.Savepoint, cmd.Key)
+	case transfer.RollbackToCmdType:
+		return createNamedTxCommand(f.txProxy, f.txProxy.RollbackTo, cmd.Key)
+	case transfer.ReleaseCmdType:
+		return createNamedTxCommand(f.txProxy, f.txProxy.Release, cmd.Key)
+	case transfer.PrepareCmdType:
+		return createNamedTxCommand(f.txProxy, f.txProxy.Prepare, cmd.Key)
+	case transfer.CommitPreparedCmdType:
+		return createNamedTxCommand(f.txProxy, f.txProxy.CommitPrepared, cmd.Key)
+	case transfer.RollbackPreparedCmdType:
+		return createNamedTxCommand(f.txProxy, f.txProxy.RollbackPrepared, cmd.Key)
+	case transfer.ListPreparedCmdType:
+		return createListPreparedCommand(f.txProxy)
+	case transfer.BackupCmdType:
+		return createBackupCommand(f.txProxy, f.node, f.cfg, cmd.Key)
+	case transfer.IncrementalBackupCmdType:
+		return createIncrementalBackupCommand(f.txProxy, f.cfg, cmd.Key, string(cmd.Value))
+	case transfer.ReplicationLagCmdType:
+		return createReplicationLagCommand(f.replica)
+	case transfer.ReplicasCmdType:
+		return createReplicasCommand(f.replicas)
+	case transfer.RaftCmdType:
+		return createRaftCommand(f.node, cmd.Value)
+	case transfer.ClusterCmdType:
+		return createClusterCommand(f.node)
+	case transfer.StatsCmdType:
+		return createStatsCommand(f.txProxy)
 	case transfer.HelpCmdType:
 		return createHelpCommand()
 	default:
-		return createDataManipulationCommand(f.txProxy, cmd)
+		return createDataManipulationCommand(f.txProxy, f.cfg, cmd)
+	}
+}
+
+// validateReplicaCmd rejects commands which change storage or journal of replica
+func validateReplicaCmd(cmd transfer.Cmd) error {
+	switch cmd.Type {
+	case transfer.SetCmdType, transfer.DelCmdType, transfer.PrepareCmdType, transfer.CommitPreparedCmdType,
+		transfer.RollbackPreparedCmdType, transfer.BackupCmdType, transfer.IncrementalBackupCmdType:
+		return transaction.ErrReadOnly
+	}
+	return nil
+}
+
+// validateClusterCmd redirects changes to leader, because only leader proposes them to cluster; prepared
+// changes aren't replicated, so two-phase commit isn't supported by cluster
+func validateClusterCmd(node *raft.Node, cmd transfer.Cmd) error {
+	switch cmd.Type {
+	case transfer.SetCmdType, transfer.DelCmdType:
+		if leader := node.Leader(); leader != node.Addr() {
+			return &raft.NotLeaderError{Leader: leader}
+		}
+	case transfer.PrepareCmdType, transfer.CommitPreparedCmdType, transfer.RollbackPreparedCmdType:
+		return transaction.ErrClusterPrepare
 	}
+	return nil
 }
 
-func createBeginCommand(txProxy *TxProxy, mode int) Command {
+func createErrCommand(err error) Command {
 	return func() *transfer.Result {
-		if err := txProxy.Init(mode); err != nil {
+		return errResult(err)
+	}
+}
+
+// errResult passes leader of cluster as a field of result, so client sends rejected changes to it
+func errResult(err error) *transfer.Result {
+	var notLeader *raft.NotLeaderError
+	if errors.As(err, &notLeader) {
+		return transfer.RedirectResult(notLeader.Leader)
+	}
+	return transfer.ErrResult(err)
+}
+
+func createBeginCommand(txProxy *TxProxy, mode int, lockTimeoutMs int) Command {
+	return func() *transfer.Result {
+		if err := txProxy.Init(mode, time.Duration(lockTimeoutMs)*time.Millisecond); err != nil {
 			return transfer.ErrResult(err)
 		}
 		return transfer.OkResult()
 	}
 }
 
-func createCommitCommand(txProxy *TxProxy) Command {
+func createCommitCommand(commit func() error) Command {
 	return func() *transfer.Result {
-		txProxy.Commit()
+		if err := commit(); err != nil {
+			return transfer.ErrResult(err)
+		}
 		return transfer.OkResult()
 	}
 }
@@ -61,6 +186,185 @@ func createAbortCommand(txProxy *TxProxy) Command {
 	}
 }
 
+// createNamedTxCommand creates transaction control command with savepoint name or global transaction id argument
+func createNamedTxCommand(txProxy *TxProxy, f func(name string) error, name string) Command {
+	return func() (res *transfer.Result) {
+		defer func() {
+			// rollback of shared transaction waits for keys locks, which are downgraded after previous commands;
+			// tx can't proceed after lock failure, so it is aborted
+			if err := recover(); err == concurrency.ErrTxLockTimeout || err == concurrency.ErrDeadlock {
+				txProxy.Abort()
+				res = transfer.ErrResult(err.(error))
+			} else if err != nil {
+				log.Panic(err)
+			}
+		}()
+		if err := f(name); err != nil {
+			return transfer.ErrResult(err)
+		}
+		return transfer.OkResult()
+	}
+}
+
+// createListPreparedCommand lists global ids of transactions prepared, but not yet finished by coordinator
+func createListPreparedCommand(txProxy *TxProxy) Command {
+	return func() *transfer.Result {
+		return transfer.KeysResult(txProxy.txMgr.Prepared())
+	}
+}
+
+// createBackupCommand copies storage and journal of running server to directory, which has layout of files path,
+// so server started on it recovers storage as of the end of backup; node of cluster copies its Raft log before,
+// so entries applied to the copied storage are compacted in the copied log
+func createBackupCommand(txProxy *TxProxy, node *raft.Node, cfg *config.CoreConfig, path string) Command {
+	return func() *transfer.Result {
+		backupCfg := *cfg
+		var err error
+		if backupCfg.FilesPath, err = resolveBackupPath(cfg, path); err != nil {
+			return transfer.ErrResult(err)
+		}
+		if node != nil {
+			if err := node.CopyLog(backupCfg.RaftPath()); err != nil {
+				return transfer.ErrResult(err)
+			}
+		}
+		if err := txProxy.txMgr.Backup(backupCfg.DataPath(), backupCfg.LogPath()); err != nil {
+			if node != nil {
+				os.RemoveAll(backupCfg.RaftPath())
+			}
+			return transfer.ErrResult(err)
+		}
+		return transfer.OkResult()
+	}
+}
+
+// resolveBackupPath returns directory of backup inside backup root; relative path is joined to root,
+// and path with parent references or outside of root is rejected
+func resolveBackupPath(cfg *config.CoreConfig, path string) (string, error) {
+	for _, elem := range strings.Split(filepath.ToSlash(path), "/") {
+		if elem == ".." {
+			return "", ErrBackupPath
+		}
+	}
+	root := cfg.BackupRootDir()
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	rel, err := filepath.Rel(root, filepath.Clean(path))
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", ErrBackupPath
+	}
+	return path, nil
+}
+
+// createIncrementalBackupCommand copies pages changed since base backup and journal to directory; backup is restored
+// by restore tool on top of its base backups
+func createIncrementalBackupCommand(txProxy *TxProxy, cfg *config.CoreConfig, path string, base string) Command {
+	return func() *transfer.Result {
+		backupCfg := *cfg
+		baseCfg := *cfg
+		var err error
+		if backupCfg.FilesPath, err = resolveBackupPath(cfg, path); err != nil {
+			return transfer.ErrResult(err)
+		}
+		if baseCfg.FilesPath, err = resolveBackupPath(cfg, base); err != nil {
+			return transfer.ErrResult(err)
+		}
+		if err := txProxy.txMgr.IncrementalBackup(backupCfg.PagesPath(), backupCfg.LogPath(), baseCfg.LogPath()); err != nil {
+			return transfer.ErrResult(err)
+		}
+		return transfer.OkResult()
+	}
+}
+
+// createReplicationLagCommand reports number of records flushed by primary, but not replayed by replica yet,
+// and time in milliseconds since replica replayed all of them
+func createReplicationLagCommand(replica *recovery.Replica) Command {
+	return func() *transfer.Result {
+		if replica == nil {
+			return transfer.ErrResult(ErrNotReplica)
+		}
+		lag := replica.Lag()
+		return transfer.PairsResult([]transfer.Pair{
+			{Key: "records", Value: []byte(strconv.FormatInt(lag.Records, 10))},
+			{Key: "delayMs", Value: []byte(strconv.FormatInt(lag.Delay.Milliseconds(), 10))},
+		})
+	}
+}
+
+// createReplicasCommand lists replicas connected to server with lsn of the last record acknowledged by them,
+// number of flushed records not acknowledged yet and time in milliseconds since the last acknowledgement
+func createReplicasCommand(replicas *replication.Replicas) Command {
+	return func() *transfer.Result {
+		statuses := replicas.Statuses()
+		pairs := make([]transfer.Pair, 0, len(statuses))
+		for _, status := range statuses {
+			var sinceAck int64
+			if !status.AckedAt.IsZero() {
+				sinceAck = time.Since(status.AckedAt).Milliseconds()
+			}
+			pairs = append(pairs, transfer.Pair{
+				Key: status.Name,
+				Value: []byte(fmt.Sprintf("address=%s acked=%d lag=%d lastAckMs=%d",
+					status.Addr, status.AckedLsn, status.Lag, sinceAck)),
+			})
+		}
+		return transfer.PairsResult(pairs)
+	}
+}
+
+// createRaftCommand passes message sent by another node of cluster to node and returns its reply
+func createRaftCommand(node *raft.Node, msg []byte) Command {
+	return func() *transfer.Result {
+		if node == nil {
+			return transfer.ErrResult(ErrNotClusterNode)
+		}
+		reply, err := node.Handle(msg)
+		if err != nil {
+			return transfer.ErrResult(err)
+		}
+		return transfer.ValueResult(reply)
+	}
+}
+
+// createClusterCommand shows role of node, its term, leader known to it and indexes of its log
+func createClusterCommand(node *raft.Node) Command {
+	return func() *transfer.Result {
+		if node == nil {
+			return transfer.ErrResult(ErrNotClusterNode)
+		}
+		status := node.Status()
+		return transfer.PairsResult([]transfer.Pair{
+			{Key: "addr", Value: []byte(status.Addr)},
+			{Key: "role", Value: []byte(status.Role)},
+			{Key: "term", Value: []byte(strconv.FormatInt(status.Term, 10))},
+			{Key: "leader", Value: []byte(status.Leader)},
+			{Key: "lastIndex", Value: []byte(strconv.FormatInt(status.LastIndex, 10))},
+			{Key: "compacted", Value: []byte(strconv.FormatInt(status.Compacted, 10))},
+			{Key: "commitIndex", Value: []byte(strconv.FormatInt(status.CommitIndex, 10))},
+			{Key: "applied", Value: []byte(strconv.FormatInt(status.Applied, 10))},
+		})
+	}
+}
+
+// createStatsCommand shows number of group commit batches and records flushed since start, size of the largest
+// batch and numbers of batches of up to 1, 2, 4, ... records (the last number counts larger batches too)
+func createStatsCommand(txProxy *TxProxy) Command {
+	return func() *transfer.Result {
+		stats := txProxy.txMgr.GroupCommitStats()
+		sizes := make([]string, len(stats.BatchSizes))
+		for i, batches := range stats.BatchSizes {
+			sizes[i] = strconv.Itoa(batches)
+		}
+		return transfer.PairsResult([]transfer.Pair{
+			{Key: "batches", Value: []byte(strconv.Itoa(stats.Batches))},
+			{Key: "records", Value: []byte(strconv.Itoa(stats.Records))},
+			{Key: "maxBatch", Value: []byte(strconv.Itoa(stats.MaxBatch))},
+			{Key: "batchSizes", Value: []byte(strings.Join(sizes, " "))},
+		})
+	}
+}
+
 func createHelpCommand() Command {
 	return func() *transfer.Result {
 		return transfer.ValueResult([]byte(`Commands structure:
@@ -68,11 +372,61 @@ Data manipulation commands:
 	GET key         - finds value associated with key
 	SET key value   - sets value associated with key
 	DEL key         - removes value associated with key
+	SCAN start end [LIMIT n]
+	                - lists key-value pairs for keys in [start, end) range in key order
+	                  (all of them without LIMIT; n must be positive)
+	KEYS prefix* [AFTER key] [LIMIT n]
+	                - lists keys with prefix in key order (up to 1000 per call; AFTER continues listing)
 Transaction management commands:
 	BEGIN SHARED    - starts new transaction with per-operation isolation
 	BEGIN EXCLUSIVE - starts new transaction with per-transation isolation
+	BEGIN SNAPSHOT  - starts new transaction which reads snapshot as of its start without locks;
+	                  changes are applied at commit, which fails on concurrent update of same keys
+	BEGIN SERIALIZABLE
+	                - starts new snapshot transaction which commit also fails if keys or ranges
+	                  read by it are changed concurrently (prevents phantoms and write skew)
+	BEGIN mode TIMEOUT ms
+	                - starts new transaction of mode (one of above) which waits for each lock
+	                  at most ms milliseconds (positive; lockTimeoutMs setting by default)
+	SAVEPOINT name  - marks state of active transaction
+	ROLLBACK TO name
+	                - undoes changes made after savepoint (savepoint is kept)
+	RELEASE name    - removes savepoint and savepoints made after it keeping changes
 	COMMIT          - commits active transaction
-	ABORT           - aborts active transaction`),
+	COMMIT NOWAIT   - commits active transaction without waiting for sync to disk
+	                  (commit may be lost by OS crash or power loss till the next sync)
+	ABORT           - aborts active transaction
+Two-phase commit commands:
+	PREPARE TRANSACTION id
+	                - makes changes of active transaction durable and detaches it from connection
+	COMMIT PREPARED id
+	                - commits prepared transaction
+	ROLLBACK PREPARED id
+	                - rolls back prepared transaction
+	LIST PREPARED   - lists ids of prepared transactions which are not finished yet
+Administration commands:
+	BACKUP path     - copies storage and journal (and Raft log of cluster node) of running server
+	                  to directory (server started with this directory as files path recovers storage
+	                  as of the end of backup)
+	BACKUP path INCREMENTAL base
+	                - copies pages changed since base (full or incremental) backup and journal
+	                  to directory (restore tool applies chain of backups); backup paths are
+	                  resolved inside backupRoot setting directory
+	REPLICATION LAG - shows number of primary journal records not replayed by replica yet
+	                  and time in milliseconds since replica caught up with primary
+	REPLICAS        - lists replicas connected to server with lsn of the last record acknowledged
+	                  by them, number of records not acknowledged yet and time in milliseconds
+	                  since the last acknowledgement
+	CLUSTER         - shows role, term and leader of cluster node, index of the last entry of its log,
+	                  index of the last entry removed by compaction, commit index and index
+	                  of the last applied entry
+	STATS           - shows number of group commit batches and records journaled since start, size
+	                  of the largest batch and numbers of batches of up to 1, 2, 4, ... 512 records
+	                  (the last number counts larger batches too)
+Replica (read-only server started with replicaOf) rejects SET, DEL, two-phase commit commands
+and BACKUP; its transactions see changes of primary transactions after their commits are replayed
+Node of cluster (server started with cluster) which is not a leader rejects SET and DEL with address
+of leader; two-phase commit commands are rejected by all nodes`),
 		)
 	}
 }
@@ -81,6 +435,7 @@ type encapsulatedCommand func(args transfer.Args)
 
 type dataManipulationCommandState struct {
 	txProxy     *TxProxy
+	cfg         *config.CoreConfig
 	cmd         transfer.Cmd
 	res         *transfer.Result
 	index       *bp_tree.BPTree
@@ -88,29 +443,41 @@ type dataManipulationCommandState struct {
 	commandsMap map[int]encapsulatedCommand
 }
 
-func createDataManipulationCommand(txProxy *TxProxy, cmd transfer.Cmd) Command {
+func createDataManipulationCommand(txProxy *TxProxy, cfg *config.CoreConfig, cmd transfer.Cmd) Command {
 	f := new(dataManipulationCommandState)
 	f.txProxy = txProxy
+	f.cfg = cfg
 	f.cmd = cmd
 	f.commandsMap = map[int]encapsulatedCommand{
-		transfer.GetCmdType: f.getCommand,
-		transfer.SetCmdType: f.setCommand,
-		transfer.DelCmdType: f.delCommand,
+		transfer.GetCmdType:  f.getCommand,
+		transfer.SetCmdType:  f.setCommand,
+		transfer.DelCmdType:  f.delCommand,
+		transfer.ScanCmdType: f.scanCommand,
+		transfer.KeysCmdType: f.keysCommand,
 	}
 	return f.execute
 }
 
-func (f *dataManipulationCommandState) execute() *transfer.Result {
+func (f *dataManipulationCommandState) execute() (res *transfer.Result) {
+	if err := f.validateKey(); err != nil {
+		return transfer.ErrResult(err)
+	}
 	if f.txProxy.Tx() == nil {
-		f.txProxy.Init(concurrency.SharedMode)
-		defer f.txProxy.Commit()
+		f.txProxy.Init(concurrency.SharedMode, 0)
+		defer func() {
+			// commit of single command fails if it isn't acknowledged by replicas in time
+			if err := f.txProxy.Commit(); err != nil && res.Ok() {
+				res = errResult(err)
+			}
+		}()
 	}
-	f.index = bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(f.txProxy.Tx()))
+	f.index = bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(f.txProxy.Tx(), f.cfg.MaxKeyLength))
 	f.da = dataAdapter.NewDataAdapter(f.txProxy.Tx())
 	defer func() {
-		if err := recover(); err == concurrency.ErrTxLockTimeout {
+		// tx can't proceed after lock failure, so it is aborted (deferred commit becomes no-op)
+		if err := recover(); err == concurrency.ErrTxLockTimeout || err == concurrency.ErrDeadlock {
 			f.txProxy.Abort()
-			*f.res = *transfer.ErrResult(concurrency.ErrTxLockTimeout)
+			res = transfer.ErrResult(err.(error))
 		} else if err != nil {
 			log.Panic(err)
 		}
@@ -119,9 +486,37 @@ func (f *dataManipulationCommandState) execute() *transfer.Result {
 	return f.res
 }
 
+// validateKey rejects keys which can't be stored in index;
+// range commands bounds are not stored, so they are not checked
+func (f *dataManipulationCommandState) validateKey() error {
+	switch f.cmd.Type {
+	case transfer.GetCmdType, transfer.SetCmdType, transfer.DelCmdType:
+		if len(f.cmd.Args.Key) > f.cfg.MaxKeyLength {
+			return bp_tree.ErrKeyTooLong
+		}
+	}
+	return nil
+}
+
 func (f *dataManipulationCommandState) getCommand(args transfer.Args) {
-	defer f.txProxy.Tx().DowngradeLocks()
-	pos, findErr := f.index.Find(args.Key)
+	tx := f.txProxy.Tx()
+	defer tx.DowngradeLocks()
+	tx.LockKey(args.Key, false)
+	tx.Latch(false)
+	if writes := f.txProxy.Writes(); writes != nil {
+		if value, found := writes.Get(args.Key); found {
+			if value == nil {
+				f.res = transfer.ErrResult(bp_tree.ErrKeyNotFound)
+				return
+			}
+			f.res = transfer.ValueResult(value)
+			return
+		}
+	}
+	if reads := f.txProxy.Reads(); reads != nil {
+		reads.AddKey(args.Key)
+	}
+	pos, findErr := f.index.FindLatched(args.Key, false)
 	if findErr == bp_tree.ErrKeyNotFound {
 		f.res = transfer.ErrResult(bp_tree.ErrKeyNotFound)
 		return
@@ -129,7 +524,11 @@ func (f *dataManipulationCommandState) getCommand(args transfer.Args) {
 		log.Panic(findErr)
 	}
 	data, findErr := f.da.FindAtPos(args.Key, pos)
-	if findErr != nil {
+	if findErr == dataAdapter.ErrRecordNotFound {
+		// record is deleted or not visible to snapshot
+		f.res = transfer.ErrResult(bp_tree.ErrKeyNotFound)
+		return
+	} else if findErr != nil {
 		log.Panic(findErr)
 	}
 	f.res = transfer.ValueResult(data)
@@ -137,32 +536,212 @@ func (f *dataManipulationCommandState) getCommand(args transfer.Args) {
 
 func (f *dataManipulationCommandState) setCommand(args transfer.Args) {
 	defer f.txProxy.Tx().DowngradeLocks()
-	pos, findErr := f.index.Find(args.Key)
+	if writes := f.txProxy.Writes(); writes != nil {
+		writes.Set(args.Key, args.Value)
+		f.res = transfer.OkResult()
+		return
+	}
+	f.txProxy.Tx().LockKey(args.Key, true)
+	pos, findErr := f.index.FindLatched(args.Key, true)
 	if findErr == nil {
-		if writeErr := f.da.WriteAtPos(args.Key, args.Value, pos); writeErr != nil {
+		writePos, writeErr := f.da.WriteAtPos(args.Key, args.Value, pos)
+		if writeErr != nil {
 			log.Panic(writeErr)
 		}
+		if writePos != pos {
+			// record is moved to another page
+			if insertErr := f.index.Insert(args.Key, writePos); insertErr != nil {
+				log.Panic(insertErr)
+			}
+		}
 	} else if findErr == bp_tree.ErrKeyNotFound {
 		writePos, writeErr := f.da.Write(args.Key, args.Value)
 		if writeErr != nil {
 			log.Panic(writeErr)
 		}
-		f.index.Insert(args.Key, writePos)
+		if insertErr := f.index.Insert(args.Key, writePos); insertErr != nil {
+			log.Panic(insertErr)
+		}
 	} else {
 		log.Panic(findErr)
 	}
 	f.res = transfer.OkResult()
 }
 
+// delCommand marks record as deleted; record and its index entry are removed by vacuum
+// when no snapshot can see them anymore
 func (f *dataManipulationCommandState) delCommand(args transfer.Args) {
 	defer f.txProxy.Tx().DowngradeLocks()
-	pos, err := f.index.Delete(args.Key)
-	if err == bp_tree.ErrKeyNotFound {
+	if writes := f.txProxy.Writes(); writes != nil {
+		if !f.exists(writes, args.Key) {
+			f.res = transfer.ErrResult(bp_tree.ErrKeyNotFound)
+			return
+		}
+		writes.Delete(args.Key)
+		f.res = transfer.OkResult()
+		return
+	}
+	f.txProxy.Tx().LockKey(args.Key, true)
+	pos, findErr := f.index.FindLatched(args.Key, true)
+	if findErr == bp_tree.ErrKeyNotFound {
 		f.res = transfer.ErrResult(bp_tree.ErrKeyNotFound)
 		return
+	} else if findErr != nil {
+		log.Panic(findErr)
 	}
-	if delErr := f.da.DeleteAtPos(args.Key, pos); delErr != nil {
+	writePos, delErr := f.da.MarkDeletedAtPos(args.Key, pos)
+	if delErr == dataAdapter.ErrRecordNotFound {
+		f.res = transfer.ErrResult(bp_tree.ErrKeyNotFound)
+		return
+	} else if delErr != nil {
 		log.Panic(delErr)
 	}
+	if writePos != pos {
+		// record is moved to another page
+		if insertErr := f.index.Insert(args.Key, writePos); insertErr != nil {
+			log.Panic(insertErr)
+		}
+	}
 	f.res = transfer.OkResult()
 }
+
+// exists checks if key is visible to snapshot transaction taking its own changes into account
+func (f *dataManipulationCommandState) exists(writes *writeSet, key string) bool {
+	if value, found := writes.Get(key); found {
+		return value != nil
+	}
+	if reads := f.txProxy.Reads(); reads != nil {
+		reads.AddKey(key)
+	}
+	pos, findErr := f.index.FindLatched(key, false)
+	if findErr == bp_tree.ErrKeyNotFound {
+		return false
+	} else if findErr != nil {
+		log.Panic(findErr)
+	}
+	return f.da.ExistsAtPos(key, pos)
+}
+
+// existsLatched checks if record met by range command is visible to transaction; record may be moved
+// since leaf is read by iterator, so its page is found again
+func (f *dataManipulationCommandState) existsLatched(key string) bool {
+	pos, findErr := f.index.FindLatched(key, false)
+	if findErr == bp_tree.ErrKeyNotFound {
+		return false
+	} else if findErr != nil {
+		log.Panic(findErr)
+	}
+	return f.da.ExistsAtPos(key, pos)
+}
+
+// lockScanned locks key met by range command; if key is locked by another transaction,
+// then latch is released while waiting for lock, so iterator must be repositioned (false is returned)
+func (f *dataManipulationCommandState) lockScanned(key string) bool {
+	tx := f.txProxy.Tx()
+	if tx.TryLockKey(key) {
+		return true
+	}
+	tx.Unlatch()
+	tx.LockKey(key, false)
+	tx.Latch(false)
+	return false
+}
+
+func (f *dataManipulationCommandState) scanCommand(args transfer.Args) {
+	defer f.txProxy.Tx().DowngradeLocks()
+	f.txProxy.Tx().Latch(false)
+	end := string(args.Value)
+	pairs := make([]transfer.Pair, 0)
+	var iter keysIterator = f.index.Seek(args.Key)
+	if writes := f.txProxy.Writes(); writes != nil {
+		iter = newMergedIterator(iter, writes.Range(args.Key, end))
+	}
+	for args.Limit == 0 || len(pairs) < args.Limit {
+		key, pos, iterErr := iter.Next()
+		if iterErr == io.EOF || key >= end {
+			break
+		}
+		if pos != -1 && !f.lockScanned(key) {
+			// index may be changed while latch was released
+			iter = f.index.Seek(key)
+			continue
+		}
+		var data []byte
+		if pos == -1 {
+			// key is changed by snapshot transaction
+			if data, _ = f.txProxy.Writes().Get(key); data == nil {
+				continue
+			}
+		} else {
+			// record may be moved since leaf is read by iterator, so its page is found again
+			var findErr error
+			if pos, findErr = f.index.FindLatched(key, false); findErr == bp_tree.ErrKeyNotFound {
+				continue
+			} else if findErr != nil {
+				log.Panic(findErr)
+			}
+			if data, findErr = f.da.FindAtPos(key, pos); findErr == dataAdapter.ErrRecordNotFound {
+				continue
+			} else if findErr != nil {
+				log.Panic(findErr)
+			}
+		}
+		pairs = append(pairs, transfer.Pair{Key: key, Value: data})
+	}
+	if reads := f.txProxy.Reads(); reads != nil {
+		// keys after the last returned one are not read, if scan is stopped by limit
+		if args.Limit != 0 && len(pairs) == args.Limit {
+			end = pairs[len(pairs)-1].Key + "\x00"
+		}
+		reads.AddRange("", args.Key, end)
+	}
+	f.res = transfer.PairsResult(pairs)
+}
+
+func (f *dataManipulationCommandState) keysCommand(args transfer.Args) {
+	defer f.txProxy.Tx().DowngradeLocks()
+	f.txProxy.Tx().Latch(false)
+	limit := args.Limit
+	if limit == 0 || limit > maxKeysPageSize {
+		limit = maxKeysPageSize
+	}
+	after := string(args.Value)
+	keys := make([]string, 0)
+	var iter keysIterator = f.index.SeekPrefix(args.Key, after)
+	if writes := f.txProxy.Writes(); writes != nil {
+		iter = newMergedIterator(iter, writes.Prefixed(args.Key, after))
+	}
+	for len(keys) < limit {
+		key, pos, iterErr := iter.Next()
+		if iterErr == io.EOF {
+			break
+		}
+		// last seen key is passed by client to continue listing, so skip it
+		if key == after {
+			continue
+		}
+		if pos != -1 && !f.lockScanned(key) {
+			// index may be changed while latch was released
+			iter = f.index.SeekPrefix(args.Key, key)
+			continue
+		}
+		if pos == -1 {
+			// key is changed by snapshot transaction
+			if data, _ := f.txProxy.Writes().Get(key); data == nil {
+				continue
+			}
+		} else if !f.existsLatched(key) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if reads := f.txProxy.Reads(); reads != nil {
+		// keys after the last returned one are not read, if listing is stopped by limit
+		end := ""
+		if len(keys) == limit {
+			end = keys[len(keys)-1] + "\x00"
+		}
+		reads.AddRange(args.Key, after, end)
+	}
+	f.res = transfer.KeysResult(keys)
+}
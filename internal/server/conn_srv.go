@@ -3,6 +3,11 @@ package server
 import (
 	"bufio"
 	"dbms/internal/config"
+	"dbms/internal/core/concurrency"
+	"dbms/internal/core/logging"
+	"dbms/internal/core/raft"
+	"dbms/internal/core/recovery"
+	"dbms/internal/core/replication"
 	"dbms/internal/core/transaction"
 	"dbms/internal/parser"
 	"dbms/internal/transfer"
@@ -11,17 +16,33 @@ import (
 	"io"
 	"log"
 	"net"
+	"time"
 )
 
 // TxProxy handles tx lifecycle (init and finalization)
 type TxProxy struct {
 	txMgr *transaction.TxManager
+	cfg   *config.CoreConfig
 	tx    transaction.Tx
+	// lockTimeout is a lock timeout of transaction set by BEGIN; default timeout is used if it is zero
+	lockTimeout time.Duration
+	// writes buffers changes of snapshot transaction; it is nil for other modes
+	writes *writeSet
+	// reads keeps keys and ranges read by serializable transaction; it is nil for other modes
+	reads *readSet
+	// savedWrites keeps write sets of snapshot transaction as of its savepoints in savepoints order
+	savedWrites []savedWriteSet
 }
 
-func NewTxProxy(txMgr *transaction.TxManager) *TxProxy {
+type savedWriteSet struct {
+	name   string
+	writes *writeSet
+}
+
+func NewTxProxy(txMgr *transaction.TxManager, cfg *config.CoreConfig) *TxProxy {
 	p := new(TxProxy)
 	p.txMgr = txMgr
+	p.cfg = cfg
 	return p
 }
 
@@ -29,43 +50,219 @@ func (p *TxProxy) Tx() transaction.Tx {
 	return p.tx
 }
 
+// Writes returns changes of snapshot transaction; it is nil for other modes
+func (p *TxProxy) Writes() *writeSet {
+	return p.writes
+}
+
+// Reads returns keys and ranges read by serializable transaction; it is nil for other modes
+func (p *TxProxy) Reads() *readSet {
+	return p.reads
+}
+
 var (
-	ErrTxStarted = errors.New("tx is already started")
+	ErrTxStarted    = errors.New("tx is already started")
+	ErrTxNotStarted = errors.New("tx is not started")
 )
 
-func (p *TxProxy) Init(mode int) error {
+// Init starts transaction; transactions of proxy wait for each lock at most lockTimeout
+// (zero means default timeout)
+func (p *TxProxy) Init(mode int, lockTimeout time.Duration) error {
 	if p.tx != nil {
 		return ErrTxStarted
 	}
-	p.tx = p.txMgr.InitTx(mode)
+	if mode == concurrency.SerializableMode {
+		// serializable transaction is a snapshot one which reads are validated at commit
+		p.reads = newReadSet()
+		mode = concurrency.SnapshotMode
+	}
+	p.lockTimeout = lockTimeout
+	p.tx = p.initTx(mode)
+	if mode == concurrency.SnapshotMode {
+		p.writes = newWriteSet()
+	}
 	return nil
 }
 
-func (p *TxProxy) Commit() {
-	if p.tx != nil {
-		p.tx.Commit()
-		p.tx = nil
+// Commit finishes transaction; changes of snapshot transaction are applied here,
+// so commit fails if they conflict with changes committed after snapshot start
+func (p *TxProxy) Commit() error {
+	return p.commit(transaction.Tx.Commit)
+}
+
+// CommitNoWait finishes transaction as Commit, but doesn't wait for journal sync
+func (p *TxProxy) CommitNoWait() error {
+	return p.commit(transaction.Tx.CommitNoWait)
+}
+
+func (p *TxProxy) commit(commitTx func(transaction.Tx)) error {
+	if p.tx == nil {
+		return nil
+	}
+	var err error
+	if p.writes != nil {
+		var applyTx transaction.Tx
+		if applyTx, err = p.applyWrites(); applyTx != nil {
+			commitTx(applyTx)
+			err = applyTx.ReplicationErr()
+		}
 	}
+	commitTx(p.tx)
+	if err == nil {
+		err = p.tx.ReplicationErr()
+	}
+	p.reset()
+	return err
 }
 
 func (p *TxProxy) Abort() {
 	if p.tx != nil {
 		p.tx.Abort()
-		p.tx = nil
+		p.reset()
+	}
+}
+
+// Prepare makes transaction changes durable and detaches transaction from connection, so it can be finished
+// by external coordinator from any connection; changes of snapshot transaction are applied and prepared
+// within exclusive transaction, which holds their keys locks, and snapshot itself is finished
+// (reads of serializable transaction are validated at prepare)
+func (p *TxProxy) Prepare(gid string) error {
+	if p.tx == nil {
+		return ErrTxNotStarted
+	}
+	tx := p.tx
+	var err error
+	if p.writes != nil {
+		if tx, err = p.applyWrites(); err == nil && tx == nil {
+			// prepared transaction is kept even without changes, because coordinator finishes it
+			tx = p.initTx(concurrency.ExclusiveMode)
+		}
+		p.tx.Commit()
+	}
+	if err == nil {
+		if err = tx.Prepare(gid); err != nil {
+			tx.Abort()
+		}
+	}
+	p.reset()
+	return err
+}
+
+func (p *TxProxy) CommitPrepared(gid string) error {
+	if p.tx != nil {
+		return ErrTxStarted
 	}
+	return p.txMgr.CommitPrepared(gid)
+}
+
+func (p *TxProxy) RollbackPrepared(gid string) error {
+	if p.tx != nil {
+		return ErrTxStarted
+	}
+	return p.txMgr.RollbackPrepared(gid)
+}
+
+// initTx starts transaction with lock timeout of proxy; snapshot transaction applies its changes by exclusive one
+// started this way
+func (p *TxProxy) initTx(mode int) transaction.Tx {
+	tx := p.txMgr.InitTx(mode)
+	if p.lockTimeout > 0 {
+		tx.SetLockTimeout(p.lockTimeout)
+	}
+	return tx
+}
+
+func (p *TxProxy) reset() {
+	p.tx = nil
+	p.lockTimeout = 0
+	p.writes = nil
+	p.reads = nil
+	p.savedWrites = nil
+}
+
+// Savepoint marks transaction state; changes of snapshot transaction are buffered,
+// so its write set is saved, and other transactions keep images of changed keys
+func (p *TxProxy) Savepoint(name string) error {
+	if p.tx == nil {
+		return ErrTxNotStarted
+	}
+	p.tx.Savepoint(name)
+	if p.writes != nil {
+		p.savedWrites = append(p.savedWrites, savedWriteSet{name, p.writes.Copy()})
+	}
+	return nil
+}
+
+func (p *TxProxy) RollbackTo(name string) error {
+	if p.tx == nil {
+		return ErrTxNotStarted
+	}
+	defer p.tx.DowngradeLocks()
+	if err := p.tx.RollbackTo(name); err != nil {
+		return err
+	}
+	if idx := p.findSavedWrites(name); idx != -1 {
+		// savepoint is kept, so saved write set stays untouched for the next rollback
+		p.writes = p.savedWrites[idx].writes.Copy()
+		p.savedWrites = p.savedWrites[:idx+1]
+	}
+	return nil
+}
+
+func (p *TxProxy) Release(name string) error {
+	if p.tx == nil {
+		return ErrTxNotStarted
+	}
+	if err := p.tx.Release(name); err != nil {
+		return err
+	}
+	if idx := p.findSavedWrites(name); idx != -1 {
+		p.savedWrites = p.savedWrites[:idx]
+	}
+	return nil
+}
+
+func (p *TxProxy) findSavedWrites(name string) int {
+	for i := len(p.savedWrites) - 1; i >= 0; i-- {
+		if p.savedWrites[i].name == name {
+			return i
+		}
+	}
+	return -1
 }
 
 type ConnServer struct {
-	cfg    *config.ServerConfig
-	parser parser.Parser
-	txMgr  *transaction.TxManager
+	cfg     *config.ServerConfig
+	coreCfg *config.CoreConfig
+	parser  parser.Parser
+	txMgr   *transaction.TxManager
+	logMgr  *logging.LogManager
+	// replica is nil if server is a primary
+	replica  *recovery.Replica
+	replicas *replication.Replicas
+	// node is nil if server is not a node of cluster
+	node *raft.Node
 }
 
-func NewConnServer(cfg *config.ServerConfig, parser parser.Parser, txMgr *transaction.TxManager) *ConnServer {
+func NewConnServer(
+	cfg *config.ServerConfig,
+	coreCfg *config.CoreConfig,
+	parser parser.Parser,
+	txMgr *transaction.TxManager,
+	logMgr *logging.LogManager,
+	replica *recovery.Replica,
+	replicas *replication.Replicas,
+	node *raft.Node,
+) *ConnServer {
 	s := new(ConnServer)
 	s.cfg = cfg
+	s.coreCfg = coreCfg
 	s.parser = parser
 	s.txMgr = txMgr
+	s.logMgr = logMgr
+	s.replica = replica
+	s.replicas = replicas
+	s.node = node
 	return s
 }
 
@@ -108,9 +305,9 @@ func (i *CmdIterator) Next() (*transfer.Cmd, error) {
 }
 
 func (s *ConnServer) serve(conn net.Conn) {
-	txProxy := NewTxProxy(s.txMgr)
+	txProxy := NewTxProxy(s.txMgr, s.coreCfg)
 	defer txProxy.Abort()
-	cmdFact := NewCommandFactory(txProxy)
+	cmdFact := NewCommandFactory(txProxy, s.coreCfg, s.replica, s.replicas, s.node)
 	reader := bufio.NewReader(conn)
 	writer := bufio.NewWriter(conn)
 	recv := transfer.NewLEObjectReader(reader)
@@ -123,6 +320,11 @@ func (s *ConnServer) serve(conn net.Conn) {
 		} else if err != nil {
 			log.Panic(err)
 		}
+		if cmd.Type == transfer.ReplicateCmdType {
+			// connection is dedicated to shipment till replica disconnects
+			s.ship(*cmd, conn.RemoteAddr().String(), recv, send, writer)
+			return
+		}
 		res := cmdFact.Create(*cmd)()
 		resObj := new(transfer.ResultObject)
 		resObj.FromResult(res)
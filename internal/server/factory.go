@@ -3,6 +3,8 @@ package server
 import (
 	"dbms/internal/config"
 	"dbms/internal/core"
+	"dbms/internal/core/raft"
+	"dbms/internal/core/recovery"
 	"dbms/internal/parser"
 )
 
@@ -26,9 +28,22 @@ func NewDefaultDBMSServerFactory(
 }
 
 func (c *DefaultDBMSServerFactory) ConnSrv() *ConnServer {
+	var replica *recovery.Replica
+	if c.coreFactory.CoreCfg().ReplicaOf != "" {
+		replica = c.coreFactory.Replica()
+	}
+	var node *raft.Node
+	if len(c.coreFactory.CoreCfg().Cluster) != 0 {
+		node = c.coreFactory.RaftNode()
+	}
 	return NewConnServer(
 		c.cfg,
+		c.coreFactory.CoreCfg(),
 		parser.NewDumbSingleLineParser(),
 		c.coreFactory.TxMgr(),
+		c.coreFactory.LogMgr(),
+		replica,
+		c.coreFactory.Replicas(),
+		node,
 	)
 }
@@ -0,0 +1,29 @@
+package server
+
+// keyRange is a set of keys with prefix from [start, end) interval; empty end means no upper bound
+type keyRange struct {
+	prefix string
+	start  string
+	end    string
+}
+
+// readSet keeps keys and ranges read by serializable transaction;
+// ranges are kept to detect phantoms (keys inserted into range after snapshot start)
+type readSet struct {
+	keys   map[string]struct{}
+	ranges []keyRange
+}
+
+func newReadSet() *readSet {
+	s := new(readSet)
+	s.keys = make(map[string]struct{})
+	return s
+}
+
+func (s *readSet) AddKey(key string) {
+	s.keys[key] = struct{}{}
+}
+
+func (s *readSet) AddRange(prefix string, start string, end string) {
+	s.ranges = append(s.ranges, keyRange{prefix, start, end})
+}
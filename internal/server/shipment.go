@@ -0,0 +1,105 @@
+package server
+
+import (
+	"bufio"
+	"dbms/internal/core/logging"
+	"dbms/internal/core/replication"
+	"dbms/internal/transfer"
+	"encoding/binary"
+	"log"
+	"time"
+)
+
+const (
+	// shipmentSize bounds size of frames sent to replica at once
+	shipmentSize = 256 << 10
+	// shipmentPollInterval is a pause between reads of journal which has no new flushed frames
+	shipmentPollInterval = 10 * time.Millisecond
+	// heartbeatInterval is a period of empty shipments, which keep replica lag fresh while journal is idle
+	heartbeatInterval = time.Second
+)
+
+// receiveAcks registers acknowledgements sent by replica; returned channel is closed when replica disconnects
+func (s *ConnServer) receiveAcks(conn *replication.ReplicaConn, recv transfer.ObjectReader) <-chan struct{} {
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		cmdIter := CmdIterator{recv}
+		for {
+			cmd, err := cmdIter.Next()
+			if err != nil {
+				return
+			}
+			if cmd.Type == transfer.ReplicaAckCmdType && len(cmd.Value) == 8 {
+				s.replicas.Ack(conn, int64(binary.LittleEndian.Uint64(cmd.Value)))
+			}
+		}
+	}()
+	return closed
+}
+
+// ship streams flushed journal frames starting from position passed by replicate command to replica till
+// it disconnects; replica journal repeats the shipped one, so replica may ship it too
+func (s *ConnServer) ship(
+	cmd transfer.Cmd,
+	addr string,
+	recv transfer.ObjectReader,
+	send transfer.ObjectWriter,
+	writer *bufio.Writer,
+) {
+	sendResult := func(res *transfer.Result) bool {
+		resObj := new(transfer.ResultObject)
+		resObj.FromResult(res)
+		if err := send.WriteObject(resObj); err != nil {
+			log.Printf("Shipment failed: %v", err)
+			return false
+		}
+		if err := writer.Flush(); err != nil {
+			log.Printf("Shipment failed: %v", err)
+			return false
+		}
+		return true
+	}
+	var pos logging.LogPos
+	if err := pos.UnmarshalBinary(cmd.Value); err != nil {
+		sendResult(transfer.ErrResult(err))
+		return
+	}
+	name := cmd.Key
+	if name == "" {
+		name = addr
+	}
+	conn := s.replicas.Register(name, addr)
+	defer s.replicas.Unregister(conn)
+	closed := s.receiveAcks(conn, recv)
+	log.Printf("Shipment of journal to replica %s starts at %d:%d", name, pos.SegId, pos.Offset)
+	var sentAt time.Time
+	for {
+		// commit waiting for replicas wakes shipment, so its records aren't delayed by polling
+		flushed := s.replicas.Flushed()
+		shipment, err := s.logMgr.ReadFrames(pos, shipmentSize)
+		if err != nil {
+			sendResult(transfer.ErrResult(err))
+			return
+		}
+		if len(shipment.Data) == 0 && time.Since(sentAt) < heartbeatInterval {
+			select {
+			case <-closed:
+				return
+			case <-flushed:
+			case <-time.After(shipmentPollInterval):
+			}
+			continue
+		}
+		data, err := shipment.MarshalBinary()
+		if err != nil {
+			log.Panic(err)
+		}
+		if !sendResult(transfer.ValueResult(data)) {
+			return
+		}
+		s.replicas.Shipped(shipment.EndLsn)
+		sentAt = time.Now()
+		pos = shipment.Next()
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"dbms/internal/core/access/bp_tree"
+	"dbms/internal/core/concurrency"
+	bpAdapter "dbms/internal/core/storage/adapters/bp_tree"
+	dataAdapter "dbms/internal/core/storage/adapters/data"
+	"dbms/internal/core/transaction"
+	"errors"
+	"io"
+	"log"
+)
+
+var (
+	ErrWriteConflict = errors.New("could not serialize access due to concurrent update")
+)
+
+// applyWrites writes changes of snapshot transaction within exclusive transaction, which is returned
+// uncommitted (nil is returned if there are no changes), so it can be either committed or prepared; key which is changed after snapshot start by another transaction is a conflict (first committer wins);
+// reads of serializable transaction are validated the same way, so it is equivalent to a serial execution
+// at commit point; read-only transactions are not validated, because snapshot is a prefix of commits order
+func (p *TxProxy) applyWrites() (applyTx transaction.Tx, err error) {
+	if p.writes.Empty() {
+		return nil, nil
+	}
+	tx := p.initTx(concurrency.ExclusiveMode)
+	defer func() {
+		if recErr := recover(); recErr == concurrency.ErrTxLockTimeout || recErr == concurrency.ErrDeadlock {
+			tx.Abort()
+			applyTx, err = nil, recErr.(error)
+		} else if recErr != nil {
+			log.Panic(recErr)
+		}
+	}()
+	keys := p.writes.Keys()
+	// keys are sorted, so concurrent commits lock them in the same order;
+	// all of them are locked before pages are latched
+	for _, key := range keys {
+		tx.LockKey(key, true)
+	}
+	index := bp_tree.NewDefaultBPTree(bpAdapter.NewBPTreeAdapter(tx, p.cfg.MaxKeyLength))
+	da := dataAdapter.NewDataAdapter(tx)
+	if p.reads != nil && !p.validateReads(index, da) {
+		tx.Abort()
+		return nil, ErrWriteConflict
+	}
+	for _, key := range keys {
+		value, _ := p.writes.Get(key)
+		pos, findErr := index.FindLatched(key, true)
+		if findErr == bp_tree.ErrKeyNotFound {
+			if value == nil {
+				continue
+			}
+			writePos, writeErr := da.Write(key, value)
+			if writeErr != nil {
+				log.Panic(writeErr)
+			}
+			if insertErr := index.Insert(key, writePos); insertErr != nil {
+				log.Panic(insertErr)
+			}
+			continue
+		} else if findErr != nil {
+			log.Panic(findErr)
+		}
+		if !p.changedBefore(da, key, pos) {
+			tx.Abort()
+			return nil, ErrWriteConflict
+		}
+		var writePos int64
+		var writeErr error
+		if value == nil {
+			writePos, writeErr = da.MarkDeletedAtPos(key, pos)
+			if writeErr == dataAdapter.ErrRecordNotFound {
+				// key is created and deleted by snapshot transaction itself
+				continue
+			}
+		} else {
+			writePos, writeErr = da.WriteAtPos(key, value, pos)
+		}
+		if writeErr != nil {
+			log.Panic(writeErr)
+		}
+		if writePos != pos {
+			// record is moved to another page
+			if insertErr := index.Insert(key, writePos); insertErr != nil {
+				log.Panic(insertErr)
+			}
+		}
+	}
+	return tx, nil
+}
+
+// validateReads checks keys and ranges read by transaction are not changed after snapshot start
+func (p *TxProxy) validateReads(index *bp_tree.BPTree, da *dataAdapter.DataAdapter) bool {
+	for key := range p.reads.keys {
+		pos, findErr := index.FindLatched(key, false)
+		if findErr == bp_tree.ErrKeyNotFound {
+			continue
+		} else if findErr != nil {
+			log.Panic(findErr)
+		}
+		if !p.changedBefore(da, key, pos) {
+			return false
+		}
+	}
+	for _, r := range p.reads.ranges {
+		iter := index.SeekPrefix(r.prefix, r.start)
+		for {
+			key, _, iterErr := iter.Next()
+			if iterErr == io.EOF || (r.end != "" && key >= r.end) {
+				break
+			}
+			// record may be moved since leaf is read by iterator, so its page is found again
+			pos, findErr := index.FindLatched(key, false)
+			if findErr == bp_tree.ErrKeyNotFound {
+				continue
+			} else if findErr != nil {
+				log.Panic(findErr)
+			}
+			// key inserted into range is a phantom, its version isn't visible to snapshot too
+			if !p.changedBefore(da, key, pos) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// changedBefore checks if the latest version of record is visible to snapshot
+func (p *TxProxy) changedBefore(da *dataAdapter.DataAdapter, key string, pos int64) bool {
+	headTxId, headErr := da.HeadTxId(key, pos)
+	if headErr != nil {
+		log.Panic(headErr)
+	}
+	return p.tx.Visible(headTxId)
+}
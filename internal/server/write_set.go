@@ -0,0 +1,118 @@
+package server
+
+import (
+	"io"
+	"sort"
+	"strings"
+)
+
+// writeSet buffers changes of snapshot transaction until commit;
+// nil value marks deleted key
+type writeSet struct {
+	values map[string][]byte
+}
+
+func newWriteSet() *writeSet {
+	s := new(writeSet)
+	s.values = make(map[string][]byte)
+	return s
+}
+
+func (s *writeSet) Set(key string, value []byte) {
+	if value == nil {
+		value = []byte{}
+	}
+	s.values[key] = value
+}
+
+func (s *writeSet) Delete(key string) {
+	s.values[key] = nil
+}
+
+// Get returns buffered value; found is false if key isn't changed by transaction
+func (s *writeSet) Get(key string) (value []byte, found bool) {
+	value, found = s.values[key]
+	return
+}
+
+// Copy returns independent copy of write set; values are not changed in place, so they are shared
+func (s *writeSet) Copy() *writeSet {
+	c := newWriteSet()
+	for key, value := range s.values {
+		c.values[key] = value
+	}
+	return c
+}
+
+func (s *writeSet) Empty() bool {
+	return len(s.values) == 0
+}
+
+// Keys returns changed keys in ascending order
+func (s *writeSet) Keys() []string {
+	return s.filter(func(string) bool { return true })
+}
+
+// Range returns changed keys from [start, end) interval in ascending order
+func (s *writeSet) Range(start string, end string) []string {
+	return s.filter(func(key string) bool { return key >= start && key < end })
+}
+
+// Prefixed returns changed keys with prefix which are not less than start in ascending order
+func (s *writeSet) Prefixed(prefix string, start string) []string {
+	return s.filter(func(key string) bool { return strings.HasPrefix(key, prefix) && key >= start })
+}
+
+func (s *writeSet) filter(accept func(key string) bool) []string {
+	keys := make([]string, 0)
+	for key := range s.values {
+		if accept(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type keysIterator interface {
+	Next() (string, int64, error)
+}
+
+// mergedIterator walks index keys together with keys changed by transaction in ascending order;
+// changed keys are returned with -1 position, so their values are taken from write set
+type mergedIterator struct {
+	iter    keysIterator
+	changed []string
+	key     string
+	pos     int64
+	err     error
+	loaded  bool
+}
+
+func newMergedIterator(iter keysIterator, changed []string) *mergedIterator {
+	i := new(mergedIterator)
+	i.iter = iter
+	i.changed = changed
+	return i
+}
+
+func (i *mergedIterator) Next() (string, int64, error) {
+	if !i.loaded {
+		i.key, i.pos, i.err = i.iter.Next()
+		i.loaded = true
+	}
+	if len(i.changed) != 0 && (i.err == io.EOF || i.changed[0] <= i.key) {
+		key := i.changed[0]
+		i.changed = i.changed[1:]
+		if i.err == nil && key == i.key {
+			// index entry is shadowed by change
+			i.loaded = false
+		}
+		return key, -1, nil
+	}
+	if i.err != nil {
+		return "", -1, i.err
+	}
+	i.loaded = false
+	return i.key, i.pos, nil
+}
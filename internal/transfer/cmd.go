@@ -3,6 +3,9 @@ package transfer
 type Args struct {
 	Key   string
 	Value []byte
+	// Limit bounds the number of entries returned by range commands; 0 means no limit;
+	// begin commands pass lock timeout in milliseconds by it (0 means default timeout)
+	Limit int
 }
 
 type Cmd struct {
@@ -19,6 +22,41 @@ const (
 	CommitCmdType = 5
 	AbortCmdType  = 6
 	HelpCmdType   = 7
+	ScanCmdType   = 8
+	KeysCmdType   = 9
+	BegSnCmdType  = 10
+	BegSrCmdType  = 11
+	// savepoints commands pass savepoint name as key
+	SavepointCmdType  = 12
+	RollbackToCmdType = 13
+	ReleaseCmdType    = 14
+	// two-phase commit commands pass global transaction id as key
+	PrepareCmdType          = 15
+	CommitPreparedCmdType   = 16
+	RollbackPreparedCmdType = 17
+	ListPreparedCmdType     = 18
+	// CommitNoWaitCmdType commits without waiting for journal sync
+	CommitNoWaitCmdType = 19
+	// BackupCmdType passes backup directory as key
+	BackupCmdType = 20
+	// IncrementalBackupCmdType passes backup directory as key and base backup directory as value
+	IncrementalBackupCmdType = 21
+	// ReplicateCmdType passes replica name as key and journal position as value; server streams journal
+	// starting from it to connection
+	ReplicateCmdType = 22
+	// ReplicationLagCmdType requests lag of replica behind primary
+	ReplicationLagCmdType = 23
+	// ReplicaAckCmdType passes lsn of the last record durably received by replica as value; replica sends it
+	// to replication connection
+	ReplicaAckCmdType = 24
+	// ReplicasCmdType requests status of replicas connected to primary
+	ReplicasCmdType = 25
+	// RaftCmdType passes message of raft node as value; it is sent between nodes of cluster
+	RaftCmdType = 26
+	// ClusterCmdType requests status of cluster node
+	ClusterCmdType = 27
+	// StatsCmdType requests statistics of group commit
+	StatsCmdType = 28
 )
 
 func GetCmd(key string) Cmd {
@@ -49,6 +87,31 @@ func DelCmd(key string) Cmd {
 	}
 }
 
+// ScanCmd creates range scan command over [start, end) keys interval
+func ScanCmd(start string, end string, limit int) Cmd {
+	return Cmd{
+		Type: ScanCmdType,
+		Args: Args{
+			Key:   start,
+			Value: []byte(end),
+			Limit: limit,
+		},
+	}
+}
+
+// KeysCmd creates command listing keys with passed prefix;
+// listing is paged: only keys greater than after are returned
+func KeysCmd(prefix string, after string, limit int) Cmd {
+	return Cmd{
+		Type: KeysCmdType,
+		Args: Args{
+			Key:   prefix,
+			Value: []byte(after),
+			Limit: limit,
+		},
+	}
+}
+
 func BegShCmd() Cmd {
 	return Cmd{
 		Type: BegShCmdType,
@@ -61,47 +124,258 @@ func BegExCmd() Cmd {
 	}
 }
 
+func BegSnCmd() Cmd {
+	return Cmd{
+		Type: BegSnCmdType,
+	}
+}
+
+func BegSrCmd() Cmd {
+	return Cmd{
+		Type: BegSrCmdType,
+	}
+}
+
+// BeginCmd creates begin command of cmdType (one of Beg*CmdType) which transaction waits for each lock
+// at most timeoutMs milliseconds
+func BeginCmd(cmdType int, timeoutMs int) Cmd {
+	return Cmd{
+		Type: cmdType,
+		Args: Args{
+			Limit: timeoutMs,
+		},
+	}
+}
+
 func CommitCmd() Cmd {
 	return Cmd{
 		Type: CommitCmdType,
 	}
 }
 
+func CommitNoWaitCmd() Cmd {
+	return Cmd{
+		Type: CommitNoWaitCmdType,
+	}
+}
+
 func AbortCmd() Cmd {
 	return Cmd{
 		Type: AbortCmdType,
 	}
 }
 
+func SavepointCmd(name string) Cmd {
+	return Cmd{
+		Type: SavepointCmdType,
+		Args: Args{
+			Key: name,
+		},
+	}
+}
+
+func RollbackToCmd(name string) Cmd {
+	return Cmd{
+		Type: RollbackToCmdType,
+		Args: Args{
+			Key: name,
+		},
+	}
+}
+
+func ReleaseCmd(name string) Cmd {
+	return Cmd{
+		Type: ReleaseCmdType,
+		Args: Args{
+			Key: name,
+		},
+	}
+}
+
+func PrepareCmd(gid string) Cmd {
+	return Cmd{
+		Type: PrepareCmdType,
+		Args: Args{
+			Key: gid,
+		},
+	}
+}
+
+func CommitPreparedCmd(gid string) Cmd {
+	return Cmd{
+		Type: CommitPreparedCmdType,
+		Args: Args{
+			Key: gid,
+		},
+	}
+}
+
+func RollbackPreparedCmd(gid string) Cmd {
+	return Cmd{
+		Type: RollbackPreparedCmdType,
+		Args: Args{
+			Key: gid,
+		},
+	}
+}
+
+func ListPreparedCmd() Cmd {
+	return Cmd{
+		Type: ListPreparedCmdType,
+	}
+}
+
+func BackupCmd(path string) Cmd {
+	return Cmd{
+		Type: BackupCmdType,
+		Args: Args{
+			Key: path,
+		},
+	}
+}
+
+func IncrementalBackupCmd(path string, base string) Cmd {
+	return Cmd{
+		Type: IncrementalBackupCmdType,
+		Args: Args{
+			Key:   path,
+			Value: []byte(base),
+		},
+	}
+}
+
+func ReplicateCmd(name string, pos []byte) Cmd {
+	return Cmd{
+		Type: ReplicateCmdType,
+		Args: Args{
+			Key:   name,
+			Value: pos,
+		},
+	}
+}
+
+func ReplicaAckCmd(lsn []byte) Cmd {
+	return Cmd{
+		Type: ReplicaAckCmdType,
+		Args: Args{
+			Value: lsn,
+		},
+	}
+}
+
+func ReplicasCmd() Cmd {
+	return Cmd{
+		Type: ReplicasCmdType,
+	}
+}
+
+func ReplicationLagCmd() Cmd {
+	return Cmd{
+		Type: ReplicationLagCmdType,
+	}
+}
+
+func RaftCmd(msg []byte) Cmd {
+	return Cmd{
+		Type: RaftCmdType,
+		Args: Args{
+			Value: msg,
+		},
+	}
+}
+
+func ClusterCmd() Cmd {
+	return Cmd{
+		Type: ClusterCmdType,
+	}
+}
+
+func StatsCmd() Cmd {
+	return Cmd{
+		Type: StatsCmdType,
+	}
+}
+
 func HelpCmd() Cmd {
 	return Cmd{
 		Type: HelpCmdType,
 	}
 }
 
-type cmdBuilder func(string, []byte) Cmd
+type cmdBuilder func(string, []byte, int) Cmd
 
 func noArgsDecorator(f func() Cmd) cmdBuilder {
-	return func(_ string, _ []byte) Cmd {
+	return func(_ string, _ []byte, _ int) Cmd {
 		return f()
 	}
 }
 
 func keyArgDecorator(f func(string) Cmd) cmdBuilder {
-	return func(key string, _ []byte) Cmd {
+	return func(key string, _ []byte, _ int) Cmd {
 		return f(key)
 	}
 }
 
+func keyValueArgsDecorator(f func(string, []byte) Cmd) cmdBuilder {
+	return func(key string, value []byte, _ int) Cmd {
+		return f(key, value)
+	}
+}
+
+func valueArgDecorator(f func([]byte) Cmd) cmdBuilder {
+	return func(_ string, value []byte, _ int) Cmd {
+		return f(value)
+	}
+}
+
+func pathArgsDecorator(f func(string, string) Cmd) cmdBuilder {
+	return func(path string, base []byte, _ int) Cmd {
+		return f(path, string(base))
+	}
+}
+
+func beginArgsDecorator(cmdType int) cmdBuilder {
+	return func(_ string, _ []byte, timeoutMs int) Cmd {
+		return BeginCmd(cmdType, timeoutMs)
+	}
+}
+
+func rangeArgsDecorator(f func(string, string, int) Cmd) cmdBuilder {
+	return func(start string, end []byte, limit int) Cmd {
+		return f(start, string(end), limit)
+	}
+}
+
 var cmdMap = map[int]cmdBuilder{
-	GetCmdType:    keyArgDecorator(GetCmd),
-	SetCmdType:    SetCmd,
-	DelCmdType:    keyArgDecorator(DelCmd),
-	BegShCmdType:  noArgsDecorator(BegShCmd),
-	BegExCmdType:  noArgsDecorator(BegExCmd),
-	CommitCmdType: noArgsDecorator(CommitCmd),
-	AbortCmdType:  noArgsDecorator(AbortCmd),
-	HelpCmdType:   noArgsDecorator(HelpCmd),
+	GetCmdType:               keyArgDecorator(GetCmd),
+	SetCmdType:               keyValueArgsDecorator(SetCmd),
+	DelCmdType:               keyArgDecorator(DelCmd),
+	BegShCmdType:             beginArgsDecorator(BegShCmdType),
+	BegExCmdType:             beginArgsDecorator(BegExCmdType),
+	BegSnCmdType:             beginArgsDecorator(BegSnCmdType),
+	BegSrCmdType:             beginArgsDecorator(BegSrCmdType),
+	CommitCmdType:            noArgsDecorator(CommitCmd),
+	CommitNoWaitCmdType:      noArgsDecorator(CommitNoWaitCmd),
+	AbortCmdType:             noArgsDecorator(AbortCmd),
+	HelpCmdType:              noArgsDecorator(HelpCmd),
+	ScanCmdType:              rangeArgsDecorator(ScanCmd),
+	KeysCmdType:              rangeArgsDecorator(KeysCmd),
+	SavepointCmdType:         keyArgDecorator(SavepointCmd),
+	RollbackToCmdType:        keyArgDecorator(RollbackToCmd),
+	ReleaseCmdType:           keyArgDecorator(ReleaseCmd),
+	PrepareCmdType:           keyArgDecorator(PrepareCmd),
+	CommitPreparedCmdType:    keyArgDecorator(CommitPreparedCmd),
+	RollbackPreparedCmdType:  keyArgDecorator(RollbackPreparedCmd),
+	ListPreparedCmdType:      noArgsDecorator(ListPreparedCmd),
+	BackupCmdType:            keyArgDecorator(BackupCmd),
+	IncrementalBackupCmdType: pathArgsDecorator(IncrementalBackupCmd),
+	ReplicateCmdType:         keyValueArgsDecorator(ReplicateCmd),
+	ReplicationLagCmdType:    noArgsDecorator(ReplicationLagCmd),
+	ReplicaAckCmdType:        valueArgDecorator(ReplicaAckCmd),
+	ReplicasCmdType:          noArgsDecorator(ReplicasCmd),
+	RaftCmdType:              valueArgDecorator(RaftCmd),
+	ClusterCmdType:           noArgsDecorator(ClusterCmd),
+	StatsCmdType:             noArgsDecorator(StatsCmd),
 }
 
 func CmdFactory(cmdType int) cmdBuilder {
@@ -50,7 +50,8 @@ func (or *LEObjectReader) ReadObject(obj Object) error {
 	}
 	size := int(hdr.Size)
 	body := make([]byte, size, size)
-	if _, err := or.r.Read(body); err != nil {
+	// body may exceed reader's buffer, so single read is not enough
+	if _, err := io.ReadFull(or.r, body); err != nil {
 		return err
 	}
 	obj.Create(body)
@@ -94,6 +95,7 @@ type CmdObject struct {
 	cmdType byte
 	key     []byte
 	value   []byte
+	limit   uint32
 }
 
 func (o *CmdObject) Header() header {
@@ -108,6 +110,7 @@ func (o *CmdObject) Body() []byte {
 	mustDumpValueToBuffer(buf, o.cmdType)
 	mustDumpBytesToBuffer(buf, o.key)
 	mustDumpBytesToBuffer(buf, o.value)
+	mustDumpValueToBuffer(buf, o.limit)
 	return buf.Bytes()
 }
 
@@ -135,17 +138,19 @@ func (o *CmdObject) Create(data []byte) {
 	mustReadValueFromBuffer(buf, &o.cmdType)
 	o.key = mustReadBytesFromBuffer(buf)
 	o.value = mustReadBytesFromBuffer(buf)
+	mustReadValueFromBuffer(buf, &o.limit)
 }
 
 func (o *CmdObject) FromCmd(c Cmd) {
 	o.cmdType = byte(c.Type)
 	o.key = []byte(c.Key)
 	o.value = c.Value
+	o.limit = uint32(c.Limit)
 }
 
 func (o *CmdObject) ToCmd() Cmd {
 	builder := CmdFactory(int(o.cmdType))
-	return builder(string(o.key), o.value)
+	return builder(string(o.key), o.value, int(o.limit))
 }
 
 type ResultObject struct {
@@ -181,6 +186,15 @@ func (o *ResultObject) FromResult(r *Result) {
 	if r.Type() == ErrResultCode {
 		o.value = []byte(r.Error())
 	}
+	if r.Type() == PairsResultCode {
+		o.value = marshalPairs(r.Pairs())
+	}
+	if r.Type() == KeysResultCode {
+		o.value = marshalKeys(r.Keys())
+	}
+	if r.Type() == RedirectResultCode {
+		o.value = []byte(r.Leader())
+	}
 }
 
 func (o *ResultObject) ToResult() *Result {
@@ -191,3 +205,45 @@ func (o *ResultObject) ToResult() *Result {
 	}
 	return builder(o.value)
 }
+
+func marshalPairs(pairs []Pair) []byte {
+	buf := new(bytes.Buffer)
+	mustDumpValueToBuffer(buf, uint32(len(pairs)))
+	for _, p := range pairs {
+		mustDumpBytesToBuffer(buf, []byte(p.Key))
+		mustDumpBytesToBuffer(buf, p.Value)
+	}
+	return buf.Bytes()
+}
+
+func unmarshalPairs(data []byte) []Pair {
+	buf := bytes.NewReader(data)
+	var pairsCount uint32
+	mustReadValueFromBuffer(buf, &pairsCount)
+	pairs := make([]Pair, pairsCount, pairsCount)
+	for i := range pairs {
+		pairs[i].Key = string(mustReadBytesFromBuffer(buf))
+		pairs[i].Value = mustReadBytesFromBuffer(buf)
+	}
+	return pairs
+}
+
+func marshalKeys(keys []string) []byte {
+	buf := new(bytes.Buffer)
+	mustDumpValueToBuffer(buf, uint32(len(keys)))
+	for _, key := range keys {
+		mustDumpBytesToBuffer(buf, []byte(key))
+	}
+	return buf.Bytes()
+}
+
+func unmarshalKeys(data []byte) []string {
+	buf := bytes.NewReader(data)
+	var keysCount uint32
+	mustReadValueFromBuffer(buf, &keysCount)
+	keys := make([]string, keysCount, keysCount)
+	for i := range keys {
+		keys[i] = string(mustReadBytesFromBuffer(buf))
+	}
+	return keys
+}
@@ -31,3 +31,57 @@ func TestObject_Result(t *testing.T) {
 	input.ReadObject(otherResObj)
 	assert.Equal(t, otherResObj.ToResult(), res)
 }
+
+func TestObject_ScanCmd(t *testing.T) {
+	data := make([]byte, 128, 128)
+	cmd := ScanCmd("A", "Z", 10)
+	cmdObj := new(CmdObject)
+	cmdObj.FromCmd(cmd)
+	output := LEObjectWriter{bytes.NewBuffer(data[0:0])}
+	output.WriteObject(cmdObj)
+	otherCmdObj := new(CmdObject)
+	input := LEObjectReader{bytes.NewReader(data)}
+	input.ReadObject(otherCmdObj)
+	assert.Equal(t, otherCmdObj.ToCmd(), cmd)
+}
+
+func TestObject_PairsResult(t *testing.T) {
+	data := make([]byte, 128, 128)
+	res := PairsResult([]Pair{{"A", []byte("1")}, {"B", []byte("2")}})
+	resObj := new(ResultObject)
+	resObj.FromResult(res)
+	output := LEObjectWriter{bytes.NewBuffer(data[0:0])}
+	output.WriteObject(resObj)
+	otherResObj := new(ResultObject)
+	input := LEObjectReader{bytes.NewReader(data)}
+	input.ReadObject(otherResObj)
+	assert.Equal(t, otherResObj.ToResult(), res)
+}
+
+func TestObject_KeysResult(t *testing.T) {
+	data := make([]byte, 128, 128)
+	res := KeysResult([]string{"A", "B"})
+	resObj := new(ResultObject)
+	resObj.FromResult(res)
+	output := LEObjectWriter{bytes.NewBuffer(data[0:0])}
+	output.WriteObject(resObj)
+	otherResObj := new(ResultObject)
+	input := LEObjectReader{bytes.NewReader(data)}
+	input.ReadObject(otherResObj)
+	assert.Equal(t, otherResObj.ToResult(), res)
+}
+
+func TestObject_RedirectResult(t *testing.T) {
+	for _, res := range []*Result{RedirectResult("node1:8081"), RedirectResult("")} {
+		data := make([]byte, 128, 128)
+		resObj := new(ResultObject)
+		resObj.FromResult(res)
+		output := LEObjectWriter{bytes.NewBuffer(data[0:0])}
+		output.WriteObject(resObj)
+		otherResObj := new(ResultObject)
+		input := LEObjectReader{bytes.NewReader(data)}
+		input.ReadObject(otherResObj)
+		assert.Equal(t, otherResObj.ToResult(), res)
+		assert.False(t, res.Ok())
+	}
+}
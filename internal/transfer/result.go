@@ -4,12 +4,27 @@ const (
 	OkResultCode    = 0
 	ValueResultCode = 1
 	ErrResultCode   = 2
+	PairsResultCode = 3
+	KeysResultCode  = 4
+	// RedirectResultCode is an error of cluster node which doesn't accept changes; its value is an address
+	// of leader which does
+	RedirectResultCode = 5
 )
 
+// Pair is a key-value entry returned by range commands
+type Pair struct {
+	Key   string
+	Value []byte
+}
+
 type Result struct {
 	code  int
 	value []byte
 	err   string
+	pairs []Pair
+	keys  []string
+	// leader is passed by redirect result; it is empty during election
+	leader string
 }
 
 func OkResult() *Result {
@@ -25,6 +40,20 @@ func ValueResult(value []byte) *Result {
 	return r
 }
 
+func PairsResult(pairs []Pair) *Result {
+	r := new(Result)
+	r.code = PairsResultCode
+	r.pairs = pairs
+	return r
+}
+
+func KeysResult(keys []string) *Result {
+	r := new(Result)
+	r.code = KeysResultCode
+	r.keys = keys
+	return r
+}
+
 func StrErrResult(err string) *Result {
 	r := new(Result)
 	r.code = ErrResultCode
@@ -36,8 +65,21 @@ func ErrResult(err error) *Result {
 	return StrErrResult(err.Error())
 }
 
+// RedirectResult rejects changes sent to node which isn't a leader of cluster
+func RedirectResult(leader string) *Result {
+	r := new(Result)
+	r.code = RedirectResultCode
+	r.leader = leader
+	if leader == "" {
+		r.err = "node is not a leader, leader is unknown"
+	} else {
+		r.err = "node is not a leader, leader is " + leader
+	}
+	return r
+}
+
 func (r *Result) Ok() bool {
-	return r.code != ErrResultCode
+	return r.code != ErrResultCode && r.code != RedirectResultCode
 }
 
 func (r *Result) Type() int {
@@ -48,10 +90,23 @@ func (r *Result) Value() []byte {
 	return r.value
 }
 
+func (r *Result) Pairs() []Pair {
+	return r.pairs
+}
+
+func (r *Result) Keys() []string {
+	return r.keys
+}
+
 func (r *Result) Error() string {
 	return r.err
 }
 
+// Leader returns address of leader passed by redirect result
+func (r *Result) Leader() string {
+	return r.leader
+}
+
 type resultBuilder func([]byte) *Result
 
 func ResultFactory(code int) resultBuilder {
@@ -68,6 +123,18 @@ func ResultFactory(code int) resultBuilder {
 		return func(err []byte) *Result {
 			return StrErrResult(string(err))
 		}
+	case PairsResultCode:
+		return func(value []byte) *Result {
+			return PairsResult(unmarshalPairs(value))
+		}
+	case KeysResultCode:
+		return func(value []byte) *Result {
+			return KeysResult(unmarshalKeys(value))
+		}
+	case RedirectResultCode:
+		return func(leader []byte) *Result {
+			return RedirectResult(string(leader))
+		}
 	}
 	return nil
 }
@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"io"
+	"os"
+)
+
+// CopyFile copies file atomically: data is synced to temporary file, which replaces destination one
+func CopyFile(src string, dst string) error {
+	return CopyFilePrefix(src, dst, -1)
+}
+
+// CopyFilePrefix copies first size bytes of file atomically; the whole file is copied if size is negative
+func CopyFilePrefix(src string, dst string, size int64) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	tmpName := dst + ".tmp"
+	dstFile, err := os.OpenFile(tmpName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	if size < 0 {
+		_, err = io.Copy(dstFile, srcFile)
+	} else {
+		_, err = io.CopyN(dstFile, srcFile, size)
+	}
+	if err != nil {
+		dstFile.Close()
+		return err
+	}
+	if err := dstFile.Sync(); err != nil {
+		dstFile.Close()
+		return err
+	}
+	if err := dstFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, dst)
+}
@@ -0,0 +1,50 @@
+package utils
+
+import "time"
+
+// PeriodicRunner calls function periodically in background goroutine
+type PeriodicRunner struct {
+	interval time.Duration
+	run      func()
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func NewPeriodicRunner(interval time.Duration, run func()) *PeriodicRunner {
+	r := new(PeriodicRunner)
+	r.interval = interval
+	r.run = run
+	return r
+}
+
+// Start runs function every interval; non-positive interval disables runs
+func (r *PeriodicRunner) Start() {
+	if r.interval <= 0 || r.stop != nil {
+		return
+	}
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				r.run()
+			}
+		}
+	}()
+}
+
+// Stop waits for the current run and stops the following ones
+func (r *PeriodicRunner) Stop() {
+	if r.stop == nil {
+		return
+	}
+	close(r.stop)
+	<-r.done
+	r.stop = nil
+}
@@ -4,9 +4,13 @@ import (
 	"bufio"
 	"dbms/internal/parser"
 	"dbms/internal/transfer"
+	"fmt"
+	"io"
 	"net"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type RawExecutor interface {
@@ -18,33 +22,107 @@ type DataCommands interface {
 	Get(key string) ([]byte, error)
 	Set(key string, value []byte) error
 	Del(key string) error
+	Scan(start string, end string, limit int) ([]transfer.Pair, error)
+	KeysPage(prefix string, after string, limit int) ([]string, error)
+	Keys(prefix string) *KeysIterator
 	MustGet(key string) []byte
 	MustSet(key string, value []byte)
 	MustDel(key string)
+	MustScan(start string, end string, limit int) []transfer.Pair
 }
 
 type TxBeginCommands interface {
 	BeginSh() (TxCommands, error)
 	BeginEx() (TxCommands, error)
+	BeginSn() (TxCommands, error)
+	BeginSr() (TxCommands, error)
+	// SetLockTimeout bounds time which transactions started after it wait for each lock;
+	// zero means lockTimeoutMs setting of server
+	SetLockTimeout(timeout time.Duration)
 	// MustBeginSh() TxCommands
 	// MustBeginEx() TxCommands
 }
 
 type TxEndCommands interface {
+	// Prepare ends transaction keeping its changes for COMMIT PREPARED or ROLLBACK PREPARED with the same id
+	Prepare(gid string) error
 	Commit() error
+	// CommitNoWait commits without waiting for sync to disk, so commit may be lost by OS crash
+	CommitNoWait() error
 	Abort() error
 	// MustCommit()
 	// MustAbort()
 }
 
+// PreparedCommands finish transactions prepared by any client
+type PreparedCommands interface {
+	CommitPrepared(gid string) error
+	RollbackPrepared(gid string) error
+	ListPrepared() ([]string, error)
+}
+
+// AdminCommands manage server
+type AdminCommands interface {
+	// Backup copies storage and journal of running server to directory on server side
+	Backup(path string) error
+	// IncrementalBackup copies pages changed since base backup and journal to directory on server side
+	IncrementalBackup(path string, base string) error
+	// ReplicationLag returns number of primary journal records not replayed by replica yet and time
+	// since replica caught up with primary
+	ReplicationLag() (int64, time.Duration, error)
+	// Replicas returns status of replicas connected to server
+	Replicas() ([]ReplicaStatus, error)
+	// ClusterStatus returns status of cluster node
+	ClusterStatus() (*ClusterStatus, error)
+	// GroupCommitStats returns statistics of group commit since server start
+	GroupCommitStats() (*GroupCommitStats, error)
+}
+
+// GroupCommitStats describes batches of commit, abort and prepare records journaled by group commit
+type GroupCommitStats struct {
+	Batches  int
+	Records  int
+	MaxBatch int
+	// BatchSizes counts batches of up to 1, 2, 4, ... records; the last bucket counts larger batches too
+	BatchSizes []int
+}
+
+// ReplicaStatus describes replica connected to primary
+type ReplicaStatus struct {
+	Name string
+	Addr string
+	// AckedLsn is an lsn of the last journal record durably received by replica
+	AckedLsn int64
+	// Lag is a number of records flushed by primary, but not acknowledged by replica yet
+	Lag int64
+	// SinceAck is a time since the last acknowledgement
+	SinceAck time.Duration
+}
+
+// DumpCommands export and import all pairs in format independent of storage
+type DumpCommands interface {
+	Dump(w io.Writer) (int, error)
+	Load(r io.Reader, batchSize int) (int, error)
+}
+
 type ClientCommands interface {
 	RawExecutor
 	DataCommands
 	TxBeginCommands
+	PreparedCommands
+	AdminCommands
+	DumpCommands
+}
+
+type TxSavepointCommands interface {
+	Savepoint(name string) error
+	RollbackTo(name string) error
+	Release(name string) error
 }
 
 type TxCommands interface {
 	DataCommands
+	TxSavepointCommands
 	TxEndCommands
 }
 
@@ -72,21 +150,33 @@ type DBMSClient struct {
 	writer *bufio.Writer
 	send   transfer.ObjectWriter
 	recv   transfer.ObjectReader
+	// lockTimeout is passed by BEGIN commands
+	lockTimeout time.Duration
 }
 
 func Connect(host string) (*DBMSClient, error) {
+	c := new(DBMSClient)
+	c.parser = parser.NewDumbSingleLineParser()
+	if err := c.dial(host); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// dial connects client to server; connection of client to another server is closed after that
+func (c *DBMSClient) dial(host string) error {
 	conn, err := net.Dial("tcp", host)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if c.conn != nil {
+		c.conn.Close()
 	}
-	reader := bufio.NewReader(conn)
-	c := new(DBMSClient)
 	c.conn = conn
-	c.parser = parser.NewDumbSingleLineParser()
 	c.writer = bufio.NewWriter(conn)
 	c.send = transfer.NewLEObjectWriter(c.writer)
-	c.recv = transfer.NewLEObjectReader(reader)
-	return c, nil
+	c.recv = transfer.NewLEObjectReader(bufio.NewReader(conn))
+	return nil
 }
 
 func (c *DBMSClient) Finalize() {
@@ -114,6 +204,23 @@ func preprocessRawCmd(rawCmd string) string {
 	return strings.TrimSpace(noWideSpacesRawCmd)
 }
 
+// execChange executes command changing storage; node of cluster which isn't a leader rejects it with address
+// of leader, so client connects to leader and executes command there, unless command is a part of transaction
+// started on the previous node
+func (c *DBMSClient) execChange(cmd transfer.Cmd) (*transfer.Result, error) {
+	res, err := c.execCmd(cmd)
+	for redirects := 0; redirects < maxRedirects; redirects++ {
+		if err != nil || res.Type() != transfer.RedirectResultCode || res.Leader() == "" || c.tx != nil {
+			break
+		}
+		if err := c.dial(res.Leader()); err != nil {
+			return nil, err
+		}
+		res, err = c.execCmd(cmd)
+	}
+	return res, err
+}
+
 func (c *DBMSClient) Exec(rawCmd string) (*transfer.Result, error) {
 	rawCmd = preprocessRawCmd(rawCmd)
 	cmd, err := c.parser.Parse(rawCmd)
@@ -147,30 +254,87 @@ func (c *DBMSClient) Get(key string) ([]byte, error) {
 }
 
 func (c *DBMSClient) Set(key string, value []byte) error {
-	_, err := handleResult(c.execCmd(transfer.SetCmd(key, value)))
+	_, err := handleResult(c.execChange(transfer.SetCmd(key, value)))
 	return err
 }
 
 func (c *DBMSClient) Del(key string) error {
-	_, err := handleResult(c.execCmd(transfer.DelCmd(key)))
+	_, err := handleResult(c.execChange(transfer.DelCmd(key)))
 	return err
 }
 
+// Scan returns key-value pairs for keys in [start, end) range in key order;
+// zero limit means no limit
+func (c *DBMSClient) Scan(start string, end string, limit int) ([]transfer.Pair, error) {
+	res, err := c.execCmd(transfer.ScanCmd(start, end, limit))
+	if _, err := handleResult(res, err); err != nil {
+		return nil, err
+	}
+	return res.Pairs(), nil
+}
+
+// KeysPage returns up to limit keys with prefix which are greater than after;
+// zero limit means server's page size
+func (c *DBMSClient) KeysPage(prefix string, after string, limit int) ([]string, error) {
+	res, err := c.execCmd(transfer.KeysCmd(prefix, after, limit))
+	if _, err := handleResult(res, err); err != nil {
+		return nil, err
+	}
+	return res.Keys(), nil
+}
+
+// Keys returns iterator over keys with prefix; keys are fetched page by page
+func (c *DBMSClient) Keys(prefix string) *KeysIterator {
+	return NewKeysIterator(c, prefix, KeysPageSize)
+}
+
 // must data methods
 func (c *DBMSClient) MustGet(key string) []byte {
 	return handleMustResult(c.execCmd(transfer.GetCmd(key)))
 }
 
 func (c *DBMSClient) MustSet(key string, value []byte) {
-	handleMustResult(c.execCmd(transfer.SetCmd(key, value)))
+	handleMustResult(c.execChange(transfer.SetCmd(key, value)))
 }
 
 func (c *DBMSClient) MustDel(key string) {
-	handleMustResult(c.execCmd(transfer.DelCmd(key)))
+	handleMustResult(c.execChange(transfer.DelCmd(key)))
+}
+
+func (c *DBMSClient) MustScan(start string, end string, limit int) []transfer.Pair {
+	pairs, err := c.Scan(start, end, limit)
+	if err != nil {
+		panic(err)
+	}
+	return pairs
 }
 
 func (c *DBMSClient) BeginSh() (TxCommands, error) {
-	res, err := c.execCmd(transfer.BegShCmd())
+	return c.begin(transfer.BegShCmdType)
+}
+
+func (c *DBMSClient) BeginEx() (TxCommands, error) {
+	return c.begin(transfer.BegExCmdType)
+}
+
+// BeginSn starts snapshot transaction; its changes are applied at commit,
+// which returns error on concurrent update of the same keys
+func (c *DBMSClient) BeginSn() (TxCommands, error) {
+	return c.begin(transfer.BegSnCmdType)
+}
+
+// BeginSr starts serializable transaction; its commit returns error
+// if keys or ranges read by transaction are changed concurrently
+func (c *DBMSClient) BeginSr() (TxCommands, error) {
+	return c.begin(transfer.BegSrCmdType)
+}
+
+func (c *DBMSClient) SetLockTimeout(timeout time.Duration) {
+	c.lockTimeout = timeout
+}
+
+func (c *DBMSClient) begin(cmdType int) (TxCommands, error) {
+	res, err := c.execCmd(transfer.BeginCmd(cmdType, int(c.lockTimeout/time.Millisecond)))
 	if err != nil {
 		return nil, err
 	}
@@ -181,20 +345,146 @@ func (c *DBMSClient) BeginSh() (TxCommands, error) {
 	return c.tx, nil
 }
 
-func (c *DBMSClient) BeginEx() (TxCommands, error) {
-	res, err := c.execCmd(transfer.BegExCmd())
+// Savepoint marks transaction state, which can be restored by RollbackTo
+func (tx *Tx) Savepoint(name string) error {
+	_, err := handleResult(tx.c.execCmd(transfer.SavepointCmd(name)))
+	return err
+}
+
+// RollbackTo undoes changes made after savepoint; savepoint itself is kept
+func (tx *Tx) RollbackTo(name string) error {
+	_, err := handleResult(tx.c.execCmd(transfer.RollbackToCmd(name)))
+	return err
+}
+
+// Release removes savepoint and all savepoints made after it keeping changes
+func (tx *Tx) Release(name string) error {
+	_, err := handleResult(tx.c.execCmd(transfer.ReleaseCmd(name)))
+	return err
+}
+
+func (tx *Tx) Prepare(gid string) error {
+	res, err := tx.c.execCmd(transfer.PrepareCmd(gid))
 	if err != nil {
-		return nil, err
+		return err
 	}
+	// transaction is finished even if prepare fails
+	tx.c.tx = nil
 	if !res.Ok() {
-		return nil, res
+		return res
 	}
-	c.tx = NewTx(c)
-	return c.tx, nil
+	return nil
+}
+
+func (c *DBMSClient) CommitPrepared(gid string) error {
+	_, err := handleResult(c.execCmd(transfer.CommitPreparedCmd(gid)))
+	return err
+}
+
+func (c *DBMSClient) RollbackPrepared(gid string) error {
+	_, err := handleResult(c.execCmd(transfer.RollbackPreparedCmd(gid)))
+	return err
+}
+
+// ListPrepared returns ids of prepared transactions which are not committed or rolled back yet
+func (c *DBMSClient) ListPrepared() ([]string, error) {
+	res, err := c.execCmd(transfer.ListPreparedCmd())
+	if _, err := handleResult(res, err); err != nil {
+		return nil, err
+	}
+	return res.Keys(), nil
+}
+
+func (c *DBMSClient) Backup(path string) error {
+	_, err := handleResult(c.execCmd(transfer.BackupCmd(path)))
+	return err
+}
+
+func (c *DBMSClient) IncrementalBackup(path string, base string) error {
+	_, err := handleResult(c.execCmd(transfer.IncrementalBackupCmd(path, base)))
+	return err
+}
+
+func (c *DBMSClient) ReplicationLag() (int64, time.Duration, error) {
+	res, err := c.execCmd(transfer.ReplicationLagCmd())
+	if _, err := handleResult(res, err); err != nil {
+		return 0, 0, err
+	}
+	var records, delayMs int64
+	for _, p := range res.Pairs() {
+		switch p.Key {
+		case "records":
+			records, err = strconv.ParseInt(string(p.Value), 10, 64)
+		case "delayMs":
+			delayMs, err = strconv.ParseInt(string(p.Value), 10, 64)
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return records, time.Duration(delayMs) * time.Millisecond, nil
+}
+
+func (c *DBMSClient) Replicas() ([]ReplicaStatus, error) {
+	res, err := c.execCmd(transfer.ReplicasCmd())
+	if _, err := handleResult(res, err); err != nil {
+		return nil, err
+	}
+	statuses := make([]ReplicaStatus, 0, len(res.Pairs()))
+	for _, p := range res.Pairs() {
+		status := ReplicaStatus{Name: p.Key}
+		var sinceAckMs int64
+		if _, err := fmt.Sscanf(string(p.Value), "address=%s acked=%d lag=%d lastAckMs=%d",
+			&status.Addr, &status.AckedLsn, &status.Lag, &sinceAckMs); err != nil {
+			return nil, err
+		}
+		status.SinceAck = time.Duration(sinceAckMs) * time.Millisecond
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func (c *DBMSClient) GroupCommitStats() (*GroupCommitStats, error) {
+	res, err := c.execCmd(transfer.StatsCmd())
+	if _, err := handleResult(res, err); err != nil {
+		return nil, err
+	}
+	stats := new(GroupCommitStats)
+	for _, p := range res.Pairs() {
+		switch p.Key {
+		case "batches":
+			stats.Batches, err = strconv.Atoi(string(p.Value))
+		case "records":
+			stats.Records, err = strconv.Atoi(string(p.Value))
+		case "maxBatch":
+			stats.MaxBatch, err = strconv.Atoi(string(p.Value))
+		case "batchSizes":
+			for _, field := range strings.Fields(string(p.Value)) {
+				var batches int
+				batches, err = strconv.Atoi(field)
+				if err != nil {
+					break
+				}
+				stats.BatchSizes = append(stats.BatchSizes, batches)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
 }
 
 func (tx *Tx) Commit() error {
-	res, err := tx.c.execCmd(transfer.CommitCmd())
+	return tx.commit(transfer.CommitCmd())
+}
+
+func (tx *Tx) CommitNoWait() error {
+	return tx.commit(transfer.CommitNoWaitCmd())
+}
+
+func (tx *Tx) commit(cmd transfer.Cmd) error {
+	res, err := tx.c.execCmd(cmd)
 	if err != nil {
 		return err
 	}
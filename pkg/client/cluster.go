@@ -0,0 +1,97 @@
+package client
+
+import (
+	"dbms/internal/transfer"
+	"errors"
+	"strconv"
+	"time"
+)
+
+const (
+	// leaderPollInterval is a pause between rounds of nodes polling by ConnectLeader
+	leaderPollInterval = 50 * time.Millisecond
+	// maxRedirects bounds leaders followed by single change, because leader may change again meanwhile
+	maxRedirects = 3
+)
+
+var ErrLeaderNotFound = errors.New("leader of cluster is not found")
+
+// ClusterStatus describes node of cluster
+type ClusterStatus struct {
+	Addr string
+	// Role is one of follower, candidate and leader
+	Role string
+	Term int64
+	// Leader is an address of leader which accepts changes; it is empty if node doesn't know it
+	Leader string
+	// LastIndex is an index of the last entry of node log
+	LastIndex int64
+	// Compacted is an index of the last entry removed from node log by compaction
+	Compacted   int64
+	CommitIndex int64
+	Applied     int64
+}
+
+func (c *DBMSClient) ClusterStatus() (*ClusterStatus, error) {
+	res, err := c.execCmd(transfer.ClusterCmd())
+	if _, err := handleResult(res, err); err != nil {
+		return nil, err
+	}
+	status := new(ClusterStatus)
+	for _, p := range res.Pairs() {
+		value := string(p.Value)
+		switch p.Key {
+		case "addr":
+			status.Addr = value
+		case "role":
+			status.Role = value
+		case "leader":
+			status.Leader = value
+		case "term":
+			status.Term, err = strconv.ParseInt(value, 10, 64)
+		case "lastIndex":
+			status.LastIndex, err = strconv.ParseInt(value, 10, 64)
+		case "compacted":
+			status.Compacted, err = strconv.ParseInt(value, 10, 64)
+		case "commitIndex":
+			status.CommitIndex, err = strconv.ParseInt(value, 10, 64)
+		case "applied":
+			status.Applied, err = strconv.ParseInt(value, 10, 64)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
+
+// ConnectLeader polls nodes of cluster till one of them is a leader ready to accept changes
+// and returns client connected to it; ErrLeaderNotFound is returned if leader isn't elected in timeout
+func ConnectLeader(nodes []string, timeout time.Duration) (*DBMSClient, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		for _, node := range nodes {
+			c, err := Connect(node)
+			if err != nil {
+				continue
+			}
+			if status, err := c.ClusterStatus(); err == nil && status.Leader == status.Addr {
+				return c, nil
+			}
+			c.Finalize()
+		}
+		if time.Now().After(deadline) {
+			return nil, ErrLeaderNotFound
+		}
+		time.Sleep(leaderPollInterval)
+	}
+}
+
+// LeaderOf returns address of leader reported by node which rejected changes; it is empty if error
+// isn't a redirect or node doesn't know leader
+func LeaderOf(err error) string {
+	if res, ok := err.(*transfer.Result); ok && res.Type() == transfer.RedirectResultCode {
+		return res.Leader()
+	}
+	return ""
+}
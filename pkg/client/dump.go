@@ -0,0 +1,173 @@
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// Base64Encoding marks dumped key or value which isn't valid UTF-8 text
+const Base64Encoding = "base64"
+
+// LoadBatchSize is a default number of pairs loaded by one transaction
+const LoadBatchSize = 1000
+
+// dumpScanLimit bounds pairs read by one range scan of dump, so values held in memory are bounded
+// (values may be large overflow chains)
+const dumpScanLimit = 16
+
+var ErrUnknownEncoding = errors.New("unknown key or value encoding")
+
+// DumpRecord is a line of logical dump (JSON Lines); it doesn't depend on storage format,
+// so dump is used to migrate data between format versions
+type DumpRecord struct {
+	Key         string `json:"key"`
+	KeyEncoding string `json:"keyEncoding,omitempty"`
+	Value       string `json:"value"`
+	Encoding    string `json:"encoding,omitempty"`
+}
+
+func newDumpRecord(key string, value []byte) *DumpRecord {
+	r := new(DumpRecord)
+	r.Key, r.KeyEncoding = encodeText([]byte(key))
+	r.Value, r.Encoding = encodeText(value)
+	return r
+}
+
+// DecodedKey returns key of record
+func (r *DumpRecord) DecodedKey() (string, error) {
+	key, err := decodeText(r.Key, r.KeyEncoding)
+	return string(key), err
+}
+
+// DecodedValue returns value of record
+func (r *DumpRecord) DecodedValue() ([]byte, error) {
+	return decodeText(r.Value, r.Encoding)
+}
+
+// encodeText returns data as text and its encoding; data which isn't valid UTF-8 text is base64 encoded
+func encodeText(data []byte) (string, string) {
+	if utf8.Valid(data) {
+		return string(data), ""
+	}
+	return base64.StdEncoding.EncodeToString(data), Base64Encoding
+}
+
+func decodeText(text string, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		return []byte(text), nil
+	case Base64Encoding:
+		return base64.StdEncoding.DecodeString(text)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownEncoding, encoding)
+	}
+}
+
+// Dump writes all key-value pairs in key order to writer as JSON Lines and returns number of pairs;
+// pairs are read by snapshot transaction, so dump is consistent and doesn't block writers;
+// keys are listed page by page, and values of each page are read by range scans of few pairs
+func (c *DBMSClient) Dump(w io.Writer) (int, error) {
+	tx, err := c.BeginSn()
+	if err != nil {
+		return 0, err
+	}
+	n, err := dump(tx, w)
+	if err != nil {
+		tx.Abort()
+		return n, err
+	}
+	return n, tx.Commit()
+}
+
+func dump(c DataCommands, w io.Writer) (int, error) {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	var n int
+	var after string
+	for {
+		keys, err := c.KeysPage("", after, KeysPageSize)
+		if err != nil {
+			return n, err
+		}
+		if len(keys) == 0 {
+			return n, nil
+		}
+		after = keys[len(keys)-1]
+		for start := keys[0]; ; {
+			pairs, err := c.Scan(start, after+"\x00", dumpScanLimit)
+			if err != nil {
+				return n, err
+			}
+			for _, p := range pairs {
+				if err := enc.Encode(newDumpRecord(p.Key, p.Value)); err != nil {
+					return n, err
+				}
+				n++
+			}
+			if len(pairs) < dumpScanLimit {
+				break
+			}
+			start = pairs[len(pairs)-1].Key + "\x00"
+		}
+		if len(keys) < KeysPageSize {
+			return n, nil
+		}
+	}
+}
+
+// Load sets pairs read from dump in exclusive transactions of batchSize pairs (LoadBatchSize if it is zero)
+// and returns number of loaded pairs; pairs of failed batch aren't loaded
+func (c *DBMSClient) Load(r io.Reader, batchSize int) (int, error) {
+	if batchSize <= 0 {
+		batchSize = LoadBatchSize
+	}
+	dec := json.NewDecoder(r)
+	batch := make([]*DumpRecord, 0, batchSize)
+	var n int
+	for {
+		rec := new(DumpRecord)
+		err := dec.Decode(rec)
+		if err != nil && err != io.EOF {
+			return n, fmt.Errorf("dump record %d: %w", n+len(batch)+1, err)
+		}
+		if err == nil {
+			batch = append(batch, rec)
+		}
+		if len(batch) == batchSize || (err == io.EOF && len(batch) != 0) {
+			if loadErr := c.loadBatch(batch); loadErr != nil {
+				return n, fmt.Errorf("dump records %d-%d: %w", n+1, n+len(batch), loadErr)
+			}
+			n += len(batch)
+			batch = batch[:0]
+		}
+		if err == io.EOF {
+			return n, nil
+		}
+	}
+}
+
+func (c *DBMSClient) loadBatch(batch []*DumpRecord) error {
+	tx, err := c.BeginEx()
+	if err != nil {
+		return err
+	}
+	for _, rec := range batch {
+		key, err := rec.DecodedKey()
+		var value []byte
+		if err == nil {
+			value, err = rec.DecodedValue()
+		}
+		if err == nil {
+			err = tx.Set(key, value)
+		}
+		if err != nil {
+			tx.Abort()
+			return err
+		}
+	}
+	return tx.Commit()
+}
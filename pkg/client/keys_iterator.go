@@ -0,0 +1,57 @@
+package client
+
+import (
+	"io"
+)
+
+// KeysPageSize is the largest page of keys returned by server
+const KeysPageSize = 1000
+
+// KeysIterator streams keys with prefix in key order;
+// each page is requested separately, so listing is not isolated
+// unless it runs inside of exclusive transaction
+type KeysIterator struct {
+	c        DataCommands
+	prefix   string
+	pageSize int
+	page     []string
+	pagePos  int
+	last     string
+	done     bool
+}
+
+func NewKeysIterator(c DataCommands, prefix string, pageSize int) *KeysIterator {
+	i := new(KeysIterator)
+	i.c = c
+	i.prefix = prefix
+	// server returns pages of at most KeysPageSize keys, so larger page would be taken for the last one
+	if pageSize <= 0 || pageSize > KeysPageSize {
+		pageSize = KeysPageSize
+	}
+	i.pageSize = pageSize
+	return i
+}
+
+// Next returns next key; io.EOF is returned when keys are exhausted
+func (i *KeysIterator) Next() (string, error) {
+	if i.pagePos == len(i.page) {
+		if i.done {
+			return "", io.EOF
+		}
+		page, err := i.c.KeysPage(i.prefix, i.last, i.pageSize)
+		if err != nil {
+			return "", err
+		}
+		i.page = page
+		i.pagePos = 0
+		// incomplete page means that there are no more keys
+		i.done = len(page) < i.pageSize
+		if len(page) == 0 {
+			return "", io.EOF
+		}
+		i.last = page[len(page)-1]
+	}
+	key := i.page[i.pagePos]
+	i.pagePos++
+	return key, nil
+}
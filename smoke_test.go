@@ -5,10 +5,24 @@ TODO: inject logger via interface to prevent logging during tests
 */
 
 import (
+	"bytes"
+	"dbms/internal/config"
+	"dbms/internal/core"
+	"dbms/internal/parser"
+	"dbms/internal/server"
 	"dbms/pkg/client"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 )
 
 // TestDBMS_TxSetCommit is a dumb test if tx commit is applied to store
@@ -47,8 +61,813 @@ func TestDBMS_TxSetAbort(t *testing.T) {
 	assert.Equal(t, expected, dbClient.MustGet("key"))
 }
 
+// TestDBMS_Scan checks range scan crosses leaves in key order and respects bounds and limit
+func TestDBMS_Scan(t *testing.T) {
+	keys := 500
+	tx, err := dbClient.BeginEx()
+	if err != nil {
+		log.Panic(err)
+	}
+	defer tx.Abort()
+	for i := 0; i < keys; i++ {
+		tx.MustSet(fmt.Sprintf("scan:%04d", i), []byte(fmt.Sprint(i)))
+	}
+	tx.Commit()
+
+	pairs := dbClient.MustScan("scan:0100", "scan:0400", 0)
+	assert.Equal(t, 300, len(pairs))
+	for i, p := range pairs {
+		assert.Equal(t, fmt.Sprintf("scan:%04d", i+100), p.Key)
+		assert.Equal(t, []byte(fmt.Sprint(i+100)), p.Value)
+	}
+	pairs = dbClient.MustScan("scan:", "scan:~", 10)
+	assert.Equal(t, 10, len(pairs))
+	assert.Equal(t, "scan:0000", pairs[0].Key)
+	assert.Equal(t, 0, len(dbClient.MustScan("scan:9", "scan:~", 0)))
+	// zero limit of command means no limit, so it isn't accepted as LIMIT 0
+	_, err = dbClient.Exec("SCAN scan: scan:~ LIMIT 0")
+	assert.Equal(t, parser.ErrInvalidCmdStruct, err)
+}
+
+// TestDBMS_Keys checks prefix listing is paged and stops at keys without prefix
+func TestDBMS_Keys(t *testing.T) {
+	users := 250
+	tx, err := dbClient.BeginEx()
+	if err != nil {
+		log.Panic(err)
+	}
+	defer tx.Abort()
+	for i := 0; i < users; i++ {
+		tx.MustSet(fmt.Sprintf("keys:user:%04d:profile", i), []byte("profile"))
+	}
+	tx.MustSet("keys:group:1", []byte("group"))
+	tx.MustSet("keys:userz", []byte("not-a-user"))
+	tx.Commit()
+
+	page, err := dbClient.KeysPage("keys:user:", "", 100)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(page))
+	assert.Equal(t, "keys:user:0000:profile", page[0])
+	page, err = dbClient.KeysPage("keys:user:", page[99], 100)
+	assert.Nil(t, err)
+	assert.Equal(t, "keys:user:0100:profile", page[0])
+
+	iter := client.NewKeysIterator(dbClient, "keys:user:", 7)
+	i := 0
+	for key, err := iter.Next(); err != io.EOF; key, err = iter.Next() {
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("keys:user:%04d:profile", i), key)
+		i++
+	}
+	assert.Equal(t, users, i)
+	_, err = dbClient.Keys("keys:none:").Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+// TestDBMS_KeysPageAboveServerCap checks iterator doesn't take full page of server for the last one
+func TestDBMS_KeysPageAboveServerCap(t *testing.T) {
+	count := client.KeysPageSize + 200
+	tx, err := dbClient.BeginEx()
+	if err != nil {
+		log.Panic(err)
+	}
+	for i := 0; i < count; i++ {
+		tx.MustSet(fmt.Sprintf("keyscap:%05d", i), []byte("v"))
+	}
+	assert.Nil(t, tx.Commit())
+	iter := client.NewKeysIterator(dbClient, "keyscap:", 2*client.KeysPageSize)
+	i := 0
+	for key, err := iter.Next(); err != io.EOF; key, err = iter.Next() {
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("keyscap:%05d", i), key)
+		i++
+	}
+	assert.Equal(t, count, i)
+	tx, err = dbClient.BeginEx()
+	if err != nil {
+		log.Panic(err)
+	}
+	for i := 0; i < count; i++ {
+		tx.MustDel(fmt.Sprintf("keyscap:%05d", i))
+	}
+	assert.Nil(t, tx.Commit())
+}
+
+// TestDBMS_LargeValue checks values larger than page are stored in overflow pages
+func TestDBMS_LargeValue(t *testing.T) {
+	large := bytes.Repeat([]byte("0123456789abcdef"), 256*1024)
+	dbClient.MustSet("large", large)
+	assert.Equal(t, large, dbClient.MustGet("large"))
+	larger := append(large, large...)
+	dbClient.MustSet("large", larger)
+	assert.Equal(t, larger, dbClient.MustGet("large"))
+	small := []byte("small")
+	dbClient.MustSet("large", small)
+	assert.Equal(t, small, dbClient.MustGet("large"))
+	dbClient.MustSet("large", large)
+	dbClient.MustDel("large")
+	_, err := dbClient.Get("large")
+	assert.NotNil(t, err)
+}
+
+// TestDBMS_LongKey checks keys longer than max key length are rejected
+func TestDBMS_LongKey(t *testing.T) {
+	key := strings.Repeat("k", 64)
+	dbClient.MustSet(key, []byte("val"))
+	assert.Equal(t, []byte("val"), dbClient.MustGet(key))
+	dbClient.MustDel(key)
+	longKey := key + "k"
+	assert.NotNil(t, dbClient.Set(longKey, []byte("val")))
+	_, err := dbClient.Get(longKey)
+	assert.NotNil(t, err)
+	assert.NotNil(t, dbClient.Del(longKey))
+}
+
+// TestDBMS_Snapshot checks snapshot transaction doesn't see changes committed after its start
+func TestDBMS_Snapshot(t *testing.T) {
+	dbClient.MustSet("snapshot:a", []byte("old"))
+	dbClient.MustSet("snapshot:b", []byte("old"))
+	dbClient.Del("snapshot:c")
+	c, err := client.Connect(dbUrl)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer c.Finalize()
+	tx, err := c.BeginSn()
+	if err != nil {
+		log.Panic(err)
+	}
+	defer tx.Abort()
+	assert.Equal(t, []byte("old"), tx.MustGet("snapshot:a"))
+	// writers are not blocked by snapshot
+	dbClient.MustSet("snapshot:a", []byte("new"))
+	dbClient.MustDel("snapshot:b")
+	dbClient.MustSet("snapshot:c", []byte("new"))
+	assert.Equal(t, []byte("old"), tx.MustGet("snapshot:a"))
+	assert.Equal(t, []byte("old"), tx.MustGet("snapshot:b"))
+	_, err = tx.Get("snapshot:c")
+	assert.NotNil(t, err)
+	pairs := tx.MustScan("snapshot:", "snapshot:~", 0)
+	assert.Equal(t, 2, len(pairs))
+	// own changes are visible to snapshot
+	tx.MustSet("snapshot:d", []byte("own"))
+	assert.Equal(t, []byte("own"), tx.MustGet("snapshot:d"))
+	assert.Equal(t, 3, len(tx.MustScan("snapshot:", "snapshot:~", 0)))
+	assert.Nil(t, tx.Commit())
+
+	assert.Equal(t, []byte("new"), dbClient.MustGet("snapshot:a"))
+	_, err = dbClient.Get("snapshot:b")
+	assert.NotNil(t, err)
+	assert.Equal(t, []byte("own"), dbClient.MustGet("snapshot:d"))
+	dbClient.MustDel("snapshot:a")
+	dbClient.MustDel("snapshot:c")
+	dbClient.MustDel("snapshot:d")
+}
+
+// TestDBMS_SnapshotWriteConflict checks snapshot commit fails if changed key is updated concurrently
+func TestDBMS_SnapshotWriteConflict(t *testing.T) {
+	dbClient.MustSet("conflict", []byte("old"))
+	c, err := client.Connect(dbUrl)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer c.Finalize()
+	tx, err := c.BeginSn()
+	if err != nil {
+		log.Panic(err)
+	}
+	defer tx.Abort()
+	tx.MustSet("conflict", []byte("snapshot"))
+	dbClient.MustSet("conflict", []byte("concurrent"))
+	assert.NotNil(t, tx.Commit())
+	assert.Equal(t, []byte("concurrent"), dbClient.MustGet("conflict"))
+
+	tx, err = c.BeginSn()
+	if err != nil {
+		log.Panic(err)
+	}
+	tx.MustDel("conflict")
+	assert.Nil(t, tx.Commit())
+	_, err = dbClient.Get("conflict")
+	assert.NotNil(t, err)
+}
+
+// writeSkew runs two transactions which check both doctors are on call and take one off call each
+func writeSkew(begin func(c client.TxBeginCommands) (client.TxCommands, error)) (error, error) {
+	dbClient.MustSet("skew:alice", []byte("on"))
+	dbClient.MustSet("skew:bob", []byte("on"))
+	c1, err := client.Connect(dbUrl)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer c1.Finalize()
+	c2, err := client.Connect(dbUrl)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer c2.Finalize()
+	tx1, err := begin(c1)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer tx1.Abort()
+	tx2, err := begin(c2)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer tx2.Abort()
+	if len(tx1.MustScan("skew:", "skew:~", 0)) == 2 {
+		tx1.MustSet("skew:alice", []byte("off"))
+	}
+	if len(tx2.MustScan("skew:", "skew:~", 0)) == 2 {
+		tx2.MustDel("skew:bob")
+	}
+	return tx1.Commit(), tx2.Commit()
+}
+
+// TestDBMS_SerializableWriteSkew checks write skew is possible for snapshot transactions only
+func TestDBMS_SerializableWriteSkew(t *testing.T) {
+	err1, err2 := writeSkew(func(c client.TxBeginCommands) (client.TxCommands, error) {
+		return c.BeginSn()
+	})
+	assert.Nil(t, err1)
+	assert.Nil(t, err2)
+	err1, err2 = writeSkew(func(c client.TxBeginCommands) (client.TxCommands, error) {
+		return c.BeginSr()
+	})
+	assert.Nil(t, err1)
+	assert.NotNil(t, err2)
+	assert.Equal(t, []byte("on"), dbClient.MustGet("skew:bob"))
+	dbClient.MustDel("skew:alice")
+	dbClient.MustDel("skew:bob")
+}
+
+// TestDBMS_SerializablePhantom checks commit fails if key is inserted into range read by transaction
+func TestDBMS_SerializablePhantom(t *testing.T) {
+	c, err := client.Connect(dbUrl)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer c.Finalize()
+	tx, err := c.BeginSr()
+	if err != nil {
+		log.Panic(err)
+	}
+	defer tx.Abort()
+	keys, err := tx.KeysPage("phantom:", "", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(keys))
+	dbClient.MustSet("phantom:key", []byte("val"))
+	tx.MustSet("phantom:count", []byte("0"))
+	assert.NotNil(t, tx.Commit())
+	_, err = dbClient.Get("phantom:count")
+	assert.NotNil(t, err)
+
+	// keys outside of read range don't conflict
+	tx, err = c.BeginSr()
+	if err != nil {
+		log.Panic(err)
+	}
+	assert.Equal(t, 1, len(tx.MustScan("phantom:", "phantom:~", 0)))
+	dbClient.MustSet("other:key", []byte("val"))
+	tx.MustSet("phantom:count", []byte("1"))
+	assert.Nil(t, tx.Commit())
+	assert.Equal(t, []byte("1"), dbClient.MustGet("phantom:count"))
+	dbClient.MustDel("phantom:key")
+	dbClient.MustDel("phantom:count")
+	dbClient.MustDel("other:key")
+}
+
+// TestDBMS_ExclusiveDisjointKeys checks exclusive transactions changing different keys don't block each other
+func TestDBMS_ExclusiveDisjointKeys(t *testing.T) {
+	c, err := client.Connect(dbUrl)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer c.Finalize()
+	tx1, err := dbClient.BeginEx()
+	if err != nil {
+		log.Panic(err)
+	}
+	defer tx1.Abort()
+	tx2, err := c.BeginEx()
+	if err != nil {
+		log.Panic(err)
+	}
+	defer tx2.Abort()
+	// both records share data page and index leaf
+	tx1.MustSet("disjoint:a", []byte("a"))
+	assert.Nil(t, tx2.Set("disjoint:b", []byte("b")))
+	assert.Nil(t, tx2.Abort())
+	assert.Nil(t, tx1.Commit())
+	assert.Equal(t, []byte("a"), dbClient.MustGet("disjoint:a"))
+	_, err = dbClient.Get("disjoint:b")
+	assert.NotNil(t, err)
+	dbClient.MustDel("disjoint:a")
+}
+
+// TestDBMS_LockTimeout checks transaction started with lock timeout fails to lock key held by another one in time
+func TestDBMS_LockTimeout(t *testing.T) {
+	c, err := client.Connect(dbUrl)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer c.Finalize()
+	tx1, err := dbClient.BeginEx()
+	if err != nil {
+		log.Panic(err)
+	}
+	defer tx1.Abort()
+	tx1.MustSet("timeout:key", []byte("1"))
+	c.SetLockTimeout(100 * time.Millisecond)
+	tx2, err := c.BeginEx()
+	if err != nil {
+		log.Panic(err)
+	}
+	start := time.Now()
+	assert.NotNil(t, tx2.Set("timeout:key", []byte("2")))
+	// lock isn't awaited for default 10 seconds
+	assert.True(t, time.Since(start) < 5*time.Second)
+	tx2.Abort()
+	c.SetLockTimeout(0)
+	res, err := c.Exec("BEGIN SHARED TIMEOUT 100")
+	assert.Nil(t, err)
+	assert.True(t, res.Ok())
+	_, err = c.Get("timeout:key")
+	assert.NotNil(t, err)
+	assert.Nil(t, tx1.Commit())
+	dbClient.MustDel("timeout:key")
+}
+
+// TestDBMS_Savepoints checks rollback to savepoint undoes only changes made after it
+func TestDBMS_Savepoints(t *testing.T) {
+	dbClient.MustSet("savepoint:a", []byte("0"))
+	dbClient.Del("savepoint:b")
+	for _, begin := range []func() (client.TxCommands, error){dbClient.BeginEx, dbClient.BeginSn} {
+		tx, err := begin()
+		if err != nil {
+			log.Panic(err)
+		}
+		tx.MustSet("savepoint:a", []byte("1"))
+		assert.Nil(t, tx.Savepoint("first"))
+		tx.MustSet("savepoint:a", []byte("2"))
+		tx.MustSet("savepoint:b", []byte("2"))
+		assert.Nil(t, tx.Savepoint("second"))
+		tx.MustDel("savepoint:a")
+		assert.Nil(t, tx.RollbackTo("second"))
+		assert.Equal(t, []byte("2"), tx.MustGet("savepoint:a"))
+		assert.Nil(t, tx.RollbackTo("first"))
+		assert.Equal(t, []byte("1"), tx.MustGet("savepoint:a"))
+		_, err = tx.Get("savepoint:b")
+		assert.NotNil(t, err)
+		assert.NotNil(t, tx.RollbackTo("second"))
+		assert.Nil(t, tx.Release("first"))
+		assert.NotNil(t, tx.RollbackTo("first"))
+		// images of released savepoint are passed to the outer one
+		assert.Nil(t, tx.Savepoint("outer"))
+		assert.Nil(t, tx.Savepoint("inner"))
+		tx.MustSet("savepoint:a", []byte("3"))
+		assert.Nil(t, tx.Release("inner"))
+		assert.Nil(t, tx.RollbackTo("outer"))
+		assert.Equal(t, []byte("1"), tx.MustGet("savepoint:a"))
+		assert.Nil(t, tx.Commit())
+		assert.Equal(t, []byte("1"), dbClient.MustGet("savepoint:a"))
+		_, err = dbClient.Get("savepoint:b")
+		assert.NotNil(t, err)
+		dbClient.MustSet("savepoint:a", []byte("0"))
+	}
+	dbClient.MustDel("savepoint:a")
+}
+
+// TestDBMS_TwoPhaseCommit checks prepared transactions are finished from another connection
+func TestDBMS_TwoPhaseCommit(t *testing.T) {
+	dbClient.Del("2pc:a")
+	dbClient.Del("2pc:b")
+	c, err := client.Connect(dbUrl)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer c.Finalize()
+	tx, err := c.BeginEx()
+	if err != nil {
+		log.Panic(err)
+	}
+	tx.MustSet("2pc:a", []byte("a"))
+	assert.Nil(t, tx.Prepare("2pc-ex"))
+	tx, err = c.BeginSn()
+	if err != nil {
+		log.Panic(err)
+	}
+	tx.MustSet("2pc:b", []byte("b"))
+	assert.Nil(t, tx.Prepare("2pc-sn"))
+	tx, err = c.BeginEx()
+	if err != nil {
+		log.Panic(err)
+	}
+	assert.NotNil(t, tx.Prepare("2pc-sn"))
+	prepared, err := dbClient.ListPrepared()
+	assert.Nil(t, err)
+	assert.Contains(t, prepared, "2pc-ex")
+	assert.Contains(t, prepared, "2pc-sn")
+	// prepared changes are not committed yet
+	snapshot, err := dbClient.BeginSn()
+	if err != nil {
+		log.Panic(err)
+	}
+	_, err = snapshot.Get("2pc:a")
+	assert.NotNil(t, err)
+	assert.Nil(t, snapshot.Commit())
+	assert.Nil(t, dbClient.CommitPrepared("2pc-ex"))
+	assert.Nil(t, dbClient.RollbackPrepared("2pc-sn"))
+	assert.NotNil(t, dbClient.CommitPrepared("2pc-sn"))
+	assert.Equal(t, []byte("a"), dbClient.MustGet("2pc:a"))
+	_, err = dbClient.Get("2pc:b")
+	assert.NotNil(t, err)
+	prepared, err = dbClient.ListPrepared()
+	assert.Nil(t, err)
+	assert.NotContains(t, prepared, "2pc-ex")
+	assert.NotContains(t, prepared, "2pc-sn")
+	dbClient.MustDel("2pc:a")
+}
+
 func setAndCheckBoilerplate(c client.DataCommands, key string, expected []byte, t *testing.T) {
 	c.MustSet("key", expected)
 	actual := c.MustGet("key")
 	assert.Equal(t, actual, expected)
 }
+
+// TestDBMS_CommitNoWait checks changes committed without waiting for sync are visible at once
+func TestDBMS_CommitNoWait(t *testing.T) {
+	for _, begin := range []func() (client.TxCommands, error){dbClient.BeginEx, dbClient.BeginSn} {
+		tx, err := begin()
+		if err != nil {
+			log.Panic(err)
+		}
+		tx.MustSet("nowait", []byte("value"))
+		assert.Nil(t, tx.CommitNoWait())
+		assert.Equal(t, []byte("value"), dbClient.MustGet("nowait"))
+		dbClient.MustDel("nowait")
+	}
+	res, err := dbClient.Exec("COMMIT NOWAIT")
+	assert.Nil(t, err)
+	assert.True(t, res.Ok())
+}
+
+// TestDBMS_GroupCommitStats checks STATS counts commit records in batches of histogram
+func TestDBMS_GroupCommitStats(t *testing.T) {
+	before, err := dbClient.GroupCommitStats()
+	assert.Nil(t, err)
+	dbClient.MustSet("stats", []byte("value"))
+	dbClient.MustDel("stats")
+	after, err := dbClient.GroupCommitStats()
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, after.Records-before.Records, 2)
+	assert.Len(t, after.BatchSizes, 10)
+	batches := 0
+	for _, n := range after.BatchSizes {
+		batches += n
+	}
+	assert.Equal(t, after.Batches, batches)
+	assert.GreaterOrEqual(t, after.MaxBatch, 1)
+}
+
+// TestDBMS_Backup checks backup contains storage with journal and is not overwritten
+func TestDBMS_Backup(t *testing.T) {
+	path := "smoke_backup"
+	defer os.RemoveAll(backupDir(path))
+	dbClient.MustSet("backup", []byte("value"))
+	assert.Nil(t, dbClient.Backup(path))
+	for _, name := range []string{"data.bin", filepath.Join("log", "checkpoint.bin")} {
+		_, err := os.Stat(filepath.Join(backupDir(path), name))
+		assert.Nil(t, err)
+	}
+	assert.NotNil(t, dbClient.Backup(path))
+	// backups are made inside backup root only
+	escape, err := filepath.Abs("smoke_backup_escape")
+	assert.Nil(t, err)
+	for _, p := range []string{"../smoke_backup_escape", "smoke_backup/../../smoke_backup_escape", escape} {
+		assert.Equal(t, server.ErrBackupPath.Error(), dbClient.Backup(p).Error())
+	}
+	_, err = os.Stat(escape)
+	assert.True(t, os.IsNotExist(err))
+	dbClient.MustDel("backup")
+}
+
+// TestDBMS_IncrementalBackup checks incremental backup contains changed pages with journal and requires base backup
+func TestDBMS_IncrementalBackup(t *testing.T) {
+	full, inc := "smoke_backup_full", "smoke_backup_inc"
+	defer os.RemoveAll(backupDir(full))
+	defer os.RemoveAll(backupDir(inc))
+	assert.Nil(t, dbClient.Backup(full))
+	assert.NotNil(t, dbClient.IncrementalBackup(inc, "smoke_backup_missing"))
+	dbClient.MustSet("backup", []byte("value"))
+	assert.Nil(t, dbClient.IncrementalBackup(inc, full))
+	for _, name := range []string{"pages.bin", filepath.Join("log", "checkpoint.bin")} {
+		_, err := os.Stat(filepath.Join(backupDir(inc), name))
+		assert.Nil(t, err)
+	}
+	dbClient.MustDel("backup")
+}
+
+// TestDBMS_DumpLoad checks dump lists pairs in key order with binary keys and values encoded and is loaded back
+func TestDBMS_DumpLoad(t *testing.T) {
+	pairs := map[string][]byte{
+		"dump0":     []byte("text <value>"),
+		"dump1":     {0xff, 0x00, 0xfe},
+		"dump2":     []byte("значение"),
+		"dump3\xff": []byte("binary key"),
+	}
+	// pairs outnumber range scan of dump
+	for i := 0; i < 40; i++ {
+		pairs[fmt.Sprintf("dump:%02d", i)] = []byte("many")
+	}
+	for key, value := range pairs {
+		dbClient.MustSet(key, value)
+	}
+	dump := new(bytes.Buffer)
+	n, err := dbClient.Dump(dump)
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSuffix(dump.String(), "\n"), "\n")
+	assert.Equal(t, n, len(lines))
+	var keys []string
+	for _, line := range lines {
+		rec := new(client.DumpRecord)
+		assert.Nil(t, json.Unmarshal([]byte(line), rec))
+		key, err := rec.DecodedKey()
+		assert.Nil(t, err)
+		keys = append(keys, key)
+	}
+	assert.True(t, sort.StringsAreSorted(keys))
+	for key := range pairs {
+		assert.Contains(t, keys, key)
+	}
+	assert.Contains(t, lines, `{"key":"dump0","value":"text <value>"}`)
+	assert.Contains(t, lines, `{"key":"dump1","value":"/wD+","encoding":"base64"}`)
+	assert.Contains(t, lines, `{"key":"ZHVtcDP/","keyEncoding":"base64","value":"binary key"}`)
+	for key := range pairs {
+		dbClient.MustDel(key)
+	}
+	loaded, err := dbClient.Load(dump, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, n, loaded)
+	for key, value := range pairs {
+		assert.Equal(t, value, dbClient.MustGet(key))
+		dbClient.MustDel(key)
+	}
+	_, err = dbClient.Load(strings.NewReader(`{"key":"dump3","value":"?","encoding":"hex"}`), 0)
+	assert.True(t, errors.Is(err, client.ErrUnknownEncoding))
+	_, err = dbClient.Load(strings.NewReader(`{"key":"ZHVtcDP/","keyEncoding":"hex","value":"?"}`), 0)
+	assert.True(t, errors.Is(err, client.ErrUnknownEncoding))
+}
+
+// startServer runs additional server on port after the main one with offset and returns its url, connected client
+// and function which stops server
+func startServer(
+	t *testing.T,
+	portOffset int,
+	configure func(cfg *config.CoreConfig),
+) (string, *client.DBMSClient, func()) {
+	cfgLdr := new(config.DefaultConfigLoader)
+	cfgLdr.Load()
+	configure(cfgLdr.CoreCfg())
+	cfgLdr.SrvCfg().Port += portOffset
+	coreFactory := core.NewDefaultDBMSCoreFactory(cfgLdr.CoreCfg())
+	coreFactory.BtstpMgr().Init()
+	go server.NewDefaultDBMSServerFactory(cfgLdr.SrvCfg(), coreFactory).ConnSrv().Run()
+	url := fmt.Sprintf("localhost:%d", cfgLdr.SrvCfg().Port)
+	var c *client.DBMSClient
+	assert.Eventually(t, func() bool {
+		var err error
+		c, err = client.Connect(url)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	return url, c, func() {
+		c.Finalize()
+		coreFactory.BtstpMgr().Finalize()
+		// primary stops shipment when it notices disconnect, so it doesn't outlive the test
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// TestDBMS_Replication checks replica started from backup of primary replays its changes and rejects writes
+func TestDBMS_Replication(t *testing.T) {
+	path := "smoke_replica"
+	defer os.RemoveAll(backupDir(path))
+	dbClient.MustSet("replica0", []byte("before backup"))
+	assert.Nil(t, dbClient.Backup(path))
+	dbClient.MustSet("replica1", []byte("after backup"))
+
+	_, replicaClient, finalize := startServer(t, 1, func(cfg *config.CoreConfig) {
+		cfg.FilesPath = backupDir(path)
+		cfg.ReplicaOf = dbUrl
+	})
+	defer finalize()
+	defer replicaClient.Finalize()
+
+	assert.Equal(t, []byte("before backup"), replicaClient.MustGet("replica0"))
+	replicated := func(key string, expected []byte) func() bool {
+		return func() bool {
+			value, err := replicaClient.Get(key)
+			return (err == nil && bytes.Equal(expected, value)) || (err != nil && expected == nil)
+		}
+	}
+	assert.Eventually(t, replicated("replica1", []byte("after backup")), 5*time.Second, 10*time.Millisecond)
+	// backup makes checkpoint, which truncates journal of replica too
+	assert.Nil(t, dbClient.Backup(path+"_checkpoint"))
+	defer os.RemoveAll(backupDir(path + "_checkpoint"))
+	dbClient.MustDel("replica0")
+	assert.Eventually(t, replicated("replica0", nil), 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		records, delay, err := replicaClient.ReplicationLag()
+		return err == nil && records == 0 && delay == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.NotNil(t, replicaClient.Set("replica2", []byte("value")))
+	assert.NotNil(t, replicaClient.Del("replica1"))
+	for _, begin := range []func() (client.TxCommands, error){replicaClient.BeginSh, replicaClient.BeginSn} {
+		tx, err := begin()
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("after backup"), tx.MustGet("replica1"))
+		assert.Nil(t, tx.Commit())
+	}
+	_, _, err := dbClient.ReplicationLag()
+	assert.NotNil(t, err)
+
+	// changes of open transaction are shipped with commit of another one, but aren't visible till its commit
+	c, err := client.Connect(dbUrl)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer c.Finalize()
+	open, err := c.BeginEx()
+	if err != nil {
+		log.Panic(err)
+	}
+	open.MustSet("replica1", []byte("uncommitted"))
+	open.MustSet("replica3", []byte("uncommitted"))
+	dbClient.MustSet("replica4", []byte("committed"))
+	assert.Eventually(t, replicated("replica4", []byte("committed")), 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []byte("after backup"), replicaClient.MustGet("replica1"))
+	_, err = replicaClient.Get("replica3")
+	assert.NotNil(t, err)
+	assert.Nil(t, open.Commit())
+	assert.Eventually(t, replicated("replica3", []byte("uncommitted")), 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []byte("uncommitted"), replicaClient.MustGet("replica1"))
+	for _, key := range []string{"replica1", "replica3", "replica4"} {
+		dbClient.MustDel(key)
+	}
+}
+
+// TestDBMS_SyncReplication checks commit waits till replica acknowledges it and fails without replica
+func TestDBMS_SyncReplication(t *testing.T) {
+	primaryPath, replicaPath := "smoke_sync_primary", "smoke_sync_replica"
+	defer os.RemoveAll(primaryPath)
+	defer os.RemoveAll(backupDir(replicaPath))
+	assert.Nil(t, os.Mkdir(primaryPath, 0777))
+	primaryUrl, primaryClient, finalizePrimary := startServer(t, 2, func(cfg *config.CoreConfig) {
+		cfg.FilesPath = primaryPath
+		cfg.SyncReplicas = 1
+		cfg.SyncReplicationTimeoutMs = 200
+		cfg.SyncReplicationFallback = config.SyncFallbackError
+	})
+	defer finalizePrimary()
+	// commit is kept by primary, but client knows it isn't replicated
+	assert.NotNil(t, primaryClient.Set("sync0", []byte("primary only")))
+	assert.Equal(t, []byte("primary only"), primaryClient.MustGet("sync0"))
+	assert.Nil(t, primaryClient.Backup(replicaPath))
+
+	_, replicaClient, finalizeReplica := startServer(t, 3, func(cfg *config.CoreConfig) {
+		cfg.FilesPath = backupDir(replicaPath)
+		cfg.ReplicaOf = primaryUrl
+		cfg.ReplicaName = "sync-replica"
+	})
+	defer finalizeReplica()
+	assert.Eventually(t, func() bool {
+		return primaryClient.Set("sync1", []byte("replicated")) == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	// replica acknowledges records after replay, so acknowledged commit is visible on replica
+	assert.Equal(t, []byte("replicated"), replicaClient.MustGet("sync1"))
+	tx, err := primaryClient.BeginEx()
+	assert.Nil(t, err)
+	tx.MustSet("sync2", []byte("replicated"))
+	assert.Nil(t, tx.Commit())
+	assert.Equal(t, []byte("replicated"), replicaClient.MustGet("sync2"))
+
+	replicas, err := primaryClient.Replicas()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(replicas))
+	assert.Equal(t, "sync-replica", replicas[0].Name)
+	assert.True(t, replicas[0].AckedLsn > 0)
+	assert.Equal(t, int64(0), replicas[0].Lag)
+}
+
+// TestDBMS_Cluster checks that nodes of cluster elect leader, which replicates changes to followers,
+// and elect another leader when it is stopped
+func TestDBMS_Cluster(t *testing.T) {
+	cfgLdr := new(config.DefaultConfigLoader)
+	cfgLdr.Load()
+	paths := []string{"smoke_node0", "smoke_node1", "smoke_node2"}
+	nodes := make([]string, len(paths))
+	for i := range paths {
+		nodes[i] = fmt.Sprintf("localhost:%d", cfgLdr.SrvCfg().Port+4+i)
+	}
+	clients := make([]*client.DBMSClient, len(paths))
+	finalizers := make([]func(), len(paths))
+	for i, path := range paths {
+		i, path := i, path
+		defer os.RemoveAll(path)
+		assert.Nil(t, os.Mkdir(path, 0777))
+		_, clients[i], finalizers[i] = startServer(t, 4+i, func(cfg *config.CoreConfig) {
+			cfg.FilesPath = path
+			cfg.Cluster = nodes
+			cfg.ClusterAddr = nodes[i]
+			cfg.ElectionTimeoutMs = 200
+		})
+	}
+	defer func() {
+		for _, finalize := range finalizers {
+			if finalize != nil {
+				finalize()
+			}
+		}
+	}()
+
+	leaderClient, err := client.ConnectLeader(nodes, 10*time.Second)
+	if !assert.Nil(t, err) {
+		return
+	}
+	status, err := leaderClient.ClusterStatus()
+	assert.Nil(t, err)
+	assert.Equal(t, "leader", status.Role)
+	leader := -1
+	for i, node := range nodes {
+		if node == status.Addr {
+			leader = i
+		}
+	}
+	assert.Nil(t, leaderClient.Set("cluster0", []byte("replicated")))
+	for _, c := range clients {
+		c := c
+		assert.Eventually(t, func() bool {
+			value, err := c.Get("cluster0")
+			return err == nil && bytes.Equal(value, []byte("replicated"))
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+	// follower redirects changes to leader, and client follows redirect
+	follower := (leader + 1) % len(nodes)
+	redirected, err := client.Connect(nodes[follower])
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, redirected.Set("cluster1", []byte("redirected")))
+	redirectedStatus, err := redirected.ClusterStatus()
+	assert.Nil(t, err)
+	assert.Equal(t, status.Addr, redirectedStatus.Addr)
+	redirected.Finalize()
+	// transaction started on follower isn't moved to leader, so its changes are rejected with leader address
+	tx, err := clients[follower].BeginEx()
+	assert.Nil(t, err)
+	err = tx.Set("cluster1", []byte("rejected"))
+	assert.NotNil(t, err)
+	assert.Equal(t, status.Addr, client.LeaderOf(err))
+	assert.Nil(t, tx.Abort())
+	for _, c := range clients {
+		c := c
+		assert.Eventually(t, func() bool {
+			value, err := c.Get("cluster1")
+			return err == nil && bytes.Equal(value, []byte("redirected"))
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+
+	leaderClient.Finalize()
+	finalizers[leader]()
+	finalizers[leader] = nil
+	leaderClient, err = client.ConnectLeader(nodes, 10*time.Second)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer leaderClient.Finalize()
+	newStatus, err := leaderClient.ClusterStatus()
+	assert.Nil(t, err)
+	assert.NotEqual(t, status.Addr, newStatus.Addr)
+	assert.True(t, newStatus.Term > status.Term)
+	assert.Equal(t, []byte("replicated"), leaderClient.MustGet("cluster0"))
+	assert.Nil(t, leaderClient.Set("cluster1", []byte("after failover")))
+	for i, c := range clients {
+		if i == leader {
+			continue
+		}
+		c := c
+		assert.Eventually(t, func() bool {
+			value, err := c.Get("cluster1")
+			return err == nil && bytes.Equal(value, []byte("after failover"))
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+}
+
+// backupDir returns directory where server makes backup by BACKUP path command
+func backupDir(path string) string {
+	cfgLdr := new(config.DefaultConfigLoader)
+	cfgLdr.Load()
+	return filepath.Join(cfgLdr.CoreCfg().BackupRoot, path)
+}